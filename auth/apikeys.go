@@ -0,0 +1,92 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/storage"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	// keyPrefix отличает API-ключи бота от других токенов, в том числе при поиске утёкших ключей
+	keyPrefix = "mtb_"
+	// shownPrefix - сколько первых символов ключа хранится открыто, чтобы ключ можно было опознать
+	shownPrefix = 12
+)
+
+// KeyStore - хранилище ключей
+type KeyStore = storage.APIKeyStore
+
+// APIKeys выпускает, проверяет и отзывает ключи API. В хранилище попадает только SHA-256 ключа:
+// ключ - 256 случайных бит, поэтому медленный хеш для него не нужен
+type APIKeys struct {
+	store KeyStore
+}
+
+func NewAPIKeys(store KeyStore) *APIKeys {
+	return &APIKeys{store: store}
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Mint выпускает ключ с правами scopes. Ключ возвращается один раз, восстановить его нельзя
+func (k *APIKeys) Mint(ctx context.Context, name string, scopes []string) (string, models.APIKey, error) {
+	if name == "" {
+		return "", models.APIKey{}, errors.New("key name is required")
+	}
+	if len(scopes) == 0 {
+		return "", models.APIKey{}, errors.New("at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			return "", models.APIKey{}, fmt.Errorf("unknown scope %q, expected one of %s", scope, strings.Join(Scopes, ", "))
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", models.APIKey{}, fmt.Errorf("generating api key: %w", err)
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record, err := k.store.CreateAPIKey(ctx, models.APIKey{Name: name, Prefix: key[:shownPrefix], Scopes: scopes}, hashKey(key))
+	if err != nil {
+		return "", models.APIKey{}, fmt.Errorf("saving api key: %w", err)
+	}
+	return key, record, nil
+}
+
+func (k *APIKeys) List(ctx context.Context) ([]models.APIKey, error) {
+	return k.store.ListAPIKeys(ctx)
+}
+
+func (k *APIKeys) Revoke(ctx context.Context, id int64) error {
+	return k.store.RevokeAPIKey(ctx, id)
+}
+
+func (k *APIKeys) Authenticate(r *http.Request) (Principal, error) {
+	key := bearer(r)
+	if !strings.HasPrefix(key, keyPrefix) {
+		return Principal{}, ErrNoCredentials
+	}
+
+	record, err := k.store.APIKeyByHash(r.Context(), hashKey(key))
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		return Principal{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return Principal{}, fmt.Errorf("looking up api key: %w", err)
+	}
+	return Principal{Name: record.Name, Method: "api_key", Scopes: record.Scopes}, nil
+}
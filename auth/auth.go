@@ -0,0 +1,90 @@
+// Package auth проверяет, кто обращается к HTTP API бота и что ему разрешено.
+// Способы входа (Authenticator) подключаются независимо и объединяются в Chain
+package auth
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Права доступа. ScopeAll разрешает всё
+const (
+	ScopeAll        = "*"
+	ScopeAdminRead  = "admin:read"
+	ScopeAdminWrite = "admin:write"
+)
+
+// Scopes - права, которые можно выдать ключу
+var Scopes = []string{ScopeAll, ScopeAdminRead, ScopeAdminWrite}
+
+var (
+	// ErrNoCredentials - в запросе нет данных для этого способа входа, стоит попробовать следующий
+	ErrNoCredentials = errors.New("no credentials")
+	// ErrInvalidCredentials - данные для входа есть, но неверны
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal - тот, от чьего имени выполняется запрос
+type Principal struct {
+	Name   string
+	Method string
+	Scopes []string
+}
+
+// Can сообщает, есть ли у Principal право scope
+func (p Principal) Can(scope string) bool {
+	return slices.Contains(p.Scopes, ScopeAll) || slices.Contains(p.Scopes, scope)
+}
+
+// String - подпись для журнала аудита, например api_key:grafana
+func (p Principal) String() string {
+	return p.Method + ":" + p.Name
+}
+
+type Authenticator interface {
+	Authenticate(r *http.Request) (Principal, error)
+}
+
+// Chain пробует способы входа по порядку до первого, для которого в запросе есть данные
+type Chain []Authenticator
+
+func (c Chain) Authenticate(r *http.Request) (Principal, error) {
+	for _, authenticator := range c {
+		principal, err := authenticator.Authenticate(r)
+		if errors.Is(err, ErrNoCredentials) {
+			continue
+		}
+		return principal, err
+	}
+	return Principal{}, ErrNoCredentials
+}
+
+// bearer возвращает токен из заголовка Authorization: Bearer <token>
+func bearer(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+type staticToken struct {
+	token string
+}
+
+// StaticToken - общий токен из конфига (ADMIN_TOKEN) со всеми правами
+func StaticToken(token string) Authenticator {
+	return staticToken{token: token}
+}
+
+func (s staticToken) Authenticate(r *http.Request) (Principal, error) {
+	token := bearer(r)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		// токен может оказаться API-ключом, его проверит следующий способ входа
+		return Principal{}, ErrNoCredentials
+	}
+	return Principal{Name: "admin_token", Method: "static", Scopes: []string{ScopeAll}}, nil
+}
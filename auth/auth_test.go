@@ -0,0 +1,128 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/storage"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func request(header, value string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/admin/chats", nil)
+	if header != "" {
+		r.Header.Set(header, value)
+	}
+	return r
+}
+
+func TestAPIKeys(t *testing.T) {
+	ctx := context.Background()
+	keys := NewAPIKeys(storage.NewMemoryStorage())
+
+	_, _, err := keys.Mint(ctx, "grafana", []string{"admin:everything"})
+	require.Error(t, err)
+
+	key, record, err := keys.Mint(ctx, "grafana", []string{ScopeAdminRead})
+	require.NoError(t, err)
+	require.Equal(t, key[:shownPrefix], record.Prefix)
+
+	principal, err := keys.Authenticate(request("Authorization", "Bearer "+key))
+	require.NoError(t, err)
+	require.Equal(t, "api_key:grafana", principal.String())
+	require.True(t, principal.Can(ScopeAdminRead))
+	require.False(t, principal.Can(ScopeAdminWrite))
+
+	_, err = keys.Authenticate(request("Authorization", "Bearer "+key+"x"))
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+	_, err = keys.Authenticate(request("Authorization", "Bearer static-token"))
+	require.ErrorIs(t, err, ErrNoCredentials)
+
+	require.NoError(t, keys.Revoke(ctx, record.ID))
+	_, err = keys.Authenticate(request("Authorization", "Bearer "+key))
+	require.ErrorIs(t, err, ErrInvalidCredentials)
+}
+
+// telegramLogin подписывает данные виджета так же, как Telegram
+func telegramLogin(t *TelegramLogin, id int64, username string, authDate time.Time) string {
+	values := url.Values{
+		"id":         {strconv.FormatInt(id, 10)},
+		"first_name": {"Admin"},
+		"username":   {username},
+		"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+	}
+	values.Set("hash", hex.EncodeToString(t.sign(values)))
+	return values.Encode()
+}
+
+func TestTelegramLogin(t *testing.T) {
+	login := NewTelegramLogin("bot-token", []int64{42}, time.Hour)
+	now := time.Now()
+
+	principal, err := login.Authenticate(request(TelegramLoginHeader, telegramLogin(login, 42, "admin", now)))
+	require.NoError(t, err)
+	require.Equal(t, "telegram:admin", principal.String())
+	require.True(t, principal.Can(ScopeAdminWrite))
+
+	principal, err = login.Authenticate(request(TelegramLoginHeader, telegramLogin(login, 7, "user", now)))
+	require.NoError(t, err)
+	require.False(t, principal.Can(ScopeAdminRead), "not an admin")
+
+	_, err = login.Authenticate(request(TelegramLoginHeader, telegramLogin(login, 42, "admin", now.Add(-2*time.Hour))))
+	require.ErrorIs(t, err, ErrInvalidCredentials, "expired")
+	_, err = login.Authenticate(request(TelegramLoginHeader, telegramLogin(login, 42, "admin", now.Add(30*time.Second))))
+	require.NoError(t, err, "server clock is slightly behind")
+	_, err = login.Authenticate(request(TelegramLoginHeader, telegramLogin(login, 42, "admin", now.Add(24*time.Hour))))
+	require.ErrorIs(t, err, ErrInvalidCredentials, "issued in the future")
+
+	other := NewTelegramLogin("other-bot-token", []int64{42}, time.Hour)
+	_, err = login.Authenticate(request(TelegramLoginHeader, telegramLogin(other, 42, "admin", now)))
+	require.ErrorIs(t, err, ErrInvalidCredentials, "signed for another bot")
+
+	_, err = login.Authenticate(request("", ""))
+	require.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys := NewAPIKeys(storage.NewMemoryStorage())
+	readKey, _, err := keys.Mint(context.Background(), "reader", []string{ScopeAdminRead})
+	require.NoError(t, err)
+
+	router := gin.New()
+	group := router.Group("/admin", Middleware(Chain{keys, StaticToken("static-token")}))
+	group.GET("/chats", RequireScope(ScopeAdminRead), func(c *gin.Context) { c.Status(http.StatusOK) })
+	group.DELETE("/users/1", RequireScope(ScopeAdminWrite), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		want   int
+	}{
+		{"no credentials", http.MethodGet, "/admin/chats", "", http.StatusUnauthorized},
+		{"wrong token", http.MethodGet, "/admin/chats", "nope", http.StatusUnauthorized},
+		{"api key with scope", http.MethodGet, "/admin/chats", readKey, http.StatusOK},
+		{"api key without scope", http.MethodDelete, "/admin/users/1", readKey, http.StatusForbidden},
+		{"static token has all scopes", http.MethodDelete, "/admin/users/1", "static-token", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			require.Equal(t, tt.want, w.Code)
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/logger"
+	"net/http"
+)
+
+const principalKey = "auth.principal"
+
+// Middleware пропускает дальше только запросы, для которых authenticator установил Principal
+func Middleware(authenticator Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, err := authenticator.Authenticate(c.Request)
+		switch {
+		case errors.Is(err, ErrNoCredentials), errors.Is(err, ErrInvalidCredentials):
+			c.Header("WWW-Authenticate", `Bearer realm="mytelegrambot"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+			return
+		case err != nil:
+			logger.FromContext(c.Request.Context()).Errorw("authenticating request", "error", err)
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "authentication unavailable"})
+			return
+		}
+
+		c.Set(principalKey, principal)
+		c.Next()
+	}
+}
+
+// RequireScope отклоняет запрос, если у Principal нет права scope. Ставится после Middleware
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, ok := PrincipalFrom(c); !ok || !principal.Can(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "forbidden", "required_scope": scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+// PrincipalFrom возвращает Principal, установленный Middleware
+func PrincipalFrom(c *gin.Context) (Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	principal, ok := value.(Principal)
+	return principal, ok
+}
@@ -2,158 +2,464 @@ package bot
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/go-telegram/bot"
 	"github.com/mytelegrambot/config"
-	"log"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"net/http"
 	"time"
 )
 
 type BotAPI interface {
-	GetUpdates(ctx context.Context) (<-chan tgbotapi.Update, error)
-	SendMessage(chatID int64, text string) (*tgbotapi.Message, error)
-	DeleteMessages(ctx context.Context, chatID int64, messageIDs []int) error
+	GetUpdates(ctx context.Context, offset int) (<-chan tgbotapi.Update, error)
+	SendMessage(ctx context.Context, chatID int64, text string) (*tgbotapi.Message, error)
+	SendMessageWithKeyboard(ctx context.Context, chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) (*tgbotapi.Message, error)
+	EditMessageText(ctx context.Context, chatID int64, msgID int, text string) (*tgbotapi.Message, error)
+	DeleteMessages(ctx context.Context, chatID int64, messageIDs []int) ([]int, error)
 	HandleCommand(ctx context.Context, msg *tgbotapi.Message, msgIDs []int) (*tgbotapi.Message, error)
-	GetMyCommands() ([]tgbotapi.BotCommand, error)
+	GetMyCommands(ctx context.Context) ([]tgbotapi.BotCommand, error)
+	SetMyCommands(ctx context.Context, commands []tgbotapi.BotCommand) error
 	DeleteMessage(ctx context.Context, chatID int64, msgID int) error
+	GetMe(ctx context.Context) (tgbotapi.User, error)
+	SendChatAction(ctx context.Context, chatID int64, action string) error
+	SendDocument(ctx context.Context, chatID int64, name string, data []byte, caption string) (*tgbotapi.Message, error)
+	DownloadFile(ctx context.Context, fileID string) ([]byte, error)
+	AnswerCallbackQuery(ctx context.Context, callbackID, text string) error
 }
 
+// deleteBatchSize - максимальное число сообщений в одном запросе deleteMessages
+const deleteBatchSize = 100
+
+// pollTimeout - сколько секунд Telegram держит запрос getUpdates, если апдейтов нет
+const pollTimeout = 25
+
+// pollRetryDelay - пауза после неудачного getUpdates, если Telegram не указал retry_after
+const pollRetryDelay = 3 * time.Second
+
+// Bot - клиент Telegram Bot API поверх HTTP. Типы запросов и ответов взяты из tgbotapi
 type Bot struct {
-	api   *tgbotapi.BotAPI
-	tgBot *bot.Bot
+	token     string
+	serverURL string
+	client    *http.Client
+	debug     bool
+	self      tgbotapi.User
+	// scheduler ограничивает частоту всех запросов к Telegram и повторяет их после 429
+	scheduler *Scheduler
 }
 
-func NewBot(config *config.Config) (*Bot, error) {
-	botAPI, err := tgbotapi.NewBotAPI(config.Token)
+// NewBot создаёт клиента и проверяет токен запросом getMe
+func NewBot(ctx context.Context, config *config.Config, opts ...Option) (*Bot, error) {
+	if config.BotEnv {
+		opts = append([]Option{WithDebug()}, opts...)
+	}
+	b := New(config.Token, opts...)
+
+	self, err := b.GetMe(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("parsing telegram bot token err: %v", err)
+		return nil, fmt.Errorf("authorizing telegram bot: %w", err)
 	}
+	b.self = self
+
+	logger.FromContext(ctx).Infow("authorized on telegram account",
+		"username", self.UserName, "first_name", self.FirstName, "debug", b.debug)
+
+	return b, nil
+}
 
-	botAPI.Debug = config.BotEnv
+func (b *Bot) GetMyCommands(ctx context.Context) (commands []tgbotapi.BotCommand, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.getMyCommands")
+	defer func() { tracing.End(span, err) }()
 
-	switch {
-	case botAPI.Debug == true:
-		log.Printf("authorized on account @%v in debug mode! (%v)\n",
-			botAPI.Self.UserName, botAPI.Self.FirstName)
-	case botAPI.Debug == false:
-		log.Printf("authorized on account @%v! (%v)\n", botAPI.Self.UserName, botAPI.Self.FirstName)
+	err = b.scheduler.Do(ctx, "getMyCommands", 0, func() error {
+		return b.request(ctx, "getMyCommands", nil, &commands)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("getMyCommands").Inc()
+		return nil, fmt.Errorf("get commands for %v: %w", b.self.UserName, err)
 	}
 
-	var opts []bot.Option
+	logger.FromContext(ctx).Debugw("got bot commands", "username", b.self.UserName, "commands", len(commands))
+	return commands, nil
+}
 
-	if config.BotEnv == true {
-		opts = []bot.Option{
-			bot.WithDebug(),
-		}
+// SetMyCommands заменяет список команд, который Telegram показывает в меню бота
+func (b *Bot) SetMyCommands(ctx context.Context, commands []tgbotapi.BotCommand) (err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.setMyCommands")
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	if err = params.AddInterface("commands", commands); err != nil {
+		return fmt.Errorf("encoding commands: %w", err)
 	}
 
-	b, err := bot.New(config.Token, opts...)
+	err = b.scheduler.Do(ctx, "setMyCommands", 0, func() error {
+		return b.request(ctx, "setMyCommands", params, nil)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("new bot with token and opts err: %w", err)
+		metrics.TelegramErrors.WithLabelValues("setMyCommands").Inc()
+		return fmt.Errorf("set commands for %v: %w", b.self.UserName, err)
 	}
 
-	return &Bot{
-		tgBot: b,
-		api:   botAPI,
-	}, nil
+	return nil
 }
 
-func (b *Bot) GetMyCommands() ([]tgbotapi.BotCommand, error) {
-	commands, err := b.api.GetMyCommands()
+// GetMe запрашивает профиль бота, используется как проверка доступности Telegram API
+func (b *Bot) GetMe(ctx context.Context) (user tgbotapi.User, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.getMe")
+	defer func() { tracing.End(span, err) }()
+
+	err = b.scheduler.Do(ctx, "getMe", 0, func() error {
+		return b.request(ctx, "getMe", nil, &user)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("get commands for %v: %w", b.api.Self.UserName, err)
+		metrics.TelegramErrors.WithLabelValues("getMe").Inc()
+		return tgbotapi.User{}, fmt.Errorf("get me: %w", err)
 	}
 
-	log.Printf("got commands for %v", b.api.Self.UserName)
-	return commands, nil
+	return user, nil
 }
 
-// GetUpdates запускает цикл получения апдейтов и делегирует их обработку
-func (b *Bot) GetUpdates(ctx context.Context) (<-chan tgbotapi.Update, error) {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = 25
-	updates := b.api.GetUpdatesChan(u)
+// GetUpdates запускает цикл получения апдейтов начиная со смещения offset.
+// После отмены ctx опрос Telegram прекращается и канал закрывается
+func (b *Bot) GetUpdates(ctx context.Context, offset int) (<-chan tgbotapi.Update, error) {
+	updates := make(chan tgbotapi.Update, 100)
+
+	go func() {
+		defer close(updates)
+
+		for ctx.Err() == nil {
+			params := make(tgbotapi.Params)
+			params.AddNonZero("offset", offset)
+			params.AddNonZero("timeout", pollTimeout)
+
+			var batch []tgbotapi.Update
+			// long polling идёт мимо scheduler: запрос один, а ожидание ответа не должно занимать лимит
+			if err := b.request(ctx, "getUpdates", params, &batch); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				metrics.TelegramErrors.WithLabelValues("getUpdates").Inc()
+				delay := pollRetryDelay
+				if retryAfter, ok := RetryAfter(err); ok {
+					delay = retryAfter
+				}
+				logger.FromContext(ctx).Warnw("failed to get updates, retrying", "delay", delay, "error", err)
+				if sleep(ctx, delay) != nil {
+					return
+				}
+				continue
+			}
+
+			for _, update := range batch {
+				if update.UpdateID < offset {
+					continue
+				}
+				offset = update.UpdateID + 1
+				select {
+				case updates <- update:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
 
 	return updates, nil
 }
 
-func (b *Bot) HandleCommand(ctx context.Context, msg *tgbotapi.Message, msgIDs []int) (*tgbotapi.Message, error) {
-	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+func (b *Bot) HandleCommand(ctx context.Context, msg *tgbotapi.Message, msgIDs []int) (answer *tgbotapi.Message, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.handleCommand",
+		attribute.String("telegram.command", msg.Command()), attribute.Int64("telegram.chat_id", msg.Chat.ID))
+	defer func() { tracing.End(span, err) }()
+
+	timeout := 5 * time.Second
+	if msg.Command() == "restart" {
+		// удаление длинной истории идёт несколькими запросами
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
 	chatID := msg.Chat.ID
 
-	log.Printf("входящая команда: %v, чат (%v)", msg.Text, chatID)
+	log := logger.FromContext(ctx).With("command", msg.Command())
+	log.Infow("handling command")
 
 	switch msg.Command() {
 	case "help":
-		commands, _ := b.api.GetMyCommands()
-		answer, err := b.SendMessage(chatID, fmt.Sprintf("Я Простой чат-бот на основе Openai API, написанный на Golang, с используемой моделью - DeepSeek V3. Команды для бота:  %v", commands))
+		commands, _ := b.GetMyCommands(ctx)
+		answer, err := b.SendMessage(ctx, chatID, fmt.Sprintf("Я Простой чат-бот на основе Openai API, написанный на Golang, с используемой моделью - DeepSeek V3. Команды для бота:  %v", commands))
 		if err != nil {
 			return nil, fmt.Errorf("send answer error: %w", err)
 		}
 		return answer, nil
 	case "start":
-		answer, err := b.SendMessage(chatID, fmt.Sprint("Привет! Задавай мне вопросы, а постараюсь ответить на них правильно! (на базе DeepSeek v3)"))
+		answer, err := b.SendMessage(ctx, chatID, fmt.Sprint("Привет! Задавай мне вопросы, а постараюсь ответить на них правильно! (на базе DeepSeek v3)"))
 		if err != nil {
 			return nil, fmt.Errorf("send start command mock, chat (%v) error: %w", msg.Chat.ID, err)
 		}
 		return answer, nil
 	case "restart":
-		err := b.DeleteMessages(ctx, chatID, msgIDs)
+		failed, err := b.DeleteMessages(ctx, chatID, msgIDs)
 		if err != nil {
 			return nil, fmt.Errorf("%v command, chat (%v) error: %w", msg.Command(), msg.Chat.ID, err)
 		}
+		if len(failed) == 0 {
+			break
+		}
+		answer, err := b.SendMessage(ctx, chatID, fmt.Sprintf(
+			"Диалог перенесён в архив, но %d из %d сообщений удалить из чата не удалось (Telegram не позволяет удалять сообщения старше 48 часов)",
+			len(failed), len(msgIDs)))
+		if err != nil {
+			return nil, fmt.Errorf("send restart report, chat (%v) error: %w", msg.Chat.ID, err)
+		}
+		return answer, nil
 	}
 
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		log.Println("deadline not set in ctx")
-	} else {
-		log.Printf("%v command in %v chat, time left: %v", msg.Command(), chatID, time.Until(deadline))
-	}
+	deadline, _ := ctx.Deadline()
+	log.Debugw("command handled", "time_left", time.Until(deadline))
 
 	return nil, nil
 }
 
-func (b *Bot) DeleteMessages(ctx context.Context, chatID int64, messageIDs []int) error {
+// DeleteMessages удаляет сообщения пачками по deleteBatchSize. Если пачку удалить не удалось,
+// сообщения из неё удаляются по одному. Возвращает ID сообщений, которые удалить не получилось
+func (b *Bot) DeleteMessages(ctx context.Context, chatID int64, messageIDs []int) (failed []int, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.deleteMessages",
+		attribute.Int64("telegram.chat_id", chatID), attribute.Int("telegram.messages", len(messageIDs)))
+	defer func() {
+		span.SetAttributes(attribute.Int("telegram.failed", len(failed)))
+		tracing.End(span, err)
+	}()
+
+	if len(messageIDs) == 0 {
+		logger.FromContext(ctx).Debugw("no message IDs to delete", "chat_id", chatID)
+		return nil, nil
+	}
+
+	for start := 0; start < len(messageIDs); start += deleteBatchSize {
+		end := min(start+deleteBatchSize, len(messageIDs))
+		batch := messageIDs[start:end]
+
+		if err := b.deleteBatch(ctx, chatID, batch); err == nil {
+			continue
+		} else if ctx.Err() != nil {
+			return nil, fmt.Errorf("delete messages batch: %w", ctx.Err())
+		} else {
+			logger.FromContext(ctx).Warnw("delete batch failed, falling back to one by one",
+				"chat_id", chatID, "batch", len(batch), "error", err)
+		}
+
+		for _, id := range batch {
+			if err := b.DeleteMessage(ctx, chatID, id); err != nil {
+				if ctx.Err() != nil {
+					return nil, fmt.Errorf("delete messages one by one: %w", ctx.Err())
+				}
+				failed = append(failed, id)
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		logger.FromContext(ctx).Warnw("some messages were not deleted",
+			"chat_id", chatID, "failed", len(failed), "total", len(messageIDs))
+	}
+
+	return failed, nil
+}
+
+func (b *Bot) deleteBatch(ctx context.Context, chatID int64, messageIDs []int) (err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.deleteMessages.batch", attribute.Int("telegram.messages", len(messageIDs)))
+	defer func() { tracing.End(span, err) }()
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
-	if messageIDs == nil {
-		log.Printf("no message IDs provided from %v chat", chatID)
-		return nil
+	ids, err := json.Marshal(messageIDs)
+	if err != nil {
+		return fmt.Errorf("encoding message ids: %w", err)
 	}
-	_, err := b.tgBot.DeleteMessages(ctx, &bot.DeleteMessagesParams{
-		ChatID:     chatID,
-		MessageIDs: messageIDs,
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params["message_ids"] = string(ids)
+
+	// удаления не отправляют сообщений в чат, лимит чата действует только на отправку
+	err = b.scheduler.Do(ctx, "deleteMessages", 0, func() error {
+		return b.request(ctx, "deleteMessages", params, nil)
 	})
 	if err != nil {
-		return fmt.Errorf("delete message err: %w", err)
+		metrics.TelegramErrors.WithLabelValues("deleteMessages").Inc()
+		return fmt.Errorf("delete messages err: %w", err)
 	}
 
 	return nil
 }
 
-func (b *Bot) DeleteMessage(ctx context.Context, chatID int64, msgID int) error {
-	_, err := b.tgBot.DeleteMessage(ctx, &bot.DeleteMessageParams{
-		ChatID:    chatID,
-		MessageID: msgID,
+func (b *Bot) DeleteMessage(ctx context.Context, chatID int64, msgID int) (err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.deleteMessage", attribute.Int64("telegram.chat_id", chatID))
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_id", msgID)
+
+	err = b.scheduler.Do(ctx, "deleteMessage", 0, func() error {
+		return b.request(ctx, "deleteMessage", params, nil)
 	})
 	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("deleteMessage").Inc()
 		return fmt.Errorf("tg bot delete message (%v) from chat (%v): %w", msgID, chatID, err)
 	}
 	return nil
 }
 
 // SendMessage отправляет текст в чат и возвращает отправленное сообщение
-func (b *Bot) SendMessage(chatID int64, text string) (*tgbotapi.Message, error) {
-	msg := tgbotapi.NewMessage(chatID, text)
-	message, err := b.api.Send(msg)
+func (b *Bot) SendMessage(ctx context.Context, chatID int64, text string) (*tgbotapi.Message, error) {
+	return b.sendMessage(ctx, chatID, text, nil)
+}
+
+// SendMessageWithKeyboard отправляет текст с inline-кнопками. Кнопки пропадают после EditMessageText
+func (b *Bot) SendMessageWithKeyboard(ctx context.Context, chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) (*tgbotapi.Message, error) {
+	return b.sendMessage(ctx, chatID, text, &keyboard)
+}
+
+func (b *Bot) sendMessage(ctx context.Context, chatID int64, text string, keyboard *tgbotapi.InlineKeyboardMarkup) (_ *tgbotapi.Message, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.sendMessage", attribute.Int64("telegram.chat_id", chatID))
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonEmpty("text", text)
+	if keyboard != nil {
+		if err = params.AddInterface("reply_markup", keyboard); err != nil {
+			return nil, fmt.Errorf("encoding keyboard: %w", err)
+		}
+	}
+
+	var message tgbotapi.Message
+	err = b.scheduler.Do(ctx, "sendMessage", chatID, func() error {
+		return b.request(ctx, "sendMessage", params, &message)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("sendMessage").Inc()
+		return nil, fmt.Errorf("send message to chat (%v), err: %w", chatID, err)
+	}
+
+	return &message, nil
+}
+
+// EditMessageText заменяет текст отправленного ботом сообщения
+func (b *Bot) EditMessageText(ctx context.Context, chatID int64, msgID int, text string) (_ *tgbotapi.Message, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.editMessageText", attribute.Int64("telegram.chat_id", chatID))
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonZero("message_id", msgID)
+	params.AddNonEmpty("text", text)
+
+	var message tgbotapi.Message
+	err = b.scheduler.Do(ctx, "editMessageText", chatID, func() error {
+		return b.request(ctx, "editMessageText", params, &message)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("editMessageText").Inc()
+		return nil, fmt.Errorf("edit message (%v) in chat (%v): %w", msgID, chatID, err)
+	}
+
+	return &message, nil
+}
+
+// SendChatAction показывает в чате статус бота, например tgbotapi.ChatTyping. Статус гаснет через 5 секунд
+func (b *Bot) SendChatAction(ctx context.Context, chatID int64, action string) (err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.sendChatAction", attribute.Int64("telegram.chat_id", chatID))
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonEmpty("action", action)
+
+	// статус не сообщение, лимит чата на него не тратится
+	err = b.scheduler.Do(ctx, "sendChatAction", 0, func() error {
+		return b.request(ctx, "sendChatAction", params, nil)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("sendChatAction").Inc()
+		return fmt.Errorf("send chat action %s to chat (%v): %w", action, chatID, err)
+	}
+	return nil
+}
+
+// SendDocument отправляет в чат файл name с подписью caption
+func (b *Bot) SendDocument(ctx context.Context, chatID int64, name string, data []byte, caption string) (_ *tgbotapi.Message, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.sendDocument",
+		attribute.Int64("telegram.chat_id", chatID), attribute.Int("telegram.file_size", len(data)))
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonZero64("chat_id", chatID)
+	params.AddNonEmpty("caption", caption)
+
+	var message tgbotapi.Message
+	err = b.scheduler.Do(ctx, "sendDocument", chatID, func() error {
+		return b.upload(ctx, "sendDocument", params, "document", name, data, &message)
+	})
 	if err != nil {
-		return nil, fmt.Errorf("send message (%v), err: %w", msg, err)
+		metrics.TelegramErrors.WithLabelValues("sendDocument").Inc()
+		return nil, fmt.Errorf("send document %s to chat (%v): %w", name, chatID, err)
 	}
 
 	return &message, nil
 }
+
+// DownloadFile скачивает файл из сообщения по его file_id
+func (b *Bot) DownloadFile(ctx context.Context, fileID string) (data []byte, err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.downloadFile")
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonEmpty("file_id", fileID)
+
+	var file tgbotapi.File
+	err = b.scheduler.Do(ctx, "getFile", 0, func() error {
+		return b.request(ctx, "getFile", params, &file)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("getFile").Inc()
+		return nil, fmt.Errorf("get file %s: %w", fileID, err)
+	}
+
+	err = b.scheduler.Do(ctx, "downloadFile", 0, func() (err error) {
+		data, err = b.download(ctx, file.FilePath)
+		return err
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("downloadFile").Inc()
+		return nil, fmt.Errorf("download file %s: %w", fileID, err)
+	}
+
+	return data, nil
+}
+
+// AnswerCallbackQuery отвечает на нажатие inline-кнопки, text показывается пользователю всплывающим уведомлением
+func (b *Bot) AnswerCallbackQuery(ctx context.Context, callbackID, text string) (err error) {
+	ctx, span := tracing.Start(ctx, "bot", "telegram.answerCallbackQuery")
+	defer func() { tracing.End(span, err) }()
+
+	params := make(tgbotapi.Params)
+	params.AddNonEmpty("callback_query_id", callbackID)
+	params.AddNonEmpty("text", text)
+
+	err = b.scheduler.Do(ctx, "answerCallbackQuery", 0, func() error {
+		return b.request(ctx, "answerCallbackQuery", params, nil)
+	})
+	if err != nil {
+		metrics.TelegramErrors.WithLabelValues("answerCallbackQuery").Inc()
+		return fmt.Errorf("answer callback query: %w", err)
+	}
+	return nil
+}
@@ -4,289 +4,230 @@ import (
 	"context"
 	"encoding/json"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/go-telegram/bot"
-	"github.com/mytelegrambot/deepseek"
-	"github.com/mytelegrambot/storage"
-
-	"github.com/mytelegrambot/config"
-	"github.com/mytelegrambot/models"
 	"github.com/stretchr/testify/require"
-	"reflect"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func Test_parseChoices(t *testing.T) {
-	type args struct {
-		data string
+// fakeDeleteServer отвечает на deleteMessages/deleteMessage и запоминает размеры пачек
+type fakeDeleteServer struct {
+	mu          sync.Mutex
+	batches     []int
+	undeletable map[int]bool
+}
+
+func (f *fakeDeleteServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	// валидный ответ с choices
-	response, _ := json.Marshal(models.CompletionResponse{
-		ID:       "123",
-		Provider: "test-ai",
-		Model:    "test-model",
-		Choices: []models.Choice{
-			{
-				Message: models.R1Message{
-					Role:    "assistant",
-					Content: "Ответ",
-				},
-			},
-		},
-		Usage: models.Usage{
-			TotalTokens: 15,
-		},
-	})
 
-	// ответ без choices, но с ошибкой
-	noTokenErr, _ := json.Marshal(models.ErrorR1Message{
-		Message: "Rate limit exceeded: free-models-per-day. Add 10 credits to unlock 1000 free model requests per day",
-		Code:    429,
-	})
+	var ids []int
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/deleteMessages"):
+		if err := json.Unmarshal([]byte(r.FormValue("message_ids")), &ids); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.batches = append(f.batches, len(ids))
+	case strings.HasSuffix(r.URL.Path, "/deleteMessage"):
+		id, _ := strconv.Atoi(r.FormValue("message_id"))
+		ids = []int{id}
+	}
+
+	for _, id := range ids {
+		if f.undeletable[id] {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":400,"description":"Bad Request: message can't be deleted"}`))
+			return
+		}
+	}
+	_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+}
+
+func newTestBot(t *testing.T, handler http.Handler) *Bot {
+	t.Helper()
+
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
 
+	// общий лимит не должен замедлять удаление по одному в тестах
+	limits := DefaultLimits()
+	limits.Global, limits.GlobalBurst = 1000, 1000
+	return New("test-token", WithServerURL(srv.URL), WithLimits(limits))
+}
+
+func ids(from, to int) []int {
+	var result []int
+	for i := from; i <= to; i++ {
+		result = append(result, i)
+	}
+	return result
+}
+
+func TestBot_DeleteMessages(t *testing.T) {
 	tests := []struct {
-		name    string
-		args    args
-		want    []string
-		wantErr bool
+		name        string
+		messageIDs  []int
+		undeletable map[int]bool
+		wantBatches []int
+		wantFailed  []int
 	}{
 		{
-			name:    "valid completion response",
-			args:    args{data: string(response)},
-			want:    []string{"Ответ", "потрачено 15 токенов"},
-			wantErr: false,
+			name:        "no messages",
+			messageIDs:  nil,
+			wantBatches: nil,
+			wantFailed:  nil,
 		},
 		{
-			name:    "valid error response",
-			args:    args{data: string(noTokenErr)},
-			want:    []string{"Закончились токены! Попробуйте завтра"},
-			wantErr: false,
+			name:        "single batch",
+			messageIDs:  ids(1, 10),
+			wantBatches: []int{10},
+			wantFailed:  nil,
 		},
 		{
-			name:    "invalid JSON",
-			args:    args{data: "{"},
-			want:    nil,
-			wantErr: true,
+			name:        "split into batches of 100",
+			messageIDs:  ids(1, 250),
+			wantBatches: []int{100, 100, 50},
+			wantFailed:  nil,
+		},
+		{
+			name:        "old messages are reported, others deleted",
+			messageIDs:  ids(1, 150),
+			undeletable: map[int]bool{3: true, 120: true},
+			wantBatches: []int{100, 50},
+			wantFailed:  []int{3, 120},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseChoices(tt.args.data)
-			if tt.wantErr {
-				require.Error(t, err)
-			} else {
-				require.NoError(t, err)
-				require.Equal(t, tt.want, got)
-			}
-		})
-	}
-}
+			srv := &fakeDeleteServer{undeletable: tt.undeletable}
+			b := newTestBot(t, srv)
 
-func TestBot_GetUpdates(t *testing.T) {
-	type fields struct {
-		api     *tgbotapi.BotAPI
-		storage storage.Storage
-		r1      deepseek.R1
-	}
-	type args struct {
-		ctx context.Context
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bot{
-				api:     tt.fields.api,
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-			}
-			if err := b.GetUpdates(tt.args.ctx); (err != nil) != tt.wantErr {
-				t.Errorf("GetUpdates() error = %v, wantErr %v", err, tt.wantErr)
-			}
+			failed, err := b.DeleteMessages(context.Background(), 1, tt.messageIDs)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFailed, failed)
+			require.Equal(t, tt.wantBatches, srv.batches)
 		})
 	}
 }
 
-func TestBot_processIncoming(t *testing.T) {
-	type fields struct {
-		api     *tgbotapi.BotAPI
-		storage storage.Storage
-		r1      deepseek.R1
-	}
-	type args struct {
-		ctx context.Context
-		msg *tgbotapi.Message
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bot{
-				api:     tt.fields.api,
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-			}
-			if err := b.processIncoming(tt.args.ctx, tt.args.msg); (err != nil) != tt.wantErr {
-				t.Errorf("processIncoming() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
+func TestBot_DeleteMessages_ContextCancelled(t *testing.T) {
+	b := newTestBot(t, &fakeDeleteServer{})
 
-func TestBot_saveMessage(t *testing.T) {
-	type fields struct {
-		api     *tgbotapi.BotAPI
-		storage storage.Storage
-		r1      deepseek.R1
-	}
-	type args struct {
-		ctx context.Context
-		m   *models.Message
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bot{
-				api:     tt.fields.api,
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-			}
-			if err := b.saveMessage(tt.args.ctx, tt.args.m); (err != nil) != tt.wantErr {
-				t.Errorf("saveMessage() error = %v, wantErr %v", err, tt.wantErr)
-			}
-		})
-	}
-}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
 
-func TestBot_sendAnswer(t *testing.T) {
-	type fields struct {
-		api     *tgbotapi.BotAPI
-		storage storage.Storage
-		r1      deepseek.R1
-	}
-	type args struct {
-		chatID int64
-		text   string
-	}
-	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    *tgbotapi.Message
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			b := &Bot{
-				api:     tt.fields.api,
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-			}
-			got, err := b.sendAnswer(tt.args.chatID, tt.args.text)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("sendAnswer() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("sendAnswer() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
+	_, err := b.DeleteMessages(ctx, 1, ids(1, 5))
+	require.ErrorIs(t, err, context.Canceled)
 }
 
-func TestNewBot(t *testing.T) {
-	type args struct {
-		config  *config.Config
-		tgbot   *bot.Bot
-		storage storage.Storage
-		r1      deepseek.R1
-	}
-	tests := []struct {
-		name    string
-		args    args
-		want    *Bot
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := NewBot(tt.args.config, tt.args.tgbot, tt.args.storage, tt.args.r1)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("NewBot() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("NewBot() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
+func TestBot_GetUpdates(t *testing.T) {
+	var mu sync.Mutex
+	var offsets []string
+	b := newTestBot(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		mu.Lock()
+		offsets = append(offsets, r.FormValue("offset"))
+		first := len(offsets) == 1
+		mu.Unlock()
+
+		if first {
+			_, _ = w.Write([]byte(`{"ok":true,"result":[{"update_id":5,"message":{"message_id":1,"text":"a"}},{"update_id":6}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":[]}`))
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	updates, err := b.GetUpdates(ctx, 5)
+	require.NoError(t, err)
+
+	require.Equal(t, 5, (<-updates).UpdateID)
+	update := <-updates
+	require.Equal(t, 6, update.UpdateID)
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(offsets) > 1
+	}, time.Second, time.Millisecond)
+	cancel()
+	for range updates {
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, "5", offsets[0])
+	require.Equal(t, "7", offsets[1], "offset follows the last update")
 }
 
-func Test_parseChoices1(t *testing.T) {
-	type args struct {
-		data string
-	}
-	tests := []struct {
-		name    string
-		args    args
-		want    []string
-		wantErr bool
-	}{
-		// TODO: Add test cases.
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := parseChoices(tt.args.data)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("parseChoices() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("parseChoices() got = %v, want %v", got, tt.want)
-			}
-		})
-	}
+func TestBot_SendDocument(t *testing.T) {
+	b := newTestBot(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.True(t, strings.HasSuffix(r.URL.Path, "/bottest-token/sendDocument"))
+		require.NoError(t, r.ParseMultipartForm(1<<20))
+		require.Equal(t, "42", r.FormValue("chat_id"))
+		require.Equal(t, "отчёт", r.FormValue("caption"))
+
+		file, header, err := r.FormFile("document")
+		require.NoError(t, err)
+		content, err := io.ReadAll(file)
+		require.NoError(t, err)
+		require.Equal(t, "report.txt", header.Filename)
+		require.Equal(t, "hello", string(content))
+
+		_, _ = w.Write([]byte(`{"ok":true,"result":{"message_id":9,"document":{"file_id":"f1"}}}`))
+	}))
+
+	message, err := b.SendDocument(context.Background(), 42, "report.txt", []byte("hello"), "отчёт")
+	require.NoError(t, err)
+	require.Equal(t, 9, message.MessageID)
+	require.Equal(t, "f1", message.Document.FileID)
 }
 
-func Test_truncate(t *testing.T) {
-	type args struct {
-		s        string
-		maxRunes int
-	}
+func TestBot_errors(t *testing.T) {
 	tests := []struct {
-		name string
-		args args
-		want string
+		name          string
+		status        int
+		body          string
+		wantCode      int
+		wantTransient bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:     "api error",
+			status:   http.StatusForbidden,
+			body:     `{"ok":false,"error_code":403,"description":"Forbidden: bot was blocked by the user"}`,
+			wantCode: 403,
+		},
+		{
+			name:          "proxy error page",
+			status:        http.StatusBadGateway,
+			body:          `<html>502 Bad Gateway</html>`,
+			wantCode:      502,
+			wantTransient: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := truncate(tt.args.s, tt.args.maxRunes); got != tt.want {
-				t.Errorf("truncate() = %v, want %v", got, tt.want)
-			}
+			b := newTestBot(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+
+			err := b.request(context.Background(), "sendMessage", nil, nil)
+			var apiErr *tgbotapi.Error
+			require.ErrorAs(t, err, &apiErr)
+			require.Equal(t, tt.wantCode, apiErr.Code)
+			require.Equal(t, tt.wantTransient, IsTransient(err))
 		})
 	}
 }
@@ -0,0 +1,235 @@
+// Package bottest содержит реализацию bot.BotAPI в памяти для тестов сервиса
+package bottest
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/bot"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Call - вызов метода бота
+type Call struct {
+	Method     string
+	ChatID     int64
+	Text       string
+	MessageID  int
+	MessageIDs []int
+	Keyboard   *tgbotapi.InlineKeyboardMarkup
+}
+
+// Recorder записывает вызовы bot.BotAPI и отвечает на них без сети. Ошибки задаются через FailNext
+type Recorder struct {
+	// Updates отдаётся из GetUpdates, закрытие канала завершает приём апдейтов
+	Updates chan tgbotapi.Update
+	// Files - содержимое файлов для DownloadFile по file_id
+	Files map[string][]byte
+
+	mu            sync.Mutex
+	calls         []Call
+	commands      []tgbotapi.BotCommand
+	failures      map[string][]error
+	nextMessageID int
+}
+
+var _ bot.BotAPI = (*Recorder)(nil)
+
+// NewRecorder создаёт бота с зарегистрированными commands, их возвращает GetMyCommands
+func NewRecorder(commands ...string) *Recorder {
+	r := &Recorder{
+		Updates:       make(chan tgbotapi.Update, 100),
+		Files:         make(map[string][]byte),
+		failures:      make(map[string][]error),
+		nextMessageID: 1000,
+	}
+	for _, command := range commands {
+		r.commands = append(r.commands, tgbotapi.BotCommand{Command: command, Description: command})
+	}
+	return r
+}
+
+// FailNext заставляет следующие вызовы method вернуть errs по одной
+func (r *Recorder) FailNext(method string, errs ...error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures[method] = append(r.failures[method], errs...)
+}
+
+// Calls возвращает вызовы methods в порядке выполнения, без methods - все вызовы
+func (r *Recorder) Calls(methods ...string) []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var calls []Call
+	for _, call := range r.calls {
+		if len(methods) == 0 || slices.Contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Sent возвращает тексты отправленных сообщений
+func (r *Recorder) Sent() []string {
+	var texts []string
+	for _, call := range r.Calls("SendMessage") {
+		texts = append(texts, call.Text)
+	}
+	return texts
+}
+
+// record запоминает вызов и возвращает ошибку, заданную FailNext, или ошибку контекста
+func (r *Recorder) record(ctx context.Context, call Call) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, call)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failures := r.failures[call.Method]; len(failures) > 0 {
+		r.failures[call.Method] = failures[1:]
+		return failures[0]
+	}
+	return nil
+}
+
+func (r *Recorder) message(chatID int64, text string) *tgbotapi.Message {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextMessageID++
+	return &tgbotapi.Message{
+		MessageID: r.nextMessageID,
+		From:      &tgbotapi.User{ID: 1, IsBot: true, UserName: "test_bot"},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Text:      text,
+	}
+}
+
+func (r *Recorder) GetUpdates(ctx context.Context, offset int) (<-chan tgbotapi.Update, error) {
+	if err := r.record(ctx, Call{Method: "GetUpdates", MessageID: offset}); err != nil {
+		return nil, err
+	}
+	return r.Updates, nil
+}
+
+func (r *Recorder) SendMessage(ctx context.Context, chatID int64, text string) (*tgbotapi.Message, error) {
+	if err := r.record(ctx, Call{Method: "SendMessage", ChatID: chatID, Text: text}); err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	return r.message(chatID, text), nil
+}
+
+// SendMessageWithKeyboard записывается как SendMessage с заполненным Keyboard
+func (r *Recorder) SendMessageWithKeyboard(ctx context.Context, chatID int64, text string, keyboard tgbotapi.InlineKeyboardMarkup) (*tgbotapi.Message, error) {
+	if err := r.record(ctx, Call{Method: "SendMessage", ChatID: chatID, Text: text, Keyboard: &keyboard}); err != nil {
+		return nil, fmt.Errorf("send message: %w", err)
+	}
+	msg := r.message(chatID, text)
+	msg.ReplyMarkup = &keyboard
+	return msg, nil
+}
+
+func (r *Recorder) EditMessageText(ctx context.Context, chatID int64, msgID int, text string) (*tgbotapi.Message, error) {
+	if err := r.record(ctx, Call{Method: "EditMessageText", ChatID: chatID, MessageID: msgID, Text: text}); err != nil {
+		return nil, fmt.Errorf("edit message: %w", err)
+	}
+	msg := r.message(chatID, text)
+	msg.MessageID = msgID
+	return msg, nil
+}
+
+func (r *Recorder) DeleteMessages(ctx context.Context, chatID int64, messageIDs []int) ([]int, error) {
+	if err := r.record(ctx, Call{Method: "DeleteMessages", ChatID: chatID, MessageIDs: slices.Clone(messageIDs)}); err != nil {
+		return nil, fmt.Errorf("delete messages: %w", err)
+	}
+	return nil, nil
+}
+
+// HandleCommand только записывает вызов, ответы команд /start и /help проверяются в пакете bot
+func (r *Recorder) HandleCommand(ctx context.Context, msg *tgbotapi.Message, msgIDs []int) (*tgbotapi.Message, error) {
+	call := Call{Method: "HandleCommand", ChatID: msg.Chat.ID, Text: msg.Command(), MessageIDs: slices.Clone(msgIDs)}
+	if err := r.record(ctx, call); err != nil {
+		return nil, fmt.Errorf("handle command: %w", err)
+	}
+	return nil, nil
+}
+
+func (r *Recorder) GetMyCommands(ctx context.Context) ([]tgbotapi.BotCommand, error) {
+	if err := r.record(ctx, Call{Method: "GetMyCommands"}); err != nil {
+		return nil, fmt.Errorf("get commands: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.commands), nil
+}
+
+// SetMyCommands заменяет команды, которые возвращает GetMyCommands
+func (r *Recorder) SetMyCommands(ctx context.Context, commands []tgbotapi.BotCommand) error {
+	var names []string
+	for _, command := range commands {
+		names = append(names, command.Command)
+	}
+	if err := r.record(ctx, Call{Method: "SetMyCommands", Text: strings.Join(names, " ")}); err != nil {
+		return fmt.Errorf("set commands: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = slices.Clone(commands)
+	return nil
+}
+
+func (r *Recorder) DeleteMessage(ctx context.Context, chatID int64, msgID int) error {
+	if err := r.record(ctx, Call{Method: "DeleteMessage", ChatID: chatID, MessageID: msgID}); err != nil {
+		return fmt.Errorf("delete message: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) GetMe(ctx context.Context) (tgbotapi.User, error) {
+	if err := r.record(ctx, Call{Method: "GetMe"}); err != nil {
+		return tgbotapi.User{}, fmt.Errorf("get me: %w", err)
+	}
+	return tgbotapi.User{ID: 1, IsBot: true, UserName: "test_bot"}, nil
+}
+
+func (r *Recorder) SendChatAction(ctx context.Context, chatID int64, action string) error {
+	if err := r.record(ctx, Call{Method: "SendChatAction", ChatID: chatID, Text: action}); err != nil {
+		return fmt.Errorf("send chat action: %w", err)
+	}
+	return nil
+}
+
+func (r *Recorder) SendDocument(ctx context.Context, chatID int64, name string, data []byte, caption string) (*tgbotapi.Message, error) {
+	if err := r.record(ctx, Call{Method: "SendDocument", ChatID: chatID, Text: name}); err != nil {
+		return nil, fmt.Errorf("send document: %w", err)
+	}
+	msg := r.message(chatID, "")
+	msg.Caption = caption
+	msg.Document = &tgbotapi.Document{FileName: name, FileSize: len(data)}
+	return msg, nil
+}
+
+func (r *Recorder) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	if err := r.record(ctx, Call{Method: "DownloadFile", Text: fileID}); err != nil {
+		return nil, fmt.Errorf("download file: %w", err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	data, ok := r.Files[fileID]
+	if !ok {
+		return nil, &tgbotapi.Error{Code: 400, Message: "Bad Request: invalid file_id"}
+	}
+	return data, nil
+}
+
+func (r *Recorder) AnswerCallbackQuery(ctx context.Context, callbackID, text string) error {
+	if err := r.record(ctx, Call{Method: "AnswerCallbackQuery", Text: text}); err != nil {
+		return fmt.Errorf("answer callback query: %w", err)
+	}
+	return nil
+}
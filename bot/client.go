@@ -0,0 +1,203 @@
+package bot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// defaultServerURL - адрес Telegram Bot API. WithServerURL заменяет его на локальный Bot API или фейковый сервер
+const defaultServerURL = "https://api.telegram.org"
+
+// defaultClientTimeout больше таймаута long polling, остальные запросы ограничиваются контекстом
+const defaultClientTimeout = time.Minute
+
+// maxDownloadSize - Bot API не отдаёт ботам файлы больше 20 МБ
+const maxDownloadSize = 20 << 20
+
+type Option func(*Bot)
+
+// WithHTTPClient задаёт HTTP-клиент, например с собственным http.RoundTripper
+func WithHTTPClient(client *http.Client) Option {
+	return func(b *Bot) {
+		b.client = client
+	}
+}
+
+// WithServerURL направляет запросы на другой сервер Bot API
+func WithServerURL(serverURL string) Option {
+	return func(b *Bot) {
+		b.serverURL = strings.TrimSuffix(serverURL, "/")
+	}
+}
+
+// WithDebug пишет в лог на уровне debug каждый запрос и ответ Telegram
+func WithDebug() Option {
+	return func(b *Bot) {
+		b.debug = true
+	}
+}
+
+// WithLimits заменяет ограничения частоты запросов по умолчанию
+func WithLimits(limits Limits) Option {
+	return func(b *Bot) {
+		b.scheduler = NewScheduler(limits)
+	}
+}
+
+// New создаёт клиента Bot API без обращения к Telegram
+func New(token string, opts ...Option) *Bot {
+	b := &Bot{
+		token:     token,
+		serverURL: defaultServerURL,
+		client:    &http.Client{Timeout: defaultClientTimeout},
+		scheduler: NewScheduler(DefaultLimits()),
+	}
+	for _, opt := range opts {
+		opt(b)
+	}
+	return b
+}
+
+// request вызывает метод Bot API и декодирует поле result в result.
+// Ответ с ok=false возвращается как *tgbotapi.Error, чтобы по коду и retry_after можно было решить, повторять ли запрос
+func (b *Bot) request(ctx context.Context, method string, params tgbotapi.Params, result any) error {
+	values := make(url.Values, len(params))
+	for key, value := range params {
+		values.Set(key, value)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.methodURL(method), strings.NewReader(values.Encode()))
+	if err != nil {
+		return fmt.Errorf("building %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	if b.debug {
+		logger.FromContext(ctx).Debugw("telegram request", "method", method, "params", params)
+	}
+	return b.do(ctx, method, req, result)
+}
+
+// upload вызывает метод Bot API с файлом в поле field
+func (b *Bot) upload(ctx context.Context, method string, params tgbotapi.Params, field, name string, data []byte, result any) error {
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range params {
+		if err := form.WriteField(key, value); err != nil {
+			return fmt.Errorf("writing %s field %s: %w", method, key, err)
+		}
+	}
+	part, err := form.CreateFormFile(field, name)
+	if err != nil {
+		return fmt.Errorf("writing %s file: %w", method, err)
+	}
+	if _, err = part.Write(data); err != nil {
+		return fmt.Errorf("writing %s file: %w", method, err)
+	}
+	if err = form.Close(); err != nil {
+		return fmt.Errorf("writing %s form: %w", method, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, b.methodURL(method), &body)
+	if err != nil {
+		return fmt.Errorf("building %s request: %w", method, err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	if b.debug {
+		logger.FromContext(ctx).Debugw("telegram request", "method", method, "params", params, "file", name, "size", len(data))
+	}
+	return b.do(ctx, method, req, result)
+}
+
+func (b *Bot) do(ctx context.Context, method string, req *http.Request, result any) error {
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s request: %w", method, b.hideToken(err))
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("reading %s response: %w", method, err)
+	}
+	if b.debug {
+		logger.FromContext(ctx).Debugw("telegram response", "method", method, "status", resp.StatusCode, "body", string(data))
+	}
+
+	var apiResp tgbotapi.APIResponse
+	if err = json.Unmarshal(data, &apiResp); err != nil {
+		if resp.StatusCode >= http.StatusInternalServerError {
+			// прокси перед Telegram отвечает HTML, ошибка всё равно временная
+			return &tgbotapi.Error{Code: resp.StatusCode, Message: resp.Status}
+		}
+		return fmt.Errorf("decoding %s response: %w", method, err)
+	}
+
+	if !apiResp.Ok {
+		apiErr := &tgbotapi.Error{Code: apiResp.ErrorCode, Message: apiResp.Description}
+		if apiResp.Parameters != nil {
+			apiErr.ResponseParameters = *apiResp.Parameters
+		}
+		return apiErr
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err = json.Unmarshal(apiResp.Result, result); err != nil {
+		return fmt.Errorf("decoding %s result: %w", method, err)
+	}
+	return nil
+}
+
+// download скачивает файл по пути, полученному из getFile
+func (b *Bot) download(ctx context.Context, filePath string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.serverURL+"/file/bot"+b.token+"/"+filePath, nil)
+	if err != nil {
+		return nil, fmt.Errorf("building file request: %w", err)
+	}
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("file request: %w", b.hideToken(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &tgbotapi.Error{Code: resp.StatusCode, Message: resp.Status}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading file: %w", err)
+	}
+	if len(data) > maxDownloadSize {
+		return nil, fmt.Errorf("file is larger than %d bytes", maxDownloadSize)
+	}
+	return data, nil
+}
+
+func (b *Bot) methodURL(method string) string {
+	return b.serverURL + "/bot" + b.token + "/" + method
+}
+
+// hideToken убирает токен бота из адреса в ошибке HTTP-клиента, чтобы он не попал в логи
+func (b *Bot) hideToken(err error) error {
+	var urlErr *url.Error
+	if b.token != "" && errors.As(err, &urlErr) {
+		urlErr.URL = strings.ReplaceAll(urlErr.URL, b.token, "<token>")
+	}
+	return err
+}
@@ -0,0 +1,306 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
+	"io"
+	"math/rand/v2"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Limits - ограничения частоты запросов к Telegram и политика повторов
+type Limits struct {
+	// Global - запросов в секунду на весь бот (Telegram допускает около 30 сообщений в секунду)
+	Global      float64
+	GlobalBurst int
+	// PrivateChat и GroupChat - сообщений в секунду в один чат. В группы Telegram разрешает не больше 20 в минуту
+	PrivateChat float64
+	GroupChat   float64
+	ChatBurst   int
+	// MaxRetries - сколько раз повторяется запрос после 429 или сетевой ошибки
+	MaxRetries int
+	// MaxRetryAfter - если Telegram просит ждать дольше, запрос не повторяется, а возвращается ошибка
+	MaxRetryAfter time.Duration
+	// Backoff - задержка перед первым повтором после сетевой ошибки, дальше удваивается до MaxBackoff
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultLimits - ограничения из документации Telegram Bot API
+func DefaultLimits() Limits {
+	return Limits{
+		Global:        30,
+		GlobalBurst:   30,
+		PrivateChat:   1,
+		GroupChat:     20.0 / 60,
+		ChatBurst:     3,
+		MaxRetries:    3,
+		MaxRetryAfter: time.Minute,
+		Backoff:       500 * time.Millisecond,
+		MaxBackoff:    10 * time.Second,
+	}
+}
+
+// maxIdleChats - сколько ограничителей чатов хранится, прежде чем простаивающие будут удалены
+const maxIdleChats = 1024
+
+// idempotentMethods - методы, которые можно повторить, даже если первый запрос дошёл до Telegram.
+// Остальные (sendMessage, sendDocument, editMessageText) после таймаута или 5xx могли выполниться,
+// поэтому повторяются, только если запрос не был отправлен (isUnsent) или Telegram ответил 429
+var idempotentMethods = map[string]bool{
+	"getMe":               true,
+	"getMyCommands":       true,
+	"setMyCommands":       true,
+	"deleteMessage":       true,
+	"deleteMessages":      true,
+	"sendChatAction":      true,
+	"getFile":             true,
+	"downloadFile":        true,
+	"answerCallbackQuery": true,
+}
+
+// Scheduler пропускает запросы к Telegram через общий и початовые token bucket, а запросы,
+// завершившиеся 429 или временной сетевой ошибкой, повторяет. Отправку и редактирование сообщений
+// после временной ошибки повторяет, только если соединение не было установлено
+type Scheduler struct {
+	limits Limits
+	now    func() time.Time
+
+	mu     sync.Mutex
+	global *tokenBucket
+	chats  map[int64]*tokenBucket
+}
+
+func NewScheduler(limits Limits) *Scheduler {
+	return &Scheduler{
+		limits: limits,
+		now:    time.Now,
+		global: newTokenBucket(limits.Global, limits.GlobalBurst),
+		chats:  make(map[int64]*tokenBucket),
+	}
+}
+
+// Do выполняет call с учётом ограничений. chatID == 0 - запрос не отправляет сообщений в чат
+// и ограничивается только общим лимитом
+func (s *Scheduler) Do(ctx context.Context, method string, chatID int64, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		if err := s.wait(ctx, chatID); err != nil {
+			return fmt.Errorf("waiting for telegram rate limit: %w", err)
+		}
+
+		err := call()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+
+		reason, delay, retry := s.retryDelay(method, err, attempt)
+		if !retry || attempt >= s.limits.MaxRetries {
+			return err
+		}
+		metrics.TelegramRetries.WithLabelValues(method, reason).Inc()
+		logger.FromContext(ctx).Warnw("telegram request failed, retrying",
+			"method", method, "chat_id", chatID, "attempt", attempt+1, "reason", reason, "delay", delay, "error", err)
+
+		if reason == "rate_limited" {
+			// Telegram сообщает, сколько ждать, дальше ожидание выполнит wait
+			s.pause(chatID, s.now().Add(delay))
+			continue
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return fmt.Errorf("waiting to retry %s: %w", method, err)
+		}
+	}
+}
+
+// retryDelay решает, стоит ли повторять запрос method после err и через сколько
+func (s *Scheduler) retryDelay(method string, err error, attempt int) (reason string, delay time.Duration, retry bool) {
+	if retryAfter, ok := RetryAfter(err); ok {
+		return "rate_limited", retryAfter, retryAfter <= s.limits.MaxRetryAfter
+	}
+	if !IsTransient(err) {
+		return "", 0, false
+	}
+	if !idempotentMethods[method] && !isUnsent(err) {
+		// повтор мог бы отправить сообщение второй раз
+		return "", 0, false
+	}
+
+	delay = min(s.limits.Backoff<<attempt, s.limits.MaxBackoff)
+	// случайная добавка, чтобы повторы разных запросов не совпадали
+	delay = delay/2 + rand.N(delay/2+1)
+	return "transient", delay, true
+}
+
+// wait блокируется, пока общий лимит и лимит чата не позволят выполнить запрос
+func (s *Scheduler) wait(ctx context.Context, chatID int64) error {
+	for {
+		delay := s.reserve(chatID)
+		if delay == 0 {
+			return nil
+		}
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reserve забирает токены, если они есть в обоих bucket, иначе возвращает, сколько ждать
+func (s *Scheduler) reserve(chatID int64) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	delay := s.global.delay(now)
+	var chat *tokenBucket
+	if chatID != 0 {
+		chat = s.chat(chatID, now)
+		delay = max(delay, chat.delay(now))
+	}
+	if delay > 0 {
+		return delay
+	}
+
+	s.global.take()
+	if chat != nil {
+		chat.take()
+	}
+	return 0
+}
+
+// chat возвращает bucket чата, попутно удаляя восполнившиеся bucket простаивающих чатов
+func (s *Scheduler) chat(chatID int64, now time.Time) *tokenBucket {
+	if bucket, ok := s.chats[chatID]; ok {
+		return bucket
+	}
+
+	if len(s.chats) >= maxIdleChats {
+		for id, bucket := range s.chats {
+			if bucket.full(now) {
+				delete(s.chats, id)
+			}
+		}
+	}
+
+	rate := s.limits.PrivateChat
+	if chatID < 0 {
+		// у групп и каналов отрицательные ID
+		rate = s.limits.GroupChat
+	}
+	bucket := newTokenBucket(rate, s.limits.ChatBurst)
+	s.chats[chatID] = bucket
+	return bucket
+}
+
+// pause запрещает запросы в чат (или все запросы, если chatID == 0) до until
+func (s *Scheduler) pause(chatID int64, until time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if chatID == 0 {
+		s.global.pause(until)
+		return
+	}
+	s.chat(chatID, s.now()).pause(until)
+}
+
+// RetryAfter возвращает время ожидания из ответа 429 Too Many Requests
+func RetryAfter(err error) (time.Duration, bool) {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return time.Duration(apiErr.RetryAfter) * time.Second, true
+	}
+	return 0, false
+}
+
+// IsTransient сообщает, что ошибка сетевая или Telegram временно недоступен. Запрос при этом
+// мог и выполниться, поэтому повторять без проверки можно только идемпотентные методы
+func IsTransient(err error) bool {
+	var apiErr *tgbotapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// isUnsent сообщает, что запрос точно не дошёл до Telegram: не удалось установить соединение
+func isUnsent(err error) bool {
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+	return (errors.As(err, &opErr) && opErr.Op == "dial") ||
+		errors.As(err, &dnsErr) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func sleep(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// tokenBucket пополняется на rate токенов в секунду, но не больше burst
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// paused - до этого времени токены не выдаются (retry_after)
+	paused time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst)}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// delay пополняет bucket и возвращает, через сколько в нём появится токен
+func (b *tokenBucket) delay(now time.Time) time.Duration {
+	b.refill(now)
+	if b.paused.After(now) {
+		return b.paused.Sub(now)
+	}
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+}
+
+func (b *tokenBucket) take() {
+	b.tokens--
+}
+
+func (b *tokenBucket) pause(until time.Time) {
+	if until.After(b.paused) {
+		b.paused = until
+	}
+}
+
+func (b *tokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= b.burst && !b.paused.After(now)
+}
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler_reserve(t *testing.T) {
+	now := time.Now()
+	s := NewScheduler(Limits{Global: 2, GlobalBurst: 3, PrivateChat: 1, GroupChat: 0.5, ChatBurst: 1})
+	s.now = func() time.Time { return now }
+
+	require.Zero(t, s.reserve(1))
+	require.Equal(t, time.Second, s.reserve(1), "one message per second to a chat")
+	require.Zero(t, s.reserve(2))
+	require.Zero(t, s.reserve(0), "requests without a chat use only the global limit")
+	require.Equal(t, 500*time.Millisecond, s.reserve(3), "global burst is spent")
+
+	now = now.Add(500 * time.Millisecond)
+	require.Zero(t, s.reserve(-100))
+	require.Equal(t, 2*time.Second, s.reserve(-100), "groups are slower")
+
+	s.pause(3, now.Add(5*time.Second))
+	now = now.Add(time.Second)
+	require.Equal(t, 4*time.Second, s.reserve(3), "retry_after pauses the chat")
+	require.Zero(t, s.reserve(4), "other chats are not paused")
+}
+
+func TestScheduler_Do(t *testing.T) {
+	limits := DefaultLimits()
+	limits.Backoff = time.Millisecond
+	limits.MaxBackoff = 10 * time.Millisecond
+	timeout := &net.OpError{Op: "read", Err: errors.New("i/o timeout")}
+	refused := &net.OpError{Op: "dial", Err: errors.New("connection refused")}
+
+	tests := []struct {
+		name      string
+		method    string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "success",
+			method:    "sendMessage",
+			wantCalls: 1,
+		},
+		{
+			name:      "network error is retried",
+			method:    "deleteMessages",
+			errs:      []error{refused, &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}},
+			wantCalls: 3,
+		},
+		{
+			name:      "bad request is not retried",
+			method:    "deleteMessages",
+			errs:      []error{&tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "retry_after longer than allowed",
+			method:    "sendMessage",
+			errs:      []error{&tgbotapi.Error{Code: 429, ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 3600}}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "retries are limited",
+			method:    "getMe",
+			errs:      []error{timeout, timeout, timeout, timeout, timeout},
+			wantCalls: limits.MaxRetries + 1,
+			wantErr:   true,
+		},
+		{
+			name:      "send is retried if the connection failed",
+			method:    "sendMessage",
+			errs:      []error{refused},
+			wantCalls: 2,
+		},
+		{
+			name:      "send is not retried after a timeout",
+			method:    "sendMessage",
+			errs:      []error{timeout},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "edit is not retried after 5xx",
+			method:    "editMessageText",
+			errs:      []error{&tgbotapi.Error{Code: 502, Message: "Bad Gateway"}},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := NewScheduler(limits).Do(context.Background(), tt.method, 0, func() error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+
+			require.Equal(t, tt.wantCalls, calls)
+			require.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestBot_SendMessage_timeoutAfterReceived(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Telegram получил запрос и, возможно, отправил сообщение, но ответ не успевает дойти
+		calls.Add(1)
+		_, _ = io.Copy(io.Discard, r.Body)
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+
+	limits := DefaultLimits()
+	limits.Backoff, limits.MaxBackoff = time.Millisecond, time.Millisecond
+	b := New("test-token", WithServerURL(srv.URL), WithLimits(limits),
+		WithHTTPClient(&http.Client{Timeout: 50 * time.Millisecond}))
+
+	_, err := b.SendMessage(context.Background(), 1, "ответ")
+	require.Error(t, err)
+	require.True(t, IsTransient(err))
+	require.EqualValues(t, 1, calls.Load(), "a send that may have been delivered is not repeated")
+
+	err = b.DeleteMessage(context.Background(), 1, 10)
+	require.Error(t, err)
+	require.EqualValues(t, 1+1+limits.MaxRetries, calls.Load(), "idempotent methods are retried after a timeout")
+}
+
+func TestBot_DeleteMessage_retryAfter(t *testing.T) {
+	var calls atomic.Int32
+	b := newTestBot(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests: retry after 1","parameters":{"retry_after":1}}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"ok":true,"result":true}`))
+	}))
+
+	start := time.Now()
+	require.NoError(t, b.DeleteMessage(context.Background(), 1, 10))
+	require.EqualValues(t, 2, calls.Load())
+	require.GreaterOrEqual(t, time.Since(start), time.Second, "waits retry_after before the next attempt")
+}
@@ -0,0 +1,49 @@
+package bot
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"sync"
+	"time"
+)
+
+// typingInterval - Telegram показывает статус 5 секунд, поэтому он обновляется чуть чаще
+const typingInterval = 4 * time.Second
+
+// Typing показывает в чате "печатает..." до вызова возвращённой функции или отмены ctx.
+// Ошибки отправки статуса только логируются: ответ важнее индикатора
+func Typing(ctx context.Context, b BotAPI, chatID int64) (stop func()) {
+	return typing(ctx, b, chatID, typingInterval)
+}
+
+func typing(ctx context.Context, b BotAPI, chatID int64, interval time.Duration) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			if err := b.SendChatAction(ctx, chatID, tgbotapi.ChatTyping); err != nil && ctx.Err() == nil {
+				logger.FromContext(ctx).Debugw("sending typing action", "chat_id", chatID, "error", err)
+			}
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			cancel()
+			<-done
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package bot
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// actionBot - бот, который только считает отправленные статусы
+type actionBot struct {
+	BotAPI
+	mu      sync.Mutex
+	actions []string
+}
+
+func (b *actionBot) SendChatAction(ctx context.Context, chatID int64, action string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.actions = append(b.actions, action)
+	return nil
+}
+
+func (b *actionBot) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.actions)
+}
+
+func TestTyping(t *testing.T) {
+	b := &actionBot{}
+
+	stop := typing(context.Background(), b, 1, 5*time.Millisecond)
+	require.Eventually(t, func() bool { return b.count() >= 3 }, time.Second, time.Millisecond, "typing is repeated")
+	stop()
+	stop()
+
+	sent := b.count()
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, sent, b.count(), "no actions after stop")
+	require.Equal(t, "typing", b.actions[0])
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop = Typing(ctx, b, 1)
+	cancel()
+	stop()
+}
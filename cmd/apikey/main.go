@@ -0,0 +1,125 @@
+// Команда apikey выпускает, показывает и отзывает ключи HTTP API бота. Хранилище и подключение
+// к нему берутся из того же конфига, что и у бота (.env, -config или CONFIG_FILE, переменные окружения,
+// флаги перед командой). Токены Telegram и модели для этого не нужны:
+//
+//	apikey create -name grafana -scopes admin:read
+//	apikey -config bot.yaml list
+//	apikey -storage-backend sqlite -sqlite-path bot.db revoke -id 3
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/mytelegrambot/auth"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/database"
+	"github.com/mytelegrambot/storage"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const usage = `usage:
+  apikey [-config FILE] [config flags] create -name NAME -scopes SCOPE[,SCOPE...]
+  apikey [-config FILE] [config flags] list
+  apikey [-config FILE] [config flags] revoke -id ID`
+
+func main() {
+	if err := run(context.Background(), os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, "apikey:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string) error {
+	botCfg, args, err := config.LoadStorage(".env", args)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return errors.New(usage)
+	}
+
+	keyStore, closeStore, err := openStorage(ctx, botCfg)
+	if err != nil {
+		return err
+	}
+	defer closeStore()
+	keys := auth.NewAPIKeys(keyStore)
+
+	command, args := args[0], args[1:]
+	flags := flag.NewFlagSet("apikey "+command, flag.ContinueOnError)
+	switch command {
+	case "create":
+		name := flags.String("name", "", "key name, e.g. the service that uses it")
+		scopes := flags.String("scopes", "", "comma separated scopes: "+strings.Join(auth.Scopes, ", "))
+		if err = flags.Parse(args); err != nil {
+			return err
+		}
+
+		key, record, err := keys.Mint(ctx, *name, strings.Split(*scopes, ","))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("created key %d (%s) with scopes %s\n", record.ID, record.Name, strings.Join(record.Scopes, ","))
+		fmt.Println("store it now, it will not be shown again:")
+		fmt.Println(key)
+	case "list":
+		if err = flags.Parse(args); err != nil {
+			return err
+		}
+
+		list, err := keys.List(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tSCOPES\tCREATED\tREVOKED")
+		for _, key := range list {
+			revoked := "-"
+			if key.RevokedAt != nil {
+				revoked = key.RevokedAt.Format(time.DateTime)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n",
+				key.ID, key.Name, key.Prefix, strings.Join(key.Scopes, ","), key.CreatedAt.Format(time.DateTime), revoked)
+		}
+		return w.Flush()
+	case "revoke":
+		id := flags.Int64("id", 0, "key id from apikey list")
+		if err = flags.Parse(args); err != nil {
+			return err
+		}
+
+		if err = keys.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Printf("revoked key %d\n", *id)
+	default:
+		return fmt.Errorf("unknown command %q\n%s", command, usage)
+	}
+
+	return nil
+}
+
+// openStorage открывает хранилище бота. Ключи в памяти процесса CLI бессмысленны, поэтому memory не поддерживается
+func openStorage(ctx context.Context, botCfg *config.Config) (auth.KeyStore, func(), error) {
+	switch botCfg.StorageBackend {
+	case config.StorageSQLite:
+		sqliteStorage, err := storage.NewSQLiteStorage(ctx, botCfg.SQLitePath)
+		if err != nil {
+			return nil, nil, err
+		}
+		return sqliteStorage, func() { sqliteStorage.Close() }, nil
+	case config.StoragePostgres:
+		pool, err := database.GetPool(ctx, botCfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return storage.NewBotStorage(pool, botCfg), pool.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("storage backend %q keeps no api keys between runs", botCfg.StorageBackend)
+	}
+}
@@ -1,106 +1,292 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"github.com/joho/godotenv"
-	"os"
-	"strconv"
-	"strings"
+	"reflect"
+	"slices"
 	"time"
 )
 
+// Config собирается слоями: значения по умолчанию (Default) → файл YAML/TOML → переменные
+// окружения → флаги командной строки, см. Load. Теги задают ключ в файле (yaml), имя переменной
+// окружения (env, вложенные структуры добавляют префикс) и секретные поля (secret), которые
+// скрываются при выводе конфига в лог
 type Config struct {
-	Token              string
-	ConnString         string
-	MaxPgxConn         int32
-	MaxPgxConnIdleTime time.Duration
-	MaxPgxConnLifeTime time.Duration
-	HealthCheckPeriod  time.Duration
-	R1Token            string
-	R1ProToken         string
-	BotEnv             bool
-	Logger             Logger
+	Token              string         `yaml:"token" env:"TOKEN" secret:"true"`
+	StorageBackend     StorageBackend `yaml:"storage_backend" env:"STORAGE_BACKEND"`
+	SQLitePath         string         `yaml:"sqlite_path" env:"SQLITE_PATH"`
+	ConnString         string         `yaml:"connection_string" env:"CONNECTION_STRING" secret:"true"`
+	MaxPgxConn         int32          `yaml:"max_pgx_conn" env:"MAX_PGX_CONN"`
+	MaxPgxConnIdleTime time.Duration  `yaml:"max_pgx_conn_idle_time" env:"MAX_PGX_CONN_IDLE_TIME"`
+	MaxPgxConnLifeTime time.Duration  `yaml:"max_pgx_conn_lifetime" env:"MAX_PGX_CONN_LIFETIME"`
+	HealthCheckPeriod  time.Duration  `yaml:"health_check_period" env:"HEALTH_CHECK_PERIOD"`
+	R1Token            string         `yaml:"r1_token" env:"R1_TOKEN" secret:"true"`
+	R1ProToken         string         `yaml:"r1_pro_token" env:"R1_PRO_TOKEN" secret:"true"`
+	BotEnv             bool           `yaml:"debug" env:"BOT_ENV"`
+	Logger             Logger         `yaml:"logger" env:"LOG"`
+	Retention          Retention      `yaml:"retention" env:"RETENTION"`
+	AdminToken         string         `yaml:"admin_token" env:"ADMIN_TOKEN" secret:"true"`
+	// AdminTelegramIDs - пользователи Telegram, которым вход через Telegram Login Widget даёт права администратора
+	AdminTelegramIDs    []int64         `yaml:"admin_telegram_ids" env:"ADMIN_TELEGRAM_IDS"`
+	TelegramLoginMaxAge time.Duration   `yaml:"telegram_login_max_age" env:"TELEGRAM_LOGIN_MAX_AGE"`
+	Workers             int             `yaml:"workers" env:"WORKERS"`
+	TracingExporter     TracingExporter `yaml:"tracing_exporter" env:"TRACING_EXPORTER"`
+	ShutdownTimeout     time.Duration   `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// SettingsFile - файл настроек времени выполнения (settings.Settings). Пустой - настройки
+	// хранятся в хранилище бота и меняются через /admin/settings
+	SettingsFile           string        `yaml:"settings_file" env:"SETTINGS_FILE"`
+	SettingsReloadInterval time.Duration `yaml:"settings_reload_interval" env:"SETTINGS_RELOAD_INTERVAL"`
+	// BroadcastRate - сколько сообщений рассылки отправляется в секунду. Telegram допускает около 30
+	// на бота, запас остаётся ответам на вопросы
+	BroadcastRate int `yaml:"broadcast_rate" env:"BROADCAST_RATE"`
+	// LLMFallback - запасные провайдеры моделей для settings.Fallbacks. В переменной окружения
+	// и флаге задаются списком JSON: [{"name":"deepseek","base_url":"...","token":"..."}]
+	LLMFallback []LLMProvider `yaml:"llm_fallback" env:"LLM_FALLBACK"`
 }
 
+// DefaultLLMProvider - имя основного провайдера моделей, OpenRouter с ключом R1ProToken
+const DefaultLLMProvider = "openrouter"
+
+// LLMProvider - OpenAI-совместимый API. Запасные модели ссылаются на него по Name
+type LLMProvider struct {
+	Name    string `json:"name"`
+	BaseURL string `json:"base_url"`
+	Token   string `json:"token"`
+}
+
+// StorageBackend выбирает реализацию storage.Storage
+type StorageBackend string
+
+const (
+	StoragePostgres StorageBackend = "postgres"
+	StorageSQLite   StorageBackend = "sqlite"
+	StorageMemory   StorageBackend = "memory"
+)
+
+// TracingExporter выбирает, куда отправляются спаны OpenTelemetry. Адрес коллектора для otlp
+// задаётся стандартными переменными OTEL_EXPORTER_OTLP_*
+type TracingExporter string
+
+const (
+	TracingNone   TracingExporter = "none"
+	TracingStdout TracingExporter = "stdout"
+	TracingOTLP   TracingExporter = "otlp"
+)
+
+// Retention описывает, как долго хранятся сообщения в каждой из таблиц. Активный диалог
+// (updates_messages) можно только удалять: /restart находит его сообщения по чату
+type Retention struct {
+	Interval        time.Duration   `yaml:"interval" env:"INTERVAL"`
+	UpdatesMessages RetentionPolicy `yaml:"updates_messages" env:"UPDATES"`
+	ArchiveMessages RetentionPolicy `yaml:"archive_messages" env:"ARCHIVE"`
+	// FinishedJobsDays - через сколько дней удаляются отправленные сообщения outbox и обработанные
+	// апдейты update_jobs. В них есть текст и ID чата, но после завершения они не нужны. 0 - хранить всегда
+	FinishedJobsDays int `yaml:"finished_jobs_days" env:"FINISHED_JOBS_DAYS"`
+}
+
+// RetentionPolicy - через сколько дней записи удаляются или обезличиваются. Days == 0 - хранить всегда.
+// Обезличивание стирает чат, автора и текст сообщения, остаются только ID сообщения и время
+type RetentionPolicy struct {
+	Days int           `yaml:"days" env:"DAYS"`
+	Mode RetentionMode `yaml:"mode" env:"MODE"`
+}
+
+type RetentionMode string
+
+const (
+	RetentionDelete    RetentionMode = "delete"
+	RetentionAnonymize RetentionMode = "anonymize"
+)
+
+// Logger описывает, куда и в каком виде пишутся логи. Пути - файлы, "stdout" или "stderr";
+// пустой OutputPaths - stdout в режиме отладки, иначе ./promtail/log/telemetry.log
 type Logger struct {
-	Development      bool
-	OutputPaths      []string
-	ErrorOutputPaths []string
+	Development bool `yaml:"development" env:"DEVELOPMENT"`
+	// Level - debug, info, warn или error. Пустой - debug в режиме отладки, иначе info
+	Level            string      `yaml:"level" env:"LEVEL"`
+	Encoding         LogEncoding `yaml:"encoding" env:"ENCODING"`
+	OutputPaths      []string    `yaml:"output_paths" env:"OUTPUT_PATHS"`
+	ErrorOutputPaths []string    `yaml:"error_output_paths" env:"ERROR_OUTPUT_PATHS"`
+	Rotation         LogRotation `yaml:"rotation"`
+	Sampling         LogSampling `yaml:"sampling" env:"SAMPLING"`
 }
 
-var (
-	cfg            *Config
-	botDebug       bool
-	logDevelopment bool
+type LogEncoding string
+
+const (
+	LogEncodingJSON    LogEncoding = "json"
+	LogEncodingConsole LogEncoding = "console"
 )
 
-func LoadEnvCfg(source string) (*Config, error) {
-	err := godotenv.Load(source)
-	if err != nil {
-		return nil, fmt.Errorf("error getting enviroment file, in %v, err: %v", source, err)
+// LogRotation - ротация файловых синков. MaxSizeMB == 0 - 100 МБ, MaxAgeDays и MaxBackups == 0 - без ограничения
+type LogRotation struct {
+	MaxSizeMB  int  `yaml:"max_size_mb" env:"MAX_SIZE_MB"`
+	MaxAgeDays int  `yaml:"max_age_days" env:"MAX_AGE_DAYS"`
+	MaxBackups int  `yaml:"max_backups" env:"MAX_BACKUPS"`
+	Compress   bool `yaml:"compress" env:"COMPRESS"`
+}
+
+// LogSampling ограничивает поток одинаковых debug-сообщений: в секунду пишутся первые Initial,
+// затем каждое Thereafter-е. Initial == 0 - без сэмплирования
+type LogSampling struct {
+	Initial    int `yaml:"initial" env:"INITIAL"`
+	Thereafter int `yaml:"thereafter" env:"THEREAFTER"`
+}
+
+// Default возвращает значения, которые используются, если параметр не задан ни в одном слое
+func Default() Config {
+	return Config{
+		StorageBackend:     StoragePostgres,
+		SQLitePath:         "mytelegrambot.db",
+		MaxPgxConn:         10,
+		MaxPgxConnIdleTime: 30 * time.Minute,
+		MaxPgxConnLifeTime: time.Hour,
+		HealthCheckPeriod:  time.Minute,
+		Retention: Retention{
+			Interval:         time.Hour,
+			UpdatesMessages:  RetentionPolicy{Mode: RetentionDelete},
+			ArchiveMessages:  RetentionPolicy{Mode: RetentionDelete},
+			FinishedJobsDays: 7,
+		},
+		TelegramLoginMaxAge: 24 * time.Hour,
+		Workers:             4,
+		TracingExporter:     TracingNone,
+		ShutdownTimeout:     30 * time.Second,
 
+		SettingsReloadInterval: 30 * time.Second,
+		BroadcastRate:          20,
 	}
+}
+
+// ValidateStorage проверяет только подключение к хранилищу, которого достаточно утилитам вроде cmd/apikey
+func (c *Config) ValidateStorage() error {
+	return errors.Join(c.storageErrors()...)
+}
 
-	parsedPgxConns, err := strconv.ParseInt(os.Getenv("MAX_PGX_CONN"), 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"error parsing MAX_PGX_CONNS string: %v, err: %v",
-			os.Getenv("MAX_PGX_CONN"),
-			err,
-		)
+func (c *Config) storageErrors() []error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
 	}
-	parsedPgxConnIdleTime, err := strconv.ParseInt(os.Getenv("MAX_PGX_CONN_IDLE_TIME"), 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"error parsing MAX_PGX_CONN_IDLE_TIME string: %v, err: %v",
-			os.Getenv("MAX_PGX_CONN_IDLE_TIME"),
-			err,
-		)
+
+	switch c.StorageBackend {
+	case StoragePostgres:
+		check(c.ConnString != "", "connection_string (CONNECTION_STRING) is required for postgres storage")
+		check(c.MaxPgxConn >= 1, "max_pgx_conn (MAX_PGX_CONN) must be at least 1, got %d", c.MaxPgxConn)
+		check(c.MaxPgxConnIdleTime > 0, "max_pgx_conn_idle_time (MAX_PGX_CONN_IDLE_TIME) must be positive, got %v", c.MaxPgxConnIdleTime)
+		check(c.MaxPgxConnLifeTime > 0, "max_pgx_conn_lifetime (MAX_PGX_CONN_LIFETIME) must be positive, got %v", c.MaxPgxConnLifeTime)
+		check(c.HealthCheckPeriod > 0, "health_check_period (HEALTH_CHECK_PERIOD) must be positive, got %v", c.HealthCheckPeriod)
+	case StorageSQLite:
+		check(c.SQLitePath != "", "sqlite_path (SQLITE_PATH) is required for sqlite storage")
+	case StorageMemory:
+	default:
+		check(false, "unknown storage_backend (STORAGE_BACKEND): %q, expected postgres, sqlite or memory", c.StorageBackend)
 	}
-	parsedMaxPgxConnLifeTime, err := strconv.ParseInt(os.Getenv("MAX_PGX_CONN_LIFETIME"), 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"error parsing MAX_PGX_CONN_LIFETIME string: %v, err: %v",
-			os.Getenv("MAX_PGX_CONN_LIFETIME"),
-			err,
-		)
+
+	return errs
+}
+
+// Validate проверяет конфиг целиком и возвращает все найденные ошибки разом
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
 	}
-	parsedHealthCheckPeriod, err := strconv.ParseInt(os.Getenv("HEALTH_CHECK_PERIOD"), 10, 64)
-	if err != nil {
-		return nil, fmt.Errorf(
-			"error parsing HEALTH_CHECK_PERIOD string: %v, err: %v",
-			os.Getenv("HEALTH_CHECK_PERIOD"),
-			err,
-		)
+
+	check(c.Token != "", "token (TOKEN) is required")
+	check(c.R1ProToken != "", "r1_pro_token (R1_PRO_TOKEN) is required")
+
+	errs = append(errs, c.storageErrors()...)
+
+	switch c.TracingExporter {
+	case TracingNone, TracingStdout, TracingOTLP:
+	default:
+		check(false, "unknown tracing_exporter (TRACING_EXPORTER): %q, expected none, stdout or otlp", c.TracingExporter)
 	}
 
-	if os.Getenv("BOT_ENV") == "debug" {
-		botDebug = true
-	} else {
-		botDebug = false
+	check(c.TelegramLoginMaxAge > 0, "telegram_login_max_age (TELEGRAM_LOGIN_MAX_AGE) must be positive, got %v", c.TelegramLoginMaxAge)
+	check(c.Workers >= 1, "workers (WORKERS) must be at least 1, got %d", c.Workers)
+	check(c.ShutdownTimeout > 0, "shutdown_timeout (SHUTDOWN_TIMEOUT) must be positive, got %v", c.ShutdownTimeout)
+	check(c.SettingsReloadInterval > 0, "settings_reload_interval (SETTINGS_RELOAD_INTERVAL) must be positive, got %v", c.SettingsReloadInterval)
+	check(c.BroadcastRate >= 1 && c.BroadcastRate <= 30, "broadcast_rate (BROADCAST_RATE) must be between 1 and 30, got %d", c.BroadcastRate)
+	providers := map[string]bool{DefaultLLMProvider: true}
+	for i, provider := range c.LLMFallback {
+		check(provider.Name != "" && !providers[provider.Name],
+			"llm_fallback[%d].name (LLM_FALLBACK) must be set and unique, %q is taken", i, provider.Name)
+		check(provider.BaseURL != "", "llm_fallback[%d].base_url (LLM_FALLBACK) must be set", i)
+		providers[provider.Name] = true
 	}
 
-	if os.Getenv("LOG_DEVELOPMENT") == "true" {
-		logDevelopment = true
-	} else {
-		logDevelopment = false
+	switch c.Logger.Encoding {
+	case "", LogEncodingJSON, LogEncodingConsole:
+	default:
+		check(false, "unknown logger.encoding (LOG_ENCODING): %q, expected json or console", c.Logger.Encoding)
+	}
+	switch c.Logger.Level {
+	case "", "debug", "info", "warn", "error":
+	default:
+		check(false, "unknown logger.level (LOG_LEVEL): %q, expected debug, info, warn or error", c.Logger.Level)
+	}
+	for _, i := range []struct {
+		name  string
+		value int
+	}{
+		{"logger.rotation.max_size_mb (LOG_MAX_SIZE_MB)", c.Logger.Rotation.MaxSizeMB},
+		{"logger.rotation.max_age_days (LOG_MAX_AGE_DAYS)", c.Logger.Rotation.MaxAgeDays},
+		{"logger.rotation.max_backups (LOG_MAX_BACKUPS)", c.Logger.Rotation.MaxBackups},
+		{"logger.sampling.initial (LOG_SAMPLING_INITIAL)", c.Logger.Sampling.Initial},
+		{"logger.sampling.thereafter (LOG_SAMPLING_THEREAFTER)", c.Logger.Sampling.Thereafter},
+		{"retention.updates_messages.days (RETENTION_UPDATES_DAYS)", c.Retention.UpdatesMessages.Days},
+		{"retention.archive_messages.days (RETENTION_ARCHIVE_DAYS)", c.Retention.ArchiveMessages.Days},
+		{"retention.finished_jobs_days (RETENTION_FINISHED_JOBS_DAYS)", c.Retention.FinishedJobsDays},
+	} {
+		check(i.value >= 0, "%s must not be negative, got %d", i.name, i.value)
 	}
 
-	cfg = &Config{
-		Token:              os.Getenv("TOKEN"),
-		ConnString:         os.Getenv("CONNECTION_STRING"),
-		MaxPgxConn:         int32(parsedPgxConns),
-		MaxPgxConnIdleTime: time.Duration(parsedPgxConnIdleTime),
-		MaxPgxConnLifeTime: time.Duration(parsedMaxPgxConnLifeTime),
-		HealthCheckPeriod:  time.Duration(parsedHealthCheckPeriod),
-		R1Token:            os.Getenv("R1_TOKEN"),
-		R1ProToken:         os.Getenv("R1_PRO_TOKEN"),
-		BotEnv:             botDebug,
-		Logger: Logger{
-			Development:      logDevelopment,
-			OutputPaths:      strings.Split(os.Getenv("LOG_OUTPUT_PATHS"), ","),
-			ErrorOutputPaths: strings.Split(os.Getenv("LOG_ERROR_OUTPUT_PATHS"), ","),
-		},
+	check(c.Retention.Interval > 0, "retention.interval (RETENTION_INTERVAL) must be positive, got %v", c.Retention.Interval)
+	for _, p := range []struct {
+		name string
+		mode RetentionMode
+	}{
+		{"retention.updates_messages.mode (RETENTION_UPDATES_MODE)", c.Retention.UpdatesMessages.Mode},
+		{"retention.archive_messages.mode (RETENTION_ARCHIVE_MODE)", c.Retention.ArchiveMessages.Mode},
+	} {
+		check(p.mode == RetentionDelete || p.mode == RetentionAnonymize,
+			"unknown %s: %q, expected delete or anonymize", p.name, p.mode)
+	}
+	check(c.Retention.UpdatesMessages.Mode != RetentionAnonymize,
+		"retention.updates_messages.mode (RETENTION_UPDATES_MODE) can't be anonymize: the active dialog can only be deleted")
+
+	return errors.Join(errs...)
+}
+
+// Redacted возвращает копию конфига, в которой заполненные секретные поля заменены на "***"
+func (c Config) Redacted() Config {
+	c.Logger.OutputPaths = slices.Clone(c.Logger.OutputPaths)
+	c.Logger.ErrorOutputPaths = slices.Clone(c.Logger.ErrorOutputPaths)
+	c.AdminTelegramIDs = slices.Clone(c.AdminTelegramIDs)
+	c.LLMFallback = slices.Clone(c.LLMFallback)
+	for i := range c.LLMFallback {
+		if c.LLMFallback[i].Token != "" {
+			c.LLMFallback[i].Token = "***"
+		}
+	}
+	for _, f := range fields(reflect.ValueOf(&c).Elem()) {
+		if f.secret && f.value.String() != "" {
+			f.value.SetString("***")
+		}
 	}
-	return cfg, nil
+	return c
+}
+
+// String выводит конфиг без секретов, поэтому его безопасно писать в лог
+func (c Config) String() string {
+	// plain не наследует String, иначе fmt уйдёт в рекурсию
+	type plain Config
+	return fmt.Sprintf("%+v", plain(c.Redacted()))
 }
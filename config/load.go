@@ -0,0 +1,320 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// field - лист конфига: ключ в файле, имя переменной окружения и ссылка на значение
+type field struct {
+	key    string
+	env    string
+	secret bool
+	value  reflect.Value
+}
+
+// flagName - имя флага командной строки: ключ файла с "-" вместо "_", например -logger.rotation.max-size-mb
+func (f field) flagName() string {
+	return strings.ReplaceAll(f.key, "_", "-")
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// fields обходит структуру конфига и собирает её листья
+func fields(v reflect.Value) []field {
+	var result []field
+	walk(v, "", "", &result)
+	return result
+}
+
+func walk(v reflect.Value, keyPrefix, envPrefix string, out *[]field) {
+	for i := 0; i < v.NumField(); i++ {
+		sf := v.Type().Field(i)
+		key := join(keyPrefix, sf.Tag.Get("yaml"), ".")
+		env := join(envPrefix, sf.Tag.Get("env"), "_")
+
+		if sf.Type.Kind() == reflect.Struct {
+			walk(v.Field(i), key, env, out)
+			continue
+		}
+		*out = append(*out, field{key: key, env: env, secret: sf.Tag.Get("secret") == "true", value: v.Field(i)})
+	}
+}
+
+func join(prefix, name, sep string) string {
+	if prefix == "" || name == "" {
+		return prefix + name
+	}
+	return prefix + sep + name
+}
+
+// Load собирает конфиг из слоёв, каждый следующий переопределяет предыдущий:
+// значения по умолчанию, файл YAML/TOML (флаг -config или CONFIG_FILE), переменные окружения
+// (в том числе из envFile, если он есть) и флаги args. Ошибки разбора и проверки возвращаются все сразу
+func Load(envFile string, args []string) (*Config, error) {
+	cfg, _, err := loadLayers(envFile, args)
+	if err != nil {
+		return nil, err
+	}
+	if err = cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadStorage собирает конфиг как Load, но проверяет только хранилище: токены Telegram и модели
+// утилитам вроде cmd/apikey не нужны. Возвращает аргументы, оставшиеся после флагов конфига
+func LoadStorage(envFile string, args []string) (*Config, []string, error) {
+	cfg, rest, err := loadLayers(envFile, args)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = cfg.ValidateStorage(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, rest, nil
+}
+
+// loadLayers собирает конфиг без проверки и возвращает аргументы после флагов
+func loadLayers(envFile string, args []string) (*Config, []string, error) {
+	cfg := Default()
+	all := fields(reflect.ValueOf(&cfg).Elem())
+
+	flags := flag.NewFlagSet("mytelegrambot", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to YAML or TOML config file (env CONFIG_FILE)")
+	fromFlags := make(map[string]string)
+	for _, f := range all {
+		flags.Func(f.flagName(), "env "+f.env, func(raw string) error {
+			fromFlags[f.key] = raw
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if err := godotenv.Load(envFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, fmt.Errorf("loading env file %v: %w", envFile, err)
+	}
+	if *configFile == "" {
+		*configFile = os.Getenv("CONFIG_FILE")
+	}
+
+	var errs []error
+	fromFile := make(map[string]string)
+	if *configFile != "" {
+		var err error
+		if fromFile, err = readFile(*configFile); err != nil {
+			return nil, nil, err
+		}
+		known := make(map[string]bool, len(all))
+		for _, f := range all {
+			known[f.key] = true
+		}
+		for _, key := range sortedKeys(fromFile) {
+			if !known[key] {
+				errs = append(errs, fmt.Errorf("%v: unknown key %q", *configFile, key))
+			}
+		}
+	}
+
+	for _, f := range all {
+		if raw, ok := fromFile[f.key]; ok {
+			if err := set(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("%v: %s: %w", *configFile, f.key, err))
+			}
+		}
+		if raw := os.Getenv(f.env); raw != "" {
+			if f.env == "BOT_ENV" {
+				// исторически режим отладки включается значением debug
+				raw = strconv.FormatBool(raw == "debug" || raw == "true")
+			}
+			if f.value.Type() == durationType {
+				raw = legacyDuration(raw)
+			}
+			if err := set(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", f.env, err))
+			}
+		}
+		if raw, ok := fromFlags[f.key]; ok {
+			if err := set(f.value, raw); err != nil {
+				errs = append(errs, fmt.Errorf("flag -%s: %w", f.flagName(), err))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	if cfg.Logger.Encoding == "" {
+		cfg.Logger.Encoding = LogEncodingJSON
+		if cfg.Logger.Development {
+			cfg.Logger.Encoding = LogEncodingConsole
+		}
+	}
+
+	return &cfg, flags.Args(), nil
+}
+
+// set разбирает строковое значение любого слоя в поле конфига
+func set(v reflect.Value, raw string) error {
+	switch {
+	case v.Type() == durationType:
+		d, err := parseDuration(raw)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(raw)
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid bool %q", raw)
+		}
+		v.SetBool(b)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int32:
+		i, err := strconv.ParseInt(strings.TrimSpace(raw), 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", raw)
+		}
+		v.SetInt(i)
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.String:
+		v.Set(reflect.ValueOf(splitList(raw)))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Int64:
+		var ids []int64
+		for _, item := range splitList(raw) {
+			id, err := strconv.ParseInt(item, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid integer %q in list", item)
+			}
+			ids = append(ids, id)
+		}
+		v.Set(reflect.ValueOf(ids))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		items := reflect.New(v.Type())
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(items.Interface()); err != nil {
+			// raw не выводится: в нём могут быть токены
+			return fmt.Errorf("invalid JSON list: %w", err)
+		}
+		v.Set(items.Elem())
+	default:
+		return fmt.Errorf("unsupported config type %v", v.Type())
+	}
+	return nil
+}
+
+// parseDuration принимает "30s", "1h30m". Число без единиц измерения - ошибка: в файле и флагах
+// "30" легко принять за секунды
+func parseDuration(raw string) (time.Duration, error) {
+	raw = strings.TrimSpace(raw)
+	if d, err := time.ParseDuration(raw); err == nil {
+		return d, nil
+	}
+	if _, err := strconv.ParseInt(raw, 10, 64); err == nil {
+		return 0, fmt.Errorf("invalid duration %q: unit is required, e.g. %ss", raw, raw)
+	}
+	return 0, fmt.Errorf("invalid duration %q, expected e.g. 30s or 1h", raw)
+}
+
+// legacyDuration переводит целое число наносекунд из старых .env в формат parseDuration.
+// Применяется только к переменным окружения
+func legacyDuration(raw string) string {
+	ns, err := strconv.ParseInt(strings.TrimSpace(raw), 10, 64)
+	if err != nil {
+		return raw
+	}
+	return time.Duration(ns).String()
+}
+
+// splitList разбивает список через запятую, отбрасывая пустые элементы
+func splitList(raw string) []string {
+	var result []string
+	for _, item := range strings.Split(raw, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// readFile читает YAML или TOML (по расширению) и раскладывает его в ключи вида logger.rotation.max_size_mb
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var tree map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &tree)
+	case ".toml":
+		err = toml.Unmarshal(data, &tree)
+	default:
+		return nil, fmt.Errorf("unknown config file format %q, expected .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %v: %w", path, err)
+	}
+
+	result := make(map[string]string)
+	flatten("", tree, result)
+	return result, nil
+}
+
+func flatten(prefix string, tree map[string]any, out map[string]string) {
+	for key, value := range tree {
+		key = join(prefix, key, ".")
+		switch value := value.(type) {
+		case map[string]any:
+			flatten(key, value, out)
+		case []any:
+			// список объектов, например llm_fallback, передаётся в set как JSON
+			if slices.ContainsFunc(value, isObject) {
+				// значения из YAML и TOML всегда кодируются в JSON
+				data, _ := json.Marshal(value)
+				out[key] = string(data)
+				continue
+			}
+			items := make([]string, 0, len(value))
+			for _, item := range value {
+				items = append(items, fmt.Sprint(item))
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			out[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+func isObject(value any) bool {
+	_, ok := value.(map[string]any)
+	return ok
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
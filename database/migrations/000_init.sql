@@ -0,0 +1,24 @@
+-- Исходная схема, на которую рассчитан storage.BotStorage
+CREATE TABLE IF NOT EXISTS updates_messages
+(
+    chat_id       BIGINT      NOT NULL,
+    message_id    INTEGER     NOT NULL,
+    from_id       BIGINT      NOT NULL,
+    from_username TEXT        NOT NULL DEFAULT '',
+    text          TEXT        NOT NULL DEFAULT '',
+    time_stamp    TIMESTAMPTZ NOT NULL,
+    db_time_stamp TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS updates_messages_chat_id_idx ON updates_messages (chat_id);
+
+CREATE TABLE IF NOT EXISTS archive_messages
+(
+    chat_id       BIGINT      NOT NULL,
+    message_id    INTEGER     NOT NULL,
+    from_id       BIGINT      NOT NULL,
+    from_username TEXT        NOT NULL DEFAULT '',
+    text          TEXT        NOT NULL DEFAULT '',
+    time_stamp    TIMESTAMPTZ NOT NULL,
+    db_time_stamp TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
@@ -0,0 +1,29 @@
+-- Каждый /restart создаёт отдельную архивную сессию, к которой привязываются сообщения
+CREATE TABLE IF NOT EXISTS archive_sessions
+(
+    id          BIGSERIAL PRIMARY KEY,
+    chat_id     BIGINT      NOT NULL,
+    archived_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX IF NOT EXISTS archive_sessions_chat_id_idx ON archive_sessions (chat_id, archived_at DESC);
+
+ALTER TABLE archive_messages
+    ADD COLUMN IF NOT EXISTS session_id BIGINT REFERENCES archive_sessions (id) ON DELETE CASCADE;
+
+CREATE INDEX IF NOT EXISTS archive_messages_session_id_idx ON archive_messages (session_id);
+
+-- Сообщения, заархивированные до появления сессий, собираются в одну сессию на чат
+WITH legacy AS (SELECT chat_id, max(db_time_stamp) AS archived_at
+                FROM archive_messages
+                WHERE session_id IS NULL
+                GROUP BY chat_id),
+     sessions AS (
+         INSERT INTO archive_sessions (chat_id, archived_at)
+             SELECT chat_id, archived_at FROM legacy
+             RETURNING id, chat_id)
+UPDATE archive_messages a
+SET session_id = s.id
+FROM sessions s
+WHERE a.session_id IS NULL
+  AND a.chat_id = s.chat_id;
@@ -0,0 +1,15 @@
+-- Индексы для задачи очистки по сроку хранения
+CREATE INDEX IF NOT EXISTS updates_messages_db_time_stamp_idx ON updates_messages (db_time_stamp);
+CREATE INDEX IF NOT EXISTS archive_messages_db_time_stamp_idx ON archive_messages (db_time_stamp);
+
+-- Журнал удаления персональных данных (/forgetme, админский запрос, очистка по сроку)
+CREATE TABLE IF NOT EXISTS data_audit
+(
+    id            BIGSERIAL PRIMARY KEY,
+    action        TEXT        NOT NULL,
+    user_id       BIGINT,
+    requested_by  TEXT        NOT NULL,
+    rows_affected BIGINT      NOT NULL,
+    details       JSONB       NOT NULL DEFAULT '{}',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
@@ -0,0 +1,17 @@
+-- Исходящие сообщения: запись создаётся до отправки в Telegram и закрывается
+-- в одной транзакции с сохранением отправленного сообщения в updates_messages
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id         BIGSERIAL PRIMARY KEY,
+    chat_id    BIGINT      NOT NULL,
+    text       TEXT        NOT NULL,
+    status     TEXT        NOT NULL DEFAULT 'pending', -- pending, sending, sent, failed
+    attempts   INTEGER     NOT NULL DEFAULT 0,
+    message_id INTEGER,
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    claimed_at TIMESTAMPTZ,
+    sent_at    TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS outbox_unfinished_idx ON outbox (created_at) WHERE status IN ('pending', 'sending');
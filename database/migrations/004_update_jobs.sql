@@ -0,0 +1,20 @@
+-- Очередь входящих апдейтов: апдейт сохраняется до обработки и забирается воркерами под аренду
+CREATE TABLE IF NOT EXISTS update_jobs
+(
+    id          BIGSERIAL PRIMARY KEY,
+    update_id   BIGINT      NOT NULL,
+    chat_id     BIGINT      NOT NULL,
+    from_id     BIGINT      NOT NULL DEFAULT 0,
+    payload     JSONB       NOT NULL,
+    status      TEXT        NOT NULL DEFAULT 'pending', -- pending, in_progress, done, failed
+    attempts    INTEGER     NOT NULL DEFAULT 0,
+    worker      TEXT,
+    lease_until TIMESTAMPTZ,
+    last_error  TEXT,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    finished_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS update_jobs_unfinished_idx ON update_jobs (chat_id, id) WHERE status IN ('pending', 'in_progress');
+-- Telegram может прислать апдейт повторно, если не получил подтверждения: в очереди он остаётся один
+CREATE UNIQUE INDEX IF NOT EXISTS update_jobs_update_id_idx ON update_jobs (update_id);
@@ -0,0 +1,14 @@
+-- Служебное состояние бота (смещение getUpdates и т.п.)
+CREATE TABLE IF NOT EXISTS bot_state
+(
+    key        TEXT PRIMARY KEY,
+    value      BIGINT      NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Уже обработанные апдейты, чтобы повторная доставка не приводила к повторному ответу
+CREATE TABLE IF NOT EXISTS processed_updates
+(
+    update_id    BIGINT PRIMARY KEY,
+    processed_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
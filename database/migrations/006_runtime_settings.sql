@@ -0,0 +1,7 @@
+-- Настройки, меняющиеся без перезапуска (модель, таймауты, тексты). Одна строка с JSON
+CREATE TABLE IF NOT EXISTS runtime_settings
+(
+    id         SMALLINT PRIMARY KEY DEFAULT 1 CHECK (id = 1),
+    value      JSONB       NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
@@ -0,0 +1,7 @@
+-- Пользователи, сообщения которых бот игнорирует
+CREATE TABLE IF NOT EXISTS blocked_users
+(
+    user_id    BIGINT PRIMARY KEY,
+    blocked_by TEXT        NOT NULL,
+    blocked_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
@@ -0,0 +1,11 @@
+-- Ключи HTTP API. Хранится только SHA-256 ключа
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id         BIGSERIAL PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    prefix     TEXT        NOT NULL,
+    key_hash   TEXT        NOT NULL UNIQUE,
+    scopes     TEXT[]      NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    revoked_at TIMESTAMPTZ
+);
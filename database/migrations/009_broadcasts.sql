@@ -0,0 +1,29 @@
+-- Рассылки: текст и сегмент чатов, по которым он отправляется
+CREATE TABLE IF NOT EXISTS broadcasts
+(
+    id          BIGSERIAL PRIMARY KEY,
+    text        TEXT        NOT NULL,
+    segment     JSONB       NOT NULL DEFAULT '{}',
+    status      TEXT        NOT NULL DEFAULT 'running', -- running, paused, finished
+    created_by  TEXT        NOT NULL,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    finished_at TIMESTAMPTZ
+);
+
+-- Доставка рассылки в каждый чат сегмента, создаются вместе с рассылкой
+CREATE TABLE IF NOT EXISTS broadcast_deliveries
+(
+    id           BIGSERIAL PRIMARY KEY,
+    broadcast_id BIGINT  NOT NULL REFERENCES broadcasts (id) ON DELETE CASCADE,
+    chat_id      BIGINT  NOT NULL,
+    status       TEXT    NOT NULL DEFAULT 'pending', -- pending, sending, sent, failed
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    message_id   INTEGER,
+    last_error   TEXT,
+    claimed_at   TIMESTAMPTZ,
+    sent_at      TIMESTAMPTZ,
+    UNIQUE (broadcast_id, chat_id)
+);
+
+CREATE INDEX IF NOT EXISTS broadcast_deliveries_unfinished_idx
+    ON broadcast_deliveries (broadcast_id, id) WHERE status IN ('pending', 'sending');
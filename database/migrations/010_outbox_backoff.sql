@@ -0,0 +1,2 @@
+-- Неудачная отправка outbox повторяется не раньше next_attempt_at, пауза растёт с каждой попыткой
+ALTER TABLE outbox ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;
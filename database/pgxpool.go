@@ -5,7 +5,7 @@ import (
 	"fmt"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mytelegrambot/config"
-	"log"
+	"github.com/mytelegrambot/logger"
 	"time"
 )
 
@@ -33,7 +33,7 @@ func GetPool(ctx context.Context, config *config.Config) (*pgxpool.Pool, error)
 	}
 
 	deadline, _ := ctx.Deadline()
-	log.Printf("db pool estabilished, time left: %v\n", time.Until(deadline))
+	logger.FromContext(ctx).Infow("db pool established", "max_conns", parseConfig.MaxConns, "time_left", time.Until(deadline))
 
 	return pool, nil
 }
@@ -1,61 +1,309 @@
 package deepseek
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/settings"
+	"github.com/mytelegrambot/tracing"
 	"github.com/openai/openai-go" // imported as openai
 	"github.com/openai/openai-go/option"
-	"log"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
 	"time"
 )
 
 type R1 interface {
-	AnswerQuestion(ctx context.Context, question string) (string, error)
+	AnswerQuestion(ctx context.Context, history []Turn, question string) (string, error)
+	Ping(ctx context.Context) error
+}
+
+// Turn - реплика диалога, которую модель получает перед вопросом
+type Turn struct {
+	// Assistant - реплика бота, иначе пользователя
+	Assistant bool
+	Text      string
 }
 
 type R1Client struct {
-	client openai.Client
+	// providers - клиенты провайдеров моделей по имени, основной - config.DefaultLLMProvider
+	providers map[string]*provider
+	settings  *settings.Store
+	now       func() time.Time
 }
 
-func NewR1(config *config.Config) *R1Client {
-	var client openai.Client
+// provider - клиент OpenAI-совместимого API со своим circuit breaker
+type provider struct {
+	name    string
+	client  openai.Client
+	breaker *breaker
+}
+
+// defaultProvider - OpenRouter, провайдер Model и запасных моделей без Provider
+const defaultProvider = config.DefaultLLMProvider
+
+// errCircuitOpen - провайдер пропущен, потому что отключён circuit breaker
+var errCircuitOpen = errors.New("llm provider is suspended by circuit breaker")
 
-	client = openai.NewClient(
+// errEmptyCompletion - поток завершился без ошибки, но без единого варианта ответа
+var errEmptyCompletion = errors.New("completion has no choices")
+
+// NewR1 создаёт клиента OpenRouter и запасных провайдеров из config.LLMFallback.
+// Модели, таймаут и политика повторов берутся из runtime на каждый вопрос. opts дополняют настройки
+// клиента OpenRouter, например option.WithBaseURL направляет запросы на другой сервер
+func NewR1(config *config.Config, runtime *settings.Store, opts ...option.RequestOption) *R1Client {
+	c := &R1Client{providers: make(map[string]*provider), settings: runtime, now: time.Now}
+
+	// повторы выполняет AnswerQuestion по настройкам, встроенные повторы клиента отключены
+	c.addProvider(defaultProvider, append([]option.RequestOption{
 		option.WithBaseURL(
 			"https://openrouter.ai/api/v1",
 		),
 		option.WithAPIKey(
 			config.R1ProToken,
 		),
-	)
+		option.WithMaxRetries(0),
+	}, opts...)...)
+
+	for _, fallback := range config.LLMFallback {
+		c.addProvider(fallback.Name,
+			option.WithBaseURL(fallback.BaseURL),
+			option.WithAPIKey(fallback.Token),
+			option.WithMaxRetries(0),
+		)
+	}
+
+	return c
+}
+
+func (c *R1Client) addProvider(name string, opts ...option.RequestOption) {
+	c.providers[name] = &provider{name: name, client: openai.NewClient(opts...), breaker: newBreaker(name)}
+}
+
+// AnswerQuestion задаёт вопрос с предшествующими репликами history основной модели, а если она
+// недоступна - запасным по порядку. Запрос к каждой модели повторяется после таймаута, 5xx, 429 и сетевых ошибок
+func (c *R1Client) AnswerQuestion(ctx context.Context, history []Turn, message string) (answer string, err error) {
+	runtime := c.settings.Get()
+
+	ctx, span := tracing.Start(ctx, "deepseek", "llm.answer_question",
+		attribute.String("llm.model", runtime.Model), attribute.Int("llm.history_turns", len(history)))
+	defer func() { tracing.End(span, err) }()
+	log := logger.FromContext(ctx)
+
+	candidates := append([]settings.Fallback{{Model: runtime.Model}}, runtime.Fallbacks...)
+	var errs []error
+	for i, candidate := range candidates {
+		name := cmp.Or(candidate.Provider, defaultProvider)
+		p, ok := c.providers[name]
+		if !ok {
+			log.Errorw("unknown llm provider in fallbacks", "provider", name, "model", candidate.Model)
+			errs = append(errs, fmt.Errorf("%s: unknown provider %q", candidate.Model, name))
+			continue
+		}
+		if !p.breaker.allow(c.now()) {
+			log.Warnw("llm provider suspended, skipping model", "provider", name, "model", candidate.Model)
+			errs = append(errs, fmt.Errorf("%s: %w", candidate.Model, errCircuitOpen))
+			continue
+		}
+		if i > 0 {
+			metrics.LLMFallbacks.WithLabelValues(candidate.Model).Inc()
+			log.Warnw("falling back to another model", "provider", name, "model", candidate.Model)
+		}
 
-	return &R1Client{client: client}
+		answer, err = c.answer(ctx, p, candidate.Model, prompt(history, message), runtime)
+		if err == nil {
+			span.SetAttributes(attribute.String("llm.answered_by", candidate.Model))
+			return answer, nil
+		}
+		// отмена и ошибки в самом запросе другая модель не исправит
+		if ctx.Err() != nil || !retryable(err) {
+			return answer, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", candidate.Model, err))
+	}
+
+	return "ошибка получения ответа", fmt.Errorf("no llm model answered: %w", errors.Join(errs...))
 }
 
-func (c *R1Client) AnswerQuestion(ctx context.Context, message string) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, 40*time.Second)
+// answer задаёт вопрос model, повторяя запрос с задержкой по runtime.Retry, пока не исчерпаны
+// runtime.MaxRetries или провайдер не отключён circuit breaker
+func (c *R1Client) answer(ctx context.Context, p *provider, model string, messages []openai.ChatCompletionMessageParamUnion, runtime settings.Settings) (string, error) {
+	for attempt := 0; ; attempt++ {
+		answer, err := c.complete(ctx, p.client, model, messages, time.Duration(runtime.LLMTimeout))
+		switch {
+		case err == nil:
+			p.breaker.success()
+			return answer, nil
+		case ctx.Err() != nil:
+			p.breaker.abort()
+			return answer, err
+		case !retryable(err):
+			// провайдер ответил, значит доступен
+			p.breaker.success()
+			return answer, err
+		}
+
+		suspended := p.breaker.failure(c.now(), runtime.CircuitBreaker)
+		if suspended {
+			logger.FromContext(ctx).Warnw("llm provider suspended", "provider", p.name, "cooldown", runtime.CircuitBreaker.Cooldown)
+		}
+		if suspended || attempt >= runtime.MaxRetries {
+			return answer, err
+		}
+
+		delay := retryDelay(err, runtime.Retry, attempt)
+		metrics.LLMRetries.Inc()
+		logger.FromContext(ctx).Warnw("llm request failed, retrying",
+			"provider", p.name, "model", model, "attempt", attempt+1, "max_attempts", runtime.MaxRetries+1, "delay", delay, "error", err)
+		if err := sleep(ctx, delay); err != nil {
+			return "таймаут/отмена", err
+		}
+	}
+}
+
+// prompt собирает сообщения запроса: реплики history и вопрос пользователя последним
+func prompt(history []Turn, question string) []openai.ChatCompletionMessageParamUnion {
+	messages := make([]openai.ChatCompletionMessageParamUnion, 0, len(history)+1)
+	for _, turn := range history {
+		if turn.Assistant {
+			messages = append(messages, openai.AssistantMessage(turn.Text))
+		} else {
+			messages = append(messages, openai.UserMessage(turn.Text))
+		}
+	}
+	return append(messages, openai.UserMessage(question))
+}
+
+// complete выполняет один запрос к модели с таймаутом timeout и возвращает JSON ответа
+func (c *R1Client) complete(ctx context.Context, client openai.Client, model string, messages []openai.ChatCompletionMessageParamUnion, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
-	completion, err := c.client.Chat.Completions.New(
+	start := time.Now()
+
+	// ответ читается потоком, чтобы при остановке генерации осталась уже полученная часть
+	stream := client.Chat.Completions.NewStreaming(
 		ctx,
 		openai.ChatCompletionNewParams{
-			Messages: []openai.ChatCompletionMessageParamUnion{
-				openai.UserMessage(message),
-			},
-			Model: "deepseek/deepseek-chat-v3-0324:free",
+			Messages:      messages,
+			Model:         model,
+			StreamOptions: openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)},
 			//Model: "deepseek/deepseek-r1:free",
 		})
+	defer stream.Close()
 
-	if err != nil {
+	var completion openai.ChatCompletionAccumulator
+	for stream.Next() {
+		completion.AddChunk(stream.Current())
+	}
+	err := stream.Err()
+
+	if err != nil || ctx.Err() != nil {
 		if ctx.Err() != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				metrics.LLMTimeouts.WithLabelValues(model).Inc()
+				metrics.LLMRequestDuration.WithLabelValues(model, "timeout").Observe(time.Since(start).Seconds())
+			}
+			if partial := content(completion.ChatCompletion); partial != "" {
+				return partial, &PartialAnswerError{Text: partial, Err: ctx.Err()}
+			}
 			return "таймаут/отмена", ctx.Err()
 		}
+		metrics.LLMRequestDuration.WithLabelValues(model, "error").Observe(time.Since(start).Seconds())
 		return "ошибка получения ответа", fmt.Errorf("failed to get new deep-seek completion:\n%w", err)
 	}
 
+	if len(completion.Choices) == 0 {
+		metrics.LLMRequestDuration.WithLabelValues(model, "error").Observe(time.Since(start).Seconds())
+		return "ошибка получения ответа", errEmptyCompletion
+	}
+
+	metrics.LLMRequestDuration.WithLabelValues(model, "ok").Observe(time.Since(start).Seconds())
+	metrics.LLMTokens.WithLabelValues(model, "prompt").Add(float64(completion.Usage.PromptTokens))
+	metrics.LLMTokens.WithLabelValues(model, "completion").Add(float64(completion.Usage.CompletionTokens))
+	trace.SpanFromContext(ctx).SetAttributes(
+		attribute.String("llm.completion_id", completion.ID),
+		attribute.Int64("llm.usage.prompt_tokens", completion.Usage.PromptTokens),
+		attribute.Int64("llm.usage.completion_tokens", completion.Usage.CompletionTokens),
+	)
+
 	deadline, _ := ctx.Deadline()
 
-	log.Printf("deepseek completion: %s, time left: %v", completion.ID, time.Until(deadline).Round(time.Second))
-	return completion.RawJSON(), nil
+	logger.FromContext(ctx).Infow("deepseek completion",
+		"completion_id", completion.ID,
+		"prompt_tokens", completion.Usage.PromptTokens,
+		"completion_tokens", completion.Usage.CompletionTokens,
+		"time_left", time.Until(deadline).Round(time.Second),
+	)
+	return completionJSON(completion.ChatCompletion)
+}
+
+// PartialAnswerError - генерация прервана, но часть ответа уже получена
+type PartialAnswerError struct {
+	Text string
+	Err  error
+}
+
+func (e *PartialAnswerError) Error() string {
+	return fmt.Sprintf("answer interrupted after %d characters: %v", len([]rune(e.Text)), e.Err)
+}
+
+func (e *PartialAnswerError) Unwrap() error {
+	return e.Err
+}
+
+// content склеивает текст всех вариантов ответа, полученный к этому моменту
+func content(completion openai.ChatCompletion) string {
+	var text strings.Builder
+	for _, choice := range completion.Choices {
+		text.WriteString(choice.Message.Content)
+	}
+	return text.String()
+}
+
+// completionJSON собирает из накопленного потока ответ chat/completions в формате models.CompletionResponse,
+// который разбирает utils.ParseChoices
+func completionJSON(completion openai.ChatCompletion) (string, error) {
+	response := models.CompletionResponse{
+		ID:      completion.ID,
+		Model:   completion.Model,
+		Object:  "chat.completion",
+		Created: int(completion.Created),
+		Usage: models.Usage{
+			PromptTokens:     int(completion.Usage.PromptTokens),
+			CompletionTokens: int(completion.Usage.CompletionTokens),
+			TotalTokens:      int(completion.Usage.TotalTokens),
+		},
+	}
+	for _, choice := range completion.Choices {
+		response.Choices = append(response.Choices, models.Choice{
+			Index:        int(choice.Index),
+			FinishReason: string(choice.FinishReason),
+			Message:      models.R1Message{Role: "assistant", Content: choice.Message.Content},
+		})
+	}
+
+	data, err := json.Marshal(response)
+	if err != nil {
+		return "", fmt.Errorf("encoding completion: %w", err)
+	}
+	return string(data), nil
+}
+
+// Ping проверяет доступность OpenRouter запросом списка моделей, без повторов
+func (c *R1Client) Ping(ctx context.Context) (err error) {
+	ctx, span := tracing.Start(ctx, "deepseek", "llm.ping")
+	defer func() { tracing.End(span, err) }()
+
+	if _, err = c.providers[defaultProvider].client.Models.List(ctx, option.WithMaxRetries(0)); err != nil {
+		return fmt.Errorf("listing openrouter models: %w", err)
+	}
+	return nil
 }
@@ -0,0 +1,201 @@
+package deepseek
+
+import (
+	"context"
+	"errors"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/fake"
+	"github.com/mytelegrambot/settings"
+	"github.com/mytelegrambot/utils"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// testSettings - настройки без пауз между повторами и с запасной моделью у провайдера "backup"
+func testSettings() settings.Settings {
+	runtime := settings.Default()
+	runtime.Model = "primary-model"
+	runtime.Retry = settings.Retry{Backoff: settings.Duration(time.Millisecond), MaxBackoff: settings.Duration(time.Millisecond)}
+	runtime.Fallbacks = []settings.Fallback{{Provider: "backup", Model: "backup-model"}}
+	return runtime
+}
+
+// newTestR1 подключает клиента к двум фейковым провайдерам: OpenRouter и "backup"
+func newTestR1(t *testing.T, runtime settings.Settings) (*R1Client, *fake.OpenAI, *fake.OpenAI) {
+	t.Helper()
+
+	primary, backup := fake.NewOpenAI(t), fake.NewOpenAI(t)
+	cfg := &config.Config{
+		R1ProToken:  "test",
+		LLMFallback: []config.LLMProvider{{Name: "backup", BaseURL: backup.URL, Token: "test"}},
+	}
+	return NewR1(cfg, settings.Static(runtime), option.WithBaseURL(primary.URL)), primary, backup
+}
+
+func TestR1Client_AnswerQuestion(t *testing.T) {
+	tests := []struct {
+		name        string
+		failures    []int
+		wantErr     string
+		wantPrimary int
+		wantBackup  int
+	}{
+		{name: "answer", wantPrimary: 1},
+		{name: "5xx is retried", failures: []int{502}, wantPrimary: 2},
+		{name: "429 is retried", failures: []int{429, 500}, wantPrimary: 3},
+		{name: "client errors are not retried", failures: []int{400}, wantErr: "400 Bad Request", wantPrimary: 1},
+		{name: "fallback when retries are exhausted", failures: []int{503, 503, 503}, wantPrimary: 3, wantBackup: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, primary, backup := newTestR1(t, testSettings())
+			for _, status := range tt.failures {
+				primary.FailNext(status)
+			}
+
+			answer, err := c.AnswerQuestion(context.Background(), nil, "вопрос")
+			require.Len(t, primary.Requests(), tt.wantPrimary)
+			require.Len(t, backup.Requests(), tt.wantBackup)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			choices, err := utils.ParseChoices(answer)
+			require.NoError(t, err)
+			require.Equal(t, "ответ: вопрос", choices[0])
+			if tt.wantBackup > 0 {
+				require.Equal(t, "backup-model", backup.Requests()[0].Model)
+			}
+		})
+	}
+}
+
+func TestR1Client_AnswerQuestion_allModelsFail(t *testing.T) {
+	runtime := testSettings()
+	runtime.MaxRetries = 0
+	runtime.Fallbacks = append(runtime.Fallbacks, settings.Fallback{Provider: "unknown", Model: "lost-model"})
+	c, primary, backup := newTestR1(t, runtime)
+	primary.FailNext(500)
+	backup.FailNext(502)
+
+	_, err := c.AnswerQuestion(context.Background(), nil, "вопрос")
+	require.ErrorContains(t, err, "primary-model")
+	require.ErrorContains(t, err, "backup-model")
+	require.ErrorContains(t, err, `unknown provider "unknown"`)
+}
+
+func TestR1Client_circuitBreaker(t *testing.T) {
+	runtime := testSettings()
+	runtime.MaxRetries = 1
+	runtime.CircuitBreaker = settings.CircuitBreaker{Failures: 2, Cooldown: settings.Duration(time.Minute)}
+	c, primary, backup := newTestR1(t, runtime)
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	primary.FailNext(500)
+	primary.FailNext(500)
+	_, err := c.AnswerQuestion(ctx, nil, "первый")
+	require.NoError(t, err)
+	require.Len(t, primary.Requests(), 2)
+	require.Len(t, backup.Requests(), 1)
+
+	// OpenRouter отключён: вопрос сразу уходит запасной модели
+	_, err = c.AnswerQuestion(ctx, nil, "второй")
+	require.NoError(t, err)
+	require.Len(t, primary.Requests(), 2)
+	require.Len(t, backup.Requests(), 2)
+
+	// после Cooldown пробный запрос снова открывает OpenRouter
+	now = now.Add(time.Minute)
+	_, err = c.AnswerQuestion(ctx, nil, "третий")
+	require.NoError(t, err)
+	require.Len(t, primary.Requests(), 3)
+	require.Len(t, backup.Requests(), 2)
+}
+
+func TestR1Client_AnswerQuestion_partial(t *testing.T) {
+	c, primary, _ := newTestR1(t, testSettings())
+	primary.Reply(func(fake.ChatRequest) string { return "начало длинного ответа" })
+	primary.ChunkDelay(time.Minute)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	_, err := c.AnswerQuestion(ctx, nil, "вопрос")
+	var partial *PartialAnswerError
+	require.ErrorAs(t, err, &partial)
+	require.Equal(t, "начало ", partial.Text)
+	require.ErrorIs(t, err, context.Canceled)
+	require.Len(t, primary.Requests(), 1, "a cancelled question is not retried")
+}
+
+func TestR1Client_AnswerQuestion_history(t *testing.T) {
+	c, primary, _ := newTestR1(t, testSettings())
+
+	_, err := c.AnswerQuestion(context.Background(), []Turn{
+		{Text: "как зовут кота?"},
+		{Assistant: true, Text: "Барсик"},
+	}, "а сколько ему лет?")
+	require.NoError(t, err)
+	require.Equal(t, []fake.ChatMessage{
+		{Role: "user", Content: "как зовут кота?"},
+		{Role: "assistant", Content: "Барсик"},
+		{Role: "user", Content: "а сколько ему лет?"},
+	}, primary.Requests()[0].Messages)
+}
+
+func TestR1Client_AnswerQuestion_noChoices(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	t.Cleanup(srv.Close)
+	runtime := testSettings()
+	runtime.Fallbacks = nil
+	c := NewR1(&config.Config{R1ProToken: "test"}, settings.Static(runtime), option.WithBaseURL(srv.URL))
+
+	_, err := c.AnswerQuestion(context.Background(), nil, "вопрос")
+	require.ErrorIs(t, err, errEmptyCompletion)
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: context.DeadlineExceeded, want: true},
+		{err: &PartialAnswerError{Text: "начало", Err: context.DeadlineExceeded}, want: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{err: &openai.Error{StatusCode: http.StatusBadGateway}, want: true},
+		{err: &openai.Error{StatusCode: http.StatusTooManyRequests}, want: true},
+		{err: &openai.Error{StatusCode: http.StatusUnauthorized}, want: false},
+		{err: errors.New("invalid model"), want: false},
+	}
+
+	for i, tt := range tests {
+		require.Equal(t, tt.want, retryable(tt.err), "case %d", i)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	policy := settings.Retry{Backoff: settings.Duration(time.Second), MaxBackoff: settings.Duration(time.Minute)}
+
+	for _, attempt := range []int{0, 1, 5, 6, 40, 62, 63, 64, 1000} {
+		delay := retryDelay(errors.New("timeout"), policy, attempt)
+		require.GreaterOrEqual(t, delay, time.Duration(0), "attempt %d", attempt)
+		require.LessOrEqual(t, delay, time.Minute, "attempt %d", attempt)
+	}
+	require.LessOrEqual(t, retryDelay(errors.New("timeout"), policy, 0), time.Second)
+	require.GreaterOrEqual(t, retryDelay(errors.New("timeout"), policy, 63), 30*time.Second, "capped, not wrapped around")
+}
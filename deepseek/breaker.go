@@ -0,0 +1,75 @@
+package deepseek
+
+import (
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/settings"
+	"sync"
+	"time"
+)
+
+// breaker - circuit breaker провайдера. После CircuitBreaker.Failures неудачных запросов подряд
+// провайдер пропускается на Cooldown, затем пропускается один пробный запрос: успех снова
+// открывает провайдера, ошибка отключает его ещё на Cooldown
+type breaker struct {
+	provider string
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func newBreaker(provider string) *breaker {
+	metrics.LLMCircuitOpen.WithLabelValues(provider).Set(0)
+	return &breaker{provider: provider}
+}
+
+// allow сообщает, можно ли сейчас отправить запрос провайдеру
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures, b.openUntil, b.probing = 0, time.Time{}, false
+	metrics.LLMCircuitOpen.WithLabelValues(b.provider).Set(0)
+}
+
+// failure учитывает неудачный запрос и возвращает true, если провайдер отключён
+func (b *breaker) failure(now time.Time, policy settings.CircuitBreaker) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	if policy.Failures == 0 {
+		// отключение выключено в настройках, в том числе пока провайдер был отключён
+		b.openUntil, b.probing = time.Time{}, false
+		metrics.LLMCircuitOpen.WithLabelValues(b.provider).Set(0)
+		return false
+	}
+	if b.failures < policy.Failures && !b.probing {
+		return false
+	}
+	b.openUntil, b.probing = now.Add(time.Duration(policy.Cooldown)), false
+	metrics.LLMCircuitOpen.WithLabelValues(b.provider).Set(1)
+	return true
+}
+
+// abort снимает пробный запрос, который не завершился ни успехом, ни ошибкой провайдера
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
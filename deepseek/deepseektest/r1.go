@@ -0,0 +1,109 @@
+// Package deepseektest содержит заменяемую реализацию deepseek.R1 для тестов сервиса
+package deepseektest
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/mytelegrambot/deepseek"
+	"github.com/mytelegrambot/models"
+	"slices"
+	"sync"
+)
+
+// Tokens - сколько токенов указано в каждом ответе R1
+const Tokens = 10
+
+// Answer - ответ на очередной вопрос: текст или ошибка. Ответ с Block ждёт отмены контекста
+// и возвращает Text как уже полученную часть ответа
+type Answer struct {
+	Text  string
+	Err   error
+	Block bool
+}
+
+// Request - вопрос, заданный R1, вместе с переданными репликами диалога
+type Request struct {
+	History  []deepseek.Turn
+	Question string
+}
+
+// R1 отвечает заранее заданными Answer по очереди, а когда они кончаются - "ответ: <вопрос>".
+// Запоминает заданные вопросы
+type R1 struct {
+	// PingErr возвращается из Ping
+	PingErr error
+
+	mu       sync.Mutex
+	answers  []Answer
+	requests []Request
+}
+
+var _ deepseek.R1 = (*R1)(nil)
+
+func NewR1(answers ...Answer) *R1 {
+	return &R1{answers: answers}
+}
+
+// Push добавляет ответы в конец очереди
+func (r *R1) Push(answers ...Answer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.answers = append(r.answers, answers...)
+}
+
+// Questions возвращает заданные вопросы по порядку
+func (r *R1) Questions() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	questions := make([]string, 0, len(r.requests))
+	for _, request := range r.requests {
+		questions = append(questions, request.Question)
+	}
+	return questions
+}
+
+// Requests возвращает заданные вопросы с репликами диалога по порядку
+func (r *R1) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.requests)
+}
+
+func (r *R1) AnswerQuestion(ctx context.Context, history []deepseek.Turn, question string) (string, error) {
+	r.mu.Lock()
+	r.requests = append(r.requests, Request{History: slices.Clone(history), Question: question})
+	answer := Answer{Text: "ответ: " + question}
+	if len(r.answers) > 0 {
+		answer, r.answers = r.answers[0], r.answers[1:]
+	}
+	r.mu.Unlock()
+
+	if answer.Block {
+		<-ctx.Done()
+		if answer.Text != "" {
+			return answer.Text, &deepseek.PartialAnswerError{Text: answer.Text, Err: ctx.Err()}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "таймаут/отмена", err
+	}
+	if answer.Err != nil {
+		return "ошибка получения ответа", answer.Err
+	}
+	return Completion(answer.Text), nil
+}
+
+func (r *R1) Ping(ctx context.Context) error {
+	return r.PingErr
+}
+
+// Completion возвращает JSON ответа chat/completions с текстом content, как его отдаёт deepseek.R1Client
+func Completion(content string) string {
+	data, _ := json.Marshal(models.CompletionResponse{
+		ID:      "chatcmpl-test",
+		Object:  "chat.completion",
+		Choices: []models.Choice{{FinishReason: "stop", Message: models.R1Message{Role: "assistant", Content: content}}},
+		Usage:   models.Usage{CompletionTokens: Tokens, TotalTokens: Tokens},
+	})
+	return string(data)
+}
@@ -0,0 +1,79 @@
+package deepseek
+
+import (
+	"context"
+	"errors"
+	"github.com/mytelegrambot/settings"
+	"github.com/openai/openai-go"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// retryable сообщает, может ли повтор запроса к модели завершиться иначе: таймаут попытки,
+// 5xx, 429 и сетевые ошибки. Ошибки запроса (400, 401, 404) повторять бесполезно
+func retryable(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode == http.StatusTooManyRequests ||
+			apiErr.StatusCode == http.StatusRequestTimeout ||
+			apiErr.StatusCode >= http.StatusInternalServerError
+	}
+
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// retryDelay - пауза перед повтором номер attempt (с 0). Retry-After из ответа 429 или 503
+// соблюдается, но не дольше MaxBackoff
+func retryDelay(err error, policy settings.Retry, attempt int) time.Duration {
+	maxBackoff := time.Duration(policy.MaxBackoff)
+	if retryAfter, ok := retryAfter(err); ok {
+		return min(retryAfter, maxBackoff)
+	}
+
+	// сдвиг проверяется до выполнения: переполнение дало бы отрицательную паузу и панику в rand.N
+	delay := time.Duration(policy.Backoff)
+	if attempt >= 62 || delay > maxBackoff>>attempt {
+		delay = maxBackoff
+	} else {
+		delay <<= attempt
+	}
+	// случайная добавка, чтобы повторы одновременных вопросов не совпадали
+	return delay/2 + rand.N(delay/2+1)
+}
+
+// retryAfter читает заголовок Retry-After в секундах из ответа провайдера
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *openai.Error
+	if !errors.As(err, &apiErr) || apiErr.Response == nil {
+		return 0, false
+	}
+	seconds, parseErr := strconv.Atoi(apiErr.Response.Header.Get("Retry-After"))
+	if parseErr != nil || seconds <= 0 {
+		return 0, false
+	}
+	return time.Duration(seconds) * time.Second, true
+}
+
+// sleep ждёт d или отмены ctx
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
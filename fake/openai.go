@@ -0,0 +1,204 @@
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mytelegrambot/models"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// ChatMessage - сообщение из запроса chat/completions
+type ChatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// ChatRequest - запрос chat/completions, полученный фейковым OpenAI
+type ChatRequest struct {
+	Model    string        `json:"model"`
+	Messages []ChatMessage `json:"messages"`
+	Stream   bool          `json:"stream"`
+}
+
+// Question возвращает текст последнего сообщения пользователя
+func (r ChatRequest) Question() string {
+	for i := len(r.Messages) - 1; i >= 0; i-- {
+		if r.Messages[i].Role == "user" {
+			return r.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+// OpenAI - фейковый OpenAI-совместимый API: chat/completions и models
+type OpenAI struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []ChatRequest
+	reply    func(ChatRequest) string
+	delay    time.Duration
+	// chunkDelay - пауза между частями потокового ответа
+	chunkDelay time.Duration
+	failures   []int
+}
+
+// NewOpenAI запускает фейковый OpenAI, по умолчанию он отвечает "ответ: <вопрос>".
+// Клиент подключается к URL сервера как к базовому адресу API
+func NewOpenAI(t testing.TB) *OpenAI {
+	ai := &OpenAI{
+		reply: func(r ChatRequest) string { return "ответ: " + r.Question() },
+	}
+	ai.Server = httptest.NewServer(ai)
+	t.Cleanup(ai.Close)
+	return ai
+}
+
+// Reply задаёт текст ответа на запрос
+func (ai *OpenAI) Reply(reply func(ChatRequest) string) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.reply = reply
+}
+
+// Delay задерживает каждый ответ, например чтобы проверить таймауты
+func (ai *OpenAI) Delay(delay time.Duration) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.delay = delay
+}
+
+// ChunkDelay задерживает каждую часть потокового ответа, чтобы генерацию можно было прервать на середине
+func (ai *OpenAI) ChunkDelay(delay time.Duration) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.chunkDelay = delay
+}
+
+// FailNext заставляет следующий запрос chat/completions вернуть HTTP-ошибку status
+func (ai *OpenAI) FailNext(status int) {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	ai.failures = append(ai.failures, status)
+}
+
+// Requests возвращает полученные запросы chat/completions
+func (ai *OpenAI) Requests() []ChatRequest {
+	ai.mu.Lock()
+	defer ai.mu.Unlock()
+	return slices.Clone(ai.requests)
+}
+
+func (ai *OpenAI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/models":
+		_ = json.NewEncoder(w).Encode(map[string]any{
+			"object": "list",
+			"data":   []map[string]any{{"id": "fake/model", "object": "model", "owned_by": "fake"}},
+		})
+	case r.Method == http.MethodPost && r.URL.Path == "/chat/completions":
+		ai.completion(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+func (ai *OpenAI) completion(w http.ResponseWriter, r *http.Request) {
+	var req ChatRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ai.mu.Lock()
+	ai.requests = append(ai.requests, req)
+	n, reply, delay, chunkDelay := len(ai.requests), ai.reply, ai.delay, ai.chunkDelay
+	var status int
+	if len(ai.failures) > 0 {
+		status, ai.failures = ai.failures[0], ai.failures[1:]
+	}
+	ai.mu.Unlock()
+
+	if status != 0 {
+		writeError(w, status, http.StatusText(status))
+		return
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	content := reply(req)
+	prompt, completion := len([]rune(req.Question())), len([]rune(content))
+	usage := models.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	id := fmt.Sprintf("chatcmpl-%d", n)
+	if req.Stream {
+		stream(w, r, id, req.Model, content, usage, chunkDelay)
+		return
+	}
+
+	_ = json.NewEncoder(w).Encode(models.CompletionResponse{
+		ID:      id,
+		Model:   req.Model,
+		Object:  "chat.completion",
+		Created: int(time.Now().Unix()),
+		Choices: []models.Choice{{
+			FinishReason: "stop",
+			Message:      models.R1Message{Role: "assistant", Content: content},
+		}},
+		Usage: usage,
+	})
+}
+
+// stream отдаёт ответ в формате server-sent events по словам, последней частью идёт usage
+func stream(w http.ResponseWriter, r *http.Request, id, model, content string, usage models.Usage, delay time.Duration) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+
+	chunk := func(choices []map[string]any, usage *models.Usage) {
+		data, _ := json.Marshal(map[string]any{
+			"id":      id,
+			"object":  "chat.completion.chunk",
+			"created": time.Now().Unix(),
+			"model":   model,
+			"choices": choices,
+			"usage":   usage,
+		})
+		_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	words := strings.SplitAfter(content, " ")
+	for i, word := range words {
+		if i > 0 {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+		}
+		chunk([]map[string]any{{"index": 0, "delta": map[string]any{"role": "assistant", "content": word}}}, nil)
+	}
+	chunk([]map[string]any{{"index": 0, "delta": map[string]any{}, "finish_reason": "stop"}}, nil)
+	chunk([]map[string]any{}, &usage)
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"message": message, "code": status},
+	})
+}
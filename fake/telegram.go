@@ -0,0 +1,343 @@
+// Package fake содержит httptest-серверы, заменяющие Telegram Bot API и OpenAI-совместимый API в тестах
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TelegramToken - токен, который принимает фейковый Telegram
+const TelegramToken = "123456:fake-token"
+
+// maxPollTimeout ограничивает long polling, чтобы тесты не ждали ответа getUpdates по 25 секунд
+const maxPollTimeout = time.Second
+
+// Call - запрос, полученный фейковым Telegram
+type Call struct {
+	Method string
+	Params url.Values
+}
+
+// Telegram - фейковый Bot API. Хранит сообщения чатов, отдаёт апдейты из очереди и записывает все запросы
+type Telegram struct {
+	*httptest.Server
+	Bot tgbotapi.User
+
+	mu            sync.Mutex
+	calls         []Call
+	updates       []tgbotapi.Update
+	nextUpdateID  int
+	nextMessageID int
+	messages      map[int64][]tgbotapi.Message
+	commands      []tgbotapi.BotCommand
+	failures      map[string][]tgbotapi.APIResponse
+	// changed закрывается и заменяется при каждом новом апдейте, чтобы разбудить getUpdates
+	changed chan struct{}
+}
+
+// NewTelegram запускает фейковый Telegram, он останавливается по окончании теста
+func NewTelegram(t testing.TB) *Telegram {
+	tg := &Telegram{
+		Bot:           tgbotapi.User{ID: 1, IsBot: true, FirstName: "Fake", UserName: "fake_bot"},
+		nextUpdateID:  1,
+		nextMessageID: 1,
+		messages:      make(map[int64][]tgbotapi.Message),
+		failures:      make(map[string][]tgbotapi.APIResponse),
+		changed:       make(chan struct{}),
+		commands: []tgbotapi.BotCommand{
+			{Command: "start", Description: "начать"},
+			{Command: "help", Description: "помощь"},
+			{Command: "restart", Description: "сбросить диалог"},
+		},
+	}
+	tg.Server = httptest.NewServer(tg)
+	t.Cleanup(tg.Close)
+	return tg
+}
+
+// SetCommands задаёт ответ getMyCommands
+func (tg *Telegram) SetCommands(commands ...tgbotapi.BotCommand) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	tg.commands = commands
+}
+
+// FailNext заставляет следующий вызов method вернуть ошибку Bot API, например 429 с retry_after
+func (tg *Telegram) FailNext(method string, code int, description string, retryAfter int) {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	resp := tgbotapi.APIResponse{ErrorCode: code, Description: description}
+	if retryAfter > 0 {
+		resp.Parameters = &tgbotapi.ResponseParameters{RetryAfter: retryAfter}
+	}
+	tg.failures[method] = append(tg.failures[method], resp)
+}
+
+// SendText ставит в очередь getUpdates сообщение пользователя fromID в чат chatID.
+// Текст, начинающийся с "/", размечается как команда
+func (tg *Telegram) SendText(chatID, fromID int64, text string) tgbotapi.Update {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	msg := tgbotapi.Message{
+		MessageID: tg.nextMessageID,
+		From:      &tgbotapi.User{ID: fromID, FirstName: "User", UserName: "user" + strconv.FormatInt(fromID, 10)},
+		Chat:      &tgbotapi.Chat{ID: chatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
+	}
+	tg.nextMessageID++
+	if strings.HasPrefix(text, "/") {
+		length, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: len([]rune(length))}}
+	}
+	tg.messages[chatID] = append(tg.messages[chatID], msg)
+
+	return tg.addUpdate(tgbotapi.Update{Message: &msg})
+}
+
+// PressButton ставит в очередь getUpdates нажатие пользователем fromID inline-кнопки с data
+// под сообщением бота messageID
+func (tg *Telegram) PressButton(chatID, fromID int64, messageID int, data string) tgbotapi.Update {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	query := &tgbotapi.CallbackQuery{
+		ID:   fmt.Sprintf("callback-%d", tg.nextUpdateID),
+		From: &tgbotapi.User{ID: fromID, FirstName: "User", UserName: "user" + strconv.FormatInt(fromID, 10)},
+		Data: data,
+	}
+	if msg := tg.message(chatID, messageID); msg != nil {
+		copied := *msg
+		query.Message = &copied
+	}
+	return tg.addUpdate(tgbotapi.Update{CallbackQuery: query})
+}
+
+// addUpdate присваивает апдейту номер и будит getUpdates, вызывается под tg.mu
+func (tg *Telegram) addUpdate(update tgbotapi.Update) tgbotapi.Update {
+	update.UpdateID = tg.nextUpdateID
+	tg.nextUpdateID++
+	tg.updates = append(tg.updates, update)
+
+	close(tg.changed)
+	tg.changed = make(chan struct{})
+	return update
+}
+
+// Calls возвращает запросы к methods в порядке получения, без methods - все запросы
+func (tg *Telegram) Calls(methods ...string) []Call {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+
+	var calls []Call
+	for _, call := range tg.calls {
+		if len(methods) == 0 || slices.Contains(methods, call.Method) {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// WaitCalls ждёт, пока к method придёт n запросов, и возвращает их
+func (tg *Telegram) WaitCalls(t testing.TB, method string, n int) []Call {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		calls := tg.Calls(method)
+		if len(calls) >= n {
+			return calls
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("waiting for %d %s calls, got %d: %v", n, method, len(calls), calls)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Messages возвращает сообщения чата, которые не были удалены, с учётом правок
+func (tg *Telegram) Messages(chatID int64) []tgbotapi.Message {
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	return slices.Clone(tg.messages[chatID])
+}
+
+func (tg *Telegram) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method, ok := strings.CutPrefix(r.URL.Path, "/bot"+TelegramToken+"/")
+	if !ok {
+		writeResponse(w, tgbotapi.APIResponse{ErrorCode: http.StatusUnauthorized, Description: "Unauthorized"})
+		return
+	}
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		writeResponse(w, tgbotapi.APIResponse{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + err.Error()})
+		return
+	}
+
+	tg.mu.Lock()
+	tg.calls = append(tg.calls, Call{Method: method, Params: r.Form})
+	if failures := tg.failures[method]; len(failures) > 0 {
+		tg.failures[method] = failures[1:]
+		tg.mu.Unlock()
+		writeResponse(w, failures[0])
+		return
+	}
+	tg.mu.Unlock()
+
+	if method == "getUpdates" {
+		writeResponse(w, result(tg.getUpdates(r)))
+		return
+	}
+
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	writeResponse(w, tg.handle(method, r.Form))
+}
+
+// getUpdates отдаёт апдейты начиная с offset, а если их нет - ждёт новые до истечения timeout
+func (tg *Telegram) getUpdates(r *http.Request) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+	poll := time.NewTimer(min(time.Duration(timeout)*time.Second, maxPollTimeout))
+	defer poll.Stop()
+
+	for {
+		tg.mu.Lock()
+		// как и Telegram, считаем апдейты до offset подтверждёнными
+		tg.updates = slices.DeleteFunc(tg.updates, func(u tgbotapi.Update) bool { return u.UpdateID < offset })
+		updates := slices.Clone(tg.updates)
+		changed := tg.changed
+		tg.mu.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+		select {
+		case <-changed:
+		case <-poll.C:
+			return []tgbotapi.Update{}
+		case <-r.Context().Done():
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+func (tg *Telegram) handle(method string, params url.Values) tgbotapi.APIResponse {
+	chatID, _ := strconv.ParseInt(params.Get("chat_id"), 10, 64)
+	messageID, _ := strconv.Atoi(params.Get("message_id"))
+
+	switch method {
+	case "getMe":
+		return result(tg.Bot)
+	case "getMyCommands":
+		return result(tg.commands)
+	case "setMyCommands":
+		var commands []tgbotapi.BotCommand
+		if err := json.Unmarshal([]byte(params.Get("commands")), &commands); err != nil {
+			return badRequest("can't parse commands JSON object")
+		}
+		tg.commands = commands
+		return result(true)
+	case "sendMessage":
+		if params.Get("text") == "" {
+			return badRequest("message text is empty")
+		}
+		msg := tgbotapi.Message{Text: params.Get("text")}
+		if markup := params.Get("reply_markup"); markup != "" {
+			msg.ReplyMarkup = &tgbotapi.InlineKeyboardMarkup{}
+			if err := json.Unmarshal([]byte(markup), msg.ReplyMarkup); err != nil {
+				return badRequest("can't parse reply keyboard markup JSON object")
+			}
+		}
+		return result(tg.addMessage(chatID, msg))
+	case "sendDocument":
+		return result(tg.addMessage(chatID, tgbotapi.Message{
+			Caption:  params.Get("caption"),
+			Document: &tgbotapi.Document{FileID: fmt.Sprintf("file-%d", tg.nextMessageID)},
+		}))
+	case "editMessageText":
+		msg := tg.message(chatID, messageID)
+		if msg == nil {
+			return badRequest("message to edit not found")
+		}
+		if msg.Text == params.Get("text") {
+			return badRequest("message is not modified")
+		}
+		msg.Text = params.Get("text")
+		// как и в Telegram, редактирование без reply_markup убирает кнопки
+		msg.ReplyMarkup = nil
+		msg.EditDate = int(time.Now().Unix())
+		return result(msg)
+	case "deleteMessage":
+		if !tg.deleteMessage(chatID, messageID) {
+			return badRequest("message to delete not found")
+		}
+		return result(true)
+	case "deleteMessages":
+		var ids []int
+		if err := json.Unmarshal([]byte(params.Get("message_ids")), &ids); err != nil {
+			return badRequest("can't parse message identifiers")
+		}
+		for _, id := range ids {
+			tg.deleteMessage(chatID, id)
+		}
+		return result(true)
+	case "sendChatAction", "answerCallbackQuery":
+		return result(true)
+	}
+	return tgbotapi.APIResponse{ErrorCode: http.StatusNotFound, Description: "Not Found: method not found"}
+}
+
+func (tg *Telegram) addMessage(chatID int64, msg tgbotapi.Message) tgbotapi.Message {
+	bot := tg.Bot
+	msg.MessageID = tg.nextMessageID
+	msg.From = &bot
+	msg.Chat = &tgbotapi.Chat{ID: chatID, Type: "private"}
+	msg.Date = int(time.Now().Unix())
+	tg.nextMessageID++
+	tg.messages[chatID] = append(tg.messages[chatID], msg)
+	return msg
+}
+
+func (tg *Telegram) message(chatID int64, messageID int) *tgbotapi.Message {
+	for i := range tg.messages[chatID] {
+		if tg.messages[chatID][i].MessageID == messageID {
+			return &tg.messages[chatID][i]
+		}
+	}
+	return nil
+}
+
+func (tg *Telegram) deleteMessage(chatID int64, messageID int) bool {
+	before := len(tg.messages[chatID])
+	tg.messages[chatID] = slices.DeleteFunc(tg.messages[chatID], func(m tgbotapi.Message) bool { return m.MessageID == messageID })
+	return len(tg.messages[chatID]) < before
+}
+
+func result(value any) tgbotapi.APIResponse {
+	data, _ := json.Marshal(value)
+	return tgbotapi.APIResponse{Ok: true, Result: data}
+}
+
+func badRequest(description string) tgbotapi.APIResponse {
+	return tgbotapi.APIResponse{ErrorCode: http.StatusBadRequest, Description: "Bad Request: " + description}
+}
+
+func writeResponse(w http.ResponseWriter, resp tgbotapi.APIResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Ok {
+		w.WriteHeader(resp.ErrorCode)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/models"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// page - ответ со страницей списка. NextOffset задан, если есть следующая страница
+type page[T any] struct {
+	Items      []T  `json:"items"`
+	Limit      int  `json:"limit"`
+	Offset     int  `json:"offset"`
+	NextOffset *int `json:"next_offset,omitempty"`
+}
+
+// parsePage читает ?limit= и ?offset=. Хранилище запрашивается на одну запись больше,
+// чтобы понять, есть ли следующая страница
+func parsePage(c *gin.Context) (models.Page, error) {
+	result := models.Page{Limit: defaultPageLimit}
+
+	if raw := c.Query("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return models.Page{}, errors.New("limit must be between 1 and 500")
+		}
+		result.Limit = limit
+	}
+	if raw := c.Query("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return models.Page{}, errors.New("offset must be a non-negative integer")
+		}
+		result.Offset = offset
+	}
+
+	return result, nil
+}
+
+func newPage[T any](items []T, requested models.Page) page[T] {
+	result := page[T]{Items: items, Limit: requested.Limit, Offset: requested.Offset}
+	if len(items) > requested.Limit {
+		result.Items = items[:requested.Limit]
+		next := requested.Offset + requested.Limit
+		result.NextOffset = &next
+	}
+	return result
+}
+
+// paginated отвечает страницей, которую возвращает list для запрошенных limit и offset
+func paginated[T any](c *gin.Context, list func(models.Page) ([]T, error)) {
+	requested, err := parsePage(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	items, err := list(models.Page{Limit: requested.Limit + 1, Offset: requested.Offset})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, newPage(items, requested))
+}
+
+// ListChats отдаёт чаты с сохранёнными сообщениями, начиная с недавно активных
+func (h *BotHandler) ListChats(c *gin.Context) {
+	paginated(c, func(p models.Page) ([]models.ChatSummary, error) {
+		return h.service.ListChats(c, p)
+	})
+}
+
+// ListUsers отдаёт пользователей, писавших боту, с признаком блокировки
+func (h *BotHandler) ListUsers(c *gin.Context) {
+	paginated(c, func(p models.Page) ([]models.UserSummary, error) {
+		return h.service.ListUsers(c, p)
+	})
+}
+
+// ChatMessages отдаёт сообщения чата из активного диалога и архива, начиная с новых
+func (h *BotHandler) ChatMessages(c *gin.Context) {
+	chatID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid chat id"})
+		return
+	}
+
+	paginated(c, func(p models.Page) ([]models.ChatMessage, error) {
+		return h.service.ChatMessages(c, chatID, p)
+	})
+}
+
+// BlockUser блокирует (PUT) или разблокирует (DELETE) пользователя
+func (h *BotHandler) BlockUser(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	blocked := c.Request.Method == http.MethodPut
+	if err = h.service.BlockUser(c, userID, blocked, requestedBy(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "blocked": blocked})
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/models"
+	"github.com/stretchr/testify/require"
+	"net/http/httptest"
+	"testing"
+)
+
+func Test_parsePage(t *testing.T) {
+	tests := []struct {
+		name    string
+		query   string
+		want    models.Page
+		wantErr bool
+	}{
+		{name: "defaults", want: models.Page{Limit: defaultPageLimit}},
+		{name: "limit and offset", query: "?limit=10&offset=20", want: models.Page{Limit: 10, Offset: 20}},
+		{name: "limit too large", query: "?limit=501", wantErr: true},
+		{name: "zero limit", query: "?limit=0", wantErr: true},
+		{name: "negative offset", query: "?offset=-1", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/admin/chats"+tt.query, nil)
+
+			got, err := parsePage(c)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_newPage(t *testing.T) {
+	requested := models.Page{Limit: 2, Offset: 4}
+
+	last := newPage([]int{1, 2}, requested)
+	require.Equal(t, []int{1, 2}, last.Items)
+	require.Nil(t, last.NextOffset)
+
+	more := newPage([]int{1, 2, 3}, requested)
+	require.Equal(t, []int{1, 2}, more.Items)
+	require.NotNil(t, more.NextOffset)
+	require.Equal(t, 6, *more.NextOffset)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/service"
+	"github.com/mytelegrambot/storage"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// broadcastRequest - тело POST /admin/broadcasts. Без сегмента рассылка уходит во все известные чаты
+type broadcastRequest struct {
+	Text    string                  `json:"text"`
+	Segment models.BroadcastSegment `json:"segment"`
+}
+
+// broadcastError отвечает кодом, соответствующим ошибке рассылки
+func broadcastError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrInvalidBroadcast):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, storage.ErrBroadcastNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "broadcast not found"})
+	case errors.Is(err, storage.ErrBroadcastStatus):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func broadcastID(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid broadcast id"})
+		return 0, false
+	}
+	return id, true
+}
+
+// CreateBroadcast создаёт рассылку и сразу начинает отправку
+func (h *BotHandler) CreateBroadcast(c *gin.Context) {
+	var request broadcastRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	broadcast, err := h.service.CreateBroadcast(c, request.Text, request.Segment, requestedBy(c))
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, broadcast)
+}
+
+// ListBroadcasts отдаёт рассылки с ходом отправки, начиная с последней
+func (h *BotHandler) ListBroadcasts(c *gin.Context) {
+	paginated(c, func(p models.Page) ([]models.Broadcast, error) {
+		return h.service.ListBroadcasts(c, p)
+	})
+}
+
+// Broadcast отдаёт рассылку со счётчиками отправленных и неудачных доставок
+func (h *BotHandler) Broadcast(c *gin.Context) {
+	id, ok := broadcastID(c)
+	if !ok {
+		return
+	}
+
+	broadcast, err := h.service.GetBroadcast(c, id)
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, broadcast)
+}
+
+// BroadcastDeliveries отдаёт статус доставки рассылки в каждый чат
+func (h *BotHandler) BroadcastDeliveries(c *gin.Context) {
+	id, ok := broadcastID(c)
+	if !ok {
+		return
+	}
+
+	paginated(c, func(p models.Page) ([]models.BroadcastDelivery, error) {
+		return h.service.BroadcastDeliveries(c, id, p)
+	})
+}
+
+// PauseBroadcast приостанавливает (POST .../pause) или продолжает (POST .../resume) рассылку
+func (h *BotHandler) PauseBroadcast(c *gin.Context) {
+	id, ok := broadcastID(c)
+	if !ok {
+		return
+	}
+
+	change := h.service.PauseBroadcast
+	if strings.HasSuffix(c.FullPath(), "/resume") {
+		change = h.service.ResumeBroadcast
+	}
+	broadcast, err := change(c, id, requestedBy(c))
+	if err != nil {
+		broadcastError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, broadcast)
+}
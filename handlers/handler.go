@@ -1,16 +1,32 @@
 package handlers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
-	"github.com/mytelegrambot/service"
+	"github.com/mytelegrambot/auth"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/settings"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
 )
 
 type BotHandler struct {
-	service *service.Service
+	service       Service
+	authenticator auth.Authenticator
 }
 
-func NewBotHandler(service *service.Service) *BotHandler {
-	return &BotHandler{service: service}
+// NewBotHandler создаёт обработчики HTTP API. Без authenticator админские маршруты не регистрируются
+func NewBotHandler(service Service, authenticator auth.Authenticator) *BotHandler {
+	return &BotHandler{service: service, authenticator: authenticator}
+}
+
+// requestedBy - кто выполняет действие, для журнала аудита
+func requestedBy(c *gin.Context) string {
+	if principal, ok := auth.PrincipalFrom(c); ok {
+		return principal.String()
+	}
+	return "admin:" + c.ClientIP()
 }
 
 func (h *BotHandler) Commands(c *gin.Context) {
@@ -21,9 +37,121 @@ func (h *BotHandler) Commands(c *gin.Context) {
 	c.JSON(200, gin.H{"commands": commands})
 }
 
+// ForgetUser удаляет все данные пользователя Telegram по его ID
+func (h *BotHandler) ForgetUser(c *gin.Context) {
+	userID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	affected, err := h.service.ForgetUser(c, userID, requestedBy(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"user_id": userID, "rows_affected": affected})
+}
+
+// Healthz - проверка живости: процесс запущен и обслуживает HTTP
+func (h *BotHandler) Healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Readyz - проверка готовности: доступны хранилище, Telegram и LLM-провайдер
+func (h *BotHandler) Readyz(c *gin.Context) {
+	code, status := http.StatusOK, "ok"
+	checks := make(gin.H)
+
+	for name, err := range h.service.Ready(c) {
+		if err != nil {
+			code, status = http.StatusServiceUnavailable, "unavailable"
+			checks[name] = err.Error()
+			continue
+		}
+		checks[name] = "ok"
+	}
+
+	c.JSON(code, gin.H{"status": status, "checks": checks})
+}
+
+// Status отдаёт время работы, версию, задержку апдейтов, глубину очереди и последнюю ошибку
+func (h *BotHandler) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.Status(c))
+}
+
+// Settings отдаёт действующие настройки времени выполнения
+func (h *BotHandler) Settings(c *gin.Context) {
+	c.JSON(http.StatusOK, h.service.RuntimeSettings())
+}
+
+// PatchSettings меняет переданные в теле ключи настроек, остальные остаются прежними
+func (h *BotHandler) PatchSettings(c *gin.Context) {
+	patch, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	updated, err := h.service.PatchSettings(c, patch)
+	switch {
+	case errors.Is(err, settings.ErrReadOnly):
+		c.JSON(http.StatusConflict, gin.H{"error": "settings are loaded from a file, edit it and reload"})
+	case err != nil:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusOK, updated)
+	}
+}
+
+// ReloadSettings перечитывает настройки из файла или хранилища, как SIGHUP
+func (h *BotHandler) ReloadSettings(c *gin.Context) {
+	reloaded, err := h.service.ReloadSettings(c)
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, reloaded)
+}
+
 func (h *BotHandler) RegisterRoutes(router *gin.Engine) {
+	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
+	router.GET("/healthz", h.Healthz)
+	router.GET("/readyz", h.Readyz)
+
 	botGroup := router.Group("/bot")
 	{
 		botGroup.GET("/commands", h.Commands)
 	}
+
+	// без способов входа админские маршруты не регистрируются
+	if h.authenticator == nil {
+		return
+	}
+	authenticated := auth.Middleware(h.authenticator)
+	read, write := auth.RequireScope(auth.ScopeAdminRead), auth.RequireScope(auth.ScopeAdminWrite)
+
+	router.GET("/status", authenticated, read, h.Status)
+
+	adminGroup := router.Group("/admin", authenticated)
+	{
+		adminGroup.GET("/chats", read, h.ListChats)
+		adminGroup.GET("/chats/:id/messages", read, h.ChatMessages)
+		adminGroup.GET("/users", read, h.ListUsers)
+		adminGroup.DELETE("/users/:id", write, h.ForgetUser)
+		adminGroup.PUT("/users/:id/block", write, h.BlockUser)
+		adminGroup.DELETE("/users/:id/block", write, h.BlockUser)
+		// GET отдаёт текущий уровень логирования, PUT {"level":"debug"} меняет его без перезапуска
+		adminGroup.GET("/log-level", read, gin.WrapH(logger.Level()))
+		adminGroup.PUT("/log-level", write, gin.WrapH(logger.Level()))
+		adminGroup.GET("/settings", read, h.Settings)
+		adminGroup.PATCH("/settings", write, h.PatchSettings)
+		adminGroup.POST("/settings/reload", write, h.ReloadSettings)
+		adminGroup.GET("/broadcasts", read, h.ListBroadcasts)
+		adminGroup.POST("/broadcasts", write, h.CreateBroadcast)
+		adminGroup.GET("/broadcasts/:id", read, h.Broadcast)
+		adminGroup.GET("/broadcasts/:id/deliveries", read, h.BroadcastDeliveries)
+		adminGroup.POST("/broadcasts/:id/pause", write, h.PauseBroadcast)
+		adminGroup.POST("/broadcasts/:id/resume", write, h.PauseBroadcast)
+	}
 }
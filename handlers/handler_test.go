@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/auth"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/service"
+	"github.com/mytelegrambot/storage"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeService реализует только методы, нужные тестам, остальные вызовы паникуют
+type fakeService struct {
+	Service
+	ready      map[string]error
+	broadcasts map[int64]models.Broadcast
+	created    []string
+}
+
+func (f *fakeService) Ready(ctx context.Context) map[string]error {
+	return f.ready
+}
+
+func (f *fakeService) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	broadcast, ok := f.broadcasts[id]
+	if !ok {
+		return models.Broadcast{}, storage.ErrBroadcastNotFound
+	}
+	return broadcast, nil
+}
+
+func (f *fakeService) CreateBroadcast(ctx context.Context, text string, segment models.BroadcastSegment, requestedBy string) (models.Broadcast, error) {
+	if strings.TrimSpace(text) == "" {
+		return models.Broadcast{}, service.ErrInvalidBroadcast
+	}
+	f.created = append(f.created, requestedBy)
+	return models.Broadcast{ID: 1, Text: text}, nil
+}
+
+func TestBotHandler_routes(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	fake := &fakeService{
+		ready:      map[string]error{"storage": nil, "telegram": errors.New("timeout")},
+		broadcasts: map[int64]models.Broadcast{3: {ID: 3, Text: "привет"}},
+	}
+	router := gin.New()
+	NewBotHandler(fake, auth.StaticToken("secret")).RegisterRoutes(router)
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		body     string
+		token    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "not ready", method: "GET", path: "/readyz", wantCode: http.StatusServiceUnavailable, wantBody: `"telegram":"timeout"`},
+		{name: "admin requires a token", method: "GET", path: "/admin/broadcasts/3", wantCode: http.StatusUnauthorized},
+		{name: "broadcast", method: "GET", path: "/admin/broadcasts/3", token: "secret", wantCode: http.StatusOK, wantBody: `"text":"привет"`},
+		{name: "unknown broadcast", method: "GET", path: "/admin/broadcasts/4", token: "secret", wantCode: http.StatusNotFound},
+		{name: "invalid broadcast id", method: "GET", path: "/admin/broadcasts/x", token: "secret", wantCode: http.StatusBadRequest},
+		{name: "empty broadcast", method: "POST", path: "/admin/broadcasts", body: `{"text":" "}`, token: "secret", wantCode: http.StatusBadRequest},
+		{name: "create broadcast", method: "POST", path: "/admin/broadcasts", body: `{"text":"всем"}`, token: "secret", wantCode: http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			require.Equal(t, tt.wantCode, rec.Code, rec.Body.String())
+			require.Contains(t, rec.Body.String(), tt.wantBody)
+		})
+	}
+	require.Equal(t, []string{"static:admin_token"}, fake.created)
+}
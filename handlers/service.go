@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/service"
+	"github.com/mytelegrambot/settings"
+)
+
+// Commands - команды бота для публичного API
+type Commands interface {
+	ListCommands(ctx context.Context) ([]string, error)
+}
+
+// Health - проверки живости и состояние бота
+type Health interface {
+	Ready(ctx context.Context) map[string]error
+	Status(ctx context.Context) service.Status
+}
+
+// Admin - просмотр чатов и пользователей, удаление данных и блокировка
+type Admin interface {
+	ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error)
+	ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error)
+	ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error)
+	ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error)
+	BlockUser(ctx context.Context, userID int64, blocked bool, requestedBy string) error
+}
+
+// Settings - настройки времени выполнения
+type Settings interface {
+	RuntimeSettings() settings.Settings
+	PatchSettings(ctx context.Context, patch []byte) (settings.Settings, error)
+	ReloadSettings(ctx context.Context) (settings.Settings, error)
+}
+
+// Broadcasts - рассылки и статус их доставки
+type Broadcasts interface {
+	CreateBroadcast(ctx context.Context, text string, segment models.BroadcastSegment, requestedBy string) (models.Broadcast, error)
+	GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error)
+	ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error)
+	BroadcastDeliveries(ctx context.Context, id int64, page models.Page) ([]models.BroadcastDelivery, error)
+	PauseBroadcast(ctx context.Context, id int64, requestedBy string) (models.Broadcast, error)
+	ResumeBroadcast(ctx context.Context, id int64, requestedBy string) (models.Broadcast, error)
+}
+
+// Service - всё, что HTTP API использует из сервиса. Реализуется *service.Service
+type Service interface {
+	Commands
+	Health
+	Admin
+	Settings
+	Broadcasts
+}
+
+var _ Service = (*service.Service)(nil)
@@ -0,0 +1,163 @@
+// Package lifecycle управляет запуском и корректной остановкой компонентов приложения
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/zap"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Manager останавливает приложение в три этапа:
+//  1. по сигналу (или ошибке компонента) отменяется Intake - компоненты перестают брать новую работу;
+//  2. в пределах timeout ожидается завершение компонентов, запущенных через Go. Если время вышло,
+//     отменяется Work и начатая работа прерывается;
+//  3. выполняются функции OnShutdown в порядке, обратном регистрации
+type Manager struct {
+	logger  *zap.SugaredLogger
+	timeout time.Duration
+
+	intake       context.Context
+	cancelIntake context.CancelFunc
+	work         context.Context
+	cancelWork   context.CancelFunc
+
+	wg       sync.WaitGroup
+	failOnce sync.Once
+	failed   chan error
+
+	mu    sync.Mutex
+	hooks []hook
+}
+
+type hook struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// abortGrace - сколько ждать компоненты после отмены Work
+const abortGrace = 5 * time.Second
+
+func New(logger *zap.SugaredLogger, timeout time.Duration) *Manager {
+	m := &Manager{
+		logger:  logger,
+		timeout: timeout,
+		failed:  make(chan error, 1),
+	}
+	m.intake, m.cancelIntake = context.WithCancel(context.Background())
+	m.work, m.cancelWork = context.WithCancel(context.Background())
+	return m
+}
+
+// Intake отменяется в начале остановки: приём апдейтов и выдача новых задач прекращаются
+func (m *Manager) Intake() context.Context {
+	return m.intake
+}
+
+// Work отменяется, только если начатая работа не успела завершиться за timeout
+func (m *Manager) Work() context.Context {
+	return m.work
+}
+
+// Go запускает компонент. Остановка ждёт его завершения;
+// ошибка компонента (кроме отмены контекста) запускает остановку приложения
+func (m *Manager) Go(name string, run func() error) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		if err := run(); err != nil && !errors.Is(err, context.Canceled) {
+			m.logger.Errorw("component stopped", "component", name, "error", err)
+			m.Fail(err)
+		}
+	}()
+}
+
+// Fail запускает остановку приложения из-за ошибки err
+func (m *Manager) Fail(err error) {
+	m.failOnce.Do(func() { m.failed <- err })
+}
+
+// OnShutdown регистрирует функцию закрытия ресурса, она вызывается после завершения компонентов
+func (m *Manager) OnShutdown(name string, fn func(ctx context.Context) error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.hooks = append(m.hooks, hook{name: name, fn: fn})
+}
+
+// Wait блокируется до SIGINT/SIGTERM или ошибки компонента и затем останавливает приложение.
+// Возвращает ошибку компонента, из-за которой началась остановка
+func (m *Manager) Wait() error {
+	signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var cause error
+	select {
+	case <-signals.Done():
+		m.logger.Infow("shutdown signal received, draining", "timeout", m.timeout)
+	case cause = <-m.failed:
+		m.logger.Errorw("component failed, shutting down", "error", cause)
+	}
+	// повторный сигнал во время остановки завершает процесс сразу
+	stop()
+
+	m.Shutdown()
+	return cause
+}
+
+// Shutdown выполняет этапы остановки. Достаточно одного вызова
+func (m *Manager) Shutdown() {
+	deadline := time.Now().Add(m.timeout)
+	m.cancelIntake()
+
+	if !m.waitComponents(m.timeout) {
+		m.logger.Warnw("drain timeout exceeded, aborting in-flight work")
+		m.cancelWork()
+		if !m.waitComponents(abortGrace) {
+			m.logger.Errorw("components did not stop after abort")
+		}
+	}
+	m.cancelWork()
+
+	// закрытию ресурсов отводится остаток timeout, но не меньше abortGrace
+	ctx, cancel := context.WithDeadline(context.Background(), maxTime(deadline, time.Now().Add(abortGrace)))
+	defer cancel()
+
+	m.mu.Lock()
+	hooks := m.hooks
+	m.mu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		if err := hooks[i].fn(ctx); err != nil {
+			m.logger.Errorw("shutdown hook failed", "hook", hooks[i].name, "error", err)
+			continue
+		}
+		m.logger.Infow("shutdown hook done", "hook", hooks[i].name)
+	}
+}
+
+func (m *Manager) waitComponents(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		m.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
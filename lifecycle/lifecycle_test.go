@@ -0,0 +1,74 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestManager_DrainsWork(t *testing.T) {
+	m := New(zap.NewNop().Sugar(), time.Second)
+
+	var finished bool
+	m.Go("worker", func() error {
+		<-m.Intake().Done()
+		// начатая работа доделывается после отмены Intake
+		select {
+		case <-time.After(50 * time.Millisecond):
+			finished = true
+		case <-m.Work().Done():
+		}
+		return m.Intake().Err()
+	})
+
+	var order []string
+	m.OnShutdown("pool", func(ctx context.Context) error {
+		order = append(order, "pool")
+		return nil
+	})
+	m.OnShutdown("http", func(ctx context.Context) error {
+		require.True(t, finished, "hooks run after components")
+		order = append(order, "http")
+		return errors.New("ignored")
+	})
+
+	m.Shutdown()
+
+	require.True(t, finished)
+	require.Equal(t, []string{"http", "pool"}, order)
+}
+
+func TestManager_AbortsAfterTimeout(t *testing.T) {
+	m := New(zap.NewNop().Sugar(), 50*time.Millisecond)
+
+	aborted := make(chan struct{})
+	m.Go("stuck", func() error {
+		<-m.Work().Done()
+		close(aborted)
+		return m.Work().Err()
+	})
+
+	start := time.Now()
+	m.Shutdown()
+
+	<-aborted
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestManager_FailStopsApp(t *testing.T) {
+	m := New(zap.NewNop().Sugar(), time.Second)
+
+	m.Go("updates", func() error {
+		return errors.New("updates channel closed")
+	})
+	m.Go("janitor", func() error {
+		<-m.Intake().Done()
+		return m.Intake().Err()
+	})
+
+	require.EqualError(t, m.Wait(), "updates channel closed")
+	require.Error(t, m.Work().Err())
+}
@@ -0,0 +1,50 @@
+package logger
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+var (
+	// level - уровень логирования, меняется на лету через /admin/log-level
+	level = zap.NewAtomicLevel()
+	// base - логгер по умолчанию для контекстов без своего логгера, задаётся в NewLogger
+	base = zap.NewNop().Sugar()
+)
+
+type ctxKey struct{}
+
+// Level возвращает уровень логирования. zap.AtomicLevel реализует http.Handler:
+// GET отдаёт текущий уровень, PUT {"level":"debug"} меняет его
+func Level() zap.AtomicLevel {
+	return level
+}
+
+// WithContext кладёт логгер в контекст
+func WithContext(ctx context.Context, logger *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, logger)
+}
+
+// With добавляет поля к логгеру контекста, например chat_id и update_id апдейта
+func With(ctx context.Context, keysAndValues ...any) context.Context {
+	return WithContext(ctx, fromContext(ctx).With(keysAndValues...))
+}
+
+// FromContext возвращает логгер контекста с trace_id и span_id текущего спана
+func FromContext(ctx context.Context) *zap.SugaredLogger {
+	logger := fromContext(ctx)
+
+	spanCtx := trace.SpanContextFromContext(ctx)
+	if !spanCtx.IsValid() {
+		return logger
+	}
+	return logger.With("trace_id", spanCtx.TraceID().String(), "span_id", spanCtx.SpanID().String())
+}
+
+func fromContext(ctx context.Context) *zap.SugaredLogger {
+	if logger, ok := ctx.Value(ctxKey{}).(*zap.SugaredLogger); ok {
+		return logger
+	}
+	return base
+}
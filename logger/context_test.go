@@ -0,0 +1,36 @@
+package logger
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+func TestFromContext(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	ctx := WithContext(context.Background(), zap.New(core).Sugar())
+
+	ctx = With(ctx, "chat_id", int64(1001), "update_id", 7)
+	FromContext(ctx).Info("no span")
+
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "telegram.update")
+	defer span.End()
+	FromContext(ctx).Info("in span")
+
+	entries := logs.All()
+	require.Len(t, entries, 2)
+
+	require.Equal(t, int64(1001), entries[0].ContextMap()["chat_id"])
+	require.NotContains(t, entries[0].ContextMap(), "trace_id")
+
+	require.Equal(t, int64(7), entries[1].ContextMap()["update_id"])
+	require.Equal(t, span.SpanContext().TraceID().String(), entries[1].ContextMap()["trace_id"])
+	require.Equal(t, span.SpanContext().SpanID().String(), entries[1].ContextMap()["span_id"])
+}
+
+func TestFromContext_Default(t *testing.T) {
+	require.Same(t, base, FromContext(context.Background()))
+}
@@ -1,21 +1,40 @@
 package logger
 
 import (
+	"fmt"
 	"github.com/mytelegrambot/config"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
-	"log"
+	"gopkg.in/natefinch/lumberjack.v2"
 	"os"
+	"time"
 )
 
+// defaultLogFile - файл, который читает promtail, используется если LOG_OUTPUT_PATHS не задан
+const defaultLogFile = "./promtail/log/telemetry.log"
+
 type ZapLogger struct {
 	*zap.SugaredLogger
 }
 
-func NewLogger(config *config.Config, serviceName string) *zap.SugaredLogger {
-	file, err := os.OpenFile("./promtail/log/telemetry.log", os.O_RDWR|os.O_CREATE|os.O_APPEND, 0666)
-	if err != nil {
-		log.Fatalf("error opening file: %v", err)
+// NewLogger собирает логгер по config.Logger: синки для всех сообщений и отдельный синк ошибок,
+// JSON или консольный формат, ротация файлов и сэмплирование debug-сообщений
+func NewLogger(botCfg *config.Config, serviceName string) (*zap.SugaredLogger, error) {
+	cfg := botCfg.Logger
+
+	outputPaths := cfg.OutputPaths
+	if len(outputPaths) == 0 {
+		outputPaths = []string{defaultLogFile}
+		if botCfg.BotEnv {
+			outputPaths = []string{"stdout"}
+		}
+	}
+
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("parsing log level: %w", err)
+	}
+	if cfg.Level == "" && botCfg.BotEnv {
+		level.SetLevel(zapcore.DebugLevel)
 	}
 
 	encoderConfig := zapcore.EncoderConfig{
@@ -32,35 +51,127 @@ func NewLogger(config *config.Config, serviceName string) *zap.SugaredLogger {
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
-	debug := config.BotEnv
+	var encoder zapcore.Encoder
+	if cfg.Encoding == config.LogEncodingConsole {
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+	} else {
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	}
+
+	sinks := newSinks(cfg.Rotation)
 
-	var core zapcore.Core
+	output, err := sinks.open(outputPaths)
+	if err != nil {
+		return nil, err
+	}
 
-	if debug == false {
-		core = zapcore.NewCore(
-			zapcore.NewJSONEncoder(encoderConfig),
-			zapcore.Lock(file),
-			zapcore.InfoLevel,
+	var cores []zapcore.Core
+	if cfg.Sampling.Initial > 0 {
+		// сэмплируются только debug-сообщения, остальные уровни пишутся всегда
+		debugCore := zapcore.NewCore(encoder, output, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l == zapcore.DebugLevel && level.Enabled(l)
+		}))
+		cores = append(cores,
+			zapcore.NewSamplerWithOptions(debugCore, time.Second, cfg.Sampling.Initial, cfg.Sampling.Thereafter),
+			zapcore.NewCore(encoder, output, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+				return l > zapcore.DebugLevel && level.Enabled(l)
+			})),
 		)
 	} else {
-		core = zapcore.NewCore(
-			zapcore.NewJSONEncoder(encoderConfig),
-			zapcore.Lock(os.Stdout),
-			zapcore.DebugLevel,
-		)
+		cores = append(cores, zapcore.NewCore(encoder, output, level))
 	}
 
-	// Добавляем кастомные поля к каждому логу
-	logger := zap.New(core,
+	options := []zap.Option{
 		zap.AddCaller(),
 		zap.AddStacktrace(zapcore.ErrorLevel),
+		// Добавляем кастомные поля к каждому логу
 		zap.Fields(
 			zap.String("service", serviceName), // Метка service
-			zap.Bool("debug", config.BotEnv),   // Метка environment
+			zap.Bool("debug", botCfg.BotEnv),   // Метка environment
 		),
-	)
+	}
+	if cfg.Development {
+		options = append(options, zap.Development())
+	}
+
+	if len(cfg.ErrorOutputPaths) > 0 {
+		errorOutput, err := sinks.open(cfg.ErrorOutputPaths)
+		if err != nil {
+			return nil, err
+		}
+		cores = append(cores, zapcore.NewCore(encoder, errorOutput, zap.LevelEnablerFunc(func(l zapcore.Level) bool {
+			return l >= zapcore.ErrorLevel && level.Enabled(l)
+		})))
+		// внутренние ошибки самого zap (например, не удалось записать в синк)
+		options = append(options, zap.ErrorOutput(errorOutput))
+	}
+
+	logger := zap.New(zapcore.NewTee(cores...), options...)
+
+	// сообщения библиотек, пишущих через стандартный log (pgx, tgbotapi), тоже идут в zap
+	zap.RedirectStdLog(logger)
+	base = logger.Sugar()
 
-	logger.Sugar().Debug("logger created")
+	logger.Sugar().Debugw("logger created", "outputs", outputPaths, "error_outputs", cfg.ErrorOutputPaths)
+
+	return logger.Sugar(), nil
+}
+
+// sinks открывает синки по путям. Один и тот же файл открывается один раз,
+// даже если он указан и в OutputPaths, и в ErrorOutputPaths
+type sinks struct {
+	rotation config.LogRotation
+	files    map[string]zapcore.WriteSyncer
+}
+
+func newSinks(rotation config.LogRotation) *sinks {
+	return &sinks{rotation: rotation, files: make(map[string]zapcore.WriteSyncer)}
+}
+
+func (s *sinks) open(paths []string) (zapcore.WriteSyncer, error) {
+	var writers []zapcore.WriteSyncer
+
+	for _, path := range paths {
+		switch path {
+		case "stdout":
+			writers = append(writers, zapcore.Lock(os.Stdout))
+		case "stderr":
+			writers = append(writers, zapcore.Lock(os.Stderr))
+		default:
+			writer, err := s.file(path)
+			if err != nil {
+				return nil, err
+			}
+			writers = append(writers, writer)
+		}
+	}
+
+	return zapcore.NewMultiWriteSyncer(writers...), nil
+}
+
+func (s *sinks) file(path string) (zapcore.WriteSyncer, error) {
+	if writer, ok := s.files[path]; ok {
+		return writer, nil
+	}
+
+	maxSize := s.rotation.MaxSizeMB
+	if maxSize == 0 {
+		maxSize = 100
+	}
+	rotated := &lumberjack.Logger{
+		Filename:   path,
+		MaxSize:    maxSize,
+		MaxAge:     s.rotation.MaxAgeDays,
+		MaxBackups: s.rotation.MaxBackups,
+		Compress:   s.rotation.Compress,
+	}
+	// открываем файл сразу (lumberjack создаёт недостающие каталоги), чтобы ошибка
+	// доступа обнаружилась при старте, а не при первой записи
+	if _, err := rotated.Write(nil); err != nil {
+		return nil, fmt.Errorf("opening log file %v: %w", path, err)
+	}
 
-	return logger.Sugar()
+	writer := zapcore.Lock(zapcore.AddSync(rotated))
+	s.files[path] = writer
+	return writer, nil
 }
@@ -0,0 +1,76 @@
+package logger
+
+import (
+	"github.com/mytelegrambot/config"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readLines(t *testing.T, path string) []string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestNewLogger_Sinks(t *testing.T) {
+	dir := t.TempDir()
+	all := filepath.Join(dir, "logs", "all.log")
+	errors := filepath.Join(dir, "errors.log")
+
+	logger, err := NewLogger(&config.Config{Logger: config.Logger{
+		Level:            "info",
+		Encoding:         config.LogEncodingJSON,
+		OutputPaths:      []string{all},
+		ErrorOutputPaths: []string{errors},
+	}}, "test")
+	require.NoError(t, err)
+
+	logger.Debugw("skipped by level")
+	logger.Infow("update received", "update_id", 7)
+	logger.Errorw("update job failed")
+	require.NoError(t, logger.Sync())
+
+	lines := readLines(t, all)
+	require.Len(t, lines, 2)
+	require.Contains(t, lines[0], `"msg":"update received"`)
+	require.Contains(t, lines[0], `"update_id":7`)
+
+	lines = readLines(t, errors)
+	require.Len(t, lines, 1)
+	require.Contains(t, lines[0], `"msg":"update job failed"`)
+}
+
+func TestNewLogger_SamplesDebug(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "debug.log")
+
+	logger, err := NewLogger(&config.Config{Logger: config.Logger{
+		Level:       "debug",
+		Encoding:    config.LogEncodingConsole,
+		OutputPaths: []string{path},
+		Sampling:    config.LogSampling{Initial: 2, Thereafter: 5},
+	}}, "test")
+	require.NoError(t, err)
+
+	for i := 0; i < 10; i++ {
+		logger.Debug("saved message")
+		logger.Info("AI response sent")
+	}
+	require.NoError(t, logger.Sync())
+
+	var debug, info int
+	for _, line := range readLines(t, path) {
+		switch {
+		case strings.Contains(line, "saved message"):
+			debug++
+		case strings.Contains(line, "AI response sent"):
+			info++
+		}
+	}
+	// 2 первых + 7-е из оставшихся (каждое 5-е после первых двух)
+	require.Equal(t, 3, debug)
+	require.Equal(t, 10, info)
+}
@@ -2,64 +2,115 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/mytelegrambot/auth"
 	"github.com/mytelegrambot/bot"
 	"github.com/mytelegrambot/config"
 	"github.com/mytelegrambot/database"
 	"github.com/mytelegrambot/deepseek"
 	"github.com/mytelegrambot/handlers"
+	"github.com/mytelegrambot/lifecycle"
 	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
 	"github.com/mytelegrambot/service"
+	"github.com/mytelegrambot/settings"
 	"github.com/mytelegrambot/storage"
+	"github.com/mytelegrambot/tracing"
+	"github.com/prometheus/client_golang/prometheus"
 	"log"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 )
 
 func main() {
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-	go func() {
-		sigChan := make(chan os.Signal, 1)
-		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
-		<-sigChan
-		fmt.Println("Shutting down...")
-		cancel()
-	}()
-
-	botCfg, err := config.LoadEnvCfg(".env")
+	botCfg, err := config.Load(".env", os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	sugaredLogger := logger.NewLogger(botCfg, "main")
+	sugaredLogger, err := logger.NewLogger(botCfg, "main")
+	if err != nil {
+		log.Fatal(err)
+	}
 	defer sugaredLogger.Sync()
 
-	sugaredLogger.Infoln(
-		"tg bot startup by vlks",
-		"configurated by .env",
-	)
+	sugaredLogger.Infow("tg bot startup by vlks", "config", botCfg.String())
+
+	app := lifecycle.New(sugaredLogger, botCfg.ShutdownTimeout)
+	ctx := app.Intake()
 
-	b, err := bot.NewBot(botCfg)
+	shutdownTracing, err := tracing.Setup(ctx, botCfg.TracingExporter)
 	if err != nil {
-		log.Fatal(err)
+		sugaredLogger.Fatalw("startup failed", "error", err)
 	}
+	app.OnShutdown("tracing", shutdownTracing)
 
-	r1 := deepseek.NewR1(botCfg)
-
-	pool, err := database.GetPool(ctx, botCfg)
+	b, err := bot.NewBot(ctx, botCfg)
 	if err != nil {
-		log.Fatal(err)
+		sugaredLogger.Fatalw("startup failed", "error", err)
+	}
+
+	var botStorage storage.Storage
+
+	switch botCfg.StorageBackend {
+	case config.StorageMemory:
+		botStorage = storage.NewMemoryStorage()
+	case config.StorageSQLite:
+		sqliteStorage, err := storage.NewSQLiteStorage(ctx, botCfg.SQLitePath)
+		if err != nil {
+			sugaredLogger.Fatalw("startup failed", "error", err)
+		}
+		app.OnShutdown("sqlite", func(context.Context) error { return sqliteStorage.Close() })
+		botStorage = sqliteStorage
+	default:
+		pool, err := database.GetPool(ctx, botCfg)
+		if err != nil {
+			sugaredLogger.Fatalw("startup failed", "error", err)
+		}
+		app.OnShutdown("postgres pool", func(context.Context) error {
+			pool.Close()
+			return nil
+		})
+		prometheus.MustRegister(metrics.NewPoolCollector(pool))
+		botStorage = storage.NewBotStorage(pool, botCfg)
+	}
+	botStorage = storage.Instrument(botStorage, string(botCfg.StorageBackend))
+	sugaredLogger.Infow("storage initialized", "backend", botCfg.StorageBackend)
+
+	settingsSource := settings.FromRepository(botStorage)
+	if botCfg.SettingsFile != "" {
+		settingsSource = settings.FromFile(botCfg.SettingsFile)
+	}
+	providers := []string{config.DefaultLLMProvider}
+	for _, provider := range botCfg.LLMFallback {
+		providers = append(providers, provider.Name)
+	}
+	runtimeSettings := settings.NewStore(settingsSource, providers...)
+	if _, err = runtimeSettings.Reload(ctx); err != nil {
+		sugaredLogger.Fatalw("startup failed", "error", err)
 	}
-	defer pool.Close()
 
-	botStorage := storage.NewBotStorage(pool, botCfg)
+	r1 := deepseek.NewR1(botCfg, runtimeSettings)
 
-	newService := service.NewService(sugaredLogger, botStorage, r1, b)
+	newService := service.NewService(sugaredLogger, botStorage, r1, b, runtimeSettings, botCfg.AdminTelegramIDs)
+	// без меню бот работает, команды просто не подсказываются в Telegram
+	if err = newService.RegisterCommands(ctx); err != nil {
+		sugaredLogger.Warnw("registering bot commands", "error", err)
+	}
+
+	authenticators := auth.Chain{auth.NewAPIKeys(botStorage)}
+	if botCfg.AdminToken != "" {
+		authenticators = append(authenticators, auth.StaticToken(botCfg.AdminToken))
+	}
+	if len(botCfg.AdminTelegramIDs) > 0 {
+		authenticators = append(authenticators, auth.NewTelegramLogin(botCfg.Token, botCfg.AdminTelegramIDs, botCfg.TelegramLoginMaxAge))
+	}
 
-	handler := handlers.NewBotHandler(newService)
+	handler := handlers.NewBotHandler(newService, authenticators)
 
 	router := gin.New()
 	router.Use(gin.Recovery())
@@ -67,25 +118,49 @@ func main() {
 
 	handler.RegisterRoutes(engine)
 
-	errCh := make(chan error)
+	server := &http.Server{Addr: ":8080", Handler: router}
 
 	go func() {
-		sugaredLogger.Infow("starting web server", "addr", ":8080")
-		errCh <- router.Run(":8080")
+		sugaredLogger.Infow("starting web server", "addr", server.Addr)
+		if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			app.Fail(fmt.Errorf("web server: %w", err))
+		}
 	}()
+	// веб-сервер останавливается после обработки начатых апдейтов, до закрытия хранилища
+	app.OnShutdown("web server", server.Shutdown)
 
-	go func() {
+	app.Go("updates", func() error {
 		sugaredLogger.Infow("waiting for incoming bot requests...", "debug", botCfg.BotEnv)
-		errCh <- newService.SetBot(ctx)
-	}()
-
-	select {
-	case err = <-errCh:
-		log.Fatal(err)
-	case <-ctx.Done():
-		pool.Close()
-		sugaredLogger.Infow("app shutdown complete")
-
+		return newService.SetBot(ctx, app.Work())
+	})
+
+	app.Go("janitor", func() error {
+		return newService.RunJanitor(ctx, botCfg.Retention)
+	})
+
+	app.Go("update workers", func() error {
+		return newService.RunWorkers(ctx, app.Work(), botCfg.Workers)
+	})
+
+	app.Go("outbox relay", func() error {
+		return newService.RunOutbox(ctx)
+	})
+
+	app.Go("broadcasts", func() error {
+		return newService.RunBroadcasts(ctx, botCfg.BroadcastRate)
+	})
+
+	// SIGHUP перечитывает настройки времени выполнения без перезапуска
+	reloadSettings := make(chan os.Signal, 1)
+	signal.Notify(reloadSettings, syscall.SIGHUP)
+	app.Go("settings", func() error {
+		return runtimeSettings.Watch(logger.WithContext(ctx, sugaredLogger.With("component", "settings")), botCfg.SettingsReloadInterval, reloadSettings)
+	})
+
+	if err = app.Wait(); err != nil {
+		sugaredLogger.Errorw("app stopped with error", "error", err)
+		sugaredLogger.Sync()
+		os.Exit(1)
 	}
-
+	sugaredLogger.Infow("app shutdown complete")
 }
@@ -0,0 +1,99 @@
+// Package metrics содержит метрики Prometheus бота, хранилища и LLM
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "mytelegrambot"
+
+var (
+	UpdatesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "updates_received_total",
+		Help:      "Updates received from Telegram by type.",
+	}, []string{"type"})
+
+	Commands = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "commands_total",
+		Help:      "Bot commands handled by command name.",
+	}, []string{"command"})
+
+	LLMRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "llm_request_duration_seconds",
+		Help:      "LLM completion latency by model and outcome (ok, timeout, error).",
+		Buckets:   []float64{0.5, 1, 2.5, 5, 10, 20, 30, 40, 60},
+	}, []string{"model", "outcome"})
+
+	LLMTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_timeouts_total",
+		Help:      "LLM completions that hit the deadline, by model.",
+	}, []string{"model"})
+
+	LLMRetries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_retries_total",
+		Help:      "LLM completion retries.",
+	})
+
+	LLMFallbacks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_fallbacks_total",
+		Help:      "Questions passed to a fallback model after the previous one failed, by fallback model.",
+	}, []string{"model"})
+
+	LLMCircuitOpen = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "llm_circuit_open",
+		Help:      "Whether requests to an LLM provider are suspended by the circuit breaker (1) or not (0).",
+	}, []string{"provider"})
+
+	GenerationsStopped = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generations_stopped_total",
+		Help:      "Answer generations stopped by users.",
+	})
+
+	LLMTokens = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "llm_tokens_total",
+		Help:      "Tokens used by model and kind (prompt, completion).",
+	}, []string{"model", "kind"})
+
+	TelegramErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_errors_total",
+		Help:      "Failed Telegram Bot API calls by method.",
+	}, []string{"method"})
+
+	TelegramRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_api_retries_total",
+		Help:      "Retried Telegram Bot API calls by method and reason (rate_limited, transient).",
+	}, []string{"method", "reason"})
+
+	BroadcastMessages = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "broadcast_messages_total",
+		Help:      "Broadcast delivery attempts by outcome (sent, retry, failed).",
+	}, []string{"outcome"})
+
+	StorageQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "storage_query_duration_seconds",
+		Help:      "Storage operation latency by backend, operation and outcome (ok, error).",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 2.5, 10),
+	}, []string{"backend", "operation", "outcome"})
+)
+
+// Outcome возвращает метку результата для err
+func Outcome(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
@@ -14,6 +14,105 @@ type Message struct {
 	Timestamp    time.Time `json:"time_stamp"`
 }
 
+// ArchiveSession - диалог, заархивированный командой /restart
+type ArchiveSession struct {
+	ID            int64     `json:"id"`
+	ChatID        int64     `json:"chat_id"`
+	ArchivedAt    time.Time `json:"archived_at"`
+	MessagesCount int       `json:"messages_count"`
+	FirstText     string    `json:"first_text"`
+}
+
+// OutboxMessage - исходящее сообщение, ожидающее отправки в Telegram
+type OutboxMessage struct {
+	ID       int64  `json:"id"`
+	ChatID   int64  `json:"chat_id"`
+	Text     string `json:"text"`
+	Attempts int    `json:"attempts"`
+}
+
+// QueuedUpdate - входящий апдейт из очереди update_jobs
+type QueuedUpdate struct {
+	ID       int64           `json:"id"`
+	Update   tgbotapi.Update `json:"update"`
+	Attempts int             `json:"attempts"`
+}
+
 type Updates struct {
 	tgbotapi.UpdatesChannel
 }
+
+// Page - страница списка: не больше Limit записей, начиная с Offset
+type Page struct {
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+// ChatSummary - чат, известный боту по сохранённым сообщениям
+type ChatSummary struct {
+	ChatID           int64     `json:"chat_id"`
+	ActiveMessages   int       `json:"active_messages"`
+	ArchivedMessages int       `json:"archived_messages"`
+	LastMessageAt    time.Time `json:"last_message_at"`
+}
+
+// UserSummary - отправитель сохранённых сообщений
+type UserSummary struct {
+	UserID        int64     `json:"user_id"`
+	Username      string    `json:"username"`
+	MessagesCount int       `json:"messages_count"`
+	LastSeenAt    time.Time `json:"last_seen_at"`
+	Blocked       bool      `json:"blocked"`
+}
+
+// ChatMessage - сообщение чата из активного диалога или архива
+type ChatMessage struct {
+	Message
+	Archived bool `json:"archived"`
+}
+
+// APIKey - ключ доступа к HTTP API. Сам ключ не хранится, только его хеш и префикс для опознания
+type APIKey struct {
+	ID        int64      `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Broadcast - рассылка одного текста по известным боту чатам. Счётчики заполняются при чтении
+type Broadcast struct {
+	ID         int64            `json:"id"`
+	Text       string           `json:"text"`
+	Segment    BroadcastSegment `json:"segment"`
+	Status     string           `json:"status"`
+	CreatedBy  string           `json:"created_by"`
+	CreatedAt  time.Time        `json:"created_at"`
+	FinishedAt *time.Time       `json:"finished_at,omitempty"`
+	Total      int              `json:"total"`
+	Sent       int              `json:"sent"`
+	Failed     int              `json:"failed"`
+}
+
+// BroadcastSegment отбирает чаты рассылки. Пустой сегмент - все чаты с сохранёнными сообщениями
+type BroadcastSegment struct {
+	// ChatIDs - только эти чаты из известных боту
+	ChatIDs []int64 `json:"chat_ids,omitempty"`
+	// ActiveSince - только чаты, в которых были сообщения после этого времени
+	ActiveSince *time.Time `json:"active_since,omitempty"`
+}
+
+// BroadcastDelivery - доставка рассылки в один чат
+type BroadcastDelivery struct {
+	ID          int64      `json:"id"`
+	BroadcastID int64      `json:"broadcast_id"`
+	ChatID      int64      `json:"chat_id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	MessageID   int        `json:"message_id,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	SentAt      *time.Time `json:"sent_at,omitempty"`
+	// Text заполняется только у захваченных для отправки доставок
+	Text string `json:"-"`
+}
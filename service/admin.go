@@ -0,0 +1,42 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/models"
+)
+
+func (s *Service) ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error) {
+	chats, err := s.storage.admin.ListChats(ctx, page)
+	if err != nil {
+		return nil, fmt.Errorf("listing chats: %w", err)
+	}
+	return chats, nil
+}
+
+func (s *Service) ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error) {
+	users, err := s.storage.admin.ListUsers(ctx, page)
+	if err != nil {
+		return nil, fmt.Errorf("listing users: %w", err)
+	}
+	return users, nil
+}
+
+func (s *Service) ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error) {
+	messages, err := s.storage.admin.ChatMessages(ctx, chatID, page)
+	if err != nil {
+		return nil, fmt.Errorf("listing messages of chat (%v): %w", chatID, err)
+	}
+	return messages, nil
+}
+
+// BlockUser блокирует или разблокирует пользователя. Сообщения заблокированного пользователя
+// принимаются из Telegram, но не обрабатываются
+func (s *Service) BlockUser(ctx context.Context, userID int64, blocked bool, requestedBy string) error {
+	if err := s.storage.admin.SetUserBlocked(ctx, userID, blocked, requestedBy); err != nil {
+		return fmt.Errorf("blocking user (%v): %w", userID, err)
+	}
+	logger.FromContext(ctx).Infow("user block changed", "user_id", userID, "blocked", blocked, "requested_by", requestedBy)
+	return nil
+}
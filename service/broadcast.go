@@ -0,0 +1,337 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/storage"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// maxBroadcastAttempts - после стольких неудачных отправок доставка помечается failed
+	maxBroadcastAttempts = 5
+	// broadcastStaleAfter - через сколько доставка в статусе sending считается брошенной упавшим процессом.
+	// Отправка ограничена тем же outboxSendTimeout, что и ответы бота
+	broadcastStaleAfter = outboxStaleAfter
+	broadcastInterval   = 5 * time.Second
+	// broadcastChatInterval - Telegram разрешает не больше одного сообщения в секунду в один чат
+	broadcastChatInterval = time.Second
+	// maxMessageLength - ограничение Telegram на длину текста сообщения
+	maxMessageLength = 4096
+)
+
+// ErrInvalidBroadcast возвращается, если рассылку нельзя отправить в Telegram как есть
+var ErrInvalidBroadcast = errors.New("invalid broadcast")
+
+// CreateBroadcast создаёт рассылку по чатам сегмента, отправку выполняет RunBroadcasts
+func (s *Service) CreateBroadcast(ctx context.Context, text string, segment models.BroadcastSegment, requestedBy string) (models.Broadcast, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return models.Broadcast{}, fmt.Errorf("%w: text is empty", ErrInvalidBroadcast)
+	}
+	if length := utf8.RuneCountInString(text); length > maxMessageLength {
+		return models.Broadcast{}, fmt.Errorf("%w: text is %d characters long, telegram allows %d", ErrInvalidBroadcast, length, maxMessageLength)
+	}
+
+	broadcast, err := s.storage.broadcasts.CreateBroadcast(ctx, models.Broadcast{Text: text, Segment: segment, CreatedBy: requestedBy})
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("creating broadcast: %w", err)
+	}
+	logger.FromContext(ctx).Infow("broadcast created",
+		"broadcast_id", broadcast.ID, "chats", broadcast.Total, "requested_by", requestedBy)
+	s.notifyBroadcasts()
+
+	return broadcast, nil
+}
+
+func (s *Service) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	broadcast, err := s.storage.broadcasts.GetBroadcast(ctx, id)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("getting broadcast (%v): %w", id, err)
+	}
+	return broadcast, nil
+}
+
+func (s *Service) ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error) {
+	broadcasts, err := s.storage.broadcasts.ListBroadcasts(ctx, page)
+	if err != nil {
+		return nil, fmt.Errorf("listing broadcasts: %w", err)
+	}
+	return broadcasts, nil
+}
+
+func (s *Service) BroadcastDeliveries(ctx context.Context, id int64, page models.Page) ([]models.BroadcastDelivery, error) {
+	deliveries, err := s.storage.broadcasts.BroadcastDeliveries(ctx, id, page)
+	if err != nil {
+		return nil, fmt.Errorf("listing deliveries of broadcast (%v): %w", id, err)
+	}
+	return deliveries, nil
+}
+
+// PauseBroadcast останавливает отправку. Уже захваченные сообщения (не больше секунды отправки) будут доставлены
+func (s *Service) PauseBroadcast(ctx context.Context, id int64, requestedBy string) (models.Broadcast, error) {
+	return s.setBroadcastStatus(ctx, id, storage.BroadcastRunning, storage.BroadcastPaused, requestedBy)
+}
+
+// ResumeBroadcast продолжает отправку приостановленной рассылки
+func (s *Service) ResumeBroadcast(ctx context.Context, id int64, requestedBy string) (models.Broadcast, error) {
+	broadcast, err := s.setBroadcastStatus(ctx, id, storage.BroadcastPaused, storage.BroadcastRunning, requestedBy)
+	if err == nil {
+		s.notifyBroadcasts()
+	}
+	return broadcast, err
+}
+
+func (s *Service) setBroadcastStatus(ctx context.Context, id int64, from, to, requestedBy string) (models.Broadcast, error) {
+	if err := s.storage.broadcasts.SetBroadcastStatus(ctx, id, from, to); err != nil {
+		return models.Broadcast{}, fmt.Errorf("changing broadcast (%v) status to %s: %w", id, to, err)
+	}
+	logger.FromContext(ctx).Infow("broadcast status changed", "broadcast_id", id, "status", to, "requested_by", requestedBy)
+	return s.GetBroadcast(ctx, id)
+}
+
+// notifyBroadcasts будит RunBroadcasts, не дожидаясь очередного опроса хранилища
+func (s *Service) notifyBroadcasts() {
+	select {
+	case s.broadcasts <- struct{}{}:
+	default:
+	}
+}
+
+// RunBroadcasts отправляет рассылки не быстрее perSecond сообщений в секунду на весь бот и одного
+// сообщения в секунду на чат. Остальная квота Telegram (около 30 сообщений в секунду) остаётся ответам бота
+func (s *Service) RunBroadcasts(ctx context.Context, perSecond int) error {
+	ctx = logger.WithContext(ctx, s.logger.With("component", "broadcasts"))
+
+	limiter := newBroadcastLimiter(perSecond, broadcastChatInterval)
+	ticker := time.NewTicker(broadcastInterval)
+	defer ticker.Stop()
+
+	for {
+		claimed, err := s.sendBroadcasts(ctx, limiter, perSecond)
+		if err != nil {
+			return err
+		}
+		if claimed > 0 {
+			continue
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.broadcasts:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// sendBroadcasts отмечает в БД доставленные, но не записанные сообщения рассылок, а затем захватывает
+// и отправляет следующие доставки. Возвращает число захваченных доставок, ошибка - только при отмене ctx
+func (s *Service) sendBroadcasts(ctx context.Context, limiter *broadcastLimiter, perSecond int) (int, error) {
+	log := logger.FromContext(ctx)
+
+	for deliveryID, message := range s.deliveredBroadcasts.snapshot() {
+		if err := s.storage.broadcasts.MarkBroadcastDeliverySent(ctx, deliveryID, message.MessageID); err != nil {
+			log.Warnw("recording broadcast delivery", "delivery_id", deliveryID, "error", err)
+			continue
+		}
+		s.deliveredBroadcasts.remove(deliveryID)
+	}
+
+	// захватываем примерно секунду отправки, чтобы пауза рассылки применялась быстро
+	deliveries, err := s.storage.broadcasts.ClaimBroadcastDeliveries(ctx, broadcastStaleAfter, perSecond)
+	if err != nil {
+		log.Errorw("claiming broadcast deliveries", "error", err)
+	}
+	for i, delivery := range deliveries {
+		if message, ok := s.deliveredBroadcasts.get(delivery.ID); ok {
+			// сообщение уже в чате, повторяется только запись
+			s.recordBroadcast(ctx, delivery, message)
+			continue
+		}
+		if err := s.deliverBroadcast(ctx, limiter, delivery); err != nil {
+			s.releaseDeliveries(ctx, deliveries[i:])
+			return 0, err
+		}
+	}
+	return len(deliveries), nil
+}
+
+// deliverBroadcast отправляет доставку в отведённое лимитером время. Ошибка возвращается только при отмене ctx.
+// Ответ 429 сюда доходит, только если его не смог переждать bot.Scheduler, и повторяется как любой другой сбой
+func (s *Service) deliverBroadcast(ctx context.Context, limiter *broadcastLimiter, delivery models.BroadcastDelivery) error {
+	log := logger.FromContext(ctx).With("broadcast_id", delivery.BroadcastID, "chat_id", delivery.ChatID)
+
+	if err := sleepUntil(ctx, limiter.reserve(delivery.ChatID, time.Now())); err != nil {
+		return err
+	}
+
+	sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+	message, err := s.bot.SendMessage(sendCtx, delivery.ChatID, delivery.Text)
+	cancel()
+	if err == nil {
+		metrics.BroadcastMessages.WithLabelValues("sent").Inc()
+		s.recordBroadcast(ctx, delivery, message)
+		return nil
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	retry := delivery.Attempts < maxBroadcastAttempts && !permanentSendError(err)
+
+	outcome := "failed"
+	if retry {
+		outcome = "retry"
+	}
+	metrics.BroadcastMessages.WithLabelValues(outcome).Inc()
+	log.Warnw("broadcast delivery failed", "attempt", delivery.Attempts, "retry", retry, "error", err)
+
+	if err = s.storage.broadcasts.MarkBroadcastDeliveryFailed(ctx, delivery.ID, err.Error(), retry); err != nil {
+		log.Errorw("marking broadcast delivery failed", "delivery_id", delivery.ID, "error", err)
+	}
+	return nil
+}
+
+// recordBroadcast отмечает доставку отправленной. Если БД недоступна, запись повторит sendBroadcasts,
+// не отправляя сообщение ещё раз
+func (s *Service) recordBroadcast(ctx context.Context, delivery models.BroadcastDelivery, message *tgbotapi.Message) {
+	if err := s.storage.broadcasts.MarkBroadcastDeliverySent(ctx, delivery.ID, message.MessageID); err != nil {
+		s.deliveredBroadcasts.add(delivery.ID, message)
+		logger.FromContext(ctx).Errorw("recording broadcast delivery",
+			"broadcast_id", delivery.BroadcastID, "delivery_id", delivery.ID, "error", err)
+		return
+	}
+	s.deliveredBroadcasts.remove(delivery.ID)
+}
+
+// releaseDeliveries возвращает в очередь захваченные, но не отправленные из-за остановки доставки
+func (s *Service) releaseDeliveries(ctx context.Context, deliveries []models.BroadcastDelivery) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	for _, delivery := range deliveries {
+		if err := s.storage.broadcasts.MarkBroadcastDeliveryFailed(ctx, delivery.ID, "interrupted by shutdown", true); err != nil {
+			logger.FromContext(ctx).Warnw("releasing broadcast delivery", "delivery_id", delivery.ID, "error", err)
+		}
+	}
+}
+
+func sleepUntil(ctx context.Context, at time.Time) error {
+	wait := time.Until(at)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// broadcastLimiter распределяет отправку рассылок во времени: не чаще interval на весь бот
+// и perChat на один чат. Используется только из RunBroadcasts, поэтому без блокировок
+type broadcastLimiter struct {
+	interval time.Duration
+	perChat  time.Duration
+	next     time.Time
+	chats    map[int64]time.Time
+}
+
+func newBroadcastLimiter(perSecond int, perChat time.Duration) *broadcastLimiter {
+	return &broadcastLimiter{
+		interval: time.Second / time.Duration(perSecond),
+		perChat:  perChat,
+		chats:    make(map[int64]time.Time),
+	}
+}
+
+// reserve возвращает время, когда можно отправить сообщение в чат, и занимает его
+func (l *broadcastLimiter) reserve(chatID int64, now time.Time) time.Time {
+	at := now
+	if l.next.After(at) {
+		at = l.next
+	}
+	if last, ok := l.chats[chatID]; ok && last.Add(l.perChat).After(at) {
+		at = last.Add(l.perChat)
+	}
+
+	l.next = at.Add(l.interval)
+	l.chats[chatID] = at
+
+	// чаты, в которые давно ничего не отправлялось, лимитер больше не ограничивают
+	for id, last := range l.chats {
+		if last.Add(l.perChat).Before(now) {
+			delete(l.chats, id)
+		}
+	}
+	return at
+}
+
+// isAdmin проверяет, что отправитель входит в список администраторов из конфига
+func (s *Service) isAdmin(from *tgbotapi.User) bool {
+	return from != nil && slices.Contains(s.admins, from.ID)
+}
+
+const broadcastUsage = `Рассылка по всем чатам бота:
+/broadcast send <текст> — отправить всем
+/broadcast status <номер> — ход рассылки
+/broadcast pause <номер> — приостановить
+/broadcast resume <номер> — продолжить`
+
+// broadcastCommand обрабатывает /broadcast от администратора. Неизвестное действие или номер
+// не создают рассылку, а возвращают справку: опечатка не должна уйти во все чаты
+func (s *Service) broadcastCommand(ctx context.Context, msg *tgbotapi.Message) error {
+	requestedBy := "telegram:" + strconv.FormatInt(msg.From.ID, 10)
+	// текст рассылки может начинаться с новой строки, поэтому действие отделяется любым пробельным символом
+	action := strings.TrimSpace(msg.CommandArguments())
+	var rest string
+	if i := strings.IndexFunc(action, unicode.IsSpace); i >= 0 {
+		action, rest = action[:i], strings.TrimSpace(action[i:])
+	}
+	id, idErr := strconv.ParseInt(strings.TrimPrefix(rest, "#"), 10, 64)
+
+	var broadcast models.Broadcast
+	var err error
+	switch {
+	case action == "send" && rest != "":
+		broadcast, err = s.CreateBroadcast(ctx, rest, models.BroadcastSegment{}, requestedBy)
+	case action == "status" && idErr == nil:
+		broadcast, err = s.GetBroadcast(ctx, id)
+	case action == "pause" && idErr == nil:
+		broadcast, err = s.PauseBroadcast(ctx, id, requestedBy)
+	case action == "resume" && idErr == nil:
+		broadcast, err = s.ResumeBroadcast(ctx, id, requestedBy)
+	default:
+		return s.sendAndSave(ctx, msg.Chat.ID, broadcastUsage)
+	}
+
+	switch {
+	case errors.Is(err, storage.ErrBroadcastNotFound):
+		return s.sendAndSave(ctx, msg.Chat.ID, fmt.Sprintf("Рассылка #%d не найдена", id))
+	case errors.Is(err, storage.ErrBroadcastStatus):
+		return s.sendAndSave(ctx, msg.Chat.ID, fmt.Sprintf("Рассылку #%d нельзя перевести в этот статус", id))
+	case errors.Is(err, ErrInvalidBroadcast):
+		return s.sendAndSave(ctx, msg.Chat.ID, "Рассылка не создана: "+err.Error())
+	case err != nil:
+		return err
+	}
+
+	return s.sendAndSave(ctx, msg.Chat.ID, fmt.Sprintf(
+		"Рассылка #%d: %s, чатов %d, отправлено %d, ошибок %d",
+		broadcast.ID, broadcast.Status, broadcast.Total, broadcast.Sent, broadcast.Failed))
+}
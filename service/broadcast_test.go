@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/bot"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/settings"
+	"github.com/mytelegrambot/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBroadcastLimiter(t *testing.T) {
+	l := newBroadcastLimiter(10, time.Second)
+	now := time.Now()
+
+	require.Equal(t, now, l.reserve(1, now))
+	require.Equal(t, now.Add(100*time.Millisecond), l.reserve(2, now), "global rate")
+	require.Equal(t, now.Add(time.Second), l.reserve(1, now), "one message per second to a chat")
+	require.Equal(t, now.Add(1100*time.Millisecond), l.reserve(3, now))
+}
+
+// sendBot - бот, у которого реализована только отправка сообщений
+type sendBot struct {
+	bot.BotAPI
+	send func(chatID int64, text string) (*tgbotapi.Message, error)
+}
+
+func (b sendBot) SendMessage(ctx context.Context, chatID int64, text string) (*tgbotapi.Message, error) {
+	return b.send(chatID, text)
+}
+
+func TestService_deliverBroadcast(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	for _, chatID := range []int64{1, 2, 3} {
+		require.NoError(t, store.Save(ctx, &models.Message{ChatID: chatID, MessageID: 1, FromID: chatID, Text: "привет", Timestamp: time.Now()}))
+	}
+
+	s := &Service{logger: zap.NewNop().Sugar(), storage: newStores(store), bot: sendBot{send: func(chatID int64, text string) (*tgbotapi.Message, error) {
+		switch chatID {
+		case 2:
+			return nil, &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}
+		case 3:
+			return nil, &tgbotapi.Error{Code: 429, Message: "Too Many Requests", ResponseParameters: tgbotapi.ResponseParameters{RetryAfter: 7}}
+		}
+		return &tgbotapi.Message{MessageID: 100 + int(chatID)}, nil
+	}}}
+
+	created, err := s.CreateBroadcast(ctx, "  плановые работы  ", models.BroadcastSegment{}, "test")
+	require.NoError(t, err)
+	require.Equal(t, "плановые работы", created.Text)
+	require.Equal(t, 3, created.Total)
+
+	limiter := newBroadcastLimiter(30, time.Second)
+	deliveries, err := store.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	for _, delivery := range deliveries {
+		require.NoError(t, s.deliverBroadcast(ctx, limiter, delivery))
+	}
+
+	result, err := store.BroadcastDeliveries(ctx, created.ID, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, storage.DeliverySent, result[0].Status)
+	require.Equal(t, 101, result[0].MessageID)
+	require.Equal(t, storage.DeliveryFailed, result[1].Status, "blocked by the user is not retried")
+	require.Equal(t, storage.DeliveryPending, result[2].Status, "flood limit is retried")
+
+	_, err = s.CreateBroadcast(ctx, " ", models.BroadcastSegment{}, "test")
+	require.ErrorIs(t, err, ErrInvalidBroadcast)
+}
+
+// failingDeliveryStorage - хранилище, которое не может отметить доставку рассылки, пока failMark == true
+type failingDeliveryStorage struct {
+	storage.Storage
+	mu       sync.Mutex
+	failMark bool
+}
+
+func (s *failingDeliveryStorage) setFailMark(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failMark = fail
+}
+
+func (s *failingDeliveryStorage) MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error {
+	s.mu.Lock()
+	fail := s.failMark
+	s.mu.Unlock()
+	if fail {
+		return errors.New("database is down")
+	}
+	return s.Storage.MarkBroadcastDeliverySent(ctx, id, messageID)
+}
+
+func TestService_sendBroadcasts_recordFails(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Now()}
+	store := &failingDeliveryStorage{Storage: storage.NewMemoryStorage(storage.WithClock(clock.Now)), failMark: true}
+	require.NoError(t, store.Save(ctx, &models.Message{ChatID: 1, MessageID: 1, FromID: 1, Text: "привет", Timestamp: time.Now()}))
+
+	var sends int
+	s := &Service{logger: zap.NewNop().Sugar(), storage: newStores(store), bot: sendBot{send: func(chatID int64, text string) (*tgbotapi.Message, error) {
+		sends++
+		return &tgbotapi.Message{MessageID: 100}, nil
+	}}}
+	created, err := s.CreateBroadcast(ctx, "плановые работы", models.BroadcastSegment{}, "test")
+	require.NoError(t, err)
+
+	limiter := newBroadcastLimiter(30, time.Nanosecond)
+	claimed, err := s.sendBroadcasts(ctx, limiter, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, claimed)
+
+	// доставка брошена в статусе sending, но сообщение уже в чате и второй раз не отправляется
+	clock.Advance(broadcastStaleAfter + time.Minute)
+	claimed, err = s.sendBroadcasts(ctx, limiter, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, claimed)
+	require.Equal(t, 1, sends)
+
+	store.setFailMark(false)
+	_, err = s.sendBroadcasts(ctx, limiter, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, sends)
+
+	result, err := store.BroadcastDeliveries(ctx, created.ID, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Equal(t, storage.DeliverySent, result[0].Status)
+	require.Equal(t, 100, result[0].MessageID)
+}
+
+func TestService_broadcastCommand(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		wantReply     string
+		wantBroadcast string
+	}{
+		{name: "usage", text: "/broadcast", wantReply: broadcastUsage},
+		{name: "text without a verb is not sent", text: "/broadcast всем привет", wantReply: broadcastUsage},
+		{name: "empty text", text: "/broadcast send  ", wantReply: broadcastUsage},
+		{name: "unknown action", text: "/broadcast stats 1", wantReply: broadcastUsage},
+		{name: "invalid id", text: "/broadcast pause первую", wantReply: broadcastUsage},
+		{name: "send", text: "/broadcast send плановые работы", wantReply: "Рассылка #1: ", wantBroadcast: "плановые работы"},
+		{name: "multiline text", text: "/broadcast send\nплановые\nработы", wantReply: "Рассылка #1: ", wantBroadcast: "плановые\nработы"},
+		{name: "unknown broadcast", text: "/broadcast status #5", wantReply: "Рассылка #5 не найдена"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestService(t, settings.Default())
+
+			require.NoError(t, s.broadcastCommand(ctx, textMessage(testAdminID, tt.text)))
+			sent := s.bot.Sent()
+			require.Len(t, sent, 1)
+			require.True(t, strings.HasPrefix(sent[0], tt.wantReply), sent[0])
+
+			broadcasts, err := s.storage.broadcasts.ListBroadcasts(ctx, models.Page{Limit: 10})
+			require.NoError(t, err)
+			if tt.wantBroadcast == "" {
+				require.Empty(t, broadcasts)
+				return
+			}
+			require.Len(t, broadcasts, 1)
+			require.Equal(t, tt.wantBroadcast, broadcasts[0].Text)
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/mytelegrambot/bot"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/deepseek"
+	"github.com/mytelegrambot/fake"
+	"github.com/mytelegrambot/settings"
+	"github.com/mytelegrambot/storage"
+	"github.com/openai/openai-go/option"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newE2EService запускает приём апдейтов и воркеры сервиса, подключённого к фейковым Telegram и OpenAI
+func newE2EService(t *testing.T) (*fake.Telegram, *fake.OpenAI, storage.Storage) {
+	t.Helper()
+
+	tg, ai := fake.NewTelegram(t), fake.NewOpenAI(t)
+	store := storage.NewMemoryStorage()
+	defaults := settings.Default()
+	defaults.Retry.Backoff = settings.Duration(time.Millisecond)
+	runtime := settings.Static(defaults)
+
+	limits := bot.DefaultLimits()
+	limits.PrivateChat, limits.ChatBurst = 100, 100
+	b, err := bot.NewBot(context.Background(), &config.Config{Token: fake.TelegramToken},
+		bot.WithServerURL(tg.URL), bot.WithLimits(limits))
+	require.NoError(t, err)
+	r1 := deepseek.NewR1(&config.Config{R1ProToken: "test"}, runtime, option.WithBaseURL(ai.URL))
+
+	s := NewService(zap.NewNop().Sugar(), store, r1, b, runtime, nil)
+	require.NoError(t, s.RegisterCommands(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_ = s.SetBot(ctx, ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		_ = s.RunWorkers(ctx, ctx, 1)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	return tg, ai, store
+}
+
+func TestService_endToEnd(t *testing.T) {
+	tg, ai, store := newE2EService(t)
+	const chatID = 10
+
+	require.Contains(t, tg.Calls("setMyCommands")[0].Params.Get("commands"), `"command":"forgetme"`)
+	question := tg.SendText(chatID, 7, "сколько будет 2+2?")
+
+	// плейсхолдер удаляется после ответа
+	tg.WaitCalls(t, "deleteMessage", 1)
+	require.Equal(t, "сколько будет 2+2?", ai.Requests()[0].Question())
+
+	var texts []string
+	for _, msg := range tg.Messages(chatID) {
+		texts = append(texts, msg.Text)
+	}
+	require.Equal(t, []string{"сколько будет 2+2?", "ответ: сколько будет 2+2?", "потрачено 43 токенов"}, texts)
+
+	sent := tg.Calls("sendMessage")
+	require.Equal(t, settings.Default().PlaceholderText, sent[0].Params.Get("text"))
+
+	require.Eventually(t, func() bool {
+		processed, err := store.IsUpdateProcessed(context.Background(), question.UpdateID)
+		return err == nil && processed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// /restart удаляет из чата весь диалог одним запросом
+	tg.SendText(chatID, 7, "/restart")
+	tg.WaitCalls(t, "deleteMessages", 1)
+	require.Empty(t, tg.Messages(chatID))
+}
+
+func TestService_endToEnd_llmFailure(t *testing.T) {
+	tg, ai, _ := newE2EService(t)
+	// R1 повторяет 5xx max_retries раз
+	for range settings.Default().MaxRetries + 1 {
+		ai.FailNext(500)
+	}
+
+	tg.SendText(11, 7, "привет")
+
+	sent := tg.WaitCalls(t, "sendMessage", 2)
+	require.Equal(t, settings.Default().FailureText, sent[1].Params.Get("text"))
+	require.Len(t, ai.Requests(), settings.Default().MaxRetries+1)
+}
+
+func TestService_endToEnd_stop(t *testing.T) {
+	tg, ai, _ := newE2EService(t)
+	const chatID = 12
+	// первое слово приходит сразу, остальные - только через минуту
+	ai.Reply(func(fake.ChatRequest) string { return "начало длинного ответа" })
+	ai.ChunkDelay(time.Minute)
+
+	question := tg.SendText(chatID, 7, "расскажи длинную историю").Message
+	placeholder := tg.WaitCalls(t, "sendMessage", 1)[0]
+	require.Contains(t, placeholder.Params.Get("reply_markup"), fmt.Sprintf(`"callback_data":"stop:%d"`, question.MessageID))
+	require.Eventually(t, func() bool { return len(ai.Requests()) == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.True(t, ai.Requests()[0].Stream, "answers are streamed")
+	time.Sleep(100 * time.Millisecond)
+
+	msgs := tg.Messages(chatID)
+	tg.PressButton(chatID, 7, msgs[len(msgs)-1].MessageID, fmt.Sprintf("stop:%d", question.MessageID))
+
+	edited := tg.WaitCalls(t, "editMessageText", 1)
+	require.Equal(t, "начало \n\n"+settings.Default().StoppedText, edited[0].Params.Get("text"))
+	require.Len(t, tg.Calls("answerCallbackQuery"), 1)
+	require.Nil(t, tg.Messages(chatID)[1].ReplyMarkup, "the stop button is removed")
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// stopCallbackPrefix - префикс данных кнопки "Остановить" под плейсхолдером, за ним следует
+// message_id вопроса, ответ на который генерируется
+const stopCallbackPrefix = "stop:"
+
+// ErrGenerationStopped - причина отмены контекста генерации по /stop или кнопке "Остановить"
+var ErrGenerationStopped = errors.New("generation stopped by user")
+
+// generations хранит отмену текущей генерации ответа для каждого чата. Реестр живёт в памяти
+// процесса: /stop и кнопка отменяют генерацию, только если апдейт получил тот же процесс,
+// воркер которого генерирует ответ
+type generations struct {
+	mu     sync.Mutex
+	byChat map[int64]*generation
+}
+
+type generation struct {
+	messageID int
+	cancel    context.CancelCauseFunc
+}
+
+// start регистрирует генерацию ответа на сообщение messageID в чате и возвращает её контекст.
+// done снимает регистрацию, если генерация в чате не была заменена новой
+func (g *generations) start(ctx context.Context, chatID int64, messageID int) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	gen := &generation{messageID: messageID, cancel: cancel}
+
+	g.mu.Lock()
+	g.byChat[chatID] = gen
+	g.mu.Unlock()
+
+	return ctx, func() {
+		g.mu.Lock()
+		if g.byChat[chatID] == gen {
+			delete(g.byChat, chatID)
+		}
+		g.mu.Unlock()
+		cancel(nil)
+	}
+}
+
+// stop отменяет генерацию в чате. Возвращает false, если в чате ничего не генерируется
+func (g *generations) stop(chatID int64) bool {
+	return g.cancel(chatID, func(*generation) bool { return true })
+}
+
+// stopMessage отменяет генерацию ответа на сообщение messageID. Возвращает false, если этот ответ
+// уже не генерируется, в том числе когда в чате идёт генерация ответа на другое сообщение
+func (g *generations) stopMessage(chatID int64, messageID int) bool {
+	return g.cancel(chatID, func(gen *generation) bool { return gen.messageID == messageID })
+}
+
+func (g *generations) cancel(chatID int64, match func(*generation) bool) bool {
+	g.mu.Lock()
+	gen, ok := g.byChat[chatID]
+	ok = ok && match(gen)
+	if ok {
+		delete(g.byChat, chatID)
+	}
+	g.mu.Unlock()
+
+	if ok {
+		gen.cancel(ErrGenerationStopped)
+	}
+	return ok
+}
+
+// stopKeyboard - кнопка "Остановить" под сообщением "ответ генерируется" на вопрос messageID
+func stopKeyboard(messageID int) tgbotapi.InlineKeyboardMarkup {
+	data := stopCallbackPrefix + strconv.Itoa(messageID)
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData("Остановить", data)),
+	)
+}
+
+// parseStopCallback возвращает message_id вопроса из данных кнопки "Остановить"
+func parseStopCallback(data string) (int, bool) {
+	raw, ok := strings.CutPrefix(data, stopCallbackPrefix)
+	if !ok {
+		return 0, false
+	}
+	messageID, err := strconv.Atoi(raw)
+	return messageID, err == nil
+}
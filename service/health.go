@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Version - версия сборки, задаётся через -ldflags "-X github.com/mytelegrambot/service.Version=..."
+var Version = "dev"
+
+const (
+	// probeTTL - сколько живёт результат проверки зависимости, чтобы частые запросы /readyz
+	// не нагружали Telegram и OpenRouter
+	probeTTL     = 15 * time.Second
+	probeTimeout = 3 * time.Second
+)
+
+// probe - проверка зависимости с кешированным результатом
+type probe struct {
+	mu        sync.Mutex
+	check     func(ctx context.Context) error
+	checkedAt time.Time
+	err       error
+}
+
+func (p *probe) result(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.checkedAt.IsZero() && time.Since(p.checkedAt) < probeTTL {
+		return p.err
+	}
+
+	// результат кешируется для всех, поэтому проверка не зависит от отмены запроса, который её запустил:
+	// оборванный клиентом /readyz не должен на probeTTL пометить зависимость недоступной
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), probeTimeout)
+	defer cancel()
+
+	p.err = p.check(ctx)
+	p.checkedAt = time.Now()
+	return p.err
+}
+
+func (s *Service) newProbes() map[string]*probe {
+	return map[string]*probe{
+		"storage": {check: func(ctx context.Context) error {
+			return s.storage.health.Ping(ctx)
+		}},
+		"telegram": {check: func(ctx context.Context) error {
+			_, err := s.bot.GetMe(ctx)
+			return err
+		}},
+		"llm": {check: func(ctx context.Context) error {
+			return s.r1.Ping(ctx)
+		}},
+	}
+}
+
+// Ready параллельно проверяет хранилище, Telegram и LLM-провайдера.
+// Возвращает ошибку по каждой зависимости (nil - доступна)
+func (s *Service) Ready(ctx context.Context) map[string]error {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]error, len(s.probes))
+	)
+
+	for name, p := range s.probes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.result(ctx)
+
+			mu.Lock()
+			results[name] = err
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Status - состояние процесса для /status
+type Status struct {
+	Version      string       `json:"version"`
+	StartedAt    time.Time    `json:"started_at"`
+	Uptime       string       `json:"uptime"`
+	LastUpdateAt *time.Time   `json:"last_update_at,omitempty"`
+	UpdateLag    string       `json:"update_lag"`
+	QueueDepth   int          `json:"queue_depth"`
+	QueueError   string       `json:"queue_error,omitempty"`
+	LastError    *StatusError `json:"last_error,omitempty"`
+}
+
+type StatusError struct {
+	At      time.Time `json:"at"`
+	Message string    `json:"message"`
+}
+
+// runtimeStats - то, что сервис запоминает о своей работе для Status
+type runtimeStats struct {
+	mu           sync.Mutex
+	startedAt    time.Time
+	lastUpdateAt time.Time
+	updateLag    time.Duration
+	lastError    *StatusError
+}
+
+// observeUpdate запоминает время получения апдейта и задержку относительно отправки сообщения
+func (s *Service) observeUpdate(sentAt time.Time) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	now := s.now()
+	s.stats.lastUpdateAt = now
+	s.stats.updateLag = max(now.Sub(sentAt), 0)
+}
+
+func (s *Service) recordError(err error) {
+	s.stats.mu.Lock()
+	defer s.stats.mu.Unlock()
+
+	s.stats.lastError = &StatusError{At: s.now(), Message: err.Error()}
+}
+
+// Status собирает время работы, версию, задержку получения апдейтов, глубину очереди и последнюю ошибку
+func (s *Service) Status(ctx context.Context) Status {
+	s.stats.mu.Lock()
+	status := Status{
+		Version:   Version,
+		StartedAt: s.stats.startedAt,
+		Uptime:    s.now().Sub(s.stats.startedAt).Round(time.Second).String(),
+		UpdateLag: s.stats.updateLag.Round(time.Millisecond).String(),
+		LastError: s.stats.lastError,
+	}
+	if !s.stats.lastUpdateAt.IsZero() {
+		lastUpdateAt := s.stats.lastUpdateAt
+		status.LastUpdateAt = &lastUpdateAt
+	}
+	s.stats.mu.Unlock()
+
+	depth, err := s.storage.updates.PendingUpdates(ctx)
+	if err != nil {
+		status.QueueError = err.Error()
+	}
+	status.QueueDepth = depth
+
+	return status
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestProbe_CachesResult(t *testing.T) {
+	calls := 0
+	p := &probe{check: func(ctx context.Context) error {
+		calls++
+		return errors.New("connection refused")
+	}}
+
+	require.EqualError(t, p.result(context.Background()), "connection refused")
+	require.EqualError(t, p.result(context.Background()), "connection refused")
+	require.Equal(t, 1, calls)
+
+	// после истечения probeTTL проверка выполняется снова
+	p.checkedAt = time.Now().Add(-probeTTL)
+	require.Error(t, p.result(context.Background()))
+	require.Equal(t, 2, calls)
+}
+
+func TestProbe_IgnoresCallerCancel(t *testing.T) {
+	p := &probe{check: func(ctx context.Context) error {
+		return ctx.Err()
+	}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.NoError(t, p.result(ctx), "the caller went away, the dependency is fine")
+	require.NoError(t, p.result(context.Background()))
+}
+
+func TestService_Ready(t *testing.T) {
+	s := &Service{probes: map[string]*probe{
+		"storage":  {check: func(ctx context.Context) error { return nil }},
+		"telegram": {check: func(ctx context.Context) error { return errors.New("401 Unauthorized") }},
+	}}
+
+	results := s.Ready(context.Background())
+	require.Len(t, results, 2)
+	require.NoError(t, results["storage"])
+	require.EqualError(t, results["telegram"], "401 Unauthorized")
+}
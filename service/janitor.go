@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/logger"
+	"time"
+)
+
+// RunJanitor периодически применяет политики хранения и чистит служебные таблицы, пока не отменён ctx
+func (s *Service) RunJanitor(ctx context.Context, retention config.Retention) error {
+	ctx = logger.WithContext(ctx, s.logger.With("component", "janitor"))
+	log := logger.FromContext(ctx)
+
+	if retention.UpdatesMessages.Days == 0 && retention.ArchiveMessages.Days == 0 {
+		log.Infow("retention is disabled, janitor cleans up service tables only")
+	}
+
+	ticker := time.NewTicker(retention.Interval)
+	defer ticker.Stop()
+
+	for {
+		affected, err := s.storage.privacy.ApplyRetention(ctx, retention)
+		if err != nil {
+			// ошибка очистки не должна останавливать бота, попробуем на следующем тике
+			log.Errorw("applying retention", "error", err)
+		} else if affected > 0 {
+			log.Infow("retention applied", "rows_affected", affected)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// ForgetUser удаляет все сохранённые данные пользователя Telegram
+func (s *Service) ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error) {
+	affected, err := s.storage.privacy.ForgetUser(ctx, userID, requestedBy)
+	if err != nil {
+		return 0, fmt.Errorf("forgetting user (%v): %w", userID, err)
+	}
+
+	logger.FromContext(ctx).Infow("user data purged", "user_id", userID, "requested_by", requestedBy, "rows_affected", affected)
+	return affected, nil
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/storage"
+	"github.com/mytelegrambot/utils"
+	"maps"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// maxOutboxAttempts - после стольких неудачных отправок запись помечается failed
+	maxOutboxAttempts = 5
+	// outboxSendTimeout ограничивает одну отправку вместе с повторами bot.Scheduler: до четырёх
+	// запросов по минуте и ожидание после 429 не дольше минуты
+	outboxSendTimeout = 5 * time.Minute
+	// outboxStaleAfter - через сколько запись в статусе sending считается брошенной упавшим процессом.
+	// Должно быть больше outboxSendTimeout, иначе запись, которая ещё отправляется, уйдёт второй раз
+	outboxStaleAfter = 2 * outboxSendTimeout
+	outboxInterval   = 10 * time.Second
+	outboxBatch      = 20
+	// outboxBackoff - пауза перед первым повтором неудачной отправки, дальше она удваивается
+	// до outboxMaxBackoff
+	outboxBackoff    = 30 * time.Second
+	outboxMaxBackoff = 10 * time.Minute
+)
+
+// deliveredMessages - доставленные сообщения outbox или рассылок, которые не удалось отметить в БД,
+// по ID записи. Отправлять их повторно нельзя, RunOutbox и RunBroadcasts повторяют только запись.
+// Список живёт в памяти процесса: если процесс упадёт раньше, чем запись удастся, сообщение
+// после outboxStaleAfter уйдёт ещё раз
+type deliveredMessages struct {
+	mu       sync.Mutex
+	messages map[int64]*tgbotapi.Message
+}
+
+func (d *deliveredMessages) add(id int64, message *tgbotapi.Message) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.messages == nil {
+		d.messages = make(map[int64]*tgbotapi.Message)
+	}
+	d.messages[id] = message
+}
+
+func (d *deliveredMessages) get(id int64) (*tgbotapi.Message, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	message, ok := d.messages[id]
+	return message, ok
+}
+
+func (d *deliveredMessages) remove(id int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.messages, id)
+}
+
+func (d *deliveredMessages) snapshot() map[int64]*tgbotapi.Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return maps.Clone(d.messages)
+}
+
+// send отправляет сообщение через outbox: запись создаётся до отправки, а после отправки
+// в одной транзакции помечается отправленной и сохраняется в updates_messages.
+// Ошибка возвращается только если сообщение не доставлено
+func (s *Service) send(ctx context.Context, chatID int64, text string) (*tgbotapi.Message, error) {
+	id, err := s.storage.outbox.EnqueueOutbox(ctx, chatID, text)
+	if err != nil {
+		return nil, fmt.Errorf("enqueue outgoing message: %w", err)
+	}
+
+	return s.deliver(ctx, models.OutboxMessage{ID: id, ChatID: chatID, Text: text, Attempts: 1})
+}
+
+func (s *Service) deliver(ctx context.Context, out models.OutboxMessage) (*tgbotapi.Message, error) {
+	sendCtx, cancel := context.WithTimeout(ctx, outboxSendTimeout)
+	message, err := s.bot.SendMessage(sendCtx, out.ChatID, out.Text)
+	cancel()
+	if err != nil {
+		var retryAfter time.Duration
+		if out.Attempts < maxOutboxAttempts && !permanentSendError(err) {
+			retryAfter = outboxRetryDelay(out.Attempts)
+		}
+		if markErr := s.storage.outbox.MarkOutboxFailed(ctx, out.ID, err.Error(), retryAfter); markErr != nil {
+			logger.FromContext(ctx).Errorw("marking outbox failed", "outbox_id", out.ID, "error", markErr)
+		}
+		return nil, fmt.Errorf("sending outbox message (%v): %w", out.ID, err)
+	}
+
+	if err = s.record(ctx, out.ID, message); err != nil {
+		// сообщение уже доставлено, поэтому не считаем это ошибкой отправки. Запись
+		// повторит RunOutbox, не отправляя сообщение ещё раз
+		s.delivered.add(out.ID, message)
+		logger.FromContext(ctx).Errorw("recording delivered message",
+			"outbox_id", out.ID,
+			"chat_id", out.ChatID,
+			"message_id", message.MessageID,
+			"error", err,
+		)
+	}
+
+	return message, nil
+}
+
+// outboxRetryDelay - пауза перед повтором отправки после неудачной попытки номер attempt (с 1)
+func outboxRetryDelay(attempt int) time.Duration {
+	delay := outboxBackoff
+	for i := 1; i < attempt && delay < outboxMaxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxBackoff)
+}
+
+// permanentSendError сообщает, что повтор отправки не поможет: бот заблокирован пользователем,
+// чата больше нет или Telegram отклонил сам запрос
+func permanentSendError(err error) bool {
+	var tgErr *tgbotapi.Error
+	return errors.As(err, &tgErr) && (tgErr.Code == http.StatusForbidden || tgErr.Code == http.StatusBadRequest)
+}
+
+// record помечает запись outbox отправленной и сохраняет сообщение, с несколькими попытками
+func (s *Service) record(ctx context.Context, outboxID int64, message *tgbotapi.Message) error {
+	const attempts = 3
+
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = s.storage.outbox.WithinTx(ctx, func(tx storage.Tx) error {
+			if err := tx.MarkOutboxSent(ctx, outboxID, message.MessageID); err != nil {
+				return err
+			}
+			return tx.Save(ctx, utils.BotMessageToModel(message))
+		})
+		if err == nil || attempt == attempts {
+			break
+		}
+
+		select {
+		case <-time.After(time.Duration(attempt) * 200 * time.Millisecond):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	return err
+}
+
+// RunOutbox повторно отправляет записи outbox, которые не были доставлены или
+// остались незавершёнными после падения процесса
+func (s *Service) RunOutbox(ctx context.Context) error {
+	ctx = logger.WithContext(ctx, s.logger.With("component", "outbox"))
+
+	ticker := time.NewTicker(outboxInterval)
+	defer ticker.Stop()
+
+	for {
+		s.flushOutbox(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// flushOutbox отмечает в БД доставленные, но не записанные сообщения и отправляет записи outbox,
+// которые ждут повтора или брошены упавшим процессом
+func (s *Service) flushOutbox(ctx context.Context) {
+	log := logger.FromContext(ctx)
+
+	for outboxID, message := range s.delivered.snapshot() {
+		if err := s.record(ctx, outboxID, message); err != nil {
+			log.Warnw("recording delivered message", "outbox_id", outboxID, "error", err)
+			continue
+		}
+		s.delivered.remove(outboxID)
+	}
+
+	pending, err := s.storage.outbox.ClaimOutbox(ctx, outboxStaleAfter, outboxBatch)
+	if err != nil {
+		log.Errorw("claiming outbox", "error", err)
+	}
+	for _, out := range pending {
+		if message, ok := s.delivered.get(out.ID); ok {
+			// сообщение уже в чате, повторяется только запись
+			if err := s.record(ctx, out.ID, message); err != nil {
+				log.Warnw("recording delivered message", "outbox_id", out.ID, "error", err)
+				continue
+			}
+			s.delivered.remove(out.ID)
+			continue
+		}
+		if _, err := s.deliver(ctx, out); err != nil {
+			log.Warnw("replaying outbox message", "outbox_id", out.ID, "attempt", out.Attempts, "error", err)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/storage"
+	"github.com/mytelegrambot/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// updateLease - на сколько воркер арендует задачу. Аренда продлевается, пока идёт обработка,
+	// поэтому после падения процесса задача вернётся в очередь не позже чем через updateLease
+	updateLease = time.Minute
+	// maxUpdateAttempts - сколько раз задача может быть выдана воркерам, прежде чем считаться проваленной
+	maxUpdateAttempts = 3
+	queuePollInterval = 2 * time.Second
+	// inlineBacklog - сколько апдейтов могут ждать обработки в обход недоступной очереди
+	inlineBacklog = 16
+)
+
+// notifyWorkers будит воркеров после добавления задачи в очередь
+func (s *Service) notifyWorkers() {
+	select {
+	case s.queued <- struct{}{}:
+	default:
+	}
+}
+
+// RunWorkers запускает workers воркеров очереди update_jobs и ждёт их завершения.
+// После отмены ctx воркеры не берут новые задачи, но доделывают начатые; work - контекст
+// обработки задач, его отмена прерывает их (задачи возвращаются в очередь).
+// Незавершённые задачи, оставшиеся от прошлого запуска, подхватываются после истечения аренды
+func (s *Service) RunWorkers(ctx context.Context, work context.Context, workers int) error {
+	ctx = logger.WithContext(ctx, s.logger.With("component", "workers"))
+	work = logger.WithContext(work, s.logger.With("component", "workers"))
+	host, _ := os.Hostname()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			s.runWorker(ctx, work, name)
+		}(fmt.Sprintf("%s-%d-%d", host, os.Getpid(), i))
+	}
+	wg.Wait()
+
+	return ctx.Err()
+}
+
+func (s *Service) runWorker(ctx context.Context, work context.Context, name string) {
+	ticker := time.NewTicker(queuePollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		jobs, err := s.storage.updates.ClaimUpdates(ctx, name, updateLease, 1)
+		if err != nil && ctx.Err() == nil {
+			logger.FromContext(ctx).Errorw("claiming updates", "worker", name, "error", err)
+		}
+
+		for _, job := range jobs {
+			s.runJob(work, name, job)
+		}
+		if len(jobs) > 0 {
+			continue
+		}
+
+		select {
+		case <-s.queued:
+		case <-ticker.C:
+		case <-ctx.Done():
+		}
+	}
+}
+
+func (s *Service) runJob(ctx context.Context, worker string, job models.QueuedUpdate) {
+	// продолжаем трассу SetBot, если апдейт принят этим же процессом
+	parent := ctx
+	if received, ok := s.traces.LoadAndDelete(job.Update.UpdateID); ok {
+		parent = trace.ContextWithRemoteSpanContext(ctx, received.(trace.SpanContext))
+	}
+	spanCtx, span := tracing.Start(parent, "service", "update.process",
+		attribute.Int("telegram.update_id", job.Update.UpdateID),
+		attribute.Int64("queue.job_id", job.ID),
+		attribute.Int("queue.attempt", job.Attempts),
+		attribute.String("queue.worker", worker),
+	)
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	spanCtx = logger.With(spanCtx, append(updateFields(job.Update), "worker", worker, "job_id", job.ID, "attempt", job.Attempts)...)
+	log := logger.FromContext(spanCtx)
+
+	if job.Attempts > maxUpdateAttempts {
+		log.Errorw("update job exceeded attempts, giving up")
+		err = errors.New("too many attempts")
+		s.finishJob(spanCtx, job.ID, storage.UpdateFailed, "too many attempts")
+		return
+	}
+
+	jobCtx, cancel := context.WithCancel(spanCtx)
+	defer cancel()
+	go s.keepLease(jobCtx, worker, job.ID)
+
+	err = s.handleUpdate(jobCtx, job.Update)
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// остановка сервиса: возвращаем задачу в очередь, её подхватят после перезапуска
+		log.Warnw("update job interrupted by shutdown", "error", err)
+		s.finishJob(spanCtx, job.ID, storage.UpdatePending, err.Error())
+	case err != nil:
+		log.Errorw("update job failed", "error", err)
+		s.recordError(err)
+		s.finishJob(spanCtx, job.ID, storage.UpdateFailed, err.Error())
+	default:
+		s.finishJob(spanCtx, job.ID, storage.UpdateDone, "")
+	}
+}
+
+// keepLease продлевает аренду задачи, пока не отменён ctx
+func (s *Service) keepLease(ctx context.Context, worker string, id int64) {
+	ticker := time.NewTicker(updateLease / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.storage.updates.ExtendUpdateLease(ctx, id, worker, updateLease); err != nil && !errors.Is(err, context.Canceled) {
+				logger.FromContext(ctx).Warnw("extending update lease", "error", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// finishJob записывает итог задачи даже если контекст сервиса уже отменён
+func (s *Service) finishJob(ctx context.Context, id int64, status string, reason string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := s.storage.updates.FinishUpdate(ctx, id, status, reason); err != nil {
+		logger.FromContext(ctx).Errorw("finishing update job", "status", status, "error", err)
+	}
+}
+
+// processInline обрабатывает апдейты, которые не удалось поставить в очередь, по одному в порядке
+// получения. Завершается после закрытия inline; апдейты, не обработанные до отмены ctx, теряются
+func (s *Service) processInline(ctx context.Context, inline <-chan tgbotapi.Update) {
+	dropped := 0
+	defer func() {
+		if dropped > 0 {
+			logger.FromContext(ctx).Warnw("updates outside the queue dropped on shutdown", "count", dropped)
+		}
+	}()
+
+	for update := range inline {
+		if ctx.Err() != nil {
+			dropped++
+			continue
+		}
+
+		parent := ctx
+		if received, ok := s.traces.LoadAndDelete(update.UpdateID); ok {
+			parent = trace.ContextWithRemoteSpanContext(ctx, received.(trace.SpanContext))
+		}
+		spanCtx, span := tracing.Start(parent, "service", "update.process",
+			attribute.Int("telegram.update_id", update.UpdateID),
+			attribute.Bool("queue.bypassed", true),
+		)
+		spanCtx = logger.With(spanCtx, updateFields(update)...)
+
+		err := s.handleUpdate(spanCtx, update)
+		if err != nil {
+			s.recordError(err)
+			logger.FromContext(spanCtx).Errorw("processing update outside the queue", "error", err)
+		}
+		tracing.End(span, err)
+	}
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+// chatLimiter считает вопросы к модели от каждого чата в скользящем окне
+type chatLimiter struct {
+	mu   sync.Mutex
+	hits map[int64][]time.Time
+	// swept - когда из hits последний раз убирались чаты без вопросов в окне
+	swept time.Time
+}
+
+// allow учитывает вопрос и сообщает, укладывается ли чат в limit вопросов за per. limit == 0 - без ограничения
+func (l *chatLimiter) allow(chatID int64, limit int, per time.Duration, now time.Time) bool {
+	if limit <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// раз в окно забываем замолчавшие чаты, иначе hits растёт с каждым новым чатом
+	if now.Sub(l.swept) >= per {
+		l.sweep(per, now)
+	}
+
+	hits := l.hits[chatID]
+	fresh := hits[:0]
+	for _, hit := range hits {
+		if now.Sub(hit) < per {
+			fresh = append(fresh, hit)
+		}
+	}
+	if len(fresh) >= limit {
+		l.hits[chatID] = fresh
+		return false
+	}
+	l.hits[chatID] = append(fresh, now)
+	return true
+}
+
+// sweep удаляет чаты, последний вопрос которых старше per
+func (l *chatLimiter) sweep(per time.Duration, now time.Time) {
+	for chatID, hits := range l.hits {
+		if len(hits) == 0 || now.Sub(hits[len(hits)-1]) >= per {
+			delete(l.hits, chatID)
+		}
+	}
+	l.swept = now
+}
@@ -0,0 +1,40 @@
+package service
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestChatLimiter(t *testing.T) {
+	l := chatLimiter{hits: make(map[int64][]time.Time)}
+	now := time.Now()
+
+	require.True(t, l.allow(1, 2, time.Minute, now))
+	require.True(t, l.allow(1, 2, time.Minute, now.Add(10*time.Second)))
+	require.False(t, l.allow(1, 2, time.Minute, now.Add(20*time.Second)))
+	require.True(t, l.allow(2, 2, time.Minute, now.Add(20*time.Second)), "chats are limited separately")
+
+	// первый вопрос вышел из окна
+	require.True(t, l.allow(1, 2, time.Minute, now.Add(61*time.Second)))
+	require.False(t, l.allow(1, 2, time.Minute, now.Add(62*time.Second)))
+
+	require.True(t, l.allow(1, 0, time.Minute, now), "zero limit disables limiting")
+}
+
+func TestChatLimiter_forgetsIdleChats(t *testing.T) {
+	l := chatLimiter{hits: make(map[int64][]time.Time)}
+	now := time.Now()
+
+	for chatID := range int64(100) {
+		require.True(t, l.allow(chatID, 2, time.Minute, now))
+	}
+	require.Len(t, l.hits, 100)
+
+	require.True(t, l.allow(1, 2, time.Minute, now.Add(30*time.Second)))
+	require.Len(t, l.hits, 100, "chats are kept while their questions are in the window")
+
+	require.True(t, l.allow(1000, 2, time.Minute, now.Add(80*time.Second)))
+	require.Len(t, l.hits, 2, "only the chat asking within the window and the new one are left")
+	require.False(t, l.allow(1, 1, time.Minute, now.Add(81*time.Second)), "the remaining chat keeps its history")
+}
@@ -7,65 +7,144 @@ import (
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/mytelegrambot/bot"
 	"github.com/mytelegrambot/deepseek"
+	"github.com/mytelegrambot/logger"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/settings"
 	"github.com/mytelegrambot/storage"
+	"github.com/mytelegrambot/tracing"
 	"github.com/mytelegrambot/utils"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/zap"
-	"log"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type Service struct {
 	logger  *zap.SugaredLogger
-	storage storage.Storage
+	storage stores
 	r1      deepseek.R1
 	bot     bot.BotAPI
+	queued  chan struct{}
+	// traces хранит контекст спана приёма апдейта (update_id -> trace.SpanContext),
+	// чтобы обработка воркером попала в ту же трассу
+	traces sync.Map
+	probes map[string]*probe
+	stats  runtimeStats
+	// settings - настройки, которые меняются без перезапуска, читаются на каждый апдейт
+	settings *settings.Store
+	limiter  chatLimiter
+	// admins - пользователи Telegram, которым доступны административные команды (/broadcast)
+	admins     []int64
+	broadcasts chan struct{}
+	// generations - текущие генерации ответов по чатам этого процесса, их отменяют /stop и кнопка "Остановить"
+	generations generations
+	// delivered и deliveredBroadcasts - отправленные сообщения outbox и рассылок, которые ещё не отмечены в БД
+	delivered           deliveredMessages
+	deliveredBroadcasts deliveredMessages
+	// now - часы сервиса, в тестах подменяются
+	now func() time.Time
 }
 
-func NewService(logger  *zap.SugaredLogger, storage storage.Storage, r1 deepseek.R1, b bot.BotAPI) *Service {
-	return &Service{
-		logger:  logger,
-		storage: storage,
-		r1:      r1,
-		bot:     b,
+// stores - части хранилища по назначению. Каждая часть сервиса (очереди, рассылки, админка,
+// janitor) работает только со своей
+type stores struct {
+	messages   storage.MessageStore
+	privacy    storage.PrivacyStore
+	outbox     storage.OutboxStore
+	updates    storage.UpdateStore
+	admin      storage.AdminStore
+	broadcasts storage.BroadcastStore
+	health     storage.Pinger
+}
+
+func newStores(storage storage.Storage) stores {
+	return stores{
+		messages:   storage,
+		privacy:    storage,
+		outbox:     storage,
+		updates:    storage,
+		admin:      storage,
+		broadcasts: storage,
+		health:     storage,
 	}
 }
 
-func (s *Service) SetBot(ctx context.Context) error {
-	updates, err := s.bot.GetUpdates(ctx)
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		return errors.New("updates channel closed")
+func NewService(logger *zap.SugaredLogger, storage storage.Storage, r1 deepseek.R1, b bot.BotAPI, runtime *settings.Store, admins []int64) *Service {
+	s := &Service{
+		logger:     logger,
+		storage:    newStores(storage),
+		r1:         r1,
+		bot:        b,
+		queued:     make(chan struct{}, 1),
+		stats:      runtimeStats{startedAt: time.Now()},
+		now:        time.Now,
+		settings:   runtime,
+		limiter:    chatLimiter{hits: make(map[int64][]time.Time)},
+		admins:     admins,
+		broadcasts: make(chan struct{}, 1),
 	}
-	if ok {
-		log.Printf("get updates from tg bot, w/ deadline (left:%v)", time.Until(deadline))
+	s.generations.byChat = make(map[int64]*generation)
+	s.probes = s.newProbes()
+	return s
+}
+
+// SetBot получает апдейты от Telegram и сохраняет их в очередь update_jobs, откуда их
+// забирают воркеры RunWorkers. Если очередь недоступна, апдейт обрабатывает отдельная
+// горутина (processInline), чтобы не задерживать long polling. Как и в RunWorkers, отмена ctx
+// прекращает приём, а work - контекст обработки: после остановки приёма SetBot дожидается
+// обработки уже принятых в обход очереди апдейтов, пока не отменён work
+func (s *Service) SetBot(ctx context.Context, work context.Context) error {
+	ctx = logger.WithContext(ctx, s.logger.With("component", "updates"))
+	work = logger.WithContext(work, s.logger.With("component", "updates"))
+
+	offset, err := s.storage.updates.LastUpdateOffset(ctx)
+	if err != nil {
+		return fmt.Errorf("bot setup, get update offset: %w", err)
 	}
+	logger.FromContext(ctx).Infow("resuming updates", "offset", offset)
 
+	updates, err := s.bot.GetUpdates(ctx, offset)
 	if err != nil {
 		return fmt.Errorf("bot setup, get updates: %w", err)
 	}
 
+	inline := make(chan tgbotapi.Update, inlineBacklog)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.processInline(work, inline)
+	}()
+	defer wg.Wait()
+	defer close(inline)
+
 	for {
 		select {
-		case update := <-updates:
+		case update, ok := <-updates:
+			if !ok {
+				// канал закрывается и при штатной остановке опроса
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				return errors.New("updates channel closed")
+			}
+			metrics.UpdatesReceived.WithLabelValues(updateType(update)).Inc()
+			if update.CallbackQuery != nil {
+				if messageID, ok := parseStopCallback(update.CallbackQuery.Data); ok {
+					s.stopCallback(ctx, update.CallbackQuery, messageID)
+					continue
+				}
+			}
 			if update.Message == nil {
 				continue
 			}
-			s.logger.Infoln("Get update from telegram bot!")
-			if err = s.ProcessMessage(ctx, update.Message); err != nil {
-				msgErr, sendMsgErr := s.bot.SendMessage(update.Message.Chat.ID, "Не могу обработать Ваше сообщение, попробуйте позднее!")
-				if sendMsgErr != nil {
-					return fmt.Errorf("sending main failure message error: %w", sendMsgErr)
-				}
-				saveMsgErr := s.storage.Save(ctx, utils.BotMessageToModel(msgErr))
-				if saveMsgErr != nil {
-					return fmt.Errorf("saving error message: %w", saveMsgErr)
-				}
-				if !errors.Is(err, context.DeadlineExceeded) {
-					return fmt.Errorf("processing message: %w", err)
-				}
-				log.Printf("processing message, context: %v", ctx.Err())
-
+			// воркер обработает /stop только после текущей генерации в чате, поэтому она отменяется сразу при получении
+			if update.Message.IsCommand() && update.Message.Command() == "stop" {
+				s.generations.stop(update.Message.Chat.ID)
 			}
+			s.receiveUpdate(ctx, update, inline)
 		case <-ctx.Done():
 			return ctx.Err()
 		}
@@ -73,10 +152,96 @@ func (s *Service) SetBot(ctx context.Context) error {
 
 }
 
+// receiveUpdate ставит апдейт в очередь в рамках его корневого спана. Если очередь недоступна,
+// апдейт передаётся в inline, смещение при этом не сохраняется
+func (s *Service) receiveUpdate(ctx context.Context, update tgbotapi.Update, inline chan<- tgbotapi.Update) {
+	ctx, span := tracing.Start(ctx, "service", "telegram.update",
+		attribute.Int("telegram.update_id", update.UpdateID),
+		attribute.String("telegram.update_type", updateType(update)),
+		attribute.Int64("telegram.chat_id", update.Message.Chat.ID),
+	)
+	var err error
+	defer func() { tracing.End(span, err) }()
+
+	ctx = logger.With(ctx, updateFields(update)...)
+	log := logger.FromContext(ctx)
+	log.Infoln("Get update from telegram bot!")
+	s.observeUpdate(update.Message.Time())
+
+	if _, enqueueErr := s.storage.updates.EnqueueUpdate(ctx, update); enqueueErr != nil {
+		err = enqueueErr
+		log.Errorw("enqueue update, processing outside the queue", "error", enqueueErr)
+		s.recordError(enqueueErr)
+		s.traces.Store(update.UpdateID, span.SpanContext())
+		// когда inline заполнен, приём апдейтов ждёт: новые апдейты остаются у Telegram
+		select {
+		case inline <- update:
+		case <-ctx.Done():
+		}
+		return
+	}
+	s.traces.Store(update.UpdateID, span.SpanContext())
+	s.notifyWorkers()
+
+	// апдейт уже в очереди, после перезапуска его можно не запрашивать
+	if err := s.storage.updates.SaveUpdateOffset(ctx, update.UpdateID+1); err != nil {
+		log.Warnw("saving update offset", "error", err)
+	}
+}
+
+// handleUpdate обрабатывает сообщение и при ошибке сообщает о ней пользователю.
+// Повторно доставленные апдейты (тот же update_id) пропускаются
+func (s *Service) handleUpdate(ctx context.Context, update tgbotapi.Update) error {
+	log := logger.FromContext(ctx)
+
+	processed, err := s.storage.updates.IsUpdateProcessed(ctx, update.UpdateID)
+	if err != nil {
+		log.Warnw("checking processed update", "error", err)
+	}
+	if processed {
+		log.Infow("skipping duplicate update")
+		return nil
+	}
+
+	if from := update.Message.From; from != nil {
+		blocked, err := s.storage.admin.IsUserBlocked(ctx, from.ID)
+		if err != nil {
+			log.Warnw("checking blocked user", "error", err)
+		}
+		if blocked {
+			log.Infow("ignoring update from blocked user")
+			if err = s.storage.updates.MarkUpdateProcessed(ctx, update.UpdateID); err != nil {
+				log.Warnw("marking update processed", "error", err)
+			}
+			return nil
+		}
+	}
+
+	err = s.ProcessMessage(ctx, update.Message)
+	if err != nil && ctx.Err() != nil {
+		return err
+	}
+
+	// после ответа (в том числе с ошибкой) повторная обработка только продублирует его
+	if markErr := s.storage.updates.MarkUpdateProcessed(ctx, update.UpdateID); markErr != nil {
+		log.Warnw("marking update processed", "error", markErr)
+	}
+	if err == nil {
+		return nil
+	}
+
+	_, sendMsgErr := s.send(ctx, update.Message.Chat.ID, s.settings.Get().FailureText)
+	if sendMsgErr != nil {
+		return errors.Join(err, fmt.Errorf("sending main failure message error: %w", sendMsgErr))
+	}
+
+	return fmt.Errorf("processing message: %w", err)
+}
+
 func (s *Service) ProcessMessage(ctx context.Context, msg *tgbotapi.Message) error {
-	const maxRetries = 2
+	runtime := s.settings.Get()
 
-	err := s.storage.Save(ctx, utils.BotMessageToModel(msg))
+	err := s.storage.messages.Save(ctx, utils.BotMessageToModel(msg))
 	if err != nil {
 		return fmt.Errorf(
 			"saving message (%v) from chat (%v): %w",
@@ -94,41 +259,46 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *tgbotapi.Message) err
 		return nil
 	}
 
-	mockMsg, err := s.bot.SendMessage(msg.Chat.ID, "Ваш ответ генерируется, подождите!")
+	if limit := runtime.RateLimit; !s.limiter.allow(msg.Chat.ID, limit.Questions, time.Duration(limit.Per), s.now()) {
+		logger.FromContext(ctx).Infow("question rate limited", "limit", limit.Questions, "per", limit.Per)
+		if _, err = s.send(ctx, msg.Chat.ID, limit.Text); err != nil {
+			return fmt.Errorf("sending rate limit message: %w", err)
+		}
+		return nil
+	}
+
+	// плейсхолдер с кнопкой отправляется мимо outbox: после перезапуска его всё равно отправят заново
+	mockMsg, err := s.bot.SendMessageWithKeyboard(ctx, msg.Chat.ID, runtime.PlaceholderText, stopKeyboard(msg.MessageID))
 	if err != nil {
 		return fmt.Errorf("sending mock message: %w", err)
 	}
-	err = s.storage.Save(ctx, utils.BotMessageToModel(mockMsg))
-	if err != nil {
-		return fmt.Errorf("saving mock message: %w", err)
+	if err = s.storage.messages.Save(ctx, utils.BotMessageToModel(mockMsg)); err != nil {
+		logger.FromContext(ctx).Warnw("saving mock message", "message_id", mockMsg.MessageID, "error", err)
 	}
-
-	for attempt := 0; attempt <= maxRetries; attempt++ {
-		err = s.getAiResponse(ctx, msg)
-		// получили ответ
-		if err == nil {
-			break
-		}
-		// если вышел дедлайн, ретраим
-		if errors.Is(err, context.DeadlineExceeded) {
-			log.Printf("Attempt %d: timeout, retrying…", attempt+1)
-			if attempt < maxRetries {
-				continue
-			}
-			// все попытки исчерпаны
-			answer, sendErr := s.bot.SendMessage(msg.Chat.ID,
-				"Время ожидания вышло, попробуем ещё раз?")
-			err := s.storage.Save(ctx, utils.BotMessageToModel(answer))
-			if err != nil {
-				return fmt.Errorf("saving answer: %w", err)
-			}
-			if sendErr != nil {
-				return fmt.Errorf("sending message: %w", sendErr)
-			}
-			return fmt.Errorf("timeout after %d retries: %w", maxRetries+1, err)
+	defer func() {
+		if ctx.Err() != nil {
+			s.cleanupPlaceholder(ctx, msg.Chat.ID, mockMsg.MessageID)
 		}
+	}()
+
+	genCtx, done := s.generations.start(ctx, msg.Chat.ID, msg.MessageID)
+	defer done()
 
-		// любая другая ошибка, вылетаем
+	// повторы и запасные модели - забота deepseek.R1, сюда приходит уже окончательная ошибка
+	err = s.getAiResponse(genCtx, msg, mockMsg)
+	switch {
+	case err == nil:
+	// генерацию остановил пользователь
+	case errors.Is(context.Cause(genCtx), ErrGenerationStopped):
+		return s.generationStopped(ctx, mockMsg, err)
+	// все попытки всех моделей закончились таймаутом, число попыток deepseek.R1 пишет в лог сам
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		logger.FromContext(ctx).Warnw("AI response timeout")
+		if _, sendErr := s.send(ctx, msg.Chat.ID, runtime.TimeoutText); sendErr != nil {
+			return fmt.Errorf("sending message: %w", sendErr)
+		}
+		return fmt.Errorf("AI response timeout: %w", err)
+	default:
 		return fmt.Errorf("getting Ai response for (%v): %w", msg.MessageID, err)
 	}
 
@@ -139,13 +309,85 @@ func (s *Service) ProcessMessage(ctx context.Context, msg *tgbotapi.Message) err
 	return nil
 }
 
+// generationStopped заменяет плейсхолдер на статус остановки, сохраняя уже полученную часть ответа.
+// Редактирование убирает и кнопку "Остановить"
+func (s *Service) generationStopped(ctx context.Context, mockMsg *tgbotapi.Message, err error) error {
+	metrics.GenerationsStopped.Inc()
+	text := s.settings.Get().StoppedText
+	var partial *deepseek.PartialAnswerError
+	if errors.As(err, &partial) && strings.TrimSpace(partial.Text) != "" {
+		text = partial.Text + "\n\n" + text
+	}
+	logger.FromContext(ctx).Infow("generation stopped by user", "partial", partial != nil)
+
+	if _, editErr := s.bot.EditMessageText(ctx, mockMsg.Chat.ID, mockMsg.MessageID, text); editErr != nil {
+		return fmt.Errorf("editing stopped generation message: %w", editErr)
+	}
+	return nil
+}
+
+// stopCallback обрабатывает нажатие кнопки "Остановить" под ответом на сообщение messageID
+func (s *Service) stopCallback(ctx context.Context, query *tgbotapi.CallbackQuery, messageID int) {
+	var text string
+	if query.Message != nil && s.generations.stopMessage(query.Message.Chat.ID, messageID) {
+		text = s.settings.Get().StoppedText
+	}
+	if err := s.bot.AnswerCallbackQuery(ctx, query.ID, text); err != nil {
+		logger.FromContext(ctx).Warnw("answering stop callback", "callback_id", query.ID, "error", err)
+	}
+}
+
+// cleanupPlaceholder удаляет сообщение "ответ генерируется", если обработка прервана остановкой сервиса.
+// Задача вернётся в очередь, и после перезапуска плейсхолдер будет отправлен заново
+func (s *Service) cleanupPlaceholder(ctx context.Context, chatID int64, messageID int) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+	defer cancel()
+
+	if err := s.bot.DeleteMessage(ctx, chatID, messageID); err != nil {
+		logger.FromContext(ctx).Warnw("deleting placeholder after abort", "placeholder_id", messageID, "error", err)
+	}
+}
+
+// updateFields - поля логов, по которым находятся все записи об апдейте
+func updateFields(update tgbotapi.Update) []any {
+	fields := []any{"update_id", update.UpdateID}
+	if msg := update.Message; msg != nil {
+		fields = append(fields, "chat_id", msg.Chat.ID, "message_id", msg.MessageID)
+		if msg.From != nil {
+			fields = append(fields, "user_id", msg.From.ID)
+		}
+	}
+	return fields
+}
+
+// updateType возвращает тип апдейта для метрик
+func updateType(update tgbotapi.Update) string {
+	switch {
+	case update.Message != nil:
+		if update.Message.IsCommand() {
+			return "command"
+		}
+		return "message"
+	case update.EditedMessage != nil:
+		return "edited_message"
+	case update.CallbackQuery != nil:
+		return "callback_query"
+	case update.InlineQuery != nil:
+		return "inline_query"
+	case update.MyChatMember != nil:
+		return "my_chat_member"
+	default:
+		return "other"
+	}
+}
+
 func (s *Service) ListCommands(ctx context.Context) ([]string, error) {
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
 	var list []string
 
-	commands, err := s.bot.GetMyCommands()
+	commands, err := s.bot.GetMyCommands(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("get commands error: %w", err)
 	}
@@ -153,18 +395,21 @@ func (s *Service) ListCommands(ctx context.Context) ([]string, error) {
 	for _, command := range commands {
 		list = append(list, command.Command)
 	}
-	deadline, ok := ctx.Deadline()
-	if !ok {
-		log.Println("handle list of commands from tg bot, deadline is not set")
-	} else {
-		log.Printf("handle list of commands: %v, left: %v", list, time.Until(deadline))
-	}
+	deadline, _ := ctx.Deadline()
+	logger.FromContext(ctx).Debugw("handled list of commands", "commands", list, "time_left", time.Until(deadline))
 
 	return list, nil
 }
 
-func (s *Service) getAiResponse(ctx context.Context, msg *tgbotapi.Message) error {
-	answerQuestion, err := s.r1.AnswerQuestion(ctx, msg.Text)
+// getAiResponse отвечает на msg с учётом активного диалога чата. placeholder - уже отправленное
+// сообщение "ответ генерируется", по нему определяется, какие сообщения диалога написал бот
+func (s *Service) getAiResponse(ctx context.Context, msg *tgbotapi.Message, placeholder *tgbotapi.Message) error {
+	history := s.dialogHistory(ctx, msg, placeholder)
+
+	// "печатает..." показывается, пока модель генерирует ответ, и гаснет до его отправки
+	stopTyping := bot.Typing(ctx, s.bot, msg.Chat.ID)
+	answerQuestion, err := s.r1.AnswerQuestion(ctx, history, msg.Text)
+	stopTyping()
 	if err != nil {
 		return fmt.Errorf("getting answer question: %w", err)
 	}
@@ -175,59 +420,127 @@ func (s *Service) getAiResponse(ctx context.Context, msg *tgbotapi.Message) erro
 	}
 
 	if len(choices) == 0 {
-		log.Printf("no response generated for q: %v", msg.MessageID)
+		logger.FromContext(ctx).Warnw("no response generated")
 		return nil
 	}
 
 	for _, choice := range choices {
-		message, err := s.bot.SendMessage(msg.Chat.ID, choice)
-		if err != nil {
+		if _, err := s.send(ctx, msg.Chat.ID, choice); err != nil {
 			return fmt.Errorf("sending answer from AI: %w", err)
 		}
-		err = s.storage.Save(ctx, utils.BotMessageToModel(message))
-		if err != nil {
-			return fmt.Errorf("saving answer message: %w", err)
+	}
+
+	logger.FromContext(ctx).Infow("AI response sent", "choices", len(choices))
+	return nil
+}
+
+// dialogContextMessages - сколько последних сообщений активного диалога модель получает перед вопросом
+const dialogContextMessages = 20
+
+// dialogHistory возвращает реплики активного диалога чата, предшествующие вопросу msg, в том числе
+// восстановленные /recover. Команды, ответы на них и служебные сообщения бота пропускаются. Сообщения хранятся
+// обрезанными, поэтому модель получает их начало. Если диалог не прочитать, вопрос задаётся без него
+func (s *Service) dialogHistory(ctx context.Context, msg *tgbotapi.Message, placeholder *tgbotapi.Message) []deepseek.Turn {
+	dialog, err := s.storage.messages.ActiveDialog(ctx, msg.Chat.ID, dialogContextMessages+2)
+	if err != nil {
+		logger.FromContext(ctx).Warnw("reading active dialog, answering without it", "error", err)
+		return nil
+	}
+
+	runtime := s.settings.Get()
+	serviceTexts := map[string]bool{
+		runtime.PlaceholderText: true,
+		runtime.TimeoutText:     true,
+		runtime.FailureText:     true,
+		runtime.RateLimit.Text:  true,
+	}
+	var botID int64
+	if placeholder.From != nil {
+		botID = placeholder.From.ID
+	}
+
+	history := make([]deepseek.Turn, 0, len(dialog))
+	afterCommand := false
+	for _, message := range dialog {
+		assistant := message.FromID == botID
+		if !assistant {
+			afterCommand = strings.HasPrefix(message.Text, "/")
+		}
+		switch {
+		case message.MessageID == msg.MessageID || message.MessageID == placeholder.MessageID:
+		case strings.TrimSpace(message.Text) == "" || afterCommand:
+		case assistant && (serviceTexts[message.Text] || utils.IsUsageLine(message.Text) ||
+			strings.HasPrefix(message.Text, restartReportPrefix)):
+		default:
+			history = append(history, deepseek.Turn{Assistant: assistant, Text: message.Text})
 		}
 	}
+	return history[max(0, len(history)-dialogContextMessages):]
+}
 
-	log.Printf("AI response sent for msg %v", msg.MessageID)
+// botCommands - меню бота в Telegram. Административные команды в него не входят
+var botCommands = []tgbotapi.BotCommand{
+	{Command: "start", Description: "начать диалог"},
+	{Command: "help", Description: "список команд"},
+	{Command: "restart", Description: "очистить диалог и перенести его в архив"},
+	{Command: "stop", Description: "остановить генерацию ответа"},
+	{Command: "history", Description: "предыдущие диалоги из архива"},
+	{Command: "recover", Description: "вернуть диалог из архива в чат"},
+	{Command: "forgetme", Description: "удалить все мои данные"},
+}
+
+// RegisterCommands публикует меню команд бота, вызывается при запуске
+func (s *Service) RegisterCommands(ctx context.Context) error {
+	if err := s.bot.SetMyCommands(ctx, botCommands); err != nil {
+		return fmt.Errorf("registering bot commands: %w", err)
+	}
 	return nil
 }
 
 func (s *Service) processCommand(ctx context.Context, msg *tgbotapi.Message) error {
-	commands, err := s.bot.GetMyCommands()
+	// административные команды не публикуются в списке команд бота, остальным они не отвечают
+	if msg.Command() == "broadcast" && s.isAdmin(msg.From) {
+		metrics.Commands.WithLabelValues("broadcast").Inc()
+		return s.broadcastCommand(ctx, msg)
+	}
+	// генерация отменяется ещё при получении /stop в SetBot, к моменту обработки она уже завершена
+	if msg.Command() == "stop" {
+		metrics.Commands.WithLabelValues("stop").Inc()
+		return nil
+	}
+
+	// команды сервиса выполняются, даже если меню бота в Telegram не обновлено
+	serviceCommands := map[string]func(context.Context, *tgbotapi.Message) error{
+		"history":  s.history,
+		"recover":  s.recover,
+		"forgetme": s.forgetMe,
+	}
+	if handle, ok := serviceCommands[msg.Command()]; ok {
+		metrics.Commands.WithLabelValues(msg.Command()).Inc()
+		return handle(ctx, msg)
+	}
+
+	commands, err := s.bot.GetMyCommands(ctx)
 	if err != nil {
 		return fmt.Errorf("getting commands: %w", err)
 	}
 	if len(commands) == 0 {
-		log.Printf("no commands found for @%v", msg.From.UserName)
+		logger.FromContext(ctx).Warnw("no commands registered for bot", "chat_id", msg.Chat.ID)
 		return nil
 	}
 	for _, command := range commands {
 
 		if command.Command == msg.Command() {
-			var msgIDs []int
+			metrics.Commands.WithLabelValues(command.Command).Inc()
 			if msg.Command() == "restart" {
-				dbIDs, err := s.storage.GetMsgIDs(ctx, msg.Chat.ID)
-				if err != nil {
-					return fmt.Errorf("getting msg ids: %w", err)
-				}
-				msgIDs = dbIDs
+				return s.restart(ctx, msg)
 			}
-			handleCommand, err := s.bot.HandleCommand(ctx, msg, msgIDs)
+			handleCommand, err := s.bot.HandleCommand(ctx, msg, nil)
 			if err != nil {
 				return fmt.Errorf("handling command: %w", err)
 			}
-			if handleCommand == nil {
-				ok, err := s.storage.MoveToRecover(ctx, msg.Chat.ID)
-				if err != nil {
-					return fmt.Errorf("moving recovery message: %w", err)
-				}
-				if !ok {
-					return nil
-				}
-			} else {
-				err = s.storage.Save(ctx, utils.BotMessageToModel(handleCommand))
+			if handleCommand != nil {
+				err = s.storage.messages.Save(ctx, utils.BotMessageToModel(handleCommand))
 				if err != nil {
 					return fmt.Errorf("saving handled command: %w", err)
 				}
@@ -237,3 +550,127 @@ func (s *Service) processCommand(ctx context.Context, msg *tgbotapi.Message) err
 
 	return nil
 }
+
+const (
+	// restartReportPrefix - начало отчётов /restart о неудалённых сообщениях, здесь и в Bot.HandleCommand.
+	// Отчёт открывает новый диалог, но модели в контексте не нужен
+	restartReportPrefix = "Диалог перенесён в архив, но "
+	// restartNotDeletedText - ответ на /restart, когда диалог заархивирован, но его сообщения остались в чате.
+	// Если не удалась только часть сообщений, их число сообщает бот
+	restartNotDeletedText = restartReportPrefix + "удалить его сообщения из чата не удалось"
+)
+
+// restart удаляет сообщения диалога из чата и переносит их в архив. Архивирование выполняется
+// даже если часть сообщений (или все) удалить не удалось, тогда пользователь получает об этом отчёт
+func (s *Service) restart(ctx context.Context, msg *tgbotapi.Message) error {
+	msgIDs, err := s.storage.messages.GetMsgIDs(ctx, msg.Chat.ID)
+	if err != nil {
+		return fmt.Errorf("getting msg ids: %w", err)
+	}
+
+	report, handleErr := s.bot.HandleCommand(ctx, msg, msgIDs)
+
+	if _, err = s.storage.messages.MoveToRecover(ctx, msg.Chat.ID); err != nil {
+		return errors.Join(
+			fmt.Errorf("moving recovery message: %w", err),
+			handleErr,
+		)
+	}
+
+	if handleErr != nil {
+		// иначе пользователь видит в чате сообщения, которых модель уже не помнит
+		return errors.Join(
+			fmt.Errorf("handling command: %w", handleErr),
+			s.sendAndSave(ctx, msg.Chat.ID, restartNotDeletedText),
+		)
+	}
+
+	if report != nil {
+		if err = s.storage.messages.Save(ctx, utils.BotMessageToModel(report)); err != nil {
+			return fmt.Errorf("saving restart report: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// historyLimit - сколько последних архивных сессий показывает /history
+const historyLimit = 10
+
+// history отправляет список архивных сессий чата
+func (s *Service) history(ctx context.Context, msg *tgbotapi.Message) error {
+	sessions, err := s.storage.messages.ListArchiveSessions(ctx, msg.Chat.ID, historyLimit)
+	if err != nil {
+		return fmt.Errorf("listing archive sessions: %w", err)
+	}
+
+	var text string
+	if len(sessions) == 0 {
+		text = "Архив пуст: после /restart здесь появятся предыдущие диалоги"
+	} else {
+		var sb strings.Builder
+		sb.WriteString("Предыдущие диалоги (восстановить: /recover <номер>):\n")
+		for _, session := range sessions {
+			fmt.Fprintf(&sb, "\n#%d — %s, сообщений: %d",
+				session.ID, session.ArchivedAt.Format("02.01.2006 15:04"), session.MessagesCount)
+			if session.FirstText != "" {
+				fmt.Fprintf(&sb, "\n«%s»", utils.Truncate(session.FirstText, 50))
+			}
+		}
+		text = sb.String()
+	}
+
+	return s.sendAndSave(ctx, msg.Chat.ID, text)
+}
+
+// recover возвращает сообщения архивной сессии, указанной в аргументе команды, в активный диалог чата:
+// модель получает их вместе со следующими вопросами (dialogHistory), а /restart снова удалит и заархивирует
+func (s *Service) recover(ctx context.Context, msg *tgbotapi.Message) error {
+	sessionID, err := strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(msg.CommandArguments()), "#"), 10, 64)
+	if err != nil {
+		return s.sendAndSave(ctx, msg.Chat.ID, "Укажите номер диалога из /history, например: /recover 12")
+	}
+
+	restored, err := s.storage.messages.RestoreArchiveSession(ctx, msg.Chat.ID, sessionID)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		return s.sendAndSave(ctx, msg.Chat.ID, fmt.Sprintf("Диалог #%d не найден, список доступных: /history", sessionID))
+	}
+	if err != nil {
+		return fmt.Errorf("restoring archive session (%v): %w", sessionID, err)
+	}
+
+	return s.sendAndSave(ctx, msg.Chat.ID, fmt.Sprintf(
+		"Диалог #%d восстановлен (%d сообщений), следующие ответы продолжат его. Текущий диалог перенесён в архив",
+		sessionID, restored))
+}
+
+func (s *Service) sendAndSave(ctx context.Context, chatID int64, text string) error {
+	if _, err := s.send(ctx, chatID, text); err != nil {
+		return fmt.Errorf("sending message: %w", err)
+	}
+	return nil
+}
+
+// forgetMe удаляет все данные отправителя. Ответ намеренно не сохраняется
+func (s *Service) forgetMe(ctx context.Context, msg *tgbotapi.Message) error {
+	// у постов каналов и анонимных администраторов нет автора, удалять нечего
+	if msg.From == nil {
+		if _, err := s.bot.SendMessage(ctx, msg.Chat.ID,
+			"Не удалось определить пользователя. Отправьте /forgetme от своего имени в личном чате с ботом"); err != nil {
+			return fmt.Errorf("sending forgetme error: %w", err)
+		}
+		return nil
+	}
+
+	affected, err := s.ForgetUser(ctx, msg.From.ID, "telegram:/forgetme")
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.bot.SendMessage(ctx, msg.Chat.ID, fmt.Sprintf(
+		"Все ваши данные удалены (записей: %d). Сообщения в самом чате можно удалить через Telegram", affected)); err != nil {
+		return fmt.Errorf("sending forgetme confirmation: %w", err)
+	}
+
+	return nil
+}
@@ -2,197 +2,676 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
-	"github.com/mytelegrambot/bot"
+	"github.com/mytelegrambot/bot/bottest"
 	"github.com/mytelegrambot/deepseek"
+	"github.com/mytelegrambot/deepseek/deepseektest"
+	"github.com/mytelegrambot/settings"
 	"github.com/mytelegrambot/storage"
-	"reflect"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
-func TestNewService(t *testing.T) {
-	type args struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		b       bot.BotAPI
-	}
-	tests := []struct {
-		name string
-		args args
-		want *Service
-	}{
-		// TODO: Add test cases.
+const (
+	testChatID  int64 = 100
+	testAdminID int64 = 42
+)
+
+// fakeClock - часы, которые идут только по Advance
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// testService - сервис на хранилище в памяти с записывающим ботом, заданными ответами модели и фейковыми часами
+type testService struct {
+	*Service
+	bot   *bottest.Recorder
+	r1    *deepseektest.R1
+	clock *fakeClock
+}
+
+func newTestService(t *testing.T, runtime settings.Settings, answers ...deepseektest.Answer) *testService {
+	t.Helper()
+
+	b := bottest.NewRecorder("start", "help", "restart")
+	r1 := deepseektest.NewR1(answers...)
+	s := NewService(zap.NewNop().Sugar(), storage.NewMemoryStorage(), r1, b, settings.Static(runtime), []int64{testAdminID})
+	clock := &fakeClock{now: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	s.now = clock.Now
+
+	return &testService{Service: s, bot: b, r1: r1, clock: clock}
+}
+
+var nextTestMessageID = 1
+
+// textMessage создаёт сообщение пользователя. Текст, начинающийся с "/", размечается как команда
+func textMessage(fromID int64, text string) *tgbotapi.Message {
+	nextTestMessageID++
+	msg := &tgbotapi.Message{
+		MessageID: nextTestMessageID,
+		From:      &tgbotapi.User{ID: fromID, UserName: "user"},
+		Chat:      &tgbotapi.Chat{ID: testChatID, Type: "private"},
+		Date:      int(time.Now().Unix()),
+		Text:      text,
 	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if got := NewService(tt.args.storage, tt.args.r1, tt.args.b); !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("NewService() = %v, want %v", got, tt.want)
-			}
-		})
+	if strings.HasPrefix(text, "/") {
+		command, _, _ := strings.Cut(text, " ")
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Length: len(command)}}
 	}
+	return msg
+}
+
+func TestNewService(t *testing.T) {
+	s := newTestService(t, settings.Default())
+
+	require.True(t, s.isAdmin(&tgbotapi.User{ID: testAdminID}))
+	require.False(t, s.isAdmin(&tgbotapi.User{ID: 7}))
+	require.False(t, s.isAdmin(nil))
+	require.Equal(t, settings.Default(), s.RuntimeSettings())
+	require.Contains(t, s.Ready(context.Background()), "telegram")
 }
 
 func TestService_ListCommands(t *testing.T) {
-	type fields struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		bot     bot.BotAPI
-	}
-	type args struct {
-		ctx context.Context
-		msg *tgbotapi.Message
-	}
+	s := newTestService(t, settings.Default())
+
+	commands, err := s.ListCommands(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"start", "help", "restart"}, commands)
+
+	s.bot.FailNext("GetMyCommands", errors.New("telegram is down"))
+	_, err = s.ListCommands(context.Background())
+	require.ErrorContains(t, err, "telegram is down")
+}
+
+func TestService_ProcessMessage(t *testing.T) {
+	deadline := deepseektest.Answer{Err: context.DeadlineExceeded}
+	runtime := settings.Default()
+	placeholder, tokens := runtime.PlaceholderText, "потрачено 10 токенов"
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    []string
-		wantErr bool
+		name          string
+		answers       []deepseektest.Answer
+		failSend      bool
+		wantErr       error
+		wantErrText   string
+		wantQuestions int
+		wantSent      []string
+		wantDeleted   bool
 	}{
-		// TODO: Add test cases.
+		{
+			name:          "answer replaces placeholder",
+			wantQuestions: 1,
+			wantSent:      []string{placeholder, "ответ: вопрос", tokens},
+			wantDeleted:   true,
+		},
+		{
+			name:          "timeout",
+			answers:       []deepseektest.Answer{deadline},
+			wantErr:       context.DeadlineExceeded,
+			wantQuestions: 1,
+			wantSent:      []string{placeholder, runtime.TimeoutText},
+		},
+		{
+			name:          "other errors",
+			answers:       []deepseektest.Answer{{Err: errors.New("401 unauthorized")}},
+			wantErrText:   "401 unauthorized",
+			wantQuestions: 1,
+			wantSent:      []string{placeholder},
+		},
+		{
+			name:        "placeholder is not sent",
+			failSend:    true,
+			wantErrText: "sending mock message",
+		},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Service{
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-				bot:     tt.fields.bot,
+			s := newTestService(t, runtime, tt.answers...)
+			if tt.failSend {
+				s.bot.FailNext("SendMessage", errors.New("chat not found"))
 			}
-			got, err := s.ListCommands(tt.args.ctx, tt.args.msg)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("ListCommands() error = %v, wantErr %v", err, tt.wantErr)
-				return
+
+			err := s.ProcessMessage(context.Background(), textMessage(7, "вопрос"))
+			switch {
+			case tt.wantErr != nil:
+				require.ErrorIs(t, err, tt.wantErr)
+			case tt.wantErrText != "":
+				require.ErrorContains(t, err, tt.wantErrText)
+			default:
+				require.NoError(t, err)
 			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("ListCommands() got = %v, want %v", got, tt.want)
+
+			require.Len(t, s.r1.Questions(), tt.wantQuestions)
+			if tt.failSend {
+				return
 			}
+			require.Equal(t, tt.wantSent, s.bot.Sent())
+			deleted := s.bot.Calls("DeleteMessage")
+			require.Equal(t, tt.wantDeleted, len(deleted) == 1, deleted)
 		})
 	}
 }
 
-func TestService_ProcessMessage(t *testing.T) {
-	type fields struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		bot     bot.BotAPI
-	}
-	type args struct {
-		ctx context.Context
-		msg *tgbotapi.Message
-	}
+func TestService_ProcessMessage_rateLimit(t *testing.T) {
+	runtime := settings.Default()
+	runtime.RateLimit.Questions = 1
+	s := newTestService(t, runtime)
+	ctx := context.Background()
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "первый")))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "второй")))
+	require.Equal(t, []string{"первый"}, s.r1.Questions())
+	require.Equal(t, runtime.RateLimit.Text, s.bot.Sent()[3])
+
+	s.clock.Advance(time.Duration(runtime.RateLimit.Per))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "третий")))
+	require.Equal(t, []string{"первый", "третий"}, s.r1.Questions())
+}
+
+func TestService_ProcessMessage_stop(t *testing.T) {
+	stopped := settings.Default().StoppedText
+
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name     string
+		answer   deepseektest.Answer
+		wantText string
 	}{
-		// TODO: Add test cases.
+		{name: "nothing generated yet", answer: deepseektest.Answer{Block: true}, wantText: stopped},
+		{name: "partial answer is kept", answer: deepseektest.Answer{Text: "начало ответа", Block: true}, wantText: "начало ответа\n\n" + stopped},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Service{
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-				bot:     tt.fields.bot,
-			}
-			if err := s.ProcessMessage(tt.args.ctx, tt.args.msg); (err != nil) != tt.wantErr {
-				t.Errorf("ProcessMessage() error = %v, wantErr %v", err, tt.wantErr)
-			}
+			s := newTestService(t, settings.Default(), tt.answer)
+
+			question := textMessage(7, "вопрос")
+			errs := make(chan error, 1)
+			go func() { errs <- s.ProcessMessage(context.Background(), question) }()
+
+			require.Eventually(t, func() bool { return s.generations.stop(testChatID) }, time.Second, time.Millisecond)
+			require.NoError(t, <-errs)
+
+			placeholder := s.bot.Calls("SendMessage")[0]
+			require.Equal(t, stopKeyboard(question.MessageID), *placeholder.Keyboard, "the placeholder has a stop button")
+
+			edited := s.bot.Calls("EditMessageText")
+			require.Len(t, edited, 1)
+			require.Equal(t, tt.wantText, edited[0].Text)
+			require.Len(t, s.r1.Questions(), 1, "a stopped generation is not retried")
+			require.Empty(t, s.bot.Calls("DeleteMessage"))
+			require.False(t, s.generations.stop(testChatID), "the generation is unregistered")
 		})
 	}
 }
 
+func TestService_handleUpdate_failureMessage(t *testing.T) {
+	s := newTestService(t, settings.Default(), deepseektest.Answer{Err: errors.New("model not found")})
+	ctx := context.Background()
+	update := tgbotapi.Update{UpdateID: 5, Message: textMessage(7, "вопрос")}
+
+	err := s.handleUpdate(ctx, update)
+	require.ErrorContains(t, err, "model not found")
+	require.Equal(t, []string{settings.Default().PlaceholderText, settings.Default().FailureText}, s.bot.Sent())
+
+	// повторная доставка апдейта не отправляет ответ ещё раз
+	require.NoError(t, s.handleUpdate(ctx, update))
+	require.Len(t, s.r1.Questions(), 1)
+}
+
 func TestService_SetBot(t *testing.T) {
-	type fields struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		bot     bot.BotAPI
-	}
-	type args struct {
-		ctx context.Context
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 10, Message: textMessage(7, "вопрос")}
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 11, CallbackQuery: &tgbotapi.CallbackQuery{ID: "cb"}}
+	close(s.bot.Updates)
+
+	require.ErrorContains(t, s.SetBot(ctx, ctx), "updates channel closed")
+
+	jobs, err := s.storage.updates.ClaimUpdates(ctx, "test", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1, "only messages are queued")
+	require.Equal(t, 10, jobs[0].Update.UpdateID)
+
+	offset, err := s.storage.updates.LastUpdateOffset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 11, offset)
+}
+
+func TestService_SetBot_stop(t *testing.T) {
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+
+	genCtx, done := s.generations.start(ctx, testChatID, 4)
+	defer done()
+	placeholder := &tgbotapi.Message{MessageID: 5, Chat: &tgbotapi.Chat{ID: testChatID}}
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 9, CallbackQuery: &tgbotapi.CallbackQuery{ID: "cb0", Data: "stop:2", Message: placeholder}}
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 10, CallbackQuery: &tgbotapi.CallbackQuery{ID: "cb", Data: "stop:4", Message: placeholder}}
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 11, CallbackQuery: &tgbotapi.CallbackQuery{ID: "cb2", Data: "stop:4", Message: placeholder}}
+	close(s.bot.Updates)
+
+	require.ErrorContains(t, s.SetBot(ctx, ctx), "updates channel closed")
+	require.ErrorIs(t, context.Cause(genCtx), ErrGenerationStopped)
+
+	answers := s.bot.Calls("AnswerCallbackQuery")
+	require.Len(t, answers, 3)
+	require.Empty(t, answers[0].Text, "a button under an earlier answer doesn't stop the current one")
+	require.Equal(t, settings.Default().StoppedText, answers[1].Text)
+	require.Empty(t, answers[2].Text, "nothing to stop")
+
+	// /stop отменяет генерацию сразу при получении и попадает в очередь как обычная команда
+	s.bot.Updates = make(chan tgbotapi.Update, 1)
+	genCtx, done = s.generations.start(ctx, testChatID, 6)
+	defer done()
+	s.bot.Updates <- tgbotapi.Update{UpdateID: 12, Message: textMessage(7, "/stop")}
+	close(s.bot.Updates)
+
+	require.ErrorContains(t, s.SetBot(ctx, ctx), "updates channel closed")
+	require.ErrorIs(t, context.Cause(genCtx), ErrGenerationStopped)
+	jobs, err := s.storage.updates.ClaimUpdates(ctx, "test", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.NoError(t, s.processCommand(ctx, jobs[0].Update.Message))
+	require.Empty(t, s.bot.Calls("HandleCommand", "SendMessage"))
+}
+
+// failingQueueStorage - хранилище с недоступной очередью апдейтов
+type failingQueueStorage struct {
+	storage.Storage
+}
+
+func (s failingQueueStorage) EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error) {
+	return 0, errors.New("database is down")
+}
+
+func TestService_SetBot_queueUnavailable(t *testing.T) {
+	b := bottest.NewRecorder("start", "help", "restart")
+	r1 := deepseektest.NewR1(deepseektest.Answer{Block: true})
+	store := failingQueueStorage{Storage: storage.NewMemoryStorage()}
+	s := NewService(zap.NewNop().Sugar(), store, r1, b, settings.Static(settings.Default()), nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	work, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	b.Updates <- tgbotapi.Update{UpdateID: 10, Message: textMessage(7, "долгий вопрос")}
+	b.Updates <- tgbotapi.Update{UpdateID: 11, Message: textMessage(7, "второй вопрос")}
+	close(b.Updates)
+
+	result := make(chan error, 1)
+	go func() { result <- s.SetBot(ctx, work) }()
+
+	// первый апдейт ещё обрабатывается, а приём апдейтов уже дочитал канал
+	require.Eventually(t, func() bool {
+		return len(r1.Questions()) == 1 && len(b.Updates) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	// остановка приёма не прерывает обработку принятых апдейтов
+	cancel()
+	select {
+	case err := <-result:
+		t.Fatalf("SetBot returned before the inline updates were processed: %v", err)
+	case <-time.After(50 * time.Millisecond):
 	}
+	require.Len(t, r1.Questions(), 1)
+
+	cancelWork()
+	require.Error(t, <-result)
+	require.Len(t, r1.Questions(), 1, "the rest is dropped once the work context is cancelled")
+
+	offset, err := store.LastUpdateOffset(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, offset, "updates outside the queue don't move the offset")
+}
+
+func TestService_SetBot_drainsInline(t *testing.T) {
+	b := bottest.NewRecorder("start", "help", "restart")
+	r1 := deepseektest.NewR1()
+	s := NewService(zap.NewNop().Sugar(), failingQueueStorage{Storage: storage.NewMemoryStorage()}, r1, b,
+		settings.Static(settings.Default()), nil)
+	ctx := context.Background()
+
+	b.Updates <- tgbotapi.Update{UpdateID: 10, Message: textMessage(7, "первый вопрос")}
+	b.Updates <- tgbotapi.Update{UpdateID: 11, Message: textMessage(7, "второй вопрос")}
+	close(b.Updates)
+
+	require.ErrorContains(t, s.SetBot(ctx, ctx), "updates channel closed")
+	require.Equal(t, []string{"первый вопрос", "второй вопрос"}, r1.Questions(), "accepted updates are processed before SetBot returns")
+}
+
+func TestService_getAiResponse(t *testing.T) {
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name     string
+		answer   deepseektest.Answer
+		wantErr  string
+		wantSent []string
 	}{
-		// TODO: Add test cases.
+		{
+			name:     "every choice is sent",
+			answer:   deepseektest.Answer{Text: "42"},
+			wantSent: []string{"42", "потрачено 10 токенов"},
+		},
+		{
+			name:    "model error",
+			answer:  deepseektest.Answer{Err: errors.New("rate limited")},
+			wantErr: "getting answer question",
+		},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Service{
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-				bot:     tt.fields.bot,
-			}
-			if err := s.SetBot(tt.args.ctx); (err != nil) != tt.wantErr {
-				t.Errorf("SetBot() error = %v, wantErr %v", err, tt.wantErr)
+			s := newTestService(t, settings.Default(), tt.answer)
+
+			placeholder := &tgbotapi.Message{MessageID: 999, From: &tgbotapi.User{ID: 1, IsBot: true}}
+			err := s.getAiResponse(context.Background(), textMessage(7, "вопрос"), placeholder)
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
 			}
+			require.NoError(t, err)
+			require.Equal(t, tt.wantSent, s.bot.Sent())
+
+			calls := s.bot.Calls("SendChatAction", "SendMessage")
+			require.Equal(t, bottest.Call{Method: "SendChatAction", ChatID: testChatID, Text: "typing"}, calls[0], "typing while the model answers")
 		})
 	}
 }
 
-func TestService_getAiResponse(t *testing.T) {
-	type fields struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		bot     bot.BotAPI
-	}
-	type args struct {
-		ctx context.Context
-		msg *tgbotapi.Message
-	}
+func TestService_processCommand(t *testing.T) {
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name         string
+		from         int64
+		text         string
+		failCommands bool
+		wantErr      string
+		wantHandled  []string
+		wantSent     int
 	}{
-		// TODO: Add test cases.
+		{name: "registered command", from: 7, text: "/help", wantHandled: []string{"help"}},
+		{name: "unknown command is ignored", from: 7, text: "/unknown"},
+		{name: "broadcast from a regular user is ignored", from: 7, text: "/broadcast всем привет"},
+		{name: "broadcast usage for an admin", from: testAdminID, text: "/broadcast", wantSent: 1},
+		{name: "commands are unavailable", from: 7, text: "/help", failCommands: true, wantErr: "getting commands"},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Service{
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-				bot:     tt.fields.bot,
+			s := newTestService(t, settings.Default())
+			if tt.failCommands {
+				s.bot.FailNext("GetMyCommands", errors.New("telegram is down"))
 			}
-			if err := s.getAiResponse(tt.args.ctx, tt.args.msg); (err != nil) != tt.wantErr {
-				t.Errorf("getAiResponse() error = %v, wantErr %v", err, tt.wantErr)
+
+			err := s.processCommand(context.Background(), textMessage(tt.from, tt.text))
+			if tt.wantErr != "" {
+				require.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+
+			var handled []string
+			for _, call := range s.bot.Calls("HandleCommand") {
+				handled = append(handled, call.Text)
 			}
+			require.Equal(t, tt.wantHandled, handled)
+			require.Len(t, s.bot.Sent(), tt.wantSent)
 		})
 	}
 }
 
-func TestService_processCommand(t *testing.T) {
-	type fields struct {
-		storage storage.Storage
-		r1      deepseek.R1
-		bot     bot.BotAPI
+func TestService_processCommand_restart(t *testing.T) {
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "вопрос")))
+	ids, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.NotEmpty(t, ids)
+
+	restart := textMessage(7, "/restart")
+	require.NoError(t, s.ProcessMessage(ctx, restart))
+
+	calls := s.bot.Calls("HandleCommand")
+	require.Len(t, calls, 1)
+	require.Equal(t, append(ids, restart.MessageID), calls[0].MessageIDs, "the whole dialog including /restart is deleted")
+
+	left, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.Empty(t, left, "dialog is moved to the archive")
+
+	// архивирование выполняется, даже если Telegram не удалил сообщения
+	s.bot.FailNext("HandleCommand", errors.New("message can't be deleted"))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "ещё вопрос")))
+	err = s.ProcessMessage(ctx, textMessage(7, "/restart"))
+	require.ErrorContains(t, err, "message can't be deleted")
+	sent := s.bot.Sent()
+	require.Equal(t, restartNotDeletedText, sent[len(sent)-1], "the user is told the messages are still in the chat")
+
+	// в активном диалоге остаётся только отчёт, следующий /restart удалит его
+	left, err = s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.Len(t, left, 1)
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "новый вопрос")))
+	requests := s.r1.Requests()
+	require.Empty(t, requests[len(requests)-1].History, "the report is not part of the dialog")
+}
+
+func TestService_RegisterCommands(t *testing.T) {
+	s := newTestService(t, settings.Default())
+
+	require.NoError(t, s.RegisterCommands(context.Background()))
+	commands, err := s.ListCommands(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []string{"start", "help", "restart", "stop", "history", "recover", "forgetme"}, commands)
+	require.NotContains(t, commands, "broadcast", "admin commands are not advertised")
+}
+
+func TestService_historyAndRecover(t *testing.T) {
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+	lastSent := func() string {
+		sent := s.bot.Sent()
+		return sent[len(sent)-1]
 	}
-	type args struct {
-		ctx context.Context
-		msg *tgbotapi.Message
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "первый вопрос")))
+	// команды выполняются, хотя их нет в меню бота
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/history")))
+	require.Contains(t, lastSent(), "Архив пуст")
+	active, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/restart")))
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/history")))
+	sessions, err := s.storage.messages.ListArchiveSessions(ctx, testChatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Contains(t, lastSent(), fmt.Sprintf("#%d", sessions[0].ID))
+	require.Contains(t, lastSent(), "«первый вопрос»")
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/recover")))
+	require.Contains(t, lastSent(), "Укажите номер диалога")
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/recover 999")))
+	require.Contains(t, lastSent(), "Диалог #999 не найден")
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, fmt.Sprintf("/recover #%d", sessions[0].ID))))
+	require.Contains(t, lastSent(), fmt.Sprintf("Диалог #%d восстановлен", sessions[0].ID))
+	restored, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.Subset(t, restored, active, "archived messages are back in the active dialog")
+	require.Len(t, s.bot.Calls("HandleCommand"), 1, "only /restart is handled by the bot")
+}
+
+func TestService_recover_restoresContext(t *testing.T) {
+	s := newTestService(t, settings.Default(), deepseektest.Answer{Text: "Барсик"})
+	ctx := context.Background()
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "как зовут моего кота?")))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/restart")))
+	sessions, err := s.storage.messages.ListArchiveSessions(ctx, testChatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "сколько ему лет?")))
+	require.Empty(t, s.r1.Requests()[1].History, "/restart clears the context")
+
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, fmt.Sprintf("/recover %d", sessions[0].ID))))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "а какого он цвета?")))
+
+	requests := s.r1.Requests()
+	require.Len(t, requests, 3)
+	require.Equal(t, []deepseek.Turn{
+		{Text: "как зовут моего кота?"},
+		{Assistant: true, Text: "Барсик"},
+	}, requests[2].History, "the restored dialog is the context of the next answer")
+	require.Equal(t, "а какого он цвета?", requests[2].Question)
+
+	// без /recover модель продолжает текущий диалог
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "спасибо")))
+	history := s.r1.Requests()[3].History
+	require.Equal(t, deepseek.Turn{Assistant: true, Text: "ответ: а какого он цвета?"}, history[len(history)-1])
+}
+
+func TestService_forgetMe(t *testing.T) {
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+
+	question := textMessage(7, "мои данные")
+	require.NoError(t, s.ProcessMessage(ctx, question))
+	require.NoError(t, s.ProcessMessage(ctx, textMessage(7, "/forgetme")))
+
+	sent := s.bot.Sent()
+	require.Contains(t, sent[len(sent)-1], "Все ваши данные удалены")
+	ids, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.NotContains(t, ids, question.MessageID)
+}
+
+func TestService_forgetMe_noSender(t *testing.T) {
+	s := newTestService(t, settings.Default())
+	ctx := context.Background()
+
+	question := textMessage(7, "мои данные")
+	require.NoError(t, s.ProcessMessage(ctx, question))
+	// анонимный администратор группы: Telegram не передаёт From
+	anonymous := textMessage(8, "/forgetme")
+	anonymous.From = nil
+	require.NoError(t, s.ProcessMessage(ctx, anonymous))
+
+	sent := s.bot.Sent()
+	require.Contains(t, sent[len(sent)-1], "Не удалось определить пользователя")
+	ids, err := s.storage.messages.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.Contains(t, ids, question.MessageID)
+}
+
+// failingTxStorage - хранилище, у которого транзакции падают, пока failTx == true
+type failingTxStorage struct {
+	storage.Storage
+	mu     sync.Mutex
+	failTx bool
+}
+
+func (s *failingTxStorage) setFailTx(fail bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failTx = fail
+}
+
+func (s *failingTxStorage) WithinTx(ctx context.Context, fn func(tx storage.Tx) error) error {
+	s.mu.Lock()
+	fail := s.failTx
+	s.mu.Unlock()
+	if fail {
+		return errors.New("database is down")
 	}
+	return s.Storage.WithinTx(ctx, fn)
+}
+
+func TestService_send_retry(t *testing.T) {
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		wantErr bool
+		name      string
+		err       error
+		wantSends int
 	}{
-		// TODO: Add test cases.
+		{name: "telegram is unavailable", err: &tgbotapi.Error{Code: 502, Message: "Bad Gateway"}, wantSends: 2},
+		{name: "bot is blocked", err: &tgbotapi.Error{Code: 403, Message: "Forbidden: bot was blocked by the user"}, wantSends: 1},
+		{name: "bad request", err: &tgbotapi.Error{Code: 400, Message: "Bad Request: chat not found"}, wantSends: 1},
 	}
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s := &Service{
-				storage: tt.fields.storage,
-				r1:      tt.fields.r1,
-				bot:     tt.fields.bot,
-			}
-			if err := s.processCommand(tt.args.ctx, tt.args.msg); (err != nil) != tt.wantErr {
-				t.Errorf("processCommand() error = %v, wantErr %v", err, tt.wantErr)
-			}
+			ctx := context.Background()
+			clock := &fakeClock{now: time.Now()}
+			b := bottest.NewRecorder()
+			s := NewService(zap.NewNop().Sugar(), storage.NewMemoryStorage(storage.WithClock(clock.Now)), deepseektest.NewR1(), b,
+				settings.Static(settings.Default()), nil)
+
+			b.FailNext("SendMessage", tt.err)
+			_, err := s.send(ctx, testChatID, "ответ")
+			require.Error(t, err)
+
+			// повтор ждёт паузы, а не ближайшего прохода RunOutbox
+			s.flushOutbox(ctx)
+			require.Len(t, b.Calls("SendMessage"), 1)
+
+			clock.Advance(outboxBackoff)
+			s.flushOutbox(ctx)
+			clock.Advance(outboxMaxBackoff)
+			s.flushOutbox(ctx)
+			require.Len(t, b.Calls("SendMessage"), tt.wantSends)
 		})
 	}
+
+	require.Equal(t, outboxBackoff, outboxRetryDelay(1))
+	require.Equal(t, 4*outboxBackoff, outboxRetryDelay(3))
+	require.Equal(t, outboxMaxBackoff, outboxRetryDelay(maxOutboxAttempts*10))
+}
+
+func TestService_send_recordFails(t *testing.T) {
+	ctx := context.Background()
+	clock := &fakeClock{now: time.Now()}
+	store := &failingTxStorage{Storage: storage.NewMemoryStorage(storage.WithClock(clock.Now)), failTx: true}
+	b := bottest.NewRecorder()
+	s := NewService(zap.NewNop().Sugar(), store, deepseektest.NewR1(), b, settings.Static(settings.Default()), nil)
+
+	message, err := s.send(ctx, testChatID, "ответ")
+	require.NoError(t, err, "the message is delivered even if it isn't recorded")
+
+	// запись outbox брошена в статусе sending, но отправлять её второй раз нельзя
+	clock.Advance(outboxStaleAfter + time.Minute)
+	s.flushOutbox(ctx)
+	require.Len(t, b.Sent(), 1)
+
+	store.setFailTx(false)
+	s.flushOutbox(ctx)
+	require.Len(t, b.Sent(), 1)
+
+	ids, err := store.GetMsgIDs(ctx, testChatID)
+	require.NoError(t, err)
+	require.Equal(t, []int{message.MessageID}, ids)
+
+	clock.Advance(outboxStaleAfter + time.Minute)
+	claimed, err := store.ClaimOutbox(ctx, outboxStaleAfter, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed, "the recorded message is marked sent")
 }
@@ -0,0 +1,21 @@
+package service
+
+import (
+	"context"
+	"github.com/mytelegrambot/settings"
+)
+
+// RuntimeSettings возвращает действующие настройки времени выполнения
+func (s *Service) RuntimeSettings() settings.Settings {
+	return s.settings.Get()
+}
+
+// ReloadSettings перечитывает настройки из источника
+func (s *Service) ReloadSettings(ctx context.Context) (settings.Settings, error) {
+	return s.settings.Reload(ctx)
+}
+
+// PatchSettings меняет часть настроек и сохраняет их. Для файла настроек возвращает settings.ErrReadOnly
+func (s *Service) PatchSettings(ctx context.Context, patch []byte) (settings.Settings, error) {
+	return s.settings.Patch(ctx, patch)
+}
@@ -0,0 +1,160 @@
+// Package settings хранит настройки, которые меняются без перезапуска бота: модель, таймауты,
+// число повторов, тексты служебных сообщений и лимиты. Код читает их через Store.Get на каждый запрос
+package settings
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+)
+
+type Settings struct {
+	// Model - модель OpenRouter, которой задаются вопросы
+	Model string `json:"model"`
+	// LLMTimeout - таймаут одного запроса к модели
+	LLMTimeout Duration `json:"llm_timeout"`
+	// MaxRetries - сколько раз запрос к модели повторяется после таймаута, 5xx, 429 или сетевой ошибки
+	MaxRetries      int       `json:"max_retries"`
+	PlaceholderText string    `json:"placeholder_text"`
+	TimeoutText     string    `json:"timeout_text"`
+	FailureText     string    `json:"failure_text"`
+	StoppedText     string    `json:"stopped_text"`
+	RateLimit       RateLimit `json:"rate_limit"`
+	// Retry - задержка между повторами запроса к модели
+	Retry Retry `json:"retry"`
+	// CircuitBreaker - когда провайдер моделей перестаёт опрашиваться
+	CircuitBreaker CircuitBreaker `json:"circuit_breaker"`
+	// Fallbacks - запасные модели в порядке опроса, если Model недоступна
+	Fallbacks []Fallback `json:"fallbacks"`
+}
+
+// RateLimit ограничивает число вопросов к модели от одного чата: не больше Questions за Per.
+// Questions == 0 - без ограничения
+type RateLimit struct {
+	Questions int      `json:"questions"`
+	Per       Duration `json:"per"`
+	Text      string   `json:"text"`
+}
+
+// Retry - экспоненциальная задержка с джиттером: Backoff перед первым повтором, дальше удваивается до MaxBackoff
+type Retry struct {
+	Backoff    Duration `json:"backoff"`
+	MaxBackoff Duration `json:"max_backoff"`
+}
+
+// CircuitBreaker отключает провайдера на Cooldown после Failures неудачных запросов подряд,
+// затем пропускает один пробный запрос. Failures == 0 - провайдер не отключается
+type CircuitBreaker struct {
+	Failures int      `json:"failures"`
+	Cooldown Duration `json:"cooldown"`
+}
+
+// Fallback - запасная модель. Provider - имя провайдера из конфига (name в llm_fallback), пустой - OpenRouter
+type Fallback struct {
+	Provider string `json:"provider,omitempty"`
+	Model    string `json:"model"`
+}
+
+// Default - настройки, которые действуют, пока в источнике ничего не задано.
+// Ключи, отсутствующие в источнике, тоже берутся отсюда
+func Default() Settings {
+	return Settings{
+		Model:           "deepseek/deepseek-chat-v3-0324:free",
+		LLMTimeout:      Duration(40 * time.Second),
+		MaxRetries:      2,
+		PlaceholderText: "Ваш ответ генерируется, подождите!",
+		TimeoutText:     "Время ожидания вышло, попробуем ещё раз?",
+		FailureText:     "Не могу обработать Ваше сообщение, попробуйте позднее!",
+		StoppedText:     "Генерация остановлена",
+		RateLimit: RateLimit{
+			Per:  Duration(time.Minute),
+			Text: "Слишком много вопросов, попробуйте чуть позже",
+		},
+		Retry: Retry{
+			Backoff:    Duration(time.Second),
+			MaxBackoff: Duration(10 * time.Second),
+		},
+		CircuitBreaker: CircuitBreaker{
+			Failures: 5,
+			Cooldown: Duration(time.Minute),
+		},
+	}
+}
+
+// maxRetriesLimit - верхняя граница MaxRetries: дальше повторы только держат вопрос без ответа
+const maxRetriesLimit = 10
+
+// Validate возвращает все ошибки настроек разом. providers - имена провайдеров моделей из конфига,
+// на которые могут ссылаться Fallbacks
+func (s Settings) Validate(providers []string) error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(s.Model != "", "model is required")
+	check(s.LLMTimeout > 0, "llm_timeout must be positive, got %v", s.LLMTimeout)
+	check(s.MaxRetries >= 0 && s.MaxRetries <= maxRetriesLimit,
+		"max_retries must be between 0 and %d, got %d", maxRetriesLimit, s.MaxRetries)
+	check(s.PlaceholderText != "", "placeholder_text is required")
+	check(s.TimeoutText != "", "timeout_text is required")
+	check(s.FailureText != "", "failure_text is required")
+	check(s.StoppedText != "", "stopped_text is required")
+	check(s.RateLimit.Questions >= 0, "rate_limit.questions must not be negative, got %d", s.RateLimit.Questions)
+	if s.RateLimit.Questions > 0 {
+		check(s.RateLimit.Per > 0, "rate_limit.per must be positive, got %v", s.RateLimit.Per)
+		check(s.RateLimit.Text != "", "rate_limit.text is required")
+	}
+	check(s.Retry.Backoff > 0, "retry.backoff must be positive, got %v", s.Retry.Backoff)
+	check(s.Retry.MaxBackoff >= s.Retry.Backoff, "retry.max_backoff must not be less than retry.backoff, got %v", s.Retry.MaxBackoff)
+	check(s.CircuitBreaker.Failures >= 0, "circuit_breaker.failures must not be negative, got %d", s.CircuitBreaker.Failures)
+	if s.CircuitBreaker.Failures > 0 {
+		check(s.CircuitBreaker.Cooldown > 0, "circuit_breaker.cooldown must be positive, got %v", s.CircuitBreaker.Cooldown)
+	}
+	for i, fallback := range s.Fallbacks {
+		check(fallback.Model != "", "fallbacks[%d].model is required", i)
+		check(fallback.Provider == "" || slices.Contains(providers, fallback.Provider),
+			"fallbacks[%d].provider %q is not configured, expected one of %q", i, fallback.Provider, providers)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Duration - time.Duration, которая в JSON записывается строкой вида "40s"
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
+	if err != nil {
+		return fmt.Errorf("invalid duration %q, expected e.g. 30s or 1m", text)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// apply накладывает JSON поверх s: отсутствующие в data ключи не меняются
+func apply(s Settings, data []byte, providers []string) (Settings, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	// опечатка в ключе иначе молча оставила бы старое значение
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&s); err != nil {
+		return Settings{}, fmt.Errorf("decoding settings: %w", err)
+	}
+	if err := s.Validate(providers); err != nil {
+		return Settings{}, err
+	}
+	return s, nil
+}
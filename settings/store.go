@@ -0,0 +1,258 @@
+package settings
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mytelegrambot/logger"
+	"gopkg.in/yaml.v3"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrReadOnly возвращается при попытке изменить настройки, которые читаются из файла
+var ErrReadOnly = errors.New("settings source is read-only")
+
+// Source - откуда читаются настройки. Load возвращает JSON или nil, если настройки не заданы
+type Source interface {
+	Load(ctx context.Context) ([]byte, error)
+}
+
+// Saver - источник, в который можно записать настройки
+type Saver interface {
+	Save(ctx context.Context, data []byte) error
+}
+
+// Repository - хранилище настроек, реализуется storage.SettingsStore
+type Repository interface {
+	RuntimeSettings(ctx context.Context) ([]byte, error)
+	SaveRuntimeSettings(ctx context.Context, value []byte) error
+}
+
+type repositorySource struct {
+	repo Repository
+}
+
+// FromRepository читает и сохраняет настройки в хранилище бота (таблица runtime_settings)
+func FromRepository(repo Repository) Source {
+	return repositorySource{repo: repo}
+}
+
+func (r repositorySource) Load(ctx context.Context) ([]byte, error) {
+	return r.repo.RuntimeSettings(ctx)
+}
+
+func (r repositorySource) Save(ctx context.Context, data []byte) error {
+	return r.repo.SaveRuntimeSettings(ctx, data)
+}
+
+type fileSource struct {
+	path string
+}
+
+// FromFile читает настройки из файла JSON или YAML. Отсутствующий файл - настройки по умолчанию.
+// Файл только читается: чтобы изменить настройки, его правят и перечитывают
+func FromFile(path string) Source {
+	return fileSource{path: path}
+}
+
+func (f fileSource) Load(context.Context) ([]byte, error) {
+	data, err := os.ReadFile(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading settings file: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(f.path)); ext {
+	case ".json":
+		return data, nil
+	case ".yaml", ".yml":
+		var tree map[string]any
+		if err = yaml.Unmarshal(data, &tree); err != nil {
+			return nil, fmt.Errorf("parsing settings file %v: %w", f.path, err)
+		}
+		return json.Marshal(tree)
+	default:
+		return nil, fmt.Errorf("unknown settings file format %q, expected .json, .yaml or .yml", ext)
+	}
+}
+
+// Store хранит текущие настройки. Get безопасен для конкурентного вызова и не обращается к источнику
+type Store struct {
+	source Source
+	// providers - провайдеры моделей из конфига, на которые могут ссылаться запасные модели
+	providers []string
+	current   atomic.Pointer[Settings]
+	// mu не даёт параллельным Reload и Patch перезаписать друг друга
+	mu sync.Mutex
+}
+
+// NewStore создаёт хранилище с настройками по умолчанию. Настройки из source читаются в Reload.
+// Запасные модели с провайдером не из providers отклоняются
+func NewStore(source Source, providers ...string) *Store {
+	s := &Store{source: source, providers: providers}
+	s.set(Default())
+	return s
+}
+
+// Static возвращает хранилище с неизменяемыми настройками, например для тестов
+func Static(settings Settings) *Store {
+	s := &Store{}
+	s.set(settings)
+	return s
+}
+
+func (s *Store) set(settings Settings) {
+	s.current.Store(&settings)
+}
+
+// Get возвращает текущие настройки
+func (s *Store) Get() Settings {
+	return *s.current.Load()
+}
+
+// Reload перечитывает источник. Если настройки в источнике некорректны, продолжают
+// действовать прежние, а ошибка возвращается
+func (s *Store) Reload(ctx context.Context) (Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.source == nil {
+		return s.Get(), nil
+	}
+	data, err := s.source.Load(ctx)
+	if err != nil {
+		return s.Get(), fmt.Errorf("loading settings: %w", err)
+	}
+
+	next := Default()
+	if len(data) > 0 {
+		if next, err = apply(next, data, s.providers); err != nil {
+			return s.Get(), err
+		}
+	}
+	s.set(next)
+	return next, nil
+}
+
+// Patch накладывает JSON patch на сохранённые в источнике настройки и применяет результат.
+// В источник попадают только ключи, заданные пользователем, остальные берутся из Default,
+// поэтому новые значения по умолчанию применяются после обновления бота. null в patch
+// возвращает ключу значение по умолчанию
+func (s *Store) Patch(ctx context.Context, patch []byte) (Settings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saver, ok := s.source.(Saver)
+	if !ok {
+		return Settings{}, ErrReadOnly
+	}
+	stored, err := s.source.Load(ctx)
+	if err != nil {
+		return Settings{}, fmt.Errorf("loading settings: %w", err)
+	}
+	data, err := mergePatch(stored, patch)
+	if err != nil {
+		return Settings{}, err
+	}
+	next, err := apply(Default(), data, s.providers)
+	if err != nil {
+		return Settings{}, err
+	}
+	if err = saver.Save(ctx, data); err != nil {
+		return Settings{}, fmt.Errorf("saving settings: %w", err)
+	}
+
+	s.set(next)
+	return next, nil
+}
+
+// mergePatch накладывает patch на stored по правилам JSON Merge Patch (RFC 7396): вложенные
+// объекты сливаются по ключам, остальные значения заменяются, null удаляет ключ
+func mergePatch(stored, patch []byte) ([]byte, error) {
+	base := make(map[string]any)
+	if len(stored) > 0 {
+		if err := decodeObject(stored, &base); err != nil {
+			return nil, fmt.Errorf("decoding stored settings: %w", err)
+		}
+	}
+	var changes map[string]any
+	if err := decodeObject(patch, &changes); err != nil {
+		return nil, fmt.Errorf("decoding settings: %w", err)
+	}
+
+	data, err := json.Marshal(merge(base, changes))
+	if err != nil {
+		return nil, fmt.Errorf("encoding settings: %w", err)
+	}
+	return data, nil
+}
+
+// decodeObject разбирает JSON-объект, сохраняя числа как есть
+func decodeObject(data []byte, out *map[string]any) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(out); err != nil {
+		return err
+	}
+	if *out == nil {
+		return errors.New("settings must be a JSON object")
+	}
+	return nil
+}
+
+func merge(base, patch map[string]any) map[string]any {
+	for key, value := range patch {
+		switch value := value.(type) {
+		case nil:
+			delete(base, key)
+		case map[string]any:
+			nested, ok := base[key].(map[string]any)
+			if !ok {
+				nested = make(map[string]any)
+			}
+			base[key] = merge(nested, value)
+		default:
+			base[key] = value
+		}
+	}
+	return base
+}
+
+// Watch перечитывает настройки каждые interval и по сигналу из reload (SIGHUP), пока не отменён ctx.
+// Так подхватываются правки файла и изменения, сделанные другим экземпляром бота
+func (s *Store) Watch(ctx context.Context, interval time.Duration, reload <-chan os.Signal) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log := logger.FromContext(ctx)
+	for {
+		select {
+		case <-ticker.C:
+		case <-reload:
+			log.Infow("reloading settings on signal")
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		previous := s.Get()
+		next, err := s.Reload(ctx)
+		if err != nil {
+			log.Errorw("reloading settings, keeping previous", "error", err)
+			continue
+		}
+		if !reflect.DeepEqual(next, previous) {
+			log.Infow("settings changed", "settings", next)
+		}
+	}
+}
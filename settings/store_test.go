@@ -0,0 +1,137 @@
+package settings
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type memoryRepository struct {
+	value []byte
+}
+
+func (m *memoryRepository) RuntimeSettings(context.Context) ([]byte, error) {
+	return m.value, nil
+}
+
+func (m *memoryRepository) SaveRuntimeSettings(_ context.Context, value []byte) error {
+	m.value = value
+	return nil
+}
+
+func TestStore_Reload(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryRepository{}
+	store := NewStore(FromRepository(repo), "openrouter", "deepseek")
+
+	got, err := store.Reload(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Default(), got, "empty source keeps defaults")
+
+	repo.value = []byte(`{"model": "deepseek/deepseek-r1:free", "llm_timeout": "1m", "rate_limit": {"questions": 5},
+		"fallbacks": [{"model": "meta-llama/llama-3.3-70b-instruct:free"}, {"provider": "deepseek", "model": "deepseek-chat"}]}`)
+	_, err = store.Reload(ctx)
+	require.NoError(t, err)
+
+	want := Default()
+	want.Model = "deepseek/deepseek-r1:free"
+	want.LLMTimeout = Duration(time.Minute)
+	want.RateLimit.Questions = 5
+	want.Fallbacks = []Fallback{{Model: "meta-llama/llama-3.3-70b-instruct:free"}, {Provider: "deepseek", Model: "deepseek-chat"}}
+	require.Equal(t, want, store.Get())
+
+	repo.value = []byte(`{"max_retries": -1, "modle": "typo"}`)
+	_, err = store.Reload(ctx)
+	require.Error(t, err)
+	repo.value = []byte(`{"fallbacks": [{"provider": "deepseek"}]}`)
+	_, err = store.Reload(ctx)
+	require.Error(t, err)
+	repo.value = []byte(`{"max_retries": 11}`)
+	_, err = store.Reload(ctx)
+	require.ErrorContains(t, err, "max_retries must be between 0 and 10")
+	repo.value = []byte(`{"fallbacks": [{"provider": "anthropic", "model": "claude"}]}`)
+	_, err = store.Reload(ctx)
+	require.ErrorContains(t, err, `fallbacks[0].provider "anthropic" is not configured`)
+	require.Equal(t, want, store.Get(), "invalid settings keep previous")
+}
+
+func TestStore_Patch(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryRepository{}
+	store := NewStore(FromRepository(repo))
+
+	got, err := store.Patch(ctx, []byte(`{"max_retries": 4, "placeholder_text": "Думаю..."}`))
+	require.NoError(t, err)
+	require.Equal(t, 4, got.MaxRetries)
+	require.Equal(t, "Думаю...", store.Get().PlaceholderText)
+	require.Equal(t, Default().Model, got.Model)
+
+	// сохранённые настройки переживают перезапуск
+	reloaded, err := NewStore(FromRepository(repo)).Reload(ctx)
+	require.NoError(t, err)
+	require.Equal(t, got, reloaded)
+
+	_, err = store.Patch(ctx, []byte(`{"llm_timeout": "soon"}`))
+	require.Error(t, err)
+	_, err = store.Patch(ctx, []byte(`{"fallbacks": [{"provider": "deepseek", "model": "deepseek-chat"}]}`))
+	require.Error(t, err, "provider is not in the config")
+	require.Equal(t, got, store.Get())
+	require.JSONEq(t, `{"max_retries": 4, "placeholder_text": "Думаю..."}`, string(repo.value))
+}
+
+func TestStore_Patch_storesOnlyUserKeys(t *testing.T) {
+	ctx := context.Background()
+	repo := &memoryRepository{value: []byte(`{"max_retries": 4, "rate_limit": {"questions": 3}}`)}
+	store := NewStore(FromRepository(repo))
+
+	got, err := store.Patch(ctx, []byte(`{"rate_limit": {"per": "30s"}, "max_retries": null, "model": "other-model"}`))
+	require.NoError(t, err)
+	require.Equal(t, Default().MaxRetries, got.MaxRetries, "null returns the default")
+	require.Equal(t, "other-model", got.Model)
+	require.Equal(t, RateLimit{Questions: 3, Per: Duration(30 * time.Second), Text: Default().RateLimit.Text}, got.RateLimit)
+
+	// значения по умолчанию не сохраняются: после обновления бота применятся новые
+	require.JSONEq(t, `{"model": "other-model", "rate_limit": {"questions": 3, "per": "30s"}}`, string(repo.value))
+
+	_, err = store.Patch(ctx, []byte(`["model"]`))
+	require.Error(t, err)
+}
+
+func TestStore_File(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "settings.yaml")
+	store := NewStore(FromFile(path))
+
+	got, err := store.Reload(ctx)
+	require.NoError(t, err)
+	require.Equal(t, Default(), got, "missing file keeps defaults")
+
+	require.NoError(t, os.WriteFile(path, []byte("max_retries: 0\nrate_limit:\n  questions: 3\n  per: 30s\n"), 0o600))
+	got, err = store.Reload(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, got.MaxRetries)
+	require.Equal(t, RateLimit{Questions: 3, Per: Duration(30 * time.Second), Text: Default().RateLimit.Text}, got.RateLimit)
+
+	_, err = store.Patch(ctx, []byte(`{"max_retries": 1}`))
+	require.ErrorIs(t, err, ErrReadOnly)
+}
+
+func TestStore_Watch(t *testing.T) {
+	repo := &memoryRepository{}
+	store := NewStore(FromRepository(repo))
+	reload := make(chan os.Signal, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- store.Watch(ctx, time.Hour, reload) }()
+
+	repo.value = []byte(`{"max_retries": 7}`)
+	reload <- os.Interrupt
+	require.Eventually(t, func() bool { return store.Get().MaxRetries == 7 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-done, context.Canceled)
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/mytelegrambot/models"
+	"time"
+)
+
+// ListChats возвращает чаты с сохранёнными сообщениями, начиная с самого активного недавно
+func (b *BotStorage) ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT chat_id, count(*) FILTER (WHERE NOT archived), count(*) FILTER (WHERE archived), max(time_stamp)
+		FROM (SELECT chat_id, time_stamp, false AS archived FROM updates_messages
+		      UNION ALL
+		      SELECT chat_id, time_stamp, true FROM archive_messages) m
+		GROUP BY chat_id
+		ORDER BY max(time_stamp) DESC, chat_id
+		LIMIT $1 OFFSET $2`,
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing chats: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ChatSummary, 0)
+	for rows.Next() {
+		var chat models.ChatSummary
+		if err := rows.Scan(&chat.ChatID, &chat.ActiveMessages, &chat.ArchivedMessages, &chat.LastMessageAt); err != nil {
+			return nil, fmt.Errorf("db scanning chat: %w", err)
+		}
+		result = append(result, chat)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading chats: %w", err)
+	}
+
+	return result, nil
+}
+
+// ListUsers возвращает отправителей сохранённых сообщений, начиная с последнего активного
+func (b *BotStorage) ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT m.from_id, (array_agg(m.from_username ORDER BY m.time_stamp DESC))[1], count(*), max(m.time_stamp),
+		       EXISTS (SELECT 1 FROM blocked_users b WHERE b.user_id = m.from_id)
+		FROM (SELECT from_id, from_username, time_stamp FROM updates_messages
+		      UNION ALL
+		      SELECT from_id, from_username, time_stamp FROM archive_messages) m
+		GROUP BY m.from_id
+		ORDER BY max(m.time_stamp) DESC, m.from_id
+		LIMIT $1 OFFSET $2`,
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing users: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.UserSummary, 0)
+	for rows.Next() {
+		var user models.UserSummary
+		if err := rows.Scan(&user.UserID, &user.Username, &user.MessagesCount, &user.LastSeenAt, &user.Blocked); err != nil {
+			return nil, fmt.Errorf("db scanning user: %w", err)
+		}
+		result = append(result, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading users: %w", err)
+	}
+
+	return result, nil
+}
+
+// ChatMessages возвращает сообщения чата из активного диалога и архива, начиная с самого нового
+func (b *BotStorage) ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT chat_id, message_id, from_id, from_username, text, time_stamp, archived
+		FROM (SELECT chat_id, message_id, from_id, from_username, text, time_stamp, false AS archived
+		      FROM updates_messages WHERE chat_id = $1
+		      UNION ALL
+		      SELECT chat_id, message_id, from_id, from_username, text, time_stamp, true
+		      FROM archive_messages WHERE chat_id = $1) m
+		ORDER BY time_stamp DESC, message_id DESC
+		LIMIT $2 OFFSET $3`,
+		chatID, page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing chat messages: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ChatMessage, 0)
+	for rows.Next() {
+		var msg models.ChatMessage
+		if err := rows.Scan(
+			&msg.ChatID,
+			&msg.MessageID,
+			&msg.FromID,
+			&msg.FromUsername,
+			&msg.Text,
+			&msg.Timestamp,
+			&msg.Archived,
+		); err != nil {
+			return nil, fmt.Errorf("db scanning chat message: %w", err)
+		}
+		result = append(result, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading chat messages: %w", err)
+	}
+
+	return result, nil
+}
+
+// SetUserBlocked блокирует или разблокирует пользователя и записывает действие в data_audit
+func (b *BotStorage) SetUserBlocked(ctx context.Context, userID int64, blocked bool, requestedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("db operation: block user, begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	action, query := "block_user",
+		"INSERT INTO blocked_users (user_id, blocked_by) VALUES ($1, $2) ON CONFLICT (user_id) DO NOTHING"
+	args := []any{userID, requestedBy}
+	if !blocked {
+		action, query, args = "unblock_user", "DELETE FROM blocked_users WHERE user_id = $1", []any{userID}
+	}
+
+	tag, err := tx.Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("db operation: %s: %w", action, err)
+	}
+	if err = writeAudit(ctx, tx, Audit{
+		Action:       action,
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: tag.RowsAffected(),
+		Details:      map[string]any{"blocked_users": tag.RowsAffected()},
+	}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("db operation: %s, commit: %w", action, err)
+	}
+	return nil
+}
+
+func (b *BotStorage) IsUserBlocked(ctx context.Context, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var blocked bool
+	if err := b.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM blocked_users WHERE user_id = $1)", userID,
+	).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("db operation: check blocked user: %w", err)
+	}
+
+	return blocked, nil
+}
@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/mytelegrambot/models"
+	"time"
+)
+
+// ErrAPIKeyNotFound возвращается, если ключа с таким хешем или ID нет либо он отозван
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// CreateAPIKey сохраняет ключ по его хешу и возвращает запись с ID и временем создания
+func (b *BotStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	err := b.pool.QueryRow(ctx,
+		"INSERT INTO api_keys (name, prefix, key_hash, scopes) VALUES ($1, $2, $3, $4) RETURNING id, created_at",
+		key.Name, key.Prefix, hash, key.Scopes,
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("db operation: create api key: %w", err)
+	}
+
+	return key, nil
+}
+
+// APIKeyByHash возвращает действующий (не отозванный) ключ по хешу
+func (b *BotStorage) APIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	err := b.pool.QueryRow(ctx,
+		"SELECT id, name, prefix, scopes, created_at FROM api_keys WHERE key_hash = $1 AND revoked_at IS NULL",
+		hash,
+	).Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("db operation: get api key: %w", err)
+	}
+
+	return key, nil
+}
+
+// ListAPIKeys возвращает все ключи, включая отозванные, в порядке создания
+func (b *BotStorage) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, "SELECT id, name, prefix, scopes, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("db listing api keys: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.APIKey, 0)
+	for rows.Next() {
+		var key models.APIKey
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &key.Scopes, &key.CreatedAt, &key.RevokedAt); err != nil {
+			return nil, fmt.Errorf("db scanning api key: %w", err)
+		}
+		result = append(result, key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading api keys: %w", err)
+	}
+
+	return result, nil
+}
+
+// RevokeAPIKey отзывает ключ. Повторный отзыв и неизвестный ID возвращают ErrAPIKeyNotFound
+func (b *BotStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tag, err := b.pool.Exec(ctx,
+		"UPDATE api_keys SET revoked_at = current_timestamp WHERE id = $1 AND revoked_at IS NULL", id,
+	)
+	if err != nil {
+		return fmt.Errorf("db operation: revoke api key: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
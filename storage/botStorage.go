@@ -2,36 +2,260 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/logger"
 	"github.com/mytelegrambot/models"
-	"log"
 	"time"
 )
 
+// Storage - всё хранилище бота, его реализуют MemoryStorage, SQLiteStorage и BotStorage.
+// Потребители зависят только от нужной им части: MessageStore, OutboxStore и т.д.
 type Storage interface {
+	MessageStore
+	PrivacyStore
+	OutboxStore
+	UpdateStore
+	SettingsStore
+	AdminStore
+	APIKeyStore
+	BroadcastStore
+	Pinger
+}
+
+// Pinger проверяет доступность хранилища
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// MessageStore - сообщения активного диалога и архив /restart
+type MessageStore interface {
 	Save(ctx context.Context, msg *models.Message) error
 	GetMsgIDs(ctx context.Context, id int64) ([]int, error)
+	ActiveDialog(ctx context.Context, chatID int64, limit int) ([]models.Message, error)
 	MoveToRecover(ctx context.Context, chatID int64) (bool, error)
+	ListArchiveSessions(ctx context.Context, chatID int64, limit int) ([]models.ArchiveSession, error)
+	RestoreArchiveSession(ctx context.Context, chatID int64, sessionID int64) (int, error)
+}
+
+// PrivacyStore - сроки хранения и удаление данных пользователя
+type PrivacyStore interface {
+	ApplyRetention(ctx context.Context, retention config.Retention) (int64, error)
+	ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error)
+}
+
+// OutboxStore - очередь исходящих сообщений
+type OutboxStore interface {
+	EnqueueOutbox(ctx context.Context, chatID int64, text string) (int64, error)
+	ClaimOutbox(ctx context.Context, staleAfter time.Duration, limit int) ([]models.OutboxMessage, error)
+	MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error
+	WithinTx(ctx context.Context, fn func(tx Tx) error) error
+}
+
+// UpdateStore - очередь входящих апдейтов, смещение getUpdates и отметки об обработке
+type UpdateStore interface {
+	EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error)
+	ClaimUpdates(ctx context.Context, worker string, lease time.Duration, limit int) ([]models.QueuedUpdate, error)
+	ExtendUpdateLease(ctx context.Context, id int64, worker string, lease time.Duration) error
+	FinishUpdate(ctx context.Context, id int64, status string, reason string) error
+	PendingUpdates(ctx context.Context) (int, error)
+
+	LastUpdateOffset(ctx context.Context) (int, error)
+	SaveUpdateOffset(ctx context.Context, offset int) error
+	IsUpdateProcessed(ctx context.Context, updateID int) (bool, error)
+	MarkUpdateProcessed(ctx context.Context, updateID int) error
+}
+
+// SettingsStore - настройки времени выполнения
+type SettingsStore interface {
+	RuntimeSettings(ctx context.Context) ([]byte, error)
+	SaveRuntimeSettings(ctx context.Context, value []byte) error
+}
+
+// AdminStore - чаты и пользователи для админки, блокировка пользователей
+type AdminStore interface {
+	ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error)
+	ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error)
+	ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error)
+	SetUserBlocked(ctx context.Context, userID int64, blocked bool, requestedBy string) error
+	IsUserBlocked(ctx context.Context, userID int64) (bool, error)
+}
+
+// APIKeyStore - ключи доступа к API
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error)
+	APIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	ListAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int64) error
+}
+
+// BroadcastStore - рассылки и их доставки
+type BroadcastStore interface {
+	CreateBroadcast(ctx context.Context, broadcast models.Broadcast) (models.Broadcast, error)
+	GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error)
+	ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error)
+	SetBroadcastStatus(ctx context.Context, id int64, from, to string) error
+	BroadcastDeliveries(ctx context.Context, broadcastID int64, page models.Page) ([]models.BroadcastDelivery, error)
+	ClaimBroadcastDeliveries(ctx context.Context, staleAfter time.Duration, limit int) ([]models.BroadcastDelivery, error)
+	MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error
+	MarkBroadcastDeliveryFailed(ctx context.Context, id int64, reason string, retry bool) error
+}
+
+// Tx - операции, которые выполняются в одной транзакции через Storage.WithinTx
+type Tx interface {
+	Save(ctx context.Context, msg *models.Message) error
+	MarkOutboxSent(ctx context.Context, id int64, messageID int) error
 }
 
+// ErrSessionNotFound возвращается, если архивной сессии нет или она принадлежит другому чату
+var ErrSessionNotFound = errors.New("archive session not found")
+
+const messageColumns = "chat_id, message_id, from_id, from_username, text, time_stamp, db_time_stamp"
+
+// MoveToRecover переносит активный диалог чата в archive_messages под новой архивной сессией
 func (b *BotStorage) MoveToRecover(ctx context.Context, chatID int64) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
 	defer cancel()
 
-	tag, err := b.pool.Exec(ctx, "WITH selection AS (DELETE FROM updates_messages WHERE chat_id = $1 RETURNING *) INSERT INTO archive_messages SELECT * FROM selection", chatID)
+	tx, err := b.pool.Begin(ctx)
 	if err != nil {
-		return false, fmt.Errorf("db operation: move to recover: %w", err)
+		return false, fmt.Errorf("db operation: move to recover, begin: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
-	if tag.RowsAffected() == 0 {
+	moved, err := archiveActive(ctx, tx, chatID)
+	if err != nil {
+		return false, err
+	}
+	if !moved {
 		return false, nil
 	}
 
+	if err = tx.Commit(ctx); err != nil {
+		return false, fmt.Errorf("db operation: move to recover, commit: %w", err)
+	}
+
 	return true, nil
 }
 
+func archiveActive(ctx context.Context, tx pgx.Tx, chatID int64) (bool, error) {
+	var sessionID int64
+	err := tx.QueryRow(ctx,
+		"INSERT INTO archive_sessions (chat_id) VALUES ($1) RETURNING id",
+		chatID,
+	).Scan(&sessionID)
+	if err != nil {
+		return false, fmt.Errorf("db operation: create archive session: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx,
+		"WITH selection AS (DELETE FROM updates_messages WHERE chat_id = $1 RETURNING "+messageColumns+") "+
+			"INSERT INTO archive_messages ("+messageColumns+", session_id) SELECT "+messageColumns+", $2 FROM selection",
+		chatID, sessionID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("db operation: move to recover: %w", err)
+	}
+
+	return tag.RowsAffected() > 0, nil
+}
+
+// ListArchiveSessions возвращает последние архивные сессии чата, начиная с самой свежей
+func (b *BotStorage) ListArchiveSessions(ctx context.Context, chatID int64, limit int) ([]models.ArchiveSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT s.id, s.chat_id, s.archived_at, count(m.message_id),
+		       coalesce((array_agg(m.text ORDER BY m.time_stamp) FILTER (WHERE m.text NOT LIKE '/%'))[1], '')
+		FROM archive_sessions s
+		JOIN archive_messages m ON m.session_id = s.id
+		WHERE s.chat_id = $1
+		GROUP BY s.id
+		ORDER BY s.archived_at DESC, s.id DESC
+		LIMIT $2`,
+		chatID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing archive sessions: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ArchiveSession, 0)
+	for rows.Next() {
+		var session models.ArchiveSession
+		if err := rows.Scan(
+			&session.ID,
+			&session.ChatID,
+			&session.ArchivedAt,
+			&session.MessagesCount,
+			&session.FirstText,
+		); err != nil {
+			return nil, fmt.Errorf("db scanning archive session: %w", err)
+		}
+		result = append(result, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading archive sessions: %w", err)
+	}
+
+	return result, nil
+}
+
+// RestoreArchiveSession делает архивную сессию активным диалогом. Текущий активный диалог
+// при этом архивируется, чтобы его тоже можно было восстановить. Возвращает число восстановленных сообщений
+func (b *BotStorage) RestoreArchiveSession(ctx context.Context, chatID int64, sessionID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: restore session, begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM archive_sessions WHERE id = $1 AND chat_id = $2)",
+		sessionID, chatID,
+	).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: restore session, lookup: %w", err)
+	}
+	if !exists {
+		return 0, ErrSessionNotFound
+	}
+
+	if _, err = archiveActive(ctx, tx, chatID); err != nil {
+		return 0, err
+	}
+
+	tag, err := tx.Exec(ctx,
+		"WITH selection AS (DELETE FROM archive_messages WHERE session_id = $1 RETURNING "+messageColumns+") "+
+			"INSERT INTO updates_messages ("+messageColumns+") SELECT "+messageColumns+" FROM selection",
+		sessionID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: restore session messages: %w", err)
+	}
+
+	if _, err = tx.Exec(ctx, "DELETE FROM archive_sessions WHERE id = $1 OR (chat_id = $2 AND NOT EXISTS "+
+		"(SELECT 1 FROM archive_messages WHERE session_id = archive_sessions.id))", sessionID, chatID); err != nil {
+		return 0, fmt.Errorf("db operation: restore session cleanup: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("db operation: restore session, commit: %w", err)
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
 func (b *BotStorage) GetMsgIDs(ctx context.Context, id int64) ([]int, error) {
 	getCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -50,30 +274,106 @@ func (b *BotStorage) GetMsgIDs(ctx context.Context, id int64) ([]int, error) {
 		}
 		result = append(result, ids)
 	}
-	deadline, ok := getCtx.Deadline()
-	if !ok {
-		log.Println("deadline not set for get messages ids")
-		return result, nil
+	deadline, _ := getCtx.Deadline()
+	logger.FromContext(ctx).Debugw("got messages ids", "chat_id", id, "count", len(result), "time_left", time.Until(deadline))
+	return result, nil
+}
+
+// ActiveDialog возвращает последние limit сообщений активного диалога чата от старых к новым
+func (b *BotStorage) ActiveDialog(ctx context.Context, chatID int64, limit int) ([]models.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT chat_id, message_id, from_id, from_username, text, time_stamp
+		FROM (SELECT chat_id, message_id, from_id, from_username, text, time_stamp FROM updates_messages
+		      WHERE chat_id = $1 ORDER BY time_stamp DESC, message_id DESC LIMIT $2) m
+		ORDER BY time_stamp, message_id`,
+		chatID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db getting active dialog: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Message, 0)
+	for rows.Next() {
+		var msg models.Message
+		if err := rows.Scan(&msg.ChatID, &msg.MessageID, &msg.FromID, &msg.FromUsername, &msg.Text, &msg.Timestamp); err != nil {
+			return nil, fmt.Errorf("db scanning active dialog: %w", err)
+		}
+		result = append(result, msg)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading active dialog: %w", err)
 	}
 
-	log.Printf("get messages ids, from %d, time left: %s", id, time.Until(deadline))
 	return result, nil
 }
 
 type BotStorage struct {
 	pool   *pgxpool.Pool
 	config *config.Config
+	// now задаёт границы сроков хранения, время записей ставит сама БД
+	now func() time.Time
+}
+
+// Option настраивает хранилище при создании
+type Option func(*options)
+
+type options struct {
+	now func() time.Time
 }
 
-func NewBotStorage(pool *pgxpool.Pool, config *config.Config) *BotStorage {
-	return &BotStorage{pool: pool, config: config}
+// WithClock задаёт часы, по которым хранилище считает сроки хранения. Нужна тестам
+func WithClock(now func() time.Time) Option {
+	return func(o *options) {
+		o.now = now
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{now: time.Now}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+func NewBotStorage(pool *pgxpool.Pool, config *config.Config, opts ...Option) *BotStorage {
+	return &BotStorage{pool: pool, config: config, now: newOptions(opts).now}
+}
+
+// Ping проверяет, что пул может выдать соединение и БД отвечает
+func (b *BotStorage) Ping(ctx context.Context) error {
+	if err := b.pool.Ping(ctx); err != nil {
+		return fmt.Errorf("db operation: ping: %w", err)
+	}
+	return nil
 }
 
 func (b *BotStorage) Save(ctx context.Context, message *models.Message) error {
 	saveCtx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
 	defer cancel()
 
-	exec, err := b.pool.Exec(
+	if err := saveMessage(ctx, b.pool, message); err != nil {
+		return err
+	}
+
+	deadline, _ := saveCtx.Deadline()
+	logger.FromContext(ctx).Debugw("saved message", "message_id", message.MessageID, "time_left", time.Until(deadline))
+	return nil
+}
+
+// querier - общее подмножество pgxpool.Pool и pgx.Tx
+type querier interface {
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func saveMessage(ctx context.Context, q querier, message *models.Message) error {
+	exec, err := q.Exec(
 		ctx,
 		`INSERT INTO updates_messages (chat_id, message_id, from_id, from_username, text, time_stamp, db_time_stamp) VALUES ($1, $2, $3, $4, $5, $6, current_timestamp)`,
 		message.ChatID,
@@ -91,11 +391,5 @@ func (b *BotStorage) Save(ctx context.Context, message *models.Message) error {
 		return fmt.Errorf("expected 1 row affected, got %d", exec.RowsAffected())
 	}
 
-	deadline, ok := saveCtx.Deadline()
-	if !ok {
-		log.Println("no deadline in context")
-		return nil
-	}
-	log.Printf("saved message %d, time left: %v", message.MessageID, time.Until(deadline))
 	return nil
 }
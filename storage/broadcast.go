@@ -0,0 +1,311 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/mytelegrambot/models"
+	"time"
+)
+
+// Статусы рассылок
+const (
+	BroadcastRunning  = "running"
+	BroadcastPaused   = "paused"
+	BroadcastFinished = "finished"
+)
+
+// Статусы доставок рассылки в отдельный чат
+const (
+	DeliveryPending = "pending"
+	DeliverySending = "sending"
+	DeliverySent    = "sent"
+	DeliveryFailed  = "failed"
+)
+
+var (
+	// ErrBroadcastNotFound возвращается, если рассылки с таким ID нет
+	ErrBroadcastNotFound = errors.New("broadcast not found")
+	// ErrBroadcastStatus возвращается, если рассылка не в том статусе, из которого возможен переход
+	ErrBroadcastStatus = errors.New("broadcast status does not allow this change")
+)
+
+const broadcastColumns = `b.id, b.text, b.segment, b.status, b.created_by, b.created_at, b.finished_at,
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id),
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id AND d.status = 'sent'),
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id AND d.status = 'failed')`
+
+func scanBroadcast(row pgx.Row) (models.Broadcast, error) {
+	var broadcast models.Broadcast
+	err := row.Scan(
+		&broadcast.ID,
+		&broadcast.Text,
+		&broadcast.Segment,
+		&broadcast.Status,
+		&broadcast.CreatedBy,
+		&broadcast.CreatedAt,
+		&broadcast.FinishedAt,
+		&broadcast.Total,
+		&broadcast.Sent,
+		&broadcast.Failed,
+	)
+	return broadcast, err
+}
+
+// CreateBroadcast создаёт рассылку и доставки во все чаты сегмента, кроме личных чатов
+// заблокированных пользователей. Рассылка без получателей сразу считается завершённой
+func (b *BotStorage) CreateBroadcast(ctx context.Context, broadcast models.Broadcast) (models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: create broadcast, begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var id int64
+	if err = tx.QueryRow(ctx,
+		"INSERT INTO broadcasts (text, segment, created_by) VALUES ($1, $2, $3) RETURNING id",
+		broadcast.Text, broadcast.Segment, broadcast.CreatedBy,
+	).Scan(&id); err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: create broadcast: %w", err)
+	}
+
+	tag, err := tx.Exec(ctx, `
+		INSERT INTO broadcast_deliveries (broadcast_id, chat_id)
+		SELECT $1, chat_id
+		FROM (SELECT chat_id, time_stamp FROM updates_messages
+		      UNION ALL
+		      SELECT chat_id, time_stamp FROM archive_messages) m
+		WHERE chat_id NOT IN (SELECT user_id FROM blocked_users)
+		  AND (coalesce(cardinality($2::BIGINT[]), 0) = 0 OR chat_id = ANY ($2))
+		GROUP BY chat_id
+		HAVING $3::TIMESTAMPTZ IS NULL OR max(time_stamp) >= $3
+		ORDER BY chat_id`,
+		id, broadcast.Segment.ChatIDs, broadcast.Segment.ActiveSince,
+	)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: create broadcast deliveries: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		if _, err = tx.Exec(ctx,
+			"UPDATE broadcasts SET status = $2, finished_at = current_timestamp WHERE id = $1", id, BroadcastFinished,
+		); err != nil {
+			return models.Broadcast{}, fmt.Errorf("db operation: finish empty broadcast: %w", err)
+		}
+	}
+
+	created, err := scanBroadcast(tx.QueryRow(ctx, "SELECT "+broadcastColumns+" FROM broadcasts b WHERE b.id = $1", id))
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: read created broadcast: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: create broadcast, commit: %w", err)
+	}
+
+	return created, nil
+}
+
+// GetBroadcast возвращает рассылку со счётчиками доставок
+func (b *BotStorage) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	broadcast, err := scanBroadcast(b.pool.QueryRow(ctx, "SELECT "+broadcastColumns+" FROM broadcasts b WHERE b.id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.Broadcast{}, ErrBroadcastNotFound
+	}
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("db operation: get broadcast: %w", err)
+	}
+
+	return broadcast, nil
+}
+
+// ListBroadcasts возвращает рассылки, начиная с последней
+func (b *BotStorage) ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx,
+		"SELECT "+broadcastColumns+" FROM broadcasts b ORDER BY b.id DESC LIMIT $1 OFFSET $2",
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Broadcast, 0)
+	for rows.Next() {
+		broadcast, err := scanBroadcast(rows)
+		if err != nil {
+			return nil, fmt.Errorf("db scanning broadcast: %w", err)
+		}
+		result = append(result, broadcast)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading broadcasts: %w", err)
+	}
+
+	return result, nil
+}
+
+// SetBroadcastStatus переводит рассылку из статуса from в to. Если рассылка в другом статусе,
+// возвращается ErrBroadcastStatus
+func (b *BotStorage) SetBroadcastStatus(ctx context.Context, id int64, from, to string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tag, err := b.pool.Exec(ctx, "UPDATE broadcasts SET status = $3 WHERE id = $1 AND status = $2", id, from, to)
+	if err != nil {
+		return fmt.Errorf("db operation: set broadcast status: %w", err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err = b.pool.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM broadcasts WHERE id = $1)", id).Scan(&exists); err != nil {
+		return fmt.Errorf("db operation: check broadcast: %w", err)
+	}
+	if !exists {
+		return ErrBroadcastNotFound
+	}
+	return ErrBroadcastStatus
+}
+
+// BroadcastDeliveries возвращает доставки рассылки по чатам в порядке создания
+func (b *BotStorage) BroadcastDeliveries(ctx context.Context, broadcastID int64, page models.Page) ([]models.BroadcastDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		SELECT id, broadcast_id, chat_id, status, attempts, coalesce(message_id, 0), coalesce(last_error, ''), sent_at
+		FROM broadcast_deliveries
+		WHERE broadcast_id = $1
+		ORDER BY id
+		LIMIT $2 OFFSET $3`,
+		broadcastID, page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db listing broadcast deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.BroadcastDelivery, 0)
+	for rows.Next() {
+		var delivery models.BroadcastDelivery
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.BroadcastID,
+			&delivery.ChatID,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.MessageID,
+			&delivery.LastError,
+			&delivery.SentAt,
+		); err != nil {
+			return nil, fmt.Errorf("db scanning broadcast delivery: %w", err)
+		}
+		result = append(result, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("db reading broadcast deliveries: %w", err)
+	}
+
+	return result, nil
+}
+
+// ClaimBroadcastDeliveries захватывает доставки запущенных рассылок: ожидающие отправки и те,
+// что остались в статусе sending дольше staleAfter. Приостановленные рассылки пропускаются
+func (b *BotStorage) ClaimBroadcastDeliveries(ctx context.Context, staleAfter time.Duration, limit int) ([]models.BroadcastDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		UPDATE broadcast_deliveries d
+		SET status = $1, attempts = d.attempts + 1, claimed_at = current_timestamp
+		FROM broadcasts b
+		WHERE b.id = d.broadcast_id AND d.id IN (
+			SELECT d.id FROM broadcast_deliveries d
+			JOIN broadcasts b ON b.id = d.broadcast_id
+			WHERE b.status = $2
+			  AND (d.status = $3 OR (d.status = $1 AND d.claimed_at < current_timestamp - make_interval(secs => $4)))
+			ORDER BY d.broadcast_id, d.id
+			LIMIT $5
+			FOR UPDATE OF d SKIP LOCKED)
+		RETURNING d.id, d.broadcast_id, d.chat_id, d.status, d.attempts, b.text`,
+		DeliverySending, BroadcastRunning, DeliveryPending, staleAfter.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db operation: claim broadcast deliveries: %w", err)
+	}
+
+	deliveries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.BroadcastDelivery, error) {
+		var delivery models.BroadcastDelivery
+		err := row.Scan(&delivery.ID, &delivery.BroadcastID, &delivery.ChatID, &delivery.Status, &delivery.Attempts, &delivery.Text)
+		return delivery, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db scanning claimed broadcast deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// MarkBroadcastDeliverySent записывает отправленное сообщение. Если это была последняя
+// незавершённая доставка, рассылка завершается
+func (b *BotStorage) MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error {
+	return b.finishDelivery(ctx, id,
+		"UPDATE broadcast_deliveries SET status = $2, message_id = $3, sent_at = current_timestamp, last_error = NULL WHERE id = $1 RETURNING broadcast_id",
+		id, DeliverySent, messageID,
+	)
+}
+
+// MarkBroadcastDeliveryFailed записывает ошибку отправки. При retry доставка вернётся в очередь
+func (b *BotStorage) MarkBroadcastDeliveryFailed(ctx context.Context, id int64, reason string, retry bool) error {
+	status := DeliveryFailed
+	if retry {
+		status = DeliveryPending
+	}
+
+	return b.finishDelivery(ctx, id,
+		"UPDATE broadcast_deliveries SET status = $2, last_error = $3 WHERE id = $1 RETURNING broadcast_id",
+		id, status, reason,
+	)
+}
+
+// finishDelivery обновляет доставку запросом query и завершает рассылку, если недоставленных не осталось
+func (b *BotStorage) finishDelivery(ctx context.Context, id int64, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("db operation: update broadcast delivery (%v), begin: %w", id, err)
+	}
+	defer tx.Rollback(ctx)
+
+	var broadcastID int64
+	if err = tx.QueryRow(ctx, query, args...).Scan(&broadcastID); err != nil {
+		return fmt.Errorf("db operation: update broadcast delivery (%v): %w", id, err)
+	}
+
+	if _, err = tx.Exec(ctx, `
+		UPDATE broadcasts SET status = $2, finished_at = current_timestamp
+		WHERE id = $1 AND status <> $2 AND NOT EXISTS (
+			SELECT 1 FROM broadcast_deliveries WHERE broadcast_id = $1 AND status IN ($3, $4))`,
+		broadcastID, BroadcastFinished, DeliveryPending, DeliverySending,
+	); err != nil {
+		return fmt.Errorf("db operation: finish broadcast (%v): %w", broadcastID, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("db operation: update broadcast delivery (%v), commit: %w", id, err)
+	}
+	return nil
+}
@@ -0,0 +1,325 @@
+package storage
+
+import (
+	"context"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"time"
+)
+
+// instrumentedStorage замеряет длительность операций хранилища для Prometheus
+// и оборачивает каждую операцию в спан OpenTelemetry
+type instrumentedStorage struct {
+	next    Storage
+	backend string
+}
+
+// Instrument оборачивает хранилище, записывая латентность каждой операции
+// в storage_query_duration_seconds с меткой backend и открывая спан storage.<операция>
+func Instrument(next Storage, backend string) Storage {
+	return &instrumentedStorage{next: next, backend: backend}
+}
+
+// start открывает спан операции, возвращённая функция закрывает его и пишет метрику
+func (s *instrumentedStorage) start(ctx context.Context, operation string) (context.Context, func(error)) {
+	start := time.Now()
+	ctx, span := tracing.Start(ctx, "storage", "storage."+operation, attribute.String("db.system", s.backend))
+
+	return ctx, func(err error) {
+		metrics.StorageQueryDuration.
+			WithLabelValues(s.backend, operation, metrics.Outcome(err)).
+			Observe(time.Since(start).Seconds())
+		tracing.End(span, err)
+	}
+}
+
+func (s *instrumentedStorage) Save(ctx context.Context, msg *models.Message) error {
+	ctx, done := s.start(ctx, "save")
+	err := s.next.Save(ctx, msg)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) GetMsgIDs(ctx context.Context, id int64) ([]int, error) {
+	ctx, done := s.start(ctx, "get_msg_ids")
+	result, err := s.next.GetMsgIDs(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ActiveDialog(ctx context.Context, chatID int64, limit int) ([]models.Message, error) {
+	ctx, done := s.start(ctx, "active_dialog")
+	result, err := s.next.ActiveDialog(ctx, chatID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) MoveToRecover(ctx context.Context, chatID int64) (bool, error) {
+	ctx, done := s.start(ctx, "move_to_recover")
+	result, err := s.next.MoveToRecover(ctx, chatID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ListArchiveSessions(ctx context.Context, chatID int64, limit int) ([]models.ArchiveSession, error) {
+	ctx, done := s.start(ctx, "list_archive_sessions")
+	result, err := s.next.ListArchiveSessions(ctx, chatID, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) RestoreArchiveSession(ctx context.Context, chatID int64, sessionID int64) (int, error) {
+	ctx, done := s.start(ctx, "restore_archive_session")
+	result, err := s.next.RestoreArchiveSession(ctx, chatID, sessionID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ApplyRetention(ctx context.Context, retention config.Retention) (int64, error) {
+	ctx, done := s.start(ctx, "apply_retention")
+	result, err := s.next.ApplyRetention(ctx, retention)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error) {
+	ctx, done := s.start(ctx, "forget_user")
+	result, err := s.next.ForgetUser(ctx, userID, requestedBy)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) EnqueueOutbox(ctx context.Context, chatID int64, text string) (int64, error) {
+	ctx, done := s.start(ctx, "enqueue_outbox")
+	result, err := s.next.EnqueueOutbox(ctx, chatID, text)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ClaimOutbox(ctx context.Context, staleAfter time.Duration, limit int) ([]models.OutboxMessage, error) {
+	ctx, done := s.start(ctx, "claim_outbox")
+	result, err := s.next.ClaimOutbox(ctx, staleAfter, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	ctx, done := s.start(ctx, "mark_outbox_failed")
+	err := s.next.MarkOutboxFailed(ctx, id, reason, retryAfter)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) WithinTx(ctx context.Context, fn func(tx Tx) error) error {
+	ctx, done := s.start(ctx, "within_tx")
+	err := s.next.WithinTx(ctx, fn)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error) {
+	ctx, done := s.start(ctx, "enqueue_update")
+	result, err := s.next.EnqueueUpdate(ctx, update)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ClaimUpdates(ctx context.Context, worker string, lease time.Duration, limit int) ([]models.QueuedUpdate, error) {
+	ctx, done := s.start(ctx, "claim_updates")
+	result, err := s.next.ClaimUpdates(ctx, worker, lease, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ExtendUpdateLease(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	ctx, done := s.start(ctx, "extend_update_lease")
+	err := s.next.ExtendUpdateLease(ctx, id, worker, lease)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) FinishUpdate(ctx context.Context, id int64, status string, reason string) error {
+	ctx, done := s.start(ctx, "finish_update")
+	err := s.next.FinishUpdate(ctx, id, status, reason)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) LastUpdateOffset(ctx context.Context) (int, error) {
+	ctx, done := s.start(ctx, "last_update_offset")
+	result, err := s.next.LastUpdateOffset(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SaveUpdateOffset(ctx context.Context, offset int) error {
+	ctx, done := s.start(ctx, "save_update_offset")
+	err := s.next.SaveUpdateOffset(ctx, offset)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) IsUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	ctx, done := s.start(ctx, "is_update_processed")
+	result, err := s.next.IsUpdateProcessed(ctx, updateID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) MarkUpdateProcessed(ctx context.Context, updateID int) error {
+	ctx, done := s.start(ctx, "mark_update_processed")
+	err := s.next.MarkUpdateProcessed(ctx, updateID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) Ping(ctx context.Context) error {
+	ctx, done := s.start(ctx, "ping")
+	err := s.next.Ping(ctx)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) PendingUpdates(ctx context.Context) (int, error) {
+	ctx, done := s.start(ctx, "pending_updates")
+	result, err := s.next.PendingUpdates(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) RuntimeSettings(ctx context.Context) ([]byte, error) {
+	ctx, done := s.start(ctx, "runtime_settings")
+	result, err := s.next.RuntimeSettings(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SaveRuntimeSettings(ctx context.Context, value []byte) error {
+	ctx, done := s.start(ctx, "save_runtime_settings")
+	err := s.next.SaveRuntimeSettings(ctx, value)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error) {
+	ctx, done := s.start(ctx, "list_chats")
+	result, err := s.next.ListChats(ctx, page)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error) {
+	ctx, done := s.start(ctx, "list_users")
+	result, err := s.next.ListUsers(ctx, page)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error) {
+	ctx, done := s.start(ctx, "chat_messages")
+	result, err := s.next.ChatMessages(ctx, chatID, page)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SetUserBlocked(ctx context.Context, userID int64, blocked bool, requestedBy string) error {
+	ctx, done := s.start(ctx, "set_user_blocked")
+	err := s.next.SetUserBlocked(ctx, userID, blocked, requestedBy)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) IsUserBlocked(ctx context.Context, userID int64) (bool, error) {
+	ctx, done := s.start(ctx, "is_user_blocked")
+	result, err := s.next.IsUserBlocked(ctx, userID)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	ctx, done := s.start(ctx, "create_api_key")
+	result, err := s.next.CreateAPIKey(ctx, key, hash)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) APIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, done := s.start(ctx, "api_key_by_hash")
+	result, err := s.next.APIKeyByHash(ctx, hash)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, done := s.start(ctx, "list_api_keys")
+	result, err := s.next.ListAPIKeys(ctx)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	ctx, done := s.start(ctx, "revoke_api_key")
+	err := s.next.RevokeAPIKey(ctx, id)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) CreateBroadcast(ctx context.Context, broadcast models.Broadcast) (models.Broadcast, error) {
+	ctx, done := s.start(ctx, "create_broadcast")
+	result, err := s.next.CreateBroadcast(ctx, broadcast)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	ctx, done := s.start(ctx, "get_broadcast")
+	result, err := s.next.GetBroadcast(ctx, id)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error) {
+	ctx, done := s.start(ctx, "list_broadcasts")
+	result, err := s.next.ListBroadcasts(ctx, page)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) SetBroadcastStatus(ctx context.Context, id int64, from, to string) error {
+	ctx, done := s.start(ctx, "set_broadcast_status")
+	err := s.next.SetBroadcastStatus(ctx, id, from, to)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) BroadcastDeliveries(ctx context.Context, broadcastID int64, page models.Page) ([]models.BroadcastDelivery, error) {
+	ctx, done := s.start(ctx, "broadcast_deliveries")
+	result, err := s.next.BroadcastDeliveries(ctx, broadcastID, page)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) ClaimBroadcastDeliveries(ctx context.Context, staleAfter time.Duration, limit int) ([]models.BroadcastDelivery, error) {
+	ctx, done := s.start(ctx, "claim_broadcast_deliveries")
+	result, err := s.next.ClaimBroadcastDeliveries(ctx, staleAfter, limit)
+	done(err)
+	return result, err
+}
+
+func (s *instrumentedStorage) MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error {
+	ctx, done := s.start(ctx, "mark_broadcast_delivery_sent")
+	err := s.next.MarkBroadcastDeliverySent(ctx, id, messageID)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) MarkBroadcastDeliveryFailed(ctx context.Context, id int64, reason string, retry bool) error {
+	ctx, done := s.start(ctx, "mark_broadcast_delivery_failed")
+	err := s.next.MarkBroadcastDeliveryFailed(ctx, id, reason, retry)
+	done(err)
+	return err
+}
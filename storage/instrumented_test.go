@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"github.com/mytelegrambot/metrics"
+	"github.com/mytelegrambot/models"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInstrument(t *testing.T) {
+	ctx := context.Background()
+	metrics.StorageQueryDuration.Reset()
+
+	s := Instrument(NewMemoryStorage(), "memory")
+
+	require.NoError(t, s.Save(ctx, &models.Message{ChatID: 1, MessageID: 1, Text: "привет"}))
+	require.NoError(t, s.Save(ctx, &models.Message{ChatID: 1, MessageID: 2, Text: "как дела?"}))
+	ids, err := s.GetMsgIDs(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int{1, 2}, ids)
+
+	_, err = s.RestoreArchiveSession(ctx, 1, 42)
+	require.ErrorIs(t, err, ErrSessionNotFound)
+
+	// по одной серии на save, get_msg_ids и неудачный restore_archive_session
+	require.Equal(t, 3, testutil.CollectAndCount(metrics.StorageQueryDuration))
+}
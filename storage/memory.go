@@ -0,0 +1,1081 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/models"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemoryStorage хранит сообщения в памяти процесса. Подходит для тестов и локального запуска,
+// данные теряются при перезапуске
+type MemoryStorage struct {
+	mu             sync.Mutex
+	now            func() time.Time
+	active         []storedMessage
+	archive        []storedMessage
+	sessions       map[int64]*models.ArchiveSession
+	nextSessionID  int64
+	outbox         map[int64]*memoryOutbox
+	nextOutboxID   int64
+	jobs           []*memoryJob
+	nextJobID      int64
+	offset         int
+	processed      map[int]time.Time
+	audit          []Audit
+	settings       []byte
+	blocked        map[int64]bool
+	apiKeys        []memoryAPIKey
+	broadcasts     []*models.Broadcast
+	deliveries     []*memoryDelivery
+	nextDeliveryID int64
+}
+
+type memoryDelivery struct {
+	models.BroadcastDelivery
+	claimedAt time.Time
+}
+
+type memoryAPIKey struct {
+	models.APIKey
+	hash string
+}
+
+type memoryJob struct {
+	models.QueuedUpdate
+	chatID     int64
+	fromID     int64
+	status     string
+	worker     string
+	leaseUntil time.Time
+	lastError  string
+	createdAt  time.Time
+}
+
+type memoryOutbox struct {
+	models.OutboxMessage
+	status    string
+	messageID int
+	lastError string
+	createdAt time.Time
+	claimedAt time.Time
+	// nextAttempt - раньше этого времени запись pending не захватывается
+	nextAttempt time.Time
+}
+
+type storedMessage struct {
+	models.Message
+	sessionID int64
+	dbTime    time.Time
+}
+
+func NewMemoryStorage(opts ...Option) *MemoryStorage {
+	return &MemoryStorage{
+		now:       newOptions(opts).now,
+		sessions:  make(map[int64]*models.ArchiveSession),
+		outbox:    make(map[int64]*memoryOutbox),
+		processed: make(map[int]time.Time),
+		blocked:   make(map[int64]bool),
+	}
+}
+
+func (m *MemoryStorage) Save(ctx context.Context, msg *models.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.save(msg)
+	return nil
+}
+
+func (m *MemoryStorage) save(msg *models.Message) {
+	m.active = append(m.active, storedMessage{Message: *msg, dbTime: m.now()})
+}
+
+func (m *MemoryStorage) GetMsgIDs(ctx context.Context, id int64) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]int, 0)
+	for _, msg := range m.active {
+		if msg.ChatID == id {
+			result = append(result, msg.MessageID)
+		}
+	}
+	return result, nil
+}
+
+// ActiveDialog возвращает последние limit сообщений активного диалога чата от старых к новым
+func (m *MemoryStorage) ActiveDialog(ctx context.Context, chatID int64, limit int) ([]models.Message, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.Message, 0)
+	for _, msg := range m.active {
+		if msg.ChatID == chatID {
+			result = append(result, msg.Message)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Timestamp.Equal(result[j].Timestamp) {
+			return result[i].Timestamp.Before(result[j].Timestamp)
+		}
+		return result[i].MessageID < result[j].MessageID
+	})
+	return result[max(0, len(result)-limit):], nil
+}
+
+func (m *MemoryStorage) MoveToRecover(ctx context.Context, chatID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.archiveActive(chatID), nil
+}
+
+func (m *MemoryStorage) archiveActive(chatID int64) bool {
+	var moved []storedMessage
+	m.active = filterMessages(m.active, func(msg storedMessage) bool {
+		if msg.ChatID == chatID {
+			moved = append(moved, msg)
+			return false
+		}
+		return true
+	})
+	if len(moved) == 0 {
+		return false
+	}
+
+	m.nextSessionID++
+	m.sessions[m.nextSessionID] = &models.ArchiveSession{
+		ID:         m.nextSessionID,
+		ChatID:     chatID,
+		ArchivedAt: m.now(),
+	}
+	for _, msg := range moved {
+		msg.sessionID = m.nextSessionID
+		m.archive = append(m.archive, msg)
+	}
+
+	return true
+}
+
+func (m *MemoryStorage) ListArchiveSessions(ctx context.Context, chatID int64, limit int) ([]models.ArchiveSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.ArchiveSession, 0)
+	for _, session := range m.sessions {
+		if session.ChatID != chatID {
+			continue
+		}
+
+		summary := *session
+		var first time.Time
+		for _, msg := range m.archive {
+			if msg.sessionID != session.ID {
+				continue
+			}
+			summary.MessagesCount++
+			if !strings.HasPrefix(msg.Text, "/") && (first.IsZero() || msg.Timestamp.Before(first)) {
+				first = msg.Timestamp
+				summary.FirstText = msg.Text
+			}
+		}
+		if summary.MessagesCount > 0 {
+			result = append(result, summary)
+		}
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ArchivedAt.Equal(result[j].ArchivedAt) {
+			return result[i].ID > result[j].ID
+		}
+		return result[i].ArchivedAt.After(result[j].ArchivedAt)
+	})
+	if len(result) > limit {
+		result = result[:limit]
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStorage) RestoreArchiveSession(ctx context.Context, chatID int64, sessionID int64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.ChatID != chatID {
+		return 0, ErrSessionNotFound
+	}
+
+	m.archiveActive(chatID)
+
+	restored := 0
+	m.archive = filterMessages(m.archive, func(msg storedMessage) bool {
+		if msg.sessionID == sessionID {
+			msg.sessionID = 0
+			m.active = append(m.active, msg)
+			restored++
+			return false
+		}
+		return true
+	})
+	delete(m.sessions, sessionID)
+	m.dropEmptySessions()
+
+	return restored, nil
+}
+
+func (m *MemoryStorage) ApplyRetention(ctx context.Context, retention config.Retention) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	details := make(map[string]any)
+	var total int64
+
+	apply := func(messages []storedMessage, policy config.RetentionPolicy) ([]storedMessage, int64) {
+		if policy.Days == 0 {
+			return messages, 0
+		}
+		deadline := m.now().AddDate(0, 0, -policy.Days)
+		var affected int64
+		result := filterMessages(messages, func(msg storedMessage) bool {
+			if !msg.dbTime.Before(deadline) {
+				return true
+			}
+			if policy.Mode == config.RetentionAnonymize {
+				if msg.ChatID != 0 || msg.FromID != 0 {
+					affected++
+				}
+				return true
+			}
+			affected++
+			return false
+		})
+		if policy.Mode == config.RetentionAnonymize {
+			for i := range result {
+				if result[i].dbTime.Before(deadline) {
+					result[i].ChatID, result[i].FromID, result[i].FromUsername, result[i].Text = 0, 0, "", ""
+				}
+			}
+		}
+		return result, affected
+	}
+
+	var affected int64
+	m.active, affected = apply(m.active, retention.UpdatesMessages)
+	if affected > 0 {
+		details[updatesMessagesTable.name] = affected
+		total += affected
+	}
+	m.archive, affected = apply(m.archive, retention.ArchiveMessages)
+	if affected > 0 {
+		details[archiveMessagesTable.name] = affected
+		total += affected
+	}
+
+	if retention.FinishedJobsDays > 0 {
+		deadline := m.now().AddDate(0, 0, -retention.FinishedJobsDays)
+		var outbox int64
+		for id, out := range m.outbox {
+			finished := out.status == OutboxSent || out.status == OutboxFailed
+			if finished && out.createdAt.Before(deadline) {
+				delete(m.outbox, id)
+				outbox++
+			}
+		}
+		if outbox > 0 {
+			details[outboxTable.name] = outbox
+			total += outbox
+		}
+
+		before := len(m.jobs)
+		m.jobs = filterJobs(m.jobs, func(job *memoryJob) bool {
+			finished := job.status == UpdateDone || job.status == UpdateFailed
+			return !finished || !job.createdAt.Before(deadline)
+		})
+		if jobs := int64(before - len(m.jobs)); jobs > 0 {
+			details[updateJobsTable.name] = jobs
+			total += jobs
+		}
+	}
+
+	if retention.ArchiveMessages.Days > 0 {
+		var sessions int64
+		if retention.ArchiveMessages.Mode == config.RetentionAnonymize {
+			sessions = m.anonymizeSessions()
+		} else {
+			sessions = m.dropEmptySessions()
+		}
+		if sessions > 0 {
+			details[archiveSessionsTable.name] = sessions
+			total += sessions
+		}
+	}
+
+	for updateID, processedAt := range m.processed {
+		if processedAt.Before(m.now().Add(-processedUpdatesTTL)) {
+			delete(m.processed, updateID)
+		}
+	}
+
+	if total > 0 {
+		m.audit = append(m.audit, Audit{
+			Action:       "retention",
+			RequestedBy:  "janitor",
+			RowsAffected: total,
+			Details:      details,
+		})
+	}
+
+	return total, nil
+}
+
+func (m *MemoryStorage) ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	details := make(map[string]any)
+	var total int64
+
+	belongs := func(msg storedMessage) bool {
+		return msg.ChatID == userID || msg.FromID == userID
+	}
+
+	before := len(m.active)
+	m.active = filterMessages(m.active, func(msg storedMessage) bool { return !belongs(msg) })
+	details[updatesMessagesTable.name] = int64(before - len(m.active))
+
+	before = len(m.archive)
+	m.archive = filterMessages(m.archive, func(msg storedMessage) bool { return !belongs(msg) })
+	details[archiveMessagesTable.name] = int64(before - len(m.archive))
+
+	var sessions int64
+	for id, session := range m.sessions {
+		if session.ChatID == userID {
+			delete(m.sessions, id)
+			sessions++
+		}
+	}
+	details[archiveSessionsTable.name] = sessions
+
+	var outbox int64
+	for id, out := range m.outbox {
+		if out.ChatID == userID {
+			delete(m.outbox, id)
+			outbox++
+		}
+	}
+	details[outboxTable.name] = outbox
+
+	before = len(m.jobs)
+	m.jobs = filterJobs(m.jobs, func(job *memoryJob) bool {
+		return job.chatID != userID && job.fromID != userID
+	})
+	details[updateJobsTable.name] = int64(before - len(m.jobs))
+
+	before = len(m.deliveries)
+	m.deliveries = slices.DeleteFunc(m.deliveries, func(delivery *memoryDelivery) bool { return delivery.ChatID == userID })
+	details[broadcastDeliveriesTable.name] = int64(before - len(m.deliveries))
+
+	for _, affected := range details {
+		total += affected.(int64)
+	}
+
+	m.audit = append(m.audit, Audit{
+		Action:       "forget_user",
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: total,
+		Details:      details,
+	})
+
+	return total, nil
+}
+
+func (m *MemoryStorage) EnqueueOutbox(ctx context.Context, chatID int64, text string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextOutboxID++
+	m.outbox[m.nextOutboxID] = &memoryOutbox{
+		OutboxMessage: models.OutboxMessage{ID: m.nextOutboxID, ChatID: chatID, Text: text, Attempts: 1},
+		status:        OutboxSending,
+		createdAt:     m.now(),
+		claimedAt:     m.now(),
+	}
+	return m.nextOutboxID, nil
+}
+
+func (m *MemoryStorage) ClaimOutbox(ctx context.Context, staleAfter time.Duration, limit int) ([]models.OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var claimable []*memoryOutbox
+	for _, out := range m.outbox {
+		stale := out.status == OutboxSending && out.claimedAt.Before(m.now().Add(-staleAfter))
+		due := out.status == OutboxPending && !out.nextAttempt.After(m.now())
+		if due || stale {
+			claimable = append(claimable, out)
+		}
+	}
+	sort.Slice(claimable, func(i, j int) bool { return claimable[i].ID < claimable[j].ID })
+	if len(claimable) > limit {
+		claimable = claimable[:limit]
+	}
+
+	result := make([]models.OutboxMessage, 0, len(claimable))
+	for _, out := range claimable {
+		out.status = OutboxSending
+		out.Attempts++
+		out.claimedAt = m.now()
+		result = append(result, out.OutboxMessage)
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out, ok := m.outbox[id]
+	if !ok {
+		return fmt.Errorf("outbox (%v) not found", id)
+	}
+	out.status, out.lastError, out.nextAttempt = OutboxFailed, reason, time.Time{}
+	if retryAfter > 0 {
+		out.status, out.nextAttempt = OutboxPending, m.now().Add(retryAfter)
+	}
+	return nil
+}
+
+// WithinTx выполняет fn под блокировкой и восстанавливает прежнее состояние, если fn вернула ошибку
+func (m *MemoryStorage) WithinTx(ctx context.Context, fn func(tx Tx) error) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	active := append([]storedMessage(nil), m.active...)
+	outbox := make(map[int64]memoryOutbox, len(m.outbox))
+	for id, out := range m.outbox {
+		outbox[id] = *out
+	}
+
+	if err := fn(&memoryTx{storage: m}); err != nil {
+		m.active = active
+		for id, out := range outbox {
+			*m.outbox[id] = out
+		}
+		return err
+	}
+	return nil
+}
+
+type memoryTx struct {
+	storage *MemoryStorage
+}
+
+func (t *memoryTx) Save(ctx context.Context, msg *models.Message) error {
+	t.storage.save(msg)
+	return nil
+}
+
+func (t *memoryTx) MarkOutboxSent(ctx context.Context, id int64, messageID int) error {
+	out, ok := t.storage.outbox[id]
+	if !ok {
+		return fmt.Errorf("outbox (%v) not found", id)
+	}
+	out.status, out.messageID, out.lastError = OutboxSent, messageID, ""
+	return nil
+}
+
+func (m *MemoryStorage) EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// как и UNIQUE (update_id) в БД: повторно присланный апдейт не ставится в очередь второй раз
+	for _, job := range m.jobs {
+		if job.Update.UpdateID == update.UpdateID {
+			return job.ID, nil
+		}
+	}
+
+	chatID, fromID := updateChat(update)
+	m.nextJobID++
+	job := &memoryJob{
+		QueuedUpdate: models.QueuedUpdate{ID: m.nextJobID, Update: update},
+		chatID:       chatID,
+		fromID:       fromID,
+		status:       UpdatePending,
+		createdAt:    m.now(),
+	}
+	m.jobs = append(m.jobs, job)
+
+	return job.ID, nil
+}
+
+func (m *MemoryStorage) ClaimUpdates(ctx context.Context, worker string, lease time.Duration, limit int) ([]models.QueuedUpdate, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	blocked := make(map[int64]bool)
+	result := make([]models.QueuedUpdate, 0)
+
+	// m.jobs упорядочен по ID, поэтому первая незавершённая задача чата блокирует остальные
+	for _, job := range m.jobs {
+		if len(result) >= limit {
+			break
+		}
+		unfinished := job.status == UpdatePending || job.status == UpdateInProgress
+		if !unfinished {
+			continue
+		}
+		if blocked[job.chatID] {
+			continue
+		}
+		blocked[job.chatID] = true
+
+		expired := job.status == UpdateInProgress && job.leaseUntil.Before(now)
+		if job.status == UpdatePending || expired {
+			job.status, job.worker, job.leaseUntil = UpdateInProgress, worker, now.Add(lease)
+			job.Attempts++
+			result = append(result, job.QueuedUpdate)
+		}
+	}
+
+	return result, nil
+}
+
+func (m *MemoryStorage) ExtendUpdateLease(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == id && job.worker == worker && job.status == UpdateInProgress {
+			job.leaseUntil = m.now().Add(lease)
+			return nil
+		}
+	}
+	return fmt.Errorf("update job (%v) is not leased by %v", id, worker)
+}
+
+func (m *MemoryStorage) FinishUpdate(ctx context.Context, id int64, status string, reason string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, job := range m.jobs {
+		if job.ID == id {
+			job.status, job.lastError, job.leaseUntil = status, reason, time.Time{}
+			return nil
+		}
+	}
+	return fmt.Errorf("update job (%v) not found", id)
+}
+
+func (m *MemoryStorage) PendingUpdates(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var depth int
+	for _, job := range m.jobs {
+		if job.status == UpdatePending || job.status == UpdateInProgress {
+			depth++
+		}
+	}
+	return depth, nil
+}
+
+func (m *MemoryStorage) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (m *MemoryStorage) LastUpdateOffset(ctx context.Context) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.offset, nil
+}
+
+func (m *MemoryStorage) SaveUpdateOffset(ctx context.Context, offset int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.offset = max(m.offset, offset)
+	return nil
+}
+
+func (m *MemoryStorage) IsUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.processed[updateID]
+	return ok, nil
+}
+
+func (m *MemoryStorage) MarkUpdateProcessed(ctx context.Context, updateID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.processed[updateID]; !ok {
+		m.processed[updateID] = m.now()
+	}
+	return nil
+}
+
+func filterJobs(jobs []*memoryJob, keep func(job *memoryJob) bool) []*memoryJob {
+	result := jobs[:0:0]
+	for _, job := range jobs {
+		if keep(job) {
+			result = append(result, job)
+		}
+	}
+	return result
+}
+
+func (m *MemoryStorage) dropEmptySessions() int64 {
+	used := make(map[int64]bool)
+	for _, msg := range m.archive {
+		used[msg.sessionID] = true
+	}
+	var dropped int64
+	for id := range m.sessions {
+		if !used[id] {
+			delete(m.sessions, id)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+func (m *MemoryStorage) anonymizeSessions() int64 {
+	identified := make(map[int64]bool)
+	for _, msg := range m.archive {
+		if msg.ChatID != 0 {
+			identified[msg.sessionID] = true
+		}
+	}
+	var anonymized int64
+	for id, session := range m.sessions {
+		if !identified[id] && session.ChatID != 0 {
+			session.ChatID = 0
+			anonymized++
+		}
+	}
+	return anonymized
+}
+
+func filterMessages(messages []storedMessage, keep func(msg storedMessage) bool) []storedMessage {
+	result := messages[:0:0]
+	for _, msg := range messages {
+		if keep(msg) {
+			result = append(result, msg)
+		}
+	}
+	return result
+}
+
+func (m *MemoryStorage) RuntimeSettings(ctx context.Context) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return slices.Clone(m.settings), nil
+}
+
+func (m *MemoryStorage) SaveRuntimeSettings(ctx context.Context, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.settings = slices.Clone(value)
+	return nil
+}
+
+// paginate вырезает из отсортированного списка страницу page
+func paginate[T any](items []T, page models.Page) []T {
+	start := min(page.Offset, len(items))
+	end := min(start+page.Limit, len(items))
+	return items[start:end]
+}
+
+// all возвращает активные и архивные сообщения вместе, помечая архивные
+func (m *MemoryStorage) all() []models.ChatMessage {
+	result := make([]models.ChatMessage, 0, len(m.active)+len(m.archive))
+	for _, msg := range m.active {
+		result = append(result, models.ChatMessage{Message: msg.Message})
+	}
+	for _, msg := range m.archive {
+		result = append(result, models.ChatMessage{Message: msg.Message, Archived: true})
+	}
+	return result
+}
+
+func (m *MemoryStorage) ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	chats := make(map[int64]*models.ChatSummary)
+	for _, msg := range m.all() {
+		chat, ok := chats[msg.ChatID]
+		if !ok {
+			chat = &models.ChatSummary{ChatID: msg.ChatID}
+			chats[msg.ChatID] = chat
+		}
+		if msg.Archived {
+			chat.ArchivedMessages++
+		} else {
+			chat.ActiveMessages++
+		}
+		if msg.Timestamp.After(chat.LastMessageAt) {
+			chat.LastMessageAt = msg.Timestamp
+		}
+	}
+
+	result := make([]models.ChatSummary, 0, len(chats))
+	for _, chat := range chats {
+		result = append(result, *chat)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastMessageAt.Equal(result[j].LastMessageAt) {
+			return result[i].LastMessageAt.After(result[j].LastMessageAt)
+		}
+		return result[i].ChatID < result[j].ChatID
+	})
+
+	return paginate(result, page), nil
+}
+
+func (m *MemoryStorage) ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	users := make(map[int64]*models.UserSummary)
+	for _, msg := range m.all() {
+		user, ok := users[msg.FromID]
+		if !ok {
+			user = &models.UserSummary{UserID: msg.FromID, Blocked: m.blocked[msg.FromID]}
+			users[msg.FromID] = user
+		}
+		user.MessagesCount++
+		if !msg.Timestamp.Before(user.LastSeenAt) {
+			user.LastSeenAt = msg.Timestamp
+			user.Username = msg.FromUsername
+		}
+	}
+
+	result := make([]models.UserSummary, 0, len(users))
+	for _, user := range users {
+		result = append(result, *user)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].LastSeenAt.Equal(result[j].LastSeenAt) {
+			return result[i].LastSeenAt.After(result[j].LastSeenAt)
+		}
+		return result[i].UserID < result[j].UserID
+	})
+
+	return paginate(result, page), nil
+}
+
+func (m *MemoryStorage) ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.ChatMessage, 0)
+	for _, msg := range m.all() {
+		if msg.ChatID == chatID {
+			result = append(result, msg)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		if !result[i].Timestamp.Equal(result[j].Timestamp) {
+			return result[i].Timestamp.After(result[j].Timestamp)
+		}
+		return result[i].MessageID > result[j].MessageID
+	})
+
+	return paginate(result, page), nil
+}
+
+func (m *MemoryStorage) SetUserBlocked(ctx context.Context, userID int64, blocked bool, requestedBy string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	action := "block_user"
+	if !blocked {
+		action = "unblock_user"
+	}
+	var affected int64
+	if m.blocked[userID] != blocked {
+		affected = 1
+	}
+	if blocked {
+		m.blocked[userID] = true
+	} else {
+		delete(m.blocked, userID)
+	}
+
+	m.audit = append(m.audit, Audit{
+		Action:       action,
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: affected,
+		Details:      map[string]any{"blocked_users": affected},
+	})
+	return nil
+}
+
+func (m *MemoryStorage) IsUserBlocked(ctx context.Context, userID int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.blocked[userID], nil
+}
+
+func (m *MemoryStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, existing := range m.apiKeys {
+		if existing.hash == hash {
+			return models.APIKey{}, fmt.Errorf("memory create api key: duplicate hash")
+		}
+	}
+	key.ID = int64(len(m.apiKeys) + 1)
+	key.CreatedAt = m.now()
+	key.Scopes = slices.Clone(key.Scopes)
+	m.apiKeys = append(m.apiKeys, memoryAPIKey{APIKey: key, hash: hash})
+	return key, nil
+}
+
+func (m *MemoryStorage) APIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range m.apiKeys {
+		if key.hash == hash && key.RevokedAt == nil {
+			return key.APIKey, nil
+		}
+	}
+	return models.APIKey{}, ErrAPIKeyNotFound
+}
+
+func (m *MemoryStorage) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.APIKey, 0, len(m.apiKeys))
+	for _, key := range m.apiKeys {
+		result = append(result, key.APIKey)
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.apiKeys {
+		if m.apiKeys[i].ID == id && m.apiKeys[i].RevokedAt == nil {
+			now := m.now()
+			m.apiKeys[i].RevokedAt = &now
+			return nil
+		}
+	}
+	return ErrAPIKeyNotFound
+}
+
+func (m *MemoryStorage) CreateBroadcast(ctx context.Context, broadcast models.Broadcast) (models.Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	broadcast.ID = int64(len(m.broadcasts) + 1)
+	broadcast.Status = BroadcastRunning
+	broadcast.CreatedAt = m.now()
+	broadcast.Segment.ChatIDs = slices.Clone(broadcast.Segment.ChatIDs)
+	m.broadcasts = append(m.broadcasts, &broadcast)
+
+	lastMessage := make(map[int64]time.Time)
+	for _, msg := range m.all() {
+		if last, ok := lastMessage[msg.ChatID]; !ok || msg.Timestamp.After(last) {
+			lastMessage[msg.ChatID] = msg.Timestamp
+		}
+	}
+	chats := make([]int64, 0, len(lastMessage))
+	for chatID, last := range lastMessage {
+		if broadcastTarget(broadcast.Segment, chatID, last) && !m.blocked[chatID] {
+			chats = append(chats, chatID)
+		}
+	}
+	slices.Sort(chats)
+
+	for _, chatID := range chats {
+		m.nextDeliveryID++
+		m.deliveries = append(m.deliveries, &memoryDelivery{BroadcastDelivery: models.BroadcastDelivery{
+			ID:          m.nextDeliveryID,
+			BroadcastID: broadcast.ID,
+			ChatID:      chatID,
+			Status:      DeliveryPending,
+		}})
+	}
+	m.finishBroadcast(broadcast.ID)
+
+	return m.broadcast(broadcast.ID), nil
+}
+
+// broadcastTarget проверяет, входит ли чат с последним сообщением в last в сегмент
+func broadcastTarget(segment models.BroadcastSegment, chatID int64, last time.Time) bool {
+	if len(segment.ChatIDs) > 0 && !slices.Contains(segment.ChatIDs, chatID) {
+		return false
+	}
+	return segment.ActiveSince == nil || !last.Before(*segment.ActiveSince)
+}
+
+// broadcast возвращает копию рассылки со счётчиками доставок
+func (m *MemoryStorage) broadcast(id int64) models.Broadcast {
+	result := *m.broadcasts[id-1]
+	for _, delivery := range m.deliveries {
+		if delivery.BroadcastID != id {
+			continue
+		}
+		result.Total++
+		switch delivery.Status {
+		case DeliverySent:
+			result.Sent++
+		case DeliveryFailed:
+			result.Failed++
+		}
+	}
+	return result
+}
+
+// finishBroadcast завершает рассылку, если у неё не осталось недоставленных сообщений
+func (m *MemoryStorage) finishBroadcast(id int64) {
+	broadcast := m.broadcasts[id-1]
+	if broadcast.Status == BroadcastFinished {
+		return
+	}
+	for _, delivery := range m.deliveries {
+		if delivery.BroadcastID == id && (delivery.Status == DeliveryPending || delivery.Status == DeliverySending) {
+			return
+		}
+	}
+	now := m.now()
+	broadcast.Status, broadcast.FinishedAt = BroadcastFinished, &now
+}
+
+func (m *MemoryStorage) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.broadcasts)) {
+		return models.Broadcast{}, ErrBroadcastNotFound
+	}
+	return m.broadcast(id), nil
+}
+
+func (m *MemoryStorage) ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.Broadcast, 0, len(m.broadcasts))
+	for id := int64(len(m.broadcasts)); id > 0; id-- {
+		result = append(result, m.broadcast(id))
+	}
+	return paginate(result, page), nil
+}
+
+func (m *MemoryStorage) SetBroadcastStatus(ctx context.Context, id int64, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if id < 1 || id > int64(len(m.broadcasts)) {
+		return ErrBroadcastNotFound
+	}
+	broadcast := m.broadcasts[id-1]
+	if broadcast.Status != from {
+		return ErrBroadcastStatus
+	}
+	broadcast.Status = to
+	return nil
+}
+
+func (m *MemoryStorage) BroadcastDeliveries(ctx context.Context, broadcastID int64, page models.Page) ([]models.BroadcastDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.BroadcastDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if delivery.BroadcastID == broadcastID {
+			result = append(result, delivery.BroadcastDelivery)
+		}
+	}
+	return paginate(result, page), nil
+}
+
+func (m *MemoryStorage) ClaimBroadcastDeliveries(ctx context.Context, staleAfter time.Duration, limit int) ([]models.BroadcastDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make([]models.BroadcastDelivery, 0)
+	for _, delivery := range m.deliveries {
+		if len(result) == limit {
+			break
+		}
+		broadcast := m.broadcasts[delivery.BroadcastID-1]
+		stale := delivery.Status == DeliverySending && delivery.claimedAt.Before(m.now().Add(-staleAfter))
+		if broadcast.Status != BroadcastRunning || (delivery.Status != DeliveryPending && !stale) {
+			continue
+		}
+
+		delivery.Status = DeliverySending
+		delivery.Attempts++
+		delivery.claimedAt = m.now()
+		claimed := delivery.BroadcastDelivery
+		claimed.Text = broadcast.Text
+		result = append(result, claimed)
+	}
+	return result, nil
+}
+
+func (m *MemoryStorage) MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery, err := m.delivery(id)
+	if err != nil {
+		return err
+	}
+	now := m.now()
+	delivery.Status, delivery.MessageID, delivery.SentAt, delivery.LastError = DeliverySent, messageID, &now, ""
+	m.finishBroadcast(delivery.BroadcastID)
+	return nil
+}
+
+func (m *MemoryStorage) MarkBroadcastDeliveryFailed(ctx context.Context, id int64, reason string, retry bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delivery, err := m.delivery(id)
+	if err != nil {
+		return err
+	}
+	delivery.Status, delivery.LastError = DeliveryFailed, reason
+	if retry {
+		delivery.Status = DeliveryPending
+	}
+	m.finishBroadcast(delivery.BroadcastID)
+	return nil
+}
+
+func (m *MemoryStorage) delivery(id int64) (*memoryDelivery, error) {
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			return delivery, nil
+		}
+	}
+	return nil, fmt.Errorf("memory broadcast delivery (%v) not found", id)
+}
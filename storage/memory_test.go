@@ -0,0 +1,49 @@
+package storage
+
+import (
+	"context"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/models"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage_ApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	s := NewMemoryStorage()
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Save(ctx, &models.Message{ChatID: 1, MessageID: 1, FromID: 1, FromUsername: "old", Text: "старое"}))
+	_, err := s.MoveToRecover(ctx, 1)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, &models.Message{ChatID: 1, MessageID: 2, FromID: 1, Text: "старое активное"}))
+
+	now = now.AddDate(0, 0, 10)
+	require.NoError(t, s.Save(ctx, &models.Message{ChatID: 1, MessageID: 3, FromID: 1, Text: "свежее"}))
+
+	affected, err := s.ApplyRetention(ctx, config.Retention{
+		UpdatesMessages: config.RetentionPolicy{Days: 7, Mode: config.RetentionDelete},
+		ArchiveMessages: config.RetentionPolicy{Days: 7, Mode: config.RetentionAnonymize},
+	})
+	require.NoError(t, err)
+	// старое активное, старое архивное сообщение и его сессия
+	require.Equal(t, int64(3), affected)
+
+	ids, err := s.GetMsgIDs(ctx, 1)
+	require.NoError(t, err)
+	require.Equal(t, []int{3}, ids)
+
+	require.Len(t, s.archive, 1)
+	require.Zero(t, s.archive[0].ChatID)
+	require.Zero(t, s.archive[0].FromID)
+	require.Empty(t, s.archive[0].FromUsername)
+	require.Empty(t, s.archive[0].Text)
+
+	sessions, err := s.ListArchiveSessions(ctx, 1, 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+	require.Len(t, s.audit, 1)
+}
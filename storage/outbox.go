@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/mytelegrambot/models"
+	"time"
+)
+
+// Статусы записей outbox
+const (
+	OutboxPending = "pending"
+	OutboxSending = "sending"
+	OutboxSent    = "sent"
+	OutboxFailed  = "failed"
+)
+
+// EnqueueOutbox создаёт запись outbox, сразу захваченную вызывающим (статус sending)
+func (b *BotStorage) EnqueueOutbox(ctx context.Context, chatID int64, text string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var id int64
+	err := b.pool.QueryRow(ctx,
+		"INSERT INTO outbox (chat_id, text, status, attempts, claimed_at) VALUES ($1, $2, $3, 1, current_timestamp) RETURNING id",
+		chatID, text, OutboxSending,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: enqueue outbox: %w", err)
+	}
+
+	return id, nil
+}
+
+// ClaimOutbox захватывает неотправленные записи: ожидающие повтора, время которого наступило, и те,
+// что остались в статусе sending дольше staleAfter (процесс упал между отправкой и записью результата)
+func (b *BotStorage) ClaimOutbox(ctx context.Context, staleAfter time.Duration, limit int) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		UPDATE outbox SET status = $1, attempts = attempts + 1, claimed_at = current_timestamp
+		WHERE id IN (
+			SELECT id FROM outbox
+			WHERE (status = $2 AND (next_attempt_at IS NULL OR next_attempt_at <= current_timestamp))
+			   OR (status = $1 AND claimed_at < current_timestamp - make_interval(secs => $3))
+			ORDER BY created_at
+			LIMIT $4
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, chat_id, text, attempts`,
+		OutboxSending, OutboxPending, staleAfter.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db operation: claim outbox: %w", err)
+	}
+
+	messages, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.OutboxMessage, error) {
+		var msg models.OutboxMessage
+		err := row.Scan(&msg.ID, &msg.ChatID, &msg.Text, &msg.Attempts)
+		return msg, err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db scanning claimed outbox: %w", err)
+	}
+
+	return messages, nil
+}
+
+// MarkOutboxFailed записывает ошибку отправки. При retryAfter > 0 запись вернётся в очередь
+// не раньше чем через retryAfter, при 0 отправка окончательно провалена
+func (b *BotStorage) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := b.pool.Exec(ctx, `
+		UPDATE outbox SET status = CASE WHEN $4::float8 > 0 THEN $2 ELSE $3 END, last_error = $5,
+		       next_attempt_at = CASE WHEN $4::float8 > 0 THEN current_timestamp + make_interval(secs => $4::float8) END
+		WHERE id = $1`,
+		id, OutboxPending, OutboxFailed, retryAfter.Seconds(), reason,
+	); err != nil {
+		return fmt.Errorf("db operation: mark outbox (%v) failed: %w", id, err)
+	}
+
+	return nil
+}
+
+// WithinTx выполняет fn в одной транзакции. Если fn вернула ошибку, изменения откатываются
+func (b *BotStorage) WithinTx(ctx context.Context, fn func(tx Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("db operation: begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = fn(&pgTx{tx: tx}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("db operation: commit: %w", err)
+	}
+
+	return nil
+}
+
+type pgTx struct {
+	tx pgx.Tx
+}
+
+func (t *pgTx) Save(ctx context.Context, msg *models.Message) error {
+	return saveMessage(ctx, t.tx, msg)
+}
+
+func (t *pgTx) MarkOutboxSent(ctx context.Context, id int64, messageID int) error {
+	if _, err := t.tx.Exec(ctx,
+		"UPDATE outbox SET status = $2, message_id = $3, sent_at = current_timestamp, last_error = NULL WHERE id = $1",
+		id, OutboxSent, messageID,
+	); err != nil {
+		return fmt.Errorf("db operation: mark outbox (%v) sent: %w", id, err)
+	}
+
+	return nil
+}
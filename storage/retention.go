@@ -0,0 +1,280 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/logger"
+	"time"
+)
+
+// userDataTable - таблица с данными пользователей. Новые таблицы с сообщениями или
+// идентификаторами пользователей нужно добавлять в userDataTables, иначе их не затронут
+// ни очистка по сроку хранения, ни /forgetme
+type userDataTable struct {
+	name       string
+	chatColumn string
+	userColumn string
+	// nameColumn и textColumn пустые, если в таблице нет таких данных
+	nameColumn string
+	textColumn string
+	timeColumn string
+}
+
+var (
+	updatesMessagesTable = userDataTable{
+		name:       "updates_messages",
+		chatColumn: "chat_id",
+		userColumn: "from_id",
+		nameColumn: "from_username",
+		textColumn: "text",
+		timeColumn: "db_time_stamp",
+	}
+	archiveMessagesTable = userDataTable{
+		name:       "archive_messages",
+		chatColumn: "chat_id",
+		userColumn: "from_id",
+		nameColumn: "from_username",
+		textColumn: "text",
+		timeColumn: "db_time_stamp",
+	}
+	archiveSessionsTable = userDataTable{
+		name:       "archive_sessions",
+		chatColumn: "chat_id",
+		timeColumn: "archived_at",
+	}
+
+	outboxTable = userDataTable{
+		name:       "outbox",
+		chatColumn: "chat_id",
+		textColumn: "text",
+		timeColumn: "created_at",
+	}
+
+	updateJobsTable = userDataTable{
+		name:       "update_jobs",
+		chatColumn: "chat_id",
+		userColumn: "from_id",
+		timeColumn: "created_at",
+	}
+
+	broadcastDeliveriesTable = userDataTable{
+		name:       "broadcast_deliveries",
+		chatColumn: "chat_id",
+		timeColumn: "sent_at",
+	}
+
+	userDataTables = []userDataTable{
+		updatesMessagesTable,
+		archiveMessagesTable,
+		archiveSessionsTable,
+		outboxTable,
+		updateJobsTable,
+		broadcastDeliveriesTable,
+	}
+)
+
+// Audit - запись журнала data_audit
+type Audit struct {
+	Action       string
+	UserID       int64
+	RequestedBy  string
+	RowsAffected int64
+	Details      map[string]any
+}
+
+// ApplyRetention удаляет или обезличивает сообщения старше сроков из config.Retention
+func (b *BotStorage) ApplyRetention(ctx context.Context, retention config.Retention) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: retention, begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	details := make(map[string]any)
+	var total int64
+	now := b.now()
+
+	policies := []struct {
+		table  userDataTable
+		policy config.RetentionPolicy
+	}{
+		{updatesMessagesTable, retention.UpdatesMessages},
+		{archiveMessagesTable, retention.ArchiveMessages},
+	}
+	for _, p := range policies {
+		if p.policy.Days == 0 {
+			continue
+		}
+		affected, err := applyPolicy(ctx, tx, p.table, p.policy, now.AddDate(0, 0, -p.policy.Days))
+		if err != nil {
+			return 0, err
+		}
+		details[p.table.name] = affected
+		total += affected
+	}
+
+	if retention.FinishedJobsDays > 0 {
+		// незавершённые записи очередей не трогаем: их ещё отправят или обработают
+		deadline := now.AddDate(0, 0, -retention.FinishedJobsDays)
+		tag, err := tx.Exec(ctx,
+			"DELETE FROM outbox WHERE status IN ($1, $2) AND created_at < $3",
+			OutboxSent, OutboxFailed, deadline,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("db operation: retention, outbox cleanup: %w", err)
+		}
+		details[outboxTable.name] = tag.RowsAffected()
+		total += tag.RowsAffected()
+
+		tag, err = tx.Exec(ctx,
+			"DELETE FROM update_jobs WHERE status IN ($1, $2) AND created_at < $3",
+			UpdateDone, UpdateFailed, deadline,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("db operation: retention, update jobs cleanup: %w", err)
+		}
+		details[updateJobsTable.name] = tag.RowsAffected()
+		total += tag.RowsAffected()
+	}
+
+	if retention.ArchiveMessages.Days > 0 {
+		// сессии без сообщений удаляем, у остальных после обезличивания убираем chat_id
+		query := "DELETE FROM archive_sessions s WHERE NOT EXISTS (SELECT 1 FROM archive_messages m WHERE m.session_id = s.id)"
+		if retention.ArchiveMessages.Mode == config.RetentionAnonymize {
+			query = "UPDATE archive_sessions s SET chat_id = 0 WHERE chat_id <> 0 AND NOT EXISTS " +
+				"(SELECT 1 FROM archive_messages m WHERE m.session_id = s.id AND m.chat_id <> 0)"
+		}
+		tag, err := tx.Exec(ctx, query)
+		if err != nil {
+			return 0, fmt.Errorf("db operation: retention, empty sessions cleanup: %w", err)
+		}
+		details[archiveSessionsTable.name] = tag.RowsAffected()
+		total += tag.RowsAffected()
+	}
+
+	// отметки об обработанных апдейтах не относятся к данным пользователей и чистятся всегда
+	if _, err = tx.Exec(ctx,
+		"DELETE FROM processed_updates WHERE processed_at < $1", now.Add(-processedUpdatesTTL),
+	); err != nil {
+		return 0, fmt.Errorf("db operation: retention, processed updates cleanup: %w", err)
+	}
+
+	if total > 0 {
+		if err = writeAudit(ctx, tx, Audit{
+			Action:       "retention",
+			RequestedBy:  "janitor",
+			RowsAffected: total,
+			Details:      details,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("db operation: retention, commit: %w", err)
+	}
+
+	logger.FromContext(ctx).Debugw("retention applied", "rows_affected", total, "details", details)
+	return total, nil
+}
+
+// applyPolicy удаляет или обезличивает записи table старше deadline. Обезличивание стирает
+// и текст: по нему можно узнать автора, а для статистики достаточно ID сообщения и времени
+func applyPolicy(ctx context.Context, tx pgx.Tx, table userDataTable, policy config.RetentionPolicy, deadline time.Time) (int64, error) {
+	var query string
+	switch policy.Mode {
+	case config.RetentionAnonymize:
+		query = anonymizeQuery(table, "$1")
+	default:
+		query = fmt.Sprintf("DELETE FROM %s WHERE %s < $1", table.name, table.timeColumn)
+	}
+
+	tag, err := tx.Exec(ctx, query, deadline)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: retention (%v) for %v: %w", policy.Mode, table.name, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// anonymizeQuery стирает чат, автора, имя и текст сообщений table старше deadline
+func anonymizeQuery(table userDataTable, deadline string) string {
+	return fmt.Sprintf(
+		"UPDATE %[1]s SET %[2]s = 0, %[3]s = 0, %[4]s = '', %[5]s = '' WHERE %[6]s < %[7]s AND (%[2]s <> 0 OR %[3]s <> 0)",
+		table.name, table.chatColumn, table.userColumn, table.nameColumn, table.textColumn, table.timeColumn, deadline,
+	)
+}
+
+// ForgetUser удаляет все данные пользователя Telegram: его сообщения и личный чат с ботом
+// (в личном чате chat_id совпадает с ID пользователя) во всех таблицах userDataTables
+func (b *BotStorage) ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := b.pool.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: forget user, begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	details := make(map[string]any)
+	var total int64
+
+	for _, table := range userDataTables {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = $1", table.name, table.chatColumn)
+		if table.userColumn != "" {
+			query += fmt.Sprintf(" OR %s = $1", table.userColumn)
+		}
+
+		tag, err := tx.Exec(ctx, query, userID)
+		if err != nil {
+			return 0, fmt.Errorf("db operation: forget user in %v: %w", table.name, err)
+		}
+		details[table.name] = tag.RowsAffected()
+		total += tag.RowsAffected()
+	}
+
+	if err = writeAudit(ctx, tx, Audit{
+		Action:       "forget_user",
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: total,
+		Details:      details,
+	}); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("db operation: forget user, commit: %w", err)
+	}
+
+	return total, nil
+}
+
+func writeAudit(ctx context.Context, tx pgx.Tx, audit Audit) error {
+	details, err := json.Marshal(audit.Details)
+	if err != nil {
+		return fmt.Errorf("marshal audit details: %w", err)
+	}
+
+	var userID *int64
+	if audit.UserID != 0 {
+		userID = &audit.UserID
+	}
+
+	_, err = tx.Exec(ctx,
+		"INSERT INTO data_audit (action, user_id, requested_by, rows_affected, details) VALUES ($1, $2, $3, $4, $5)",
+		audit.Action, userID, audit.RequestedBy, audit.RowsAffected, details,
+	)
+	if err != nil {
+		return fmt.Errorf("db operation: write audit: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+)
+
+// RuntimeSettings возвращает сохранённые настройки времени выполнения в JSON или nil, если их ещё нет
+func (b *BotStorage) RuntimeSettings(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var value []byte
+	err := b.pool.QueryRow(ctx, "SELECT value FROM runtime_settings WHERE id = 1").Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("db operation: get runtime settings: %w", err)
+	}
+
+	return value, nil
+}
+
+// SaveRuntimeSettings заменяет настройки времени выполнения
+func (b *BotStorage) SaveRuntimeSettings(ctx context.Context, value []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO runtime_settings (id, value) VALUES (1, $1)
+		ON CONFLICT (id) DO UPDATE SET value = excluded.value, updated_at = current_timestamp`,
+		value,
+	); err != nil {
+		return fmt.Errorf("db operation: save runtime settings: %w", err)
+	}
+
+	return nil
+}
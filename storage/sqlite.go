@@ -0,0 +1,1595 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mattn/go-sqlite3"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/models"
+	"strings"
+	"time"
+)
+
+// sqliteSchema повторяет схему из database/migrations для однонодовой установки
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS updates_messages
+(
+    chat_id       INTEGER  NOT NULL,
+    message_id    INTEGER  NOT NULL,
+    from_id       INTEGER  NOT NULL,
+    from_username TEXT     NOT NULL DEFAULT '',
+    text          TEXT     NOT NULL DEFAULT '',
+    time_stamp    DATETIME NOT NULL,
+    db_time_stamp DATETIME NOT NULL
+);
+CREATE INDEX IF NOT EXISTS updates_messages_chat_id_idx ON updates_messages (chat_id);
+
+CREATE TABLE IF NOT EXISTS archive_sessions
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id     INTEGER  NOT NULL,
+    archived_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS archive_messages
+(
+    chat_id       INTEGER  NOT NULL,
+    message_id    INTEGER  NOT NULL,
+    from_id       INTEGER  NOT NULL,
+    from_username TEXT     NOT NULL DEFAULT '',
+    text          TEXT     NOT NULL DEFAULT '',
+    time_stamp    DATETIME NOT NULL,
+    db_time_stamp DATETIME NOT NULL,
+    session_id    INTEGER REFERENCES archive_sessions (id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS archive_messages_session_id_idx ON archive_messages (session_id);
+
+CREATE TABLE IF NOT EXISTS outbox
+(
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id         INTEGER  NOT NULL,
+    text            TEXT     NOT NULL,
+    status          TEXT     NOT NULL DEFAULT 'pending',
+    attempts        INTEGER  NOT NULL DEFAULT 0,
+    message_id      INTEGER,
+    last_error      TEXT,
+    created_at      DATETIME NOT NULL,
+    claimed_at      DATETIME,
+    sent_at         DATETIME,
+    next_attempt_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS update_jobs
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    update_id   INTEGER  NOT NULL,
+    chat_id     INTEGER  NOT NULL,
+    from_id     INTEGER  NOT NULL DEFAULT 0,
+    payload     TEXT     NOT NULL,
+    status      TEXT     NOT NULL DEFAULT 'pending',
+    attempts    INTEGER  NOT NULL DEFAULT 0,
+    worker      TEXT,
+    lease_until DATETIME,
+    last_error  TEXT,
+    created_at  DATETIME NOT NULL,
+    finished_at DATETIME
+);
+CREATE INDEX IF NOT EXISTS update_jobs_chat_id_idx ON update_jobs (chat_id, id);
+CREATE UNIQUE INDEX IF NOT EXISTS update_jobs_update_id_idx ON update_jobs (update_id);
+
+CREATE TABLE IF NOT EXISTS bot_state
+(
+    key        TEXT PRIMARY KEY,
+    value      INTEGER  NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS processed_updates
+(
+    update_id    INTEGER PRIMARY KEY,
+    processed_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS data_audit
+(
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    action        TEXT     NOT NULL,
+    user_id       INTEGER,
+    requested_by  TEXT     NOT NULL,
+    rows_affected INTEGER  NOT NULL,
+    details       TEXT     NOT NULL DEFAULT '{}',
+    created_at    DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS blocked_users
+(
+    user_id    INTEGER PRIMARY KEY,
+    blocked_by TEXT     NOT NULL,
+    blocked_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_keys
+(
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT     NOT NULL,
+    prefix     TEXT     NOT NULL,
+    key_hash   TEXT     NOT NULL UNIQUE,
+    scopes     TEXT     NOT NULL,
+    created_at DATETIME NOT NULL,
+    revoked_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS runtime_settings
+(
+    id         INTEGER PRIMARY KEY CHECK (id = 1),
+    value      TEXT     NOT NULL,
+    updated_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS broadcasts
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    text        TEXT     NOT NULL,
+    segment     TEXT     NOT NULL DEFAULT '{}',
+    status      TEXT     NOT NULL DEFAULT 'running',
+    created_by  TEXT     NOT NULL,
+    created_at  DATETIME NOT NULL,
+    finished_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS broadcast_deliveries
+(
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    broadcast_id INTEGER NOT NULL REFERENCES broadcasts (id) ON DELETE CASCADE,
+    chat_id      INTEGER NOT NULL,
+    status       TEXT    NOT NULL DEFAULT 'pending',
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    message_id   INTEGER,
+    last_error   TEXT,
+    claimed_at   DATETIME,
+    sent_at      DATETIME,
+    UNIQUE (broadcast_id, chat_id)
+);
+`
+
+// sqliteColumns - столбцы, добавленные в таблицы после их создания. В базах, созданных раньше,
+// их добавляет NewSQLiteStorage: в SQLite нет ADD COLUMN IF NOT EXISTS
+var sqliteColumns = []struct{ table, column, definition string }{
+	{"outbox", "next_attempt_at", "DATETIME"},
+}
+
+// SQLiteStorage - хранилище в файле SQLite для установки на одном сервере
+type SQLiteStorage struct {
+	db  *sql.DB
+	now func() time.Time
+}
+
+// NewSQLiteStorage открывает (или создаёт) базу по пути path и применяет схему
+func NewSQLiteStorage(ctx context.Context, path string, opts ...Option) (*SQLiteStorage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 7*time.Second)
+	defer cancel()
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_foreign_keys=on&_busy_timeout=5000", path))
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite (%v): %w", path, err)
+	}
+	// SQLite не умеет параллельную запись, держим одно соединение
+	db.SetMaxOpenConns(1)
+
+	if _, err = db.ExecContext(ctx, sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("apply sqlite schema: %w", err)
+	}
+	for _, c := range sqliteColumns {
+		if err = addSQLiteColumn(ctx, db, c.table, c.column, c.definition); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("apply sqlite schema: %w", err)
+		}
+	}
+
+	now := newOptions(opts).now
+	return &SQLiteStorage{db: db, now: func() time.Time { return now().UTC() }}, nil
+}
+
+// addSQLiteColumn добавляет столбец в таблицу, если его там ещё нет
+func addSQLiteColumn(ctx context.Context, db *sql.DB, table, column, definition string) error {
+	var exists bool
+	err := db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM pragma_table_info(?) WHERE name = ?)", table, column,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("checking column %s.%s: %w", table, column, err)
+	}
+	if exists {
+		return nil
+	}
+
+	if _, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("adding column %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) Close() error {
+	return s.db.Close()
+}
+
+func (s *SQLiteStorage) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("sqlite ping: %w", err)
+	}
+	return nil
+}
+
+// sqlQuerier - общее подмножество sql.DB и sql.Tx
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (s *SQLiteStorage) Save(ctx context.Context, message *models.Message) error {
+	ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+	defer cancel()
+
+	return s.saveMessage(ctx, s.db, message)
+}
+
+func (s *SQLiteStorage) saveMessage(ctx context.Context, q sqlQuerier, message *models.Message) error {
+	_, err := q.ExecContext(ctx,
+		"INSERT INTO updates_messages ("+messageColumns+") VALUES (?, ?, ?, ?, ?, ?, ?)",
+		message.ChatID,
+		message.MessageID,
+		message.FromID,
+		message.FromUsername,
+		message.Text,
+		message.Timestamp.UTC(),
+		s.now(),
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite insert updates err: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) GetMsgIDs(ctx context.Context, id int64) ([]int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT message_id FROM updates_messages WHERE chat_id = ? ORDER BY rowid", id)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite getting messages ids: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]int, 0)
+	for rows.Next() {
+		var msgID int
+		if err := rows.Scan(&msgID); err != nil {
+			return nil, fmt.Errorf("sqlite scanning messages ids: %w", err)
+		}
+		result = append(result, msgID)
+	}
+
+	return result, rows.Err()
+}
+
+// ActiveDialog возвращает последние limit сообщений активного диалога чата от старых к новым
+func (s *SQLiteStorage) ActiveDialog(ctx context.Context, chatID int64, limit int) ([]models.Message, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, message_id, from_id, from_username, text, time_stamp
+		FROM (SELECT chat_id, message_id, from_id, from_username, text, time_stamp FROM updates_messages
+		      WHERE chat_id = ? ORDER BY time_stamp DESC, message_id DESC LIMIT ?) m
+		ORDER BY time_stamp, message_id`,
+		chatID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite getting active dialog: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Message, 0)
+	for rows.Next() {
+		var msg models.Message
+		var timestamp sqliteTime
+		if err := rows.Scan(&msg.ChatID, &msg.MessageID, &msg.FromID, &msg.FromUsername, &msg.Text, &timestamp); err != nil {
+			return nil, fmt.Errorf("sqlite scanning active dialog: %w", err)
+		}
+		msg.Timestamp = timestamp.Time
+		result = append(result, msg)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) MoveToRecover(ctx context.Context, chatID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("sqlite move to recover, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	moved, err := s.archiveActive(ctx, tx, chatID)
+	if err != nil || !moved {
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, fmt.Errorf("sqlite move to recover, commit: %w", err)
+	}
+
+	return true, nil
+}
+
+func (s *SQLiteStorage) archiveActive(ctx context.Context, tx *sql.Tx, chatID int64) (bool, error) {
+	res, err := tx.ExecContext(ctx, "INSERT INTO archive_sessions (chat_id, archived_at) VALUES (?, ?)", chatID, s.now())
+	if err != nil {
+		return false, fmt.Errorf("sqlite create archive session: %w", err)
+	}
+	sessionID, err := res.LastInsertId()
+	if err != nil {
+		return false, fmt.Errorf("sqlite archive session id: %w", err)
+	}
+
+	res, err = tx.ExecContext(ctx,
+		"INSERT INTO archive_messages ("+messageColumns+", session_id) SELECT "+messageColumns+", ? FROM updates_messages WHERE chat_id = ? ORDER BY rowid",
+		sessionID, chatID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("sqlite move to recover: %w", err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM updates_messages WHERE chat_id = ?", chatID); err != nil {
+		return false, fmt.Errorf("sqlite clear active messages: %w", err)
+	}
+
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("sqlite move to recover rows: %w", err)
+	}
+
+	return moved > 0, nil
+}
+
+func (s *SQLiteStorage) ListArchiveSessions(ctx context.Context, chatID int64, limit int) ([]models.ArchiveSession, error) {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.id, s.chat_id, s.archived_at, count(m.message_id),
+		       coalesce((SELECT f.text FROM archive_messages f
+		                 WHERE f.session_id = s.id AND f.text NOT LIKE '/%'
+		                 ORDER BY f.time_stamp LIMIT 1), '')
+		FROM archive_sessions s
+		JOIN archive_messages m ON m.session_id = s.id
+		WHERE s.chat_id = ?
+		GROUP BY s.id
+		ORDER BY s.archived_at DESC, s.id DESC
+		LIMIT ?`,
+		chatID, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing archive sessions: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ArchiveSession, 0)
+	for rows.Next() {
+		var session models.ArchiveSession
+		if err := rows.Scan(
+			&session.ID,
+			&session.ChatID,
+			&session.ArchivedAt,
+			&session.MessagesCount,
+			&session.FirstText,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite scanning archive session: %w", err)
+		}
+		result = append(result, session)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) RestoreArchiveSession(ctx context.Context, chatID int64, sessionID int64) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite restore session, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM archive_sessions WHERE id = ? AND chat_id = ?)",
+		sessionID, chatID,
+	).Scan(&exists)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite restore session, lookup: %w", err)
+	}
+	if !exists {
+		return 0, ErrSessionNotFound
+	}
+
+	if _, err = s.archiveActive(ctx, tx, chatID); err != nil {
+		return 0, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO updates_messages ("+messageColumns+") SELECT "+messageColumns+" FROM archive_messages WHERE session_id = ? ORDER BY rowid",
+		sessionID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite restore session messages: %w", err)
+	}
+	restored, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite restore session rows: %w", err)
+	}
+
+	if _, err = tx.ExecContext(ctx, "DELETE FROM archive_sessions WHERE id = ? OR (chat_id = ? AND NOT EXISTS "+
+		"(SELECT 1 FROM archive_messages WHERE session_id = archive_sessions.id))", sessionID, chatID); err != nil {
+		return 0, fmt.Errorf("sqlite restore session cleanup: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlite restore session, commit: %w", err)
+	}
+
+	return int(restored), nil
+}
+
+func (s *SQLiteStorage) ApplyRetention(ctx context.Context, retention config.Retention) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite retention, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	details := make(map[string]any)
+	var total int64
+
+	policies := []struct {
+		table  userDataTable
+		policy config.RetentionPolicy
+	}{
+		{updatesMessagesTable, retention.UpdatesMessages},
+		{archiveMessagesTable, retention.ArchiveMessages},
+	}
+	for _, p := range policies {
+		if p.policy.Days == 0 {
+			continue
+		}
+
+		deadline := s.now().AddDate(0, 0, -p.policy.Days)
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s < ?", p.table.name, p.table.timeColumn)
+		if p.policy.Mode == config.RetentionAnonymize {
+			query = anonymizeQuery(p.table, "?")
+		}
+
+		res, err := tx.ExecContext(ctx, query, deadline)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite retention (%v) for %v: %w", p.policy.Mode, p.table.name, err)
+		}
+		affected, _ := res.RowsAffected()
+		details[p.table.name] = affected
+		total += affected
+	}
+
+	if retention.FinishedJobsDays > 0 {
+		deadline := s.now().AddDate(0, 0, -retention.FinishedJobsDays)
+		res, err := tx.ExecContext(ctx,
+			"DELETE FROM outbox WHERE status IN (?, ?) AND created_at < ?",
+			OutboxSent, OutboxFailed, deadline,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite retention, outbox cleanup: %w", err)
+		}
+		affected, _ := res.RowsAffected()
+		details[outboxTable.name] = affected
+		total += affected
+
+		res, err = tx.ExecContext(ctx,
+			"DELETE FROM update_jobs WHERE status IN (?, ?) AND created_at < ?",
+			UpdateDone, UpdateFailed, deadline,
+		)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite retention, update jobs cleanup: %w", err)
+		}
+		affected, _ = res.RowsAffected()
+		details[updateJobsTable.name] = affected
+		total += affected
+	}
+
+	if retention.ArchiveMessages.Days > 0 {
+		query := "DELETE FROM archive_sessions WHERE NOT EXISTS (SELECT 1 FROM archive_messages m WHERE m.session_id = archive_sessions.id)"
+		if retention.ArchiveMessages.Mode == config.RetentionAnonymize {
+			query = "UPDATE archive_sessions SET chat_id = 0 WHERE chat_id <> 0 AND NOT EXISTS " +
+				"(SELECT 1 FROM archive_messages m WHERE m.session_id = archive_sessions.id AND m.chat_id <> 0)"
+		}
+		res, err := tx.ExecContext(ctx, query)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite retention, sessions cleanup: %w", err)
+		}
+		affected, _ := res.RowsAffected()
+		details[archiveSessionsTable.name] = affected
+		total += affected
+	}
+
+	if _, err = tx.ExecContext(ctx,
+		"DELETE FROM processed_updates WHERE processed_at < ?", s.now().Add(-processedUpdatesTTL),
+	); err != nil {
+		return 0, fmt.Errorf("sqlite retention, processed updates cleanup: %w", err)
+	}
+
+	if total > 0 {
+		if err = s.writeAudit(ctx, tx, Audit{
+			Action:       "retention",
+			RequestedBy:  "janitor",
+			RowsAffected: total,
+			Details:      details,
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlite retention, commit: %w", err)
+	}
+
+	return total, nil
+}
+
+func (s *SQLiteStorage) ForgetUser(ctx context.Context, userID int64, requestedBy string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite forget user, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	details := make(map[string]any)
+	var total int64
+
+	for _, table := range userDataTables {
+		query := fmt.Sprintf("DELETE FROM %s WHERE %s = ?1", table.name, table.chatColumn)
+		if table.userColumn != "" {
+			query += fmt.Sprintf(" OR %s = ?1", table.userColumn)
+		}
+
+		res, err := tx.ExecContext(ctx, query, userID)
+		if err != nil {
+			return 0, fmt.Errorf("sqlite forget user in %v: %w", table.name, err)
+		}
+		affected, _ := res.RowsAffected()
+		details[table.name] = affected
+		total += affected
+	}
+
+	if err = s.writeAudit(ctx, tx, Audit{
+		Action:       "forget_user",
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: total,
+		Details:      details,
+	}); err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, fmt.Errorf("sqlite forget user, commit: %w", err)
+	}
+
+	return total, nil
+}
+
+func (s *SQLiteStorage) writeAudit(ctx context.Context, tx *sql.Tx, audit Audit) error {
+	details, err := json.Marshal(audit.Details)
+	if err != nil {
+		return fmt.Errorf("marshal audit details: %w", err)
+	}
+
+	var userID *int64
+	if audit.UserID != 0 {
+		userID = &audit.UserID
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO data_audit (action, user_id, requested_by, rows_affected, details, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		audit.Action, userID, audit.RequestedBy, audit.RowsAffected, string(details), s.now(),
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite write audit: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) EnqueueOutbox(ctx context.Context, chatID int64, text string) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO outbox (chat_id, text, status, attempts, created_at, claimed_at) VALUES (?, ?, ?, 1, ?, ?)",
+		chatID, text, OutboxSending, s.now(), s.now(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite enqueue outbox: %w", err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (s *SQLiteStorage) ClaimOutbox(ctx context.Context, staleAfter time.Duration, limit int) ([]models.OutboxMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim outbox, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, chat_id, text, attempts FROM outbox
+		WHERE (status = ? AND (next_attempt_at IS NULL OR next_attempt_at <= ?)) OR (status = ? AND claimed_at < ?)
+		ORDER BY created_at
+		LIMIT ?`,
+		OutboxPending, s.now(), OutboxSending, s.now().Add(-staleAfter), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim outbox: %w", err)
+	}
+
+	var messages []models.OutboxMessage
+	for rows.Next() {
+		var msg models.OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.ChatID, &msg.Text, &msg.Attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("sqlite scanning claimed outbox: %w", err)
+		}
+		msg.Attempts++
+		messages = append(messages, msg)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite reading claimed outbox: %w", err)
+	}
+
+	for _, msg := range messages {
+		if _, err = tx.ExecContext(ctx,
+			"UPDATE outbox SET status = ?, attempts = ?, claimed_at = ? WHERE id = ?",
+			OutboxSending, msg.Attempts, s.now(), msg.ID,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite claim outbox (%v): %w", msg.ID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite claim outbox, commit: %w", err)
+	}
+
+	return messages, nil
+}
+
+func (s *SQLiteStorage) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAfter time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	status, nextAttempt := OutboxFailed, sql.NullTime{}
+	if retryAfter > 0 {
+		status, nextAttempt = OutboxPending, sql.NullTime{Time: s.now().Add(retryAfter), Valid: true}
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, last_error = ?, next_attempt_at = ? WHERE id = ?",
+		status, reason, nextAttempt, id,
+	); err != nil {
+		return fmt.Errorf("sqlite mark outbox (%v) failed: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) WithinTx(ctx context.Context, fn func(tx Tx) error) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(&sqliteTx{storage: s, tx: tx}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite commit: %w", err)
+	}
+
+	return nil
+}
+
+type sqliteTx struct {
+	storage *SQLiteStorage
+	tx      *sql.Tx
+}
+
+func (t *sqliteTx) Save(ctx context.Context, msg *models.Message) error {
+	return t.storage.saveMessage(ctx, t.tx, msg)
+}
+
+func (t *sqliteTx) MarkOutboxSent(ctx context.Context, id int64, messageID int) error {
+	if _, err := t.tx.ExecContext(ctx,
+		"UPDATE outbox SET status = ?, message_id = ?, sent_at = ?, last_error = NULL WHERE id = ?",
+		OutboxSent, messageID, t.storage.now(), id,
+	); err != nil {
+		return fmt.Errorf("sqlite mark outbox (%v) sent: %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return 0, fmt.Errorf("marshal update (%v): %w", update.UpdateID, err)
+	}
+	chatID, fromID := updateChat(update)
+
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO update_jobs (update_id, chat_id, from_id, payload, created_at) VALUES (?, ?, ?, ?, ?) "+
+			"ON CONFLICT (update_id) DO NOTHING",
+		update.UpdateID, chatID, fromID, string(payload), s.now(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("sqlite enqueue update (%v): %w", update.UpdateID, err)
+	}
+	if inserted, _ := res.RowsAffected(); inserted > 0 {
+		return res.LastInsertId()
+	}
+
+	// апдейт уже в очереди
+	var id int64
+	if err = s.db.QueryRowContext(ctx, "SELECT id FROM update_jobs WHERE update_id = ?", update.UpdateID).Scan(&id); err != nil {
+		return 0, fmt.Errorf("sqlite enqueue update (%v), find queued: %w", update.UpdateID, err)
+	}
+	return id, nil
+}
+
+func (s *SQLiteStorage) ClaimUpdates(ctx context.Context, worker string, lease time.Duration, limit int) ([]models.QueuedUpdate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim updates, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := s.now()
+	rows, err := tx.QueryContext(ctx, `
+		SELECT j.id, j.payload, j.attempts FROM update_jobs j
+		WHERE (j.status = ?1 OR (j.status = ?2 AND j.lease_until < ?3))
+		  AND NOT EXISTS (SELECT 1 FROM update_jobs e
+		                  WHERE e.chat_id = j.chat_id AND e.id < j.id AND e.status IN (?1, ?2))
+		ORDER BY j.id
+		LIMIT ?4`,
+		UpdatePending, UpdateInProgress, now, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim updates: %w", err)
+	}
+
+	var jobs []models.QueuedUpdate
+	for rows.Next() {
+		var (
+			job     models.QueuedUpdate
+			payload string
+		)
+		if err := rows.Scan(&job.ID, &payload, &job.Attempts); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("sqlite scanning claimed updates: %w", err)
+		}
+		if err := json.Unmarshal([]byte(payload), &job.Update); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("unmarshal queued update (%v): %w", job.ID, err)
+		}
+		job.Attempts++
+		jobs = append(jobs, job)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite reading claimed updates: %w", err)
+	}
+
+	for _, job := range jobs {
+		if _, err = tx.ExecContext(ctx,
+			"UPDATE update_jobs SET status = ?, worker = ?, attempts = ?, lease_until = ? WHERE id = ?",
+			UpdateInProgress, worker, job.Attempts, now.Add(lease), job.ID,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite claim update (%v): %w", job.ID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite claim updates, commit: %w", err)
+	}
+
+	return jobs, nil
+}
+
+func (s *SQLiteStorage) ExtendUpdateLease(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE update_jobs SET lease_until = ? WHERE id = ? AND worker = ? AND status = ?",
+		s.now().Add(lease), id, worker, UpdateInProgress,
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite extend update lease (%v): %w", id, err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return fmt.Errorf("update job (%v) is not leased by %v", id, worker)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) FinishUpdate(ctx context.Context, id int64, status string, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var finishedAt *time.Time
+	if status == UpdateDone || status == UpdateFailed {
+		now := s.now()
+		finishedAt = &now
+	}
+
+	if _, err := s.db.ExecContext(ctx,
+		"UPDATE update_jobs SET status = ?, last_error = nullif(?, ''), lease_until = NULL, finished_at = ? WHERE id = ?",
+		status, reason, finishedAt, id,
+	); err != nil {
+		return fmt.Errorf("sqlite finish update (%v): %w", id, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) PendingUpdates(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var depth int
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT count(*) FROM update_jobs WHERE status IN (?, ?)", UpdatePending, UpdateInProgress,
+	).Scan(&depth); err != nil {
+		return 0, fmt.Errorf("sqlite count pending updates: %w", err)
+	}
+
+	return depth, nil
+}
+
+func (s *SQLiteStorage) LastUpdateOffset(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var offset int
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM bot_state WHERE key = ?", updateOffsetKey).Scan(&offset)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("sqlite get update offset: %w", err)
+	}
+
+	return offset, nil
+}
+
+func (s *SQLiteStorage) SaveUpdateOffset(ctx context.Context, offset int) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO bot_state (key, value, updated_at) VALUES (?1, ?2, ?3)
+		ON CONFLICT (key) DO UPDATE SET value = max(bot_state.value, excluded.value), updated_at = ?3`,
+		updateOffsetKey, offset, s.now(),
+	); err != nil {
+		return fmt.Errorf("sqlite save update offset: %w", err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) IsUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var processed bool
+	err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM processed_updates WHERE update_id = ?)", updateID,
+	).Scan(&processed)
+	if err != nil {
+		return false, fmt.Errorf("sqlite check processed update (%v): %w", updateID, err)
+	}
+
+	return processed, nil
+}
+
+func (s *SQLiteStorage) MarkUpdateProcessed(ctx context.Context, updateID int) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx,
+		"INSERT INTO processed_updates (update_id, processed_at) VALUES (?, ?) ON CONFLICT DO NOTHING",
+		updateID, s.now(),
+	); err != nil {
+		return fmt.Errorf("sqlite mark update (%v) processed: %w", updateID, err)
+	}
+
+	return nil
+}
+
+func (s *SQLiteStorage) RuntimeSettings(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var value []byte
+	err := s.db.QueryRowContext(ctx, "SELECT value FROM runtime_settings WHERE id = 1").Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("sqlite get runtime settings: %w", err)
+	}
+
+	return value, nil
+}
+
+func (s *SQLiteStorage) SaveRuntimeSettings(ctx context.Context, value []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, `
+		INSERT INTO runtime_settings (id, value, updated_at) VALUES (1, ?1, ?2)
+		ON CONFLICT (id) DO UPDATE SET value = excluded.value, updated_at = ?2`,
+		string(value), s.now(),
+	); err != nil {
+		return fmt.Errorf("sqlite save runtime settings: %w", err)
+	}
+
+	return nil
+}
+
+// sqliteTime читает время и из колонок DATETIME, и из выражений (max(...)), которые драйвер отдаёт строкой
+type sqliteTime struct {
+	time.Time
+}
+
+func (t *sqliteTime) Scan(value any) error {
+	switch v := value.(type) {
+	case time.Time:
+		t.Time = v
+		return nil
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	default:
+		return fmt.Errorf("unsupported time value %T", value)
+	}
+}
+
+func (t *sqliteTime) parse(raw string) error {
+	for _, layout := range sqlite3.SQLiteTimestampFormats {
+		if parsed, err := time.ParseInLocation(layout, raw, time.UTC); err == nil {
+			t.Time = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported time format %q", raw)
+}
+
+func (s *SQLiteStorage) ListChats(ctx context.Context, page models.Page) ([]models.ChatSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, count(*) FILTER (WHERE NOT archived), count(*) FILTER (WHERE archived), max(time_stamp)
+		FROM (SELECT chat_id, time_stamp, false AS archived FROM updates_messages
+		      UNION ALL
+		      SELECT chat_id, time_stamp, true FROM archive_messages) m
+		GROUP BY chat_id
+		ORDER BY max(time_stamp) DESC, chat_id
+		LIMIT ? OFFSET ?`,
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing chats: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ChatSummary, 0)
+	for rows.Next() {
+		var chat models.ChatSummary
+		var last sqliteTime
+		if err := rows.Scan(&chat.ChatID, &chat.ActiveMessages, &chat.ArchivedMessages, &last); err != nil {
+			return nil, fmt.Errorf("sqlite scanning chat: %w", err)
+		}
+		chat.LastMessageAt = last.Time
+		result = append(result, chat)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) ListUsers(ctx context.Context, page models.Page) ([]models.UserSummary, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		WITH m AS (SELECT from_id, from_username, time_stamp FROM updates_messages
+		           UNION ALL
+		           SELECT from_id, from_username, time_stamp FROM archive_messages)
+		SELECT m.from_id,
+		       (SELECT l.from_username FROM m l WHERE l.from_id = m.from_id ORDER BY l.time_stamp DESC LIMIT 1),
+		       count(*), max(m.time_stamp),
+		       EXISTS (SELECT 1 FROM blocked_users b WHERE b.user_id = m.from_id)
+		FROM m
+		GROUP BY m.from_id
+		ORDER BY max(m.time_stamp) DESC, m.from_id
+		LIMIT ? OFFSET ?`,
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing users: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.UserSummary, 0)
+	for rows.Next() {
+		var user models.UserSummary
+		var last sqliteTime
+		if err := rows.Scan(&user.UserID, &user.Username, &user.MessagesCount, &last, &user.Blocked); err != nil {
+			return nil, fmt.Errorf("sqlite scanning user: %w", err)
+		}
+		user.LastSeenAt = last.Time
+		result = append(result, user)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) ChatMessages(ctx context.Context, chatID int64, page models.Page) ([]models.ChatMessage, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT chat_id, message_id, from_id, from_username, text, time_stamp, archived
+		FROM (SELECT chat_id, message_id, from_id, from_username, text, time_stamp, false AS archived
+		      FROM updates_messages WHERE chat_id = ?1
+		      UNION ALL
+		      SELECT chat_id, message_id, from_id, from_username, text, time_stamp, true
+		      FROM archive_messages WHERE chat_id = ?1) m
+		ORDER BY time_stamp DESC, message_id DESC
+		LIMIT ?2 OFFSET ?3`,
+		chatID, page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing chat messages: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.ChatMessage, 0)
+	for rows.Next() {
+		var msg models.ChatMessage
+		var timestamp sqliteTime
+		if err := rows.Scan(
+			&msg.ChatID,
+			&msg.MessageID,
+			&msg.FromID,
+			&msg.FromUsername,
+			&msg.Text,
+			&timestamp,
+			&msg.Archived,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite scanning chat message: %w", err)
+		}
+		msg.Timestamp = timestamp.Time
+		result = append(result, msg)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) SetUserBlocked(ctx context.Context, userID int64, blocked bool, requestedBy string) error {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite block user, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	action, query := "block_user",
+		"INSERT INTO blocked_users (user_id, blocked_by, blocked_at) VALUES (?, ?, ?) ON CONFLICT (user_id) DO NOTHING"
+	args := []any{userID, requestedBy, s.now()}
+	if !blocked {
+		action, query, args = "unblock_user", "DELETE FROM blocked_users WHERE user_id = ?", []any{userID}
+	}
+
+	res, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("sqlite %s: %w", action, err)
+	}
+	affected, _ := res.RowsAffected()
+	if err = s.writeAudit(ctx, tx, Audit{
+		Action:       action,
+		UserID:       userID,
+		RequestedBy:  requestedBy,
+		RowsAffected: affected,
+		Details:      map[string]any{"blocked_users": affected},
+	}); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite %s, commit: %w", action, err)
+	}
+	return nil
+}
+
+func (s *SQLiteStorage) IsUserBlocked(ctx context.Context, userID int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var blocked bool
+	if err := s.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM blocked_users WHERE user_id = ?)", userID,
+	).Scan(&blocked); err != nil {
+		return false, fmt.Errorf("sqlite check blocked user: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// CreateAPIKey сохраняет ключ. Scopes в SQLite хранятся строкой через запятую
+func (s *SQLiteStorage) CreateAPIKey(ctx context.Context, key models.APIKey, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	key.CreatedAt = s.now()
+	res, err := s.db.ExecContext(ctx,
+		"INSERT INTO api_keys (name, prefix, key_hash, scopes, created_at) VALUES (?, ?, ?, ?, ?)",
+		key.Name, key.Prefix, hash, strings.Join(key.Scopes, ","), key.CreatedAt,
+	)
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("sqlite create api key: %w", err)
+	}
+	if key.ID, err = res.LastInsertId(); err != nil {
+		return models.APIKey{}, fmt.Errorf("sqlite create api key, id: %w", err)
+	}
+
+	return key, nil
+}
+
+func (s *SQLiteStorage) APIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var key models.APIKey
+	var scopes string
+	err := s.db.QueryRowContext(ctx,
+		"SELECT id, name, prefix, scopes, created_at FROM api_keys WHERE key_hash = ? AND revoked_at IS NULL",
+		hash,
+	).Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.APIKey{}, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return models.APIKey{}, fmt.Errorf("sqlite get api key: %w", err)
+	}
+	key.Scopes = strings.Split(scopes, ",")
+
+	return key, nil
+}
+
+func (s *SQLiteStorage) ListAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, prefix, scopes, created_at, revoked_at FROM api_keys ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing api keys: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.APIKey, 0)
+	for rows.Next() {
+		var key models.APIKey
+		var scopes string
+		var revokedAt sql.NullTime
+		if err := rows.Scan(&key.ID, &key.Name, &key.Prefix, &scopes, &key.CreatedAt, &revokedAt); err != nil {
+			return nil, fmt.Errorf("sqlite scanning api key: %w", err)
+		}
+		key.Scopes = strings.Split(scopes, ",")
+		if revokedAt.Valid {
+			key.RevokedAt = &revokedAt.Time
+		}
+		result = append(result, key)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) RevokeAPIKey(ctx context.Context, id int64) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = ? WHERE id = ? AND revoked_at IS NULL", s.now(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("sqlite revoke api key: %w", err)
+	}
+	if affected, _ := res.RowsAffected(); affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+const sqliteBroadcastColumns = `b.id, b.text, b.segment, b.status, b.created_by, b.created_at, b.finished_at,
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id),
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id AND d.status = 'sent'),
+	(SELECT count(*) FROM broadcast_deliveries d WHERE d.broadcast_id = b.id AND d.status = 'failed')`
+
+// scanSQLiteBroadcast читает рассылку. Сегмент в SQLite хранится JSON-строкой
+func scanSQLiteBroadcast(row interface{ Scan(...any) error }) (models.Broadcast, error) {
+	var broadcast models.Broadcast
+	var segment string
+	var finishedAt sql.NullTime
+	if err := row.Scan(
+		&broadcast.ID,
+		&broadcast.Text,
+		&segment,
+		&broadcast.Status,
+		&broadcast.CreatedBy,
+		&broadcast.CreatedAt,
+		&finishedAt,
+		&broadcast.Total,
+		&broadcast.Sent,
+		&broadcast.Failed,
+	); err != nil {
+		return models.Broadcast{}, err
+	}
+	if finishedAt.Valid {
+		broadcast.FinishedAt = &finishedAt.Time
+	}
+	if err := json.Unmarshal([]byte(segment), &broadcast.Segment); err != nil {
+		return models.Broadcast{}, fmt.Errorf("unmarshal broadcast segment: %w", err)
+	}
+	return broadcast, nil
+}
+
+// CreateBroadcast создаёт рассылку. Чаты сегмента отбираются на стороне Go, чтобы не зависеть
+// от расширения JSON в SQLite
+func (s *SQLiteStorage) CreateBroadcast(ctx context.Context, broadcast models.Broadcast) (models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 20*time.Second)
+	defer cancel()
+
+	segment, err := json.Marshal(broadcast.Segment)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("marshal broadcast segment: %w", err)
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite create broadcast, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx,
+		"INSERT INTO broadcasts (text, segment, status, created_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		broadcast.Text, string(segment), BroadcastRunning, broadcast.CreatedBy, s.now(),
+	)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite create broadcast: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite create broadcast, id: %w", err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT chat_id, max(time_stamp)
+		FROM (SELECT chat_id, time_stamp FROM updates_messages
+		      UNION ALL
+		      SELECT chat_id, time_stamp FROM archive_messages)
+		WHERE chat_id NOT IN (SELECT user_id FROM blocked_users)
+		GROUP BY chat_id
+		ORDER BY chat_id`)
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite selecting broadcast chats: %w", err)
+	}
+	var chats []int64
+	for rows.Next() {
+		var chatID int64
+		var last sqliteTime
+		if err := rows.Scan(&chatID, &last); err != nil {
+			rows.Close()
+			return models.Broadcast{}, fmt.Errorf("sqlite scanning broadcast chat: %w", err)
+		}
+		if broadcastTarget(broadcast.Segment, chatID, last.Time) {
+			chats = append(chats, chatID)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite reading broadcast chats: %w", err)
+	}
+
+	for _, chatID := range chats {
+		if _, err = tx.ExecContext(ctx,
+			"INSERT INTO broadcast_deliveries (broadcast_id, chat_id, status) VALUES (?, ?, ?)",
+			id, chatID, DeliveryPending,
+		); err != nil {
+			return models.Broadcast{}, fmt.Errorf("sqlite create broadcast delivery: %w", err)
+		}
+	}
+	if len(chats) == 0 {
+		if _, err = tx.ExecContext(ctx,
+			"UPDATE broadcasts SET status = ?, finished_at = ? WHERE id = ?", BroadcastFinished, s.now(), id,
+		); err != nil {
+			return models.Broadcast{}, fmt.Errorf("sqlite finish empty broadcast: %w", err)
+		}
+	}
+
+	created, err := scanSQLiteBroadcast(tx.QueryRowContext(ctx,
+		"SELECT "+sqliteBroadcastColumns+" FROM broadcasts b WHERE b.id = ?", id))
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite read created broadcast: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite create broadcast, commit: %w", err)
+	}
+
+	return created, nil
+}
+
+func (s *SQLiteStorage) GetBroadcast(ctx context.Context, id int64) (models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	broadcast, err := scanSQLiteBroadcast(s.db.QueryRowContext(ctx,
+		"SELECT "+sqliteBroadcastColumns+" FROM broadcasts b WHERE b.id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Broadcast{}, ErrBroadcastNotFound
+	}
+	if err != nil {
+		return models.Broadcast{}, fmt.Errorf("sqlite get broadcast: %w", err)
+	}
+
+	return broadcast, nil
+}
+
+func (s *SQLiteStorage) ListBroadcasts(ctx context.Context, page models.Page) ([]models.Broadcast, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx,
+		"SELECT "+sqliteBroadcastColumns+" FROM broadcasts b ORDER BY b.id DESC LIMIT ? OFFSET ?",
+		page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing broadcasts: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.Broadcast, 0)
+	for rows.Next() {
+		broadcast, err := scanSQLiteBroadcast(rows)
+		if err != nil {
+			return nil, fmt.Errorf("sqlite scanning broadcast: %w", err)
+		}
+		result = append(result, broadcast)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) SetBroadcastStatus(ctx context.Context, id int64, from, to string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE broadcasts SET status = ? WHERE id = ? AND status = ?", to, id, from)
+	if err != nil {
+		return fmt.Errorf("sqlite set broadcast status: %w", err)
+	}
+	if affected, _ := res.RowsAffected(); affected > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err = s.db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM broadcasts WHERE id = ?)", id).Scan(&exists); err != nil {
+		return fmt.Errorf("sqlite check broadcast: %w", err)
+	}
+	if !exists {
+		return ErrBroadcastNotFound
+	}
+	return ErrBroadcastStatus
+}
+
+func (s *SQLiteStorage) BroadcastDeliveries(ctx context.Context, broadcastID int64, page models.Page) ([]models.BroadcastDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, broadcast_id, chat_id, status, attempts, coalesce(message_id, 0), coalesce(last_error, ''), sent_at
+		FROM broadcast_deliveries
+		WHERE broadcast_id = ?
+		ORDER BY id
+		LIMIT ? OFFSET ?`,
+		broadcastID, page.Limit, page.Offset,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite listing broadcast deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	result := make([]models.BroadcastDelivery, 0)
+	for rows.Next() {
+		var delivery models.BroadcastDelivery
+		var sentAt sql.NullTime
+		if err := rows.Scan(
+			&delivery.ID,
+			&delivery.BroadcastID,
+			&delivery.ChatID,
+			&delivery.Status,
+			&delivery.Attempts,
+			&delivery.MessageID,
+			&delivery.LastError,
+			&sentAt,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite scanning broadcast delivery: %w", err)
+		}
+		if sentAt.Valid {
+			delivery.SentAt = &sentAt.Time
+		}
+		result = append(result, delivery)
+	}
+
+	return result, rows.Err()
+}
+
+func (s *SQLiteStorage) ClaimBroadcastDeliveries(ctx context.Context, staleAfter time.Duration, limit int) ([]models.BroadcastDelivery, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim broadcast deliveries, begin: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, `
+		SELECT d.id, d.broadcast_id, d.chat_id, d.attempts, b.text
+		FROM broadcast_deliveries d
+		JOIN broadcasts b ON b.id = d.broadcast_id
+		WHERE b.status = ? AND (d.status = ? OR (d.status = ? AND d.claimed_at < ?))
+		ORDER BY d.broadcast_id, d.id
+		LIMIT ?`,
+		BroadcastRunning, DeliveryPending, DeliverySending, s.now().Add(-staleAfter), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite claim broadcast deliveries: %w", err)
+	}
+
+	deliveries := make([]models.BroadcastDelivery, 0)
+	for rows.Next() {
+		delivery := models.BroadcastDelivery{Status: DeliverySending}
+		if err := rows.Scan(&delivery.ID, &delivery.BroadcastID, &delivery.ChatID, &delivery.Attempts, &delivery.Text); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("sqlite scanning claimed broadcast delivery: %w", err)
+		}
+		delivery.Attempts++
+		deliveries = append(deliveries, delivery)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlite reading claimed broadcast deliveries: %w", err)
+	}
+
+	for _, delivery := range deliveries {
+		if _, err = tx.ExecContext(ctx,
+			"UPDATE broadcast_deliveries SET status = ?, attempts = ?, claimed_at = ? WHERE id = ?",
+			DeliverySending, delivery.Attempts, s.now(), delivery.ID,
+		); err != nil {
+			return nil, fmt.Errorf("sqlite claim broadcast delivery (%v): %w", delivery.ID, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("sqlite claim broadcast deliveries, commit: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+func (s *SQLiteStorage) MarkBroadcastDeliverySent(ctx context.Context, id int64, messageID int) error {
+	return s.finishDelivery(ctx, id,
+		"UPDATE broadcast_deliveries SET status = ?, message_id = ?, sent_at = ?, last_error = NULL WHERE id = ? RETURNING broadcast_id",
+		DeliverySent, messageID, s.now(), id,
+	)
+}
+
+func (s *SQLiteStorage) MarkBroadcastDeliveryFailed(ctx context.Context, id int64, reason string, retry bool) error {
+	status := DeliveryFailed
+	if retry {
+		status = DeliveryPending
+	}
+
+	return s.finishDelivery(ctx, id,
+		"UPDATE broadcast_deliveries SET status = ?, last_error = ? WHERE id = ? RETURNING broadcast_id",
+		status, reason, id,
+	)
+}
+
+// finishDelivery обновляет доставку запросом query и завершает рассылку, если недоставленных не осталось
+func (s *SQLiteStorage) finishDelivery(ctx context.Context, id int64, query string, args ...any) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite update broadcast delivery (%v), begin: %w", id, err)
+	}
+	defer tx.Rollback()
+
+	var broadcastID int64
+	if err = tx.QueryRowContext(ctx, query, args...).Scan(&broadcastID); err != nil {
+		return fmt.Errorf("sqlite update broadcast delivery (%v): %w", id, err)
+	}
+
+	if _, err = tx.ExecContext(ctx, `
+		UPDATE broadcasts SET status = ?2, finished_at = ?3
+		WHERE id = ?1 AND status <> ?2 AND NOT EXISTS (
+			SELECT 1 FROM broadcast_deliveries WHERE broadcast_id = ?1 AND status IN (?4, ?5))`,
+		broadcastID, BroadcastFinished, s.now(), DeliveryPending, DeliverySending,
+	); err != nil {
+		return fmt.Errorf("sqlite finish broadcast (%v): %w", broadcastID, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite update broadcast delivery (%v), commit: %w", id, err)
+	}
+	return nil
+}
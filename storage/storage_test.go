@@ -0,0 +1,102 @@
+package storage_test
+
+import (
+	"context"
+	"database/sql"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/database"
+	"github.com/mytelegrambot/storage"
+	"github.com/mytelegrambot/storage/storagetest"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, now func() time.Time) storage.Storage {
+		return storage.NewMemoryStorage(storage.WithClock(now))
+	})
+}
+
+func TestSQLiteStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T, now func() time.Time) storage.Storage {
+		s, err := storage.NewSQLiteStorage(context.Background(), filepath.Join(t.TempDir(), "bot.db"), storage.WithClock(now))
+		require.NoError(t, err)
+		t.Cleanup(func() { s.Close() })
+		return s
+	})
+}
+
+// TestSQLiteStorage_upgrade открывает базу, созданную до появления next_attempt_at в outbox
+func TestSQLiteStorage_upgrade(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "bot.db")
+
+	db, err := sql.Open("sqlite3", path)
+	require.NoError(t, err)
+	_, err = db.ExecContext(ctx, `CREATE TABLE outbox
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id     INTEGER  NOT NULL,
+    text        TEXT     NOT NULL,
+    status      TEXT     NOT NULL DEFAULT 'pending',
+    attempts    INTEGER  NOT NULL DEFAULT 0,
+    message_id  INTEGER,
+    last_error  TEXT,
+    created_at  DATETIME NOT NULL,
+    claimed_at  DATETIME,
+    sent_at     DATETIME
+)`)
+	require.NoError(t, err)
+	require.NoError(t, db.Close())
+
+	s, err := storage.NewSQLiteStorage(ctx, path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	id, err := s.EnqueueOutbox(ctx, 1, "ответ")
+	require.NoError(t, err)
+	require.NoError(t, s.MarkOutboxFailed(ctx, id, "timeout", time.Hour))
+	claimed, err := s.ClaimOutbox(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+}
+
+// TestBotStorage запускается только при заданной STORAGE_TEST_POSTGRES, база очищается перед каждым тестом
+func TestBotStorage(t *testing.T) {
+	connString := os.Getenv("STORAGE_TEST_POSTGRES")
+	if connString == "" {
+		t.Skip("STORAGE_TEST_POSTGRES is not set")
+	}
+
+	cfg := &config.Config{
+		ConnString:         connString,
+		MaxPgxConn:         4,
+		MaxPgxConnIdleTime: time.Minute,
+		MaxPgxConnLifeTime: time.Hour,
+		HealthCheckPeriod:  time.Minute,
+	}
+	pool, err := database.GetPool(context.Background(), cfg)
+	require.NoError(t, err)
+	t.Cleanup(pool.Close)
+
+	migrations, err := filepath.Glob("../database/migrations/*.sql")
+	require.NoError(t, err)
+	sort.Strings(migrations)
+	for _, migration := range migrations {
+		sql, err := os.ReadFile(migration)
+		require.NoError(t, err)
+		_, err = pool.Exec(context.Background(), string(sql))
+		require.NoError(t, err, migration)
+	}
+
+	storagetest.Run(t, func(t *testing.T, now func() time.Time) storage.Storage {
+		_, err := pool.Exec(context.Background(),
+			"TRUNCATE updates_messages, archive_messages, archive_sessions, data_audit, outbox, update_jobs, bot_state, processed_updates, runtime_settings, blocked_users, api_keys, broadcasts, broadcast_deliveries")
+		require.NoError(t, err)
+		return storage.NewBotStorage(pool, cfg, storage.WithClock(now))
+	})
+}
@@ -0,0 +1,933 @@
+// Package storagetest содержит общий набор тестов, который должна проходить каждая реализация storage.Storage
+package storagetest
+
+import (
+	"context"
+	"errors"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/mytelegrambot/config"
+	"github.com/mytelegrambot/models"
+	"github.com/mytelegrambot/storage"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Factory возвращает пустое хранилище с часами now (storage.WithClock). Очистку ресурсов
+// регистрирует через t.Cleanup
+type Factory func(t *testing.T, now func() time.Time) storage.Storage
+
+// clock идёт вместе с настоящим временем, но тест может перевести его вперёд: время записей
+// Postgres ставит сам, поэтому состарить данные можно только сдвигом часов хранилища
+type clock struct {
+	mu     sync.Mutex
+	offset time.Duration
+}
+
+func (c *clock) now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Now().Add(c.offset)
+}
+
+func (c *clock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.offset += d
+}
+
+const (
+	chatID  int64 = 1001
+	otherID int64 = 2002
+	botID   int64 = 9009
+)
+
+// Run запускает все проверки на хранилищах, созданных newStorage
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage)
+	}{
+		{"SaveAndGetMsgIDs", testSaveAndGetMsgIDs},
+		{"ActiveDialog", testActiveDialog},
+		{"MoveToRecover", testMoveToRecover},
+		{"MoveToRecoverEmpty", testMoveToRecoverEmpty},
+		{"ListArchiveSessions", testListArchiveSessions},
+		{"RestoreArchiveSession", testRestoreArchiveSession},
+		{"RestoreForeignSession", testRestoreForeignSession},
+		{"ForgetUser", testForgetUser},
+		{"ForgetUserQueues", testForgetUserQueues},
+		{"RetentionKeepsFreshMessages", testRetentionKeepsFreshMessages},
+		{"OutboxDelivered", testOutboxDelivered},
+		{"OutboxRetry", testOutboxRetry},
+		{"OutboxStaleClaim", testOutboxStaleClaim},
+		{"WithinTxRollback", testWithinTxRollback},
+		{"UpdateQueueOrderPerChat", testUpdateQueueOrderPerChat},
+		{"UpdateQueueLeaseExpiry", testUpdateQueueLeaseExpiry},
+		{"UpdateQueueRequeue", testUpdateQueueRequeue},
+		{"UpdateQueueDuplicate", testUpdateQueueDuplicate},
+		{"UpdateOffset", testUpdateOffset},
+		{"ProcessedUpdates", testProcessedUpdates},
+		{"PendingUpdates", testPendingUpdates},
+		{"Ping", testPing},
+		{"RuntimeSettings", testRuntimeSettings},
+		{"ListChats", testListChats},
+		{"ListUsers", testListUsers},
+		{"ChatMessages", testChatMessages},
+		{"BlockUser", testBlockUser},
+		{"APIKeys", testAPIKeys},
+		{"BroadcastDelivery", testBroadcastDelivery},
+		{"BroadcastSegmentAndPause", testBroadcastSegmentAndPause},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.run(t, newStorage(t, time.Now))
+		})
+	}
+
+	// проверки сроков хранения переводят часы хранилища вперёд
+	clockTests := []struct {
+		name string
+		run  func(t *testing.T, s storage.Storage, c *clock)
+	}{
+		{"RetentionDeletesOldData", testRetentionDeletesOldData},
+		{"RetentionAnonymizesArchive", testRetentionAnonymizesArchive},
+	}
+
+	for _, tt := range clockTests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &clock{}
+			tt.run(t, newStorage(t, c.now), c)
+		})
+	}
+}
+
+func message(chat int64, id int, from int64, text string) *models.Message {
+	return &models.Message{
+		ChatID:       chat,
+		MessageID:    id,
+		FromID:       from,
+		FromUsername: "user",
+		Text:         text,
+		Timestamp:    time.Now().Add(time.Duration(id) * time.Millisecond),
+	}
+}
+
+func save(t *testing.T, s storage.Storage, msgs ...*models.Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		require.NoError(t, s.Save(context.Background(), msg))
+	}
+}
+
+func testSaveAndGetMsgIDs(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	save(t, s,
+		message(chatID, 1, chatID, "привет"),
+		message(otherID, 2, otherID, "другой чат"),
+		message(chatID, 3, botID, "ответ"),
+	)
+
+	ids, err = s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{1, 3}, ids)
+}
+
+func testActiveDialog(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	save(t, s,
+		message(chatID, 3, chatID, "второй вопрос"),
+		message(chatID, 1, chatID, "первый вопрос"),
+		message(otherID, 4, otherID, "другой чат"),
+		message(chatID, 2, botID, "ответ"),
+	)
+
+	dialog, err := s.ActiveDialog(ctx, chatID, 10)
+	require.NoError(t, err)
+	var texts []string
+	for _, msg := range dialog {
+		texts = append(texts, msg.Text)
+	}
+	require.Equal(t, []string{"первый вопрос", "ответ", "второй вопрос"}, texts, "oldest first")
+	require.Equal(t, botID, dialog[1].FromID)
+
+	dialog, err = s.ActiveDialog(ctx, chatID, 2)
+	require.NoError(t, err)
+	require.Len(t, dialog, 2)
+	require.Equal(t, "ответ", dialog[0].Text, "the latest messages are kept")
+
+	_, err = s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	dialog, err = s.ActiveDialog(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Empty(t, dialog)
+}
+
+func testMoveToRecover(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	save(t, s,
+		message(chatID, 1, chatID, "вопрос"),
+		message(chatID, 2, botID, "ответ"),
+		message(otherID, 3, otherID, "другой чат"),
+	)
+
+	moved, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	require.True(t, moved)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	ids, err = s.GetMsgIDs(ctx, otherID)
+	require.NoError(t, err)
+	require.Equal(t, []int{3}, ids)
+}
+
+func testMoveToRecoverEmpty(t *testing.T, s storage.Storage) {
+	moved, err := s.MoveToRecover(context.Background(), chatID)
+	require.NoError(t, err)
+	require.False(t, moved)
+
+	sessions, err := s.ListArchiveSessions(context.Background(), chatID, 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+}
+
+func testListArchiveSessions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "первый диалог"), message(chatID, 2, botID, "ответ"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+
+	save(t, s, message(chatID, 3, chatID, "/start"), message(chatID, 4, chatID, "второй диалог"))
+	_, err = s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+
+	require.Equal(t, "второй диалог", sessions[0].FirstText)
+	require.Equal(t, 2, sessions[0].MessagesCount)
+	require.Equal(t, "первый диалог", sessions[1].FirstText)
+	require.NotEqual(t, sessions[0].ID, sessions[1].ID)
+
+	limited, err := s.ListArchiveSessions(ctx, chatID, 1)
+	require.NoError(t, err)
+	require.Equal(t, sessions[:1], limited)
+
+	foreign, err := s.ListArchiveSessions(ctx, otherID, 10)
+	require.NoError(t, err)
+	require.Empty(t, foreign)
+}
+
+func testRestoreArchiveSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "старый диалог"), message(chatID, 2, botID, "ответ"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	save(t, s, message(chatID, 3, chatID, "текущий диалог"))
+
+	restored, err := s.RestoreArchiveSession(ctx, chatID, sessions[0].ID)
+	require.NoError(t, err)
+	require.Equal(t, 2, restored)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.ElementsMatch(t, []int{1, 2}, ids)
+
+	// текущий диалог ушёл в архив, восстановленная сессия из архива пропала
+	sessions, err = s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "текущий диалог", sessions[0].FirstText)
+}
+
+func testRestoreForeignSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(otherID, 1, otherID, "чужой диалог"))
+	_, err := s.MoveToRecover(ctx, otherID)
+	require.NoError(t, err)
+
+	sessions, err := s.ListArchiveSessions(ctx, otherID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+
+	_, err = s.RestoreArchiveSession(ctx, chatID, sessions[0].ID)
+	require.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+	_, err = s.RestoreArchiveSession(ctx, chatID, sessions[0].ID+1000)
+	require.ErrorIs(t, err, storage.ErrSessionNotFound)
+}
+
+func testForgetUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "архив"), message(chatID, 2, botID, "ответ"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	save(t, s,
+		message(chatID, 3, chatID, "активный"),
+		message(otherID, 4, otherID, "другой пользователь"),
+	)
+
+	affected, err := s.ForgetUser(ctx, chatID, "test")
+	require.NoError(t, err)
+	require.GreaterOrEqual(t, affected, int64(3))
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	ids, err = s.GetMsgIDs(ctx, otherID)
+	require.NoError(t, err)
+	require.Equal(t, []int{4}, ids)
+}
+
+func testRetentionKeepsFreshMessages(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "свежее"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	save(t, s, message(chatID, 2, chatID, "активное"))
+
+	affected, err := s.ApplyRetention(ctx, config.Retention{
+		UpdatesMessages: config.RetentionPolicy{Days: 1, Mode: config.RetentionDelete},
+		ArchiveMessages: config.RetentionPolicy{Days: 1, Mode: config.RetentionAnonymize},
+	})
+	require.NoError(t, err)
+	require.Zero(t, affected)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Equal(t, []int{2}, ids)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+}
+
+func testForgetUserQueues(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.EnqueueOutbox(ctx, chatID, "ответ")
+	require.NoError(t, err)
+	_, err = s.EnqueueUpdate(ctx, update(1, chatID, "вопрос"))
+	require.NoError(t, err)
+	other, err := s.EnqueueOutbox(ctx, otherID, "другому")
+	require.NoError(t, err)
+
+	affected, err := s.ForgetUser(ctx, chatID, "test")
+	require.NoError(t, err)
+	require.Equal(t, int64(2), affected)
+
+	claimed, err := s.ClaimOutbox(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, other, claimed[0].ID)
+
+	pending, err := s.PendingUpdates(ctx)
+	require.NoError(t, err)
+	require.Zero(t, pending)
+}
+
+func testRetentionDeletesOldData(t *testing.T, s storage.Storage, c *clock) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "архив"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	save(t, s, message(chatID, 2, chatID, "активное"))
+
+	sent, err := s.EnqueueOutbox(ctx, chatID, "отправлено")
+	require.NoError(t, err)
+	require.NoError(t, s.WithinTx(ctx, func(tx storage.Tx) error {
+		return tx.MarkOutboxSent(ctx, sent, 3)
+	}))
+	pending, err := s.EnqueueOutbox(ctx, chatID, "ещё не отправлено")
+	require.NoError(t, err)
+
+	job, err := s.EnqueueUpdate(ctx, update(4, chatID, "вопрос"))
+	require.NoError(t, err)
+	_, err = s.ClaimUpdates(ctx, "w1", time.Minute, 1)
+	require.NoError(t, err)
+	require.NoError(t, s.FinishUpdate(ctx, job, storage.UpdateDone, ""))
+
+	c.advance(48 * time.Hour)
+	affected, err := s.ApplyRetention(ctx, config.Retention{
+		UpdatesMessages:  config.RetentionPolicy{Days: 1, Mode: config.RetentionDelete},
+		ArchiveMessages:  config.RetentionPolicy{Days: 1, Mode: config.RetentionDelete},
+		FinishedJobsDays: 1,
+	})
+	require.NoError(t, err)
+	// активное и архивное сообщения, пустая сессия, отправленное сообщение и обработанный апдейт
+	require.Equal(t, int64(5), affected)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	// неотправленное сообщение остаётся в очереди, сколько бы ни ждало
+	claimed, err := s.ClaimOutbox(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, pending, claimed[0].ID)
+}
+
+func testRetentionAnonymizesArchive(t *testing.T, s storage.Storage, c *clock) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "мой адрес"), message(chatID, 2, botID, "ответ"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+
+	c.advance(48 * time.Hour)
+	affected, err := s.ApplyRetention(ctx, config.Retention{
+		ArchiveMessages: config.RetentionPolicy{Days: 1, Mode: config.RetentionAnonymize},
+	})
+	require.NoError(t, err)
+	// два сообщения и сессия
+	require.Equal(t, int64(3), affected)
+
+	sessions, err := s.ListArchiveSessions(ctx, chatID, 10)
+	require.NoError(t, err)
+	require.Empty(t, sessions)
+
+	messages, err := s.ChatMessages(ctx, chatID, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, messages)
+
+	messages, err = s.ChatMessages(ctx, 0, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	for _, msg := range messages {
+		require.Zero(t, msg.FromID)
+		require.Empty(t, msg.FromUsername)
+		require.Empty(t, msg.Text, "text can identify the author")
+	}
+}
+
+func testOutboxDelivered(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueOutbox(ctx, chatID, "ответ")
+	require.NoError(t, err)
+
+	err = s.WithinTx(ctx, func(tx storage.Tx) error {
+		if err := tx.MarkOutboxSent(ctx, id, 10); err != nil {
+			return err
+		}
+		return tx.Save(ctx, message(chatID, 10, botID, "ответ"))
+	})
+	require.NoError(t, err)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Equal(t, []int{10}, ids)
+
+	claimed, err := s.ClaimOutbox(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+}
+
+func testOutboxRetry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueOutbox(ctx, chatID, "ответ")
+	require.NoError(t, err)
+
+	// только что захваченная запись не отдаётся повторно
+	claimed, err := s.ClaimOutbox(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+
+	// повтор ждёт своего времени
+	require.NoError(t, s.MarkOutboxFailed(ctx, id, "telegram is down", time.Hour))
+	claimed, err = s.ClaimOutbox(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+
+	require.NoError(t, s.MarkOutboxFailed(ctx, id, "telegram is down", time.Millisecond))
+	time.Sleep(10 * time.Millisecond)
+	claimed, err = s.ClaimOutbox(ctx, time.Hour, 10)
+	require.NoError(t, err)
+	require.Equal(t, []models.OutboxMessage{{ID: id, ChatID: chatID, Text: "ответ", Attempts: 2}}, claimed)
+
+	require.NoError(t, s.MarkOutboxFailed(ctx, id, "chat not found", 0))
+
+	claimed, err = s.ClaimOutbox(ctx, 0, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed)
+}
+
+func testOutboxStaleClaim(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueOutbox(ctx, chatID, "ответ")
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	claimed, err := s.ClaimOutbox(ctx, time.Millisecond, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, id, claimed[0].ID)
+	require.Equal(t, 2, claimed[0].Attempts)
+}
+
+func testWithinTxRollback(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+	errBoom := errors.New("boom")
+
+	id, err := s.EnqueueOutbox(ctx, chatID, "ответ")
+	require.NoError(t, err)
+
+	err = s.WithinTx(ctx, func(tx storage.Tx) error {
+		require.NoError(t, tx.Save(ctx, message(chatID, 10, botID, "ответ")))
+		require.NoError(t, tx.MarkOutboxSent(ctx, id, 10))
+		return errBoom
+	})
+	require.ErrorIs(t, err, errBoom)
+
+	ids, err := s.GetMsgIDs(ctx, chatID)
+	require.NoError(t, err)
+	require.Empty(t, ids)
+
+	// запись осталась неотправленной и будет захвачена повторно
+	time.Sleep(10 * time.Millisecond)
+	claimed, err := s.ClaimOutbox(ctx, time.Millisecond, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+}
+
+func update(updateID int, chat int64, text string) tgbotapi.Update {
+	return tgbotapi.Update{
+		UpdateID: updateID,
+		Message: &tgbotapi.Message{
+			MessageID: updateID,
+			From:      &tgbotapi.User{ID: chat, UserName: "user"},
+			Chat:      &tgbotapi.Chat{ID: chat},
+			Text:      text,
+		},
+	}
+}
+
+func testUpdateQueueOrderPerChat(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	first, err := s.EnqueueUpdate(ctx, update(1, chatID, "первый"))
+	require.NoError(t, err)
+	second, err := s.EnqueueUpdate(ctx, update(2, chatID, "второй"))
+	require.NoError(t, err)
+	other, err := s.EnqueueUpdate(ctx, update(3, otherID, "другой чат"))
+	require.NoError(t, err)
+
+	jobs, err := s.ClaimUpdates(ctx, "w1", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 2)
+	require.Equal(t, first, jobs[0].ID)
+	require.Equal(t, "первый", jobs[0].Update.Message.Text)
+	require.Equal(t, 1, jobs[0].Attempts)
+	require.Equal(t, other, jobs[1].ID)
+
+	// второе сообщение чата ждёт завершения первого
+	jobs, err = s.ClaimUpdates(ctx, "w2", time.Minute, 10)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+
+	require.NoError(t, s.FinishUpdate(ctx, first, storage.UpdateDone, ""))
+
+	jobs, err = s.ClaimUpdates(ctx, "w2", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, second, jobs[0].ID)
+}
+
+func testUpdateQueueLeaseExpiry(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueUpdate(ctx, update(1, chatID, "вопрос"))
+	require.NoError(t, err)
+
+	jobs, err := s.ClaimUpdates(ctx, "crashed", 5*time.Millisecond, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	require.Error(t, s.ExtendUpdateLease(ctx, id, "someone-else", time.Minute))
+
+	time.Sleep(20 * time.Millisecond)
+
+	jobs, err = s.ClaimUpdates(ctx, "w2", time.Minute, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	require.Equal(t, id, jobs[0].ID)
+	require.Equal(t, 2, jobs[0].Attempts)
+
+	require.NoError(t, s.ExtendUpdateLease(ctx, id, "w2", time.Minute))
+	require.Error(t, s.ExtendUpdateLease(ctx, id, "crashed", time.Minute))
+}
+
+func testUpdateQueueRequeue(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueUpdate(ctx, update(1, chatID, "вопрос"))
+	require.NoError(t, err)
+
+	jobs, err := s.ClaimUpdates(ctx, "w1", time.Minute, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	require.NoError(t, s.FinishUpdate(ctx, id, storage.UpdatePending, "shutdown"))
+
+	jobs, err = s.ClaimUpdates(ctx, "w2", time.Minute, 1)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	require.NoError(t, s.FinishUpdate(ctx, id, storage.UpdateFailed, "boom"))
+
+	jobs, err = s.ClaimUpdates(ctx, "w3", time.Minute, 1)
+	require.NoError(t, err)
+	require.Empty(t, jobs)
+}
+
+func testUpdateQueueDuplicate(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	id, err := s.EnqueueUpdate(ctx, update(1, chatID, "вопрос"))
+	require.NoError(t, err)
+	// Telegram прислал тот же апдейт ещё раз
+	again, err := s.EnqueueUpdate(ctx, update(1, chatID, "вопрос"))
+	require.NoError(t, err)
+	require.Equal(t, id, again)
+
+	jobs, err := s.ClaimUpdates(ctx, "w1", time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+
+	pending, err := s.PendingUpdates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, pending)
+}
+
+func testUpdateOffset(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	offset, err := s.LastUpdateOffset(ctx)
+	require.NoError(t, err)
+	require.Zero(t, offset)
+
+	require.NoError(t, s.SaveUpdateOffset(ctx, 42))
+	require.NoError(t, s.SaveUpdateOffset(ctx, 41))
+
+	offset, err = s.LastUpdateOffset(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 42, offset)
+}
+
+func testProcessedUpdates(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	processed, err := s.IsUpdateProcessed(ctx, 7)
+	require.NoError(t, err)
+	require.False(t, processed)
+
+	require.NoError(t, s.MarkUpdateProcessed(ctx, 7))
+	require.NoError(t, s.MarkUpdateProcessed(ctx, 7))
+
+	processed, err = s.IsUpdateProcessed(ctx, 7)
+	require.NoError(t, err)
+	require.True(t, processed)
+
+	processed, err = s.IsUpdateProcessed(ctx, 8)
+	require.NoError(t, err)
+	require.False(t, processed)
+}
+
+func testPendingUpdates(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	depth, err := s.PendingUpdates(ctx)
+	require.NoError(t, err)
+	require.Zero(t, depth)
+
+	first, err := s.EnqueueUpdate(ctx, update(1, chatID, "первый"))
+	require.NoError(t, err)
+	_, err = s.EnqueueUpdate(ctx, update(2, otherID, "второй"))
+	require.NoError(t, err)
+
+	// задача в обработке тоже входит в глубину очереди
+	_, err = s.ClaimUpdates(ctx, "w1", time.Minute, 1)
+	require.NoError(t, err)
+	depth, err = s.PendingUpdates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, depth)
+
+	require.NoError(t, s.FinishUpdate(ctx, first, storage.UpdateDone, ""))
+	depth, err = s.PendingUpdates(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, depth)
+}
+
+func testPing(t *testing.T, s storage.Storage) {
+	require.NoError(t, s.Ping(context.Background()))
+}
+
+func testRuntimeSettings(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	value, err := s.RuntimeSettings(ctx)
+	require.NoError(t, err)
+	require.Nil(t, value)
+
+	require.NoError(t, s.SaveRuntimeSettings(ctx, []byte(`{"max_retries": 1}`)))
+	require.NoError(t, s.SaveRuntimeSettings(ctx, []byte(`{"max_retries": 3}`)))
+
+	value, err = s.RuntimeSettings(ctx)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"max_retries": 3}`, string(value))
+}
+
+func testListChats(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "архив"), message(chatID, 2, botID, "ответ"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	last := message(chatID, 5, chatID, "активный")
+	save(t, s, message(otherID, 3, otherID, "другой чат"), last)
+
+	chats, err := s.ListChats(ctx, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, chats, 2)
+	require.Equal(t, chatID, chats[0].ChatID, "most recent chat first")
+	require.Equal(t, 1, chats[0].ActiveMessages)
+	require.Equal(t, 2, chats[0].ArchivedMessages)
+	require.WithinDuration(t, last.Timestamp, chats[0].LastMessageAt, time.Millisecond)
+	require.Equal(t, otherID, chats[1].ChatID)
+
+	chats, err = s.ListChats(ctx, models.Page{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, chats, 1)
+	require.Equal(t, otherID, chats[0].ChatID)
+
+	chats, err = s.ListChats(ctx, models.Page{Limit: 10, Offset: 2})
+	require.NoError(t, err)
+	require.Empty(t, chats)
+}
+
+func testListUsers(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	renamed := message(chatID, 3, chatID, "новое имя")
+	renamed.FromUsername = "renamed"
+	save(t, s, message(chatID, 1, chatID, "привет"), message(otherID, 2, otherID, "привет"), renamed)
+	require.NoError(t, s.SetUserBlocked(ctx, otherID, true, "test"))
+
+	users, err := s.ListUsers(ctx, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	require.Equal(t, chatID, users[0].UserID)
+	require.Equal(t, "renamed", users[0].Username, "latest username")
+	require.Equal(t, 2, users[0].MessagesCount)
+	require.False(t, users[0].Blocked)
+	require.Equal(t, otherID, users[1].UserID)
+	require.True(t, users[1].Blocked)
+}
+
+func testChatMessages(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "архив"))
+	_, err := s.MoveToRecover(ctx, chatID)
+	require.NoError(t, err)
+	save(t, s, message(chatID, 2, chatID, "активный"), message(otherID, 3, otherID, "чужой"))
+
+	messages, err := s.ChatMessages(ctx, chatID, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, messages, 2)
+	require.Equal(t, 2, messages[0].MessageID, "newest first")
+	require.False(t, messages[0].Archived)
+	require.Equal(t, "активный", messages[0].Text)
+	require.Equal(t, 1, messages[1].MessageID)
+	require.True(t, messages[1].Archived)
+
+	messages, err = s.ChatMessages(ctx, chatID, models.Page{Limit: 1, Offset: 1})
+	require.NoError(t, err)
+	require.Len(t, messages, 1)
+	require.Equal(t, 1, messages[0].MessageID)
+}
+
+func testBlockUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	blocked, err := s.IsUserBlocked(ctx, chatID)
+	require.NoError(t, err)
+	require.False(t, blocked)
+
+	require.NoError(t, s.SetUserBlocked(ctx, chatID, true, "test"))
+	require.NoError(t, s.SetUserBlocked(ctx, chatID, true, "test"))
+	blocked, err = s.IsUserBlocked(ctx, chatID)
+	require.NoError(t, err)
+	require.True(t, blocked)
+
+	blocked, err = s.IsUserBlocked(ctx, otherID)
+	require.NoError(t, err)
+	require.False(t, blocked)
+
+	require.NoError(t, s.SetUserBlocked(ctx, chatID, false, "test"))
+	blocked, err = s.IsUserBlocked(ctx, chatID)
+	require.NoError(t, err)
+	require.False(t, blocked)
+}
+
+func testAPIKeys(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	created, err := s.CreateAPIKey(ctx, models.APIKey{Name: "grafana", Prefix: "mtb_abcd", Scopes: []string{"admin:read", "status:read"}}, "hash-1")
+	require.NoError(t, err)
+	require.NotZero(t, created.ID)
+	require.False(t, created.CreatedAt.IsZero())
+
+	_, err = s.CreateAPIKey(ctx, models.APIKey{Name: "duplicate", Prefix: "mtb_abcd", Scopes: []string{"admin:read"}}, "hash-1")
+	require.Error(t, err, "hash is unique")
+
+	key, err := s.APIKeyByHash(ctx, "hash-1")
+	require.NoError(t, err)
+	require.Equal(t, created.ID, key.ID)
+	require.Equal(t, "grafana", key.Name)
+	require.Equal(t, []string{"admin:read", "status:read"}, key.Scopes)
+
+	_, err = s.APIKeyByHash(ctx, "hash-2")
+	require.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+	require.NoError(t, s.RevokeAPIKey(ctx, created.ID))
+	require.ErrorIs(t, s.RevokeAPIKey(ctx, created.ID), storage.ErrAPIKeyNotFound)
+	_, err = s.APIKeyByHash(ctx, "hash-1")
+	require.ErrorIs(t, err, storage.ErrAPIKeyNotFound, "revoked key does not authenticate")
+
+	keys, err := s.ListAPIKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.NotNil(t, keys[0].RevokedAt)
+}
+
+func testBroadcastDelivery(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	const blockedID int64 = 3003
+	save(t, s, message(chatID, 1, chatID, "привет"), message(otherID, 2, otherID, "привет"), message(blockedID, 3, blockedID, "спам"))
+	require.NoError(t, s.SetUserBlocked(ctx, blockedID, true, "test"))
+
+	created, err := s.CreateBroadcast(ctx, models.Broadcast{Text: "плановые работы", CreatedBy: "test"})
+	require.NoError(t, err)
+	require.NotZero(t, created.ID)
+	require.Equal(t, storage.BroadcastRunning, created.Status)
+	require.Equal(t, 2, created.Total, "blocked user's chat is skipped")
+
+	claimed, err := s.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 2)
+	require.Equal(t, "плановые работы", claimed[0].Text)
+	require.Equal(t, 1, claimed[0].Attempts)
+
+	again, err := s.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Empty(t, again, "claimed deliveries are not handed out twice")
+
+	require.NoError(t, s.MarkBroadcastDeliverySent(ctx, claimed[0].ID, 77))
+	require.NoError(t, s.MarkBroadcastDeliveryFailed(ctx, claimed[1].ID, "429 Too Many Requests", true))
+
+	retried, err := s.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, retried, 1)
+	require.Equal(t, claimed[1].ChatID, retried[0].ChatID)
+	require.Equal(t, 2, retried[0].Attempts)
+	require.NoError(t, s.MarkBroadcastDeliveryFailed(ctx, retried[0].ID, "Forbidden: bot was blocked by the user", false))
+
+	broadcast, err := s.GetBroadcast(ctx, created.ID)
+	require.NoError(t, err)
+	require.Equal(t, storage.BroadcastFinished, broadcast.Status)
+	require.NotNil(t, broadcast.FinishedAt)
+	require.Equal(t, 1, broadcast.Sent)
+	require.Equal(t, 1, broadcast.Failed)
+
+	deliveries, err := s.BroadcastDeliveries(ctx, created.ID, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	byChat := make(map[int64]models.BroadcastDelivery)
+	for _, delivery := range deliveries {
+		byChat[delivery.ChatID] = delivery
+	}
+	require.Equal(t, storage.DeliverySent, byChat[claimed[0].ChatID].Status)
+	require.Equal(t, 77, byChat[claimed[0].ChatID].MessageID)
+	require.NotNil(t, byChat[claimed[0].ChatID].SentAt)
+	require.Equal(t, storage.DeliveryFailed, byChat[claimed[1].ChatID].Status)
+	require.Contains(t, byChat[claimed[1].ChatID].LastError, "blocked by the user")
+}
+
+func testBroadcastSegmentAndPause(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	save(t, s, message(chatID, 1, chatID, "привет"), message(otherID, 2, otherID, "привет"))
+
+	future := time.Now().Add(time.Hour)
+	empty, err := s.CreateBroadcast(ctx, models.Broadcast{
+		Text: "никому", CreatedBy: "test", Segment: models.BroadcastSegment{ActiveSince: &future},
+	})
+	require.NoError(t, err)
+	require.Zero(t, empty.Total)
+	require.Equal(t, storage.BroadcastFinished, empty.Status, "nothing to send")
+
+	created, err := s.CreateBroadcast(ctx, models.Broadcast{
+		Text: "только одному", CreatedBy: "test", Segment: models.BroadcastSegment{ChatIDs: []int64{otherID, 404}},
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, created.Total, "unknown chats are not targeted")
+	require.Equal(t, []int64{otherID, 404}, created.Segment.ChatIDs)
+
+	require.NoError(t, s.SetBroadcastStatus(ctx, created.ID, storage.BroadcastRunning, storage.BroadcastPaused))
+	require.ErrorIs(t, s.SetBroadcastStatus(ctx, created.ID, storage.BroadcastRunning, storage.BroadcastPaused), storage.ErrBroadcastStatus)
+	require.ErrorIs(t, s.SetBroadcastStatus(ctx, 9999, storage.BroadcastRunning, storage.BroadcastPaused), storage.ErrBroadcastNotFound)
+	_, err = s.GetBroadcast(ctx, 9999)
+	require.ErrorIs(t, err, storage.ErrBroadcastNotFound)
+
+	claimed, err := s.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Empty(t, claimed, "paused broadcast is not sent")
+
+	require.NoError(t, s.SetBroadcastStatus(ctx, created.ID, storage.BroadcastPaused, storage.BroadcastRunning))
+	claimed, err = s.ClaimBroadcastDeliveries(ctx, time.Minute, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	require.Equal(t, otherID, claimed[0].ChatID)
+
+	list, err := s.ListBroadcasts(ctx, models.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	require.Equal(t, created.ID, list[0].ID, "newest first")
+	require.Equal(t, storage.BroadcastRunning, list[0].Status)
+}
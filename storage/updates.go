@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/jackc/pgx/v5"
+	"github.com/mytelegrambot/models"
+	"time"
+)
+
+// Статусы задач update_jobs
+const (
+	UpdatePending    = "pending"
+	UpdateInProgress = "in_progress"
+	UpdateDone       = "done"
+	UpdateFailed     = "failed"
+)
+
+// updateOffsetKey - ключ bot_state со следующим смещением getUpdates
+const updateOffsetKey = "update_offset"
+
+// processedUpdatesTTL - сколько хранятся отметки об обработанных апдейтах. Telegram хранит
+// неподтверждённые апдейты не дольше суток, поэтому более старые отметки не нужны
+const processedUpdatesTTL = 7 * 24 * time.Hour
+
+// updateChat возвращает чат и отправителя апдейта для сериализации обработки по чатам
+func updateChat(update tgbotapi.Update) (chatID int64, fromID int64) {
+	if chat := update.FromChat(); chat != nil {
+		chatID = chat.ID
+	}
+	if from := update.SentFrom(); from != nil {
+		fromID = from.ID
+	}
+	return chatID, fromID
+}
+
+// EnqueueUpdate сохраняет апдейт в очередь до начала обработки
+func (b *BotStorage) EnqueueUpdate(ctx context.Context, update tgbotapi.Update) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(update)
+	if err != nil {
+		return 0, fmt.Errorf("marshal update (%v): %w", update.UpdateID, err)
+	}
+	chatID, fromID := updateChat(update)
+
+	// повторно присланный апдейт не ставится в очередь второй раз, возвращается уже сохранённый
+	var id int64
+	err = b.pool.QueryRow(ctx, `
+		WITH inserted AS (
+			INSERT INTO update_jobs (update_id, chat_id, from_id, payload) VALUES ($1, $2, $3, $4)
+			ON CONFLICT (update_id) DO NOTHING
+			RETURNING id)
+		SELECT id FROM inserted
+		UNION ALL
+		SELECT id FROM update_jobs WHERE update_id = $1
+		LIMIT 1`,
+		update.UpdateID, chatID, fromID, payload,
+	).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("db operation: enqueue update (%v): %w", update.UpdateID, err)
+	}
+
+	return id, nil
+}
+
+// ClaimUpdates выдаёт воркеру задачи под аренду на lease. Задачи с истёкшей арендой
+// (воркер упал) выдаются повторно. Из каждого чата выдаётся только самая старая
+// незавершённая задача, чтобы сообщения одного чата обрабатывались по порядку
+func (b *BotStorage) ClaimUpdates(ctx context.Context, worker string, lease time.Duration, limit int) ([]models.QueuedUpdate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := b.pool.Query(ctx, `
+		UPDATE update_jobs SET status = $1, worker = $2, attempts = attempts + 1,
+		                       lease_until = current_timestamp + make_interval(secs => $3)
+		WHERE id IN (
+			SELECT j.id FROM update_jobs j
+			WHERE (j.status = $4 OR (j.status = $1 AND j.lease_until < current_timestamp))
+			  AND NOT EXISTS (SELECT 1 FROM update_jobs e
+			                  WHERE e.chat_id = j.chat_id AND e.id < j.id AND e.status IN ($4, $1))
+			ORDER BY j.id
+			LIMIT $5
+			FOR UPDATE SKIP LOCKED)
+		RETURNING id, payload, attempts`,
+		UpdateInProgress, worker, lease.Seconds(), UpdatePending, limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("db operation: claim updates: %w", err)
+	}
+
+	jobs, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.QueuedUpdate, error) {
+		var (
+			job     models.QueuedUpdate
+			payload []byte
+		)
+		if err := row.Scan(&job.ID, &payload, &job.Attempts); err != nil {
+			return job, err
+		}
+		return job, json.Unmarshal(payload, &job.Update)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("db scanning claimed updates: %w", err)
+	}
+
+	return jobs, nil
+}
+
+// ExtendUpdateLease продлевает аренду задачи, пока воркер её обрабатывает
+func (b *BotStorage) ExtendUpdateLease(ctx context.Context, id int64, worker string, lease time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	tag, err := b.pool.Exec(ctx,
+		"UPDATE update_jobs SET lease_until = current_timestamp + make_interval(secs => $3) WHERE id = $1 AND worker = $2 AND status = $4",
+		id, worker, lease.Seconds(), UpdateInProgress,
+	)
+	if err != nil {
+		return fmt.Errorf("db operation: extend update lease (%v): %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("update job (%v) is not leased by %v", id, worker)
+	}
+
+	return nil
+}
+
+// FinishUpdate переводит задачу в status. UpdatePending возвращает её в очередь
+func (b *BotStorage) FinishUpdate(ctx context.Context, id int64, status string, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := b.pool.Exec(ctx, `
+		UPDATE update_jobs SET status = $2, last_error = nullif($3, ''), lease_until = NULL,
+		                       finished_at = CASE WHEN $2 IN ('done', 'failed') THEN current_timestamp END
+		WHERE id = $1`,
+		id, status, reason,
+	); err != nil {
+		return fmt.Errorf("db operation: finish update (%v): %w", id, err)
+	}
+
+	return nil
+}
+
+// PendingUpdates возвращает глубину очереди: число ожидающих и обрабатываемых задач
+func (b *BotStorage) PendingUpdates(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var depth int
+	if err := b.pool.QueryRow(ctx,
+		"SELECT count(*) FROM update_jobs WHERE status IN ($1, $2)", UpdatePending, UpdateInProgress,
+	).Scan(&depth); err != nil {
+		return 0, fmt.Errorf("db operation: count pending updates: %w", err)
+	}
+
+	return depth, nil
+}
+
+// LastUpdateOffset возвращает сохранённое смещение getUpdates, 0 - если его ещё нет
+func (b *BotStorage) LastUpdateOffset(ctx context.Context) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var offset int
+	err := b.pool.QueryRow(ctx, "SELECT value FROM bot_state WHERE key = $1", updateOffsetKey).Scan(&offset)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("db operation: get update offset: %w", err)
+	}
+
+	return offset, nil
+}
+
+// SaveUpdateOffset сохраняет смещение getUpdates. Смещение никогда не уменьшается
+func (b *BotStorage) SaveUpdateOffset(ctx context.Context, offset int) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := b.pool.Exec(ctx, `
+		INSERT INTO bot_state (key, value) VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE SET value = GREATEST(bot_state.value, excluded.value), updated_at = current_timestamp`,
+		updateOffsetKey, offset,
+	); err != nil {
+		return fmt.Errorf("db operation: save update offset: %w", err)
+	}
+
+	return nil
+}
+
+func (b *BotStorage) IsUpdateProcessed(ctx context.Context, updateID int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	var processed bool
+	err := b.pool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM processed_updates WHERE update_id = $1)", updateID,
+	).Scan(&processed)
+	if err != nil {
+		return false, fmt.Errorf("db operation: check processed update (%v): %w", updateID, err)
+	}
+
+	return processed, nil
+}
+
+func (b *BotStorage) MarkUpdateProcessed(ctx context.Context, updateID int) error {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	if _, err := b.pool.Exec(ctx,
+		"INSERT INTO processed_updates (update_id) VALUES ($1) ON CONFLICT DO NOTHING", updateID,
+	); err != nil {
+		return fmt.Errorf("db operation: mark update (%v) processed: %w", updateID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,31 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+)
+
+func TestStartEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, parent := Start(context.Background(), "service", "telegram.update")
+	_, child := Start(ctx, "storage", "storage.save")
+	End(child, errors.New("connection refused"))
+	End(parent, nil)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	save, update := spans[0], spans[1]
+	require.Equal(t, "storage.save", save.Name())
+	require.Equal(t, update.SpanContext().SpanID(), save.Parent().SpanID())
+	require.Equal(t, codes.Error, save.Status().Code)
+	require.Equal(t, codes.Unset, update.Status().Code)
+}
@@ -27,36 +27,46 @@ func Truncate(s string, maxRunes int) string {
 
 func BotMessageToModel(message *tgbotapi.Message) *models.Message {
 	const maxRunes = 200
-	return &models.Message{
-		ChatID:       message.Chat.ID,
-		MessageID:    message.MessageID,
-		FromID:       message.From.ID,
-		FromUsername: message.From.UserName,
-		Text:         Truncate(message.Text, maxRunes),
-		Timestamp:    time.Now(),
+	result := &models.Message{
+		ChatID:    message.Chat.ID,
+		MessageID: message.MessageID,
+		Text:      Truncate(message.Text, maxRunes),
+		Timestamp: time.Now(),
 	}
+	// у постов каналов и анонимных администраторов нет автора
+	if message.From != nil {
+		result.FromID, result.FromUsername = message.From.ID, message.From.UserName
+	}
+	return result
 }
 
-// ParseChoices разбирает JSON-ответ AI и возвращает список текстов
+// usageFormat - строка о потраченных токенах, которую ParseChoices добавляет после вариантов ответа
+const usageFormat = "потрачено %d токенов"
+
+// IsUsageLine сообщает, что text - строка о потраченных токенах, а не текст ответа
+func IsUsageLine(text string) bool {
+	var tokens int
+	n, err := fmt.Sscanf(text, usageFormat, &tokens)
+	return err == nil && n == 1 && fmt.Sprintf(usageFormat, tokens) == text
+}
+
+// ParseChoices разбирает JSON-ответ AI и возвращает список текстов. Ответ без вариантов
+// deepseek.R1 возвращает ошибкой, поэтому здесь он тоже ошибка
 func ParseChoices(data string) ([]string, error) {
 	var response models.CompletionResponse
-	var errMsg models.ErrorR1Message
-
-	err := json.Unmarshal([]byte(data), &response)
-	if err == nil && len(response.Choices) > 0 {
-		var text []string
-		for _, choice := range response.Choices {
-			if choice.Message.Content != "" {
-				text = append(text, choice.Message.Content)
-			}
-		}
-		text = append(text, fmt.Sprintf("потрачено %d токенов", response.Usage.TotalTokens))
-		return text, nil
+	if err := json.Unmarshal([]byte(data), &response); err != nil {
+		return nil, fmt.Errorf("не удалось распарсить ответ:\n%v\n[ОШИБКА]: %w", data, err)
+	}
+	if len(response.Choices) == 0 {
+		return nil, fmt.Errorf("в ответе нет вариантов:\n%v", data)
 	}
 
-	// Попытка распарсить ошибку
-	if err = json.Unmarshal([]byte(data), &errMsg); err != nil {
-		return nil, fmt.Errorf("не удалось распарсить ответ:\n%v\n[ОШИБКА]: %w", data, err)
+	var text []string
+	for _, choice := range response.Choices {
+		if choice.Message.Content != "" {
+			text = append(text, choice.Message.Content)
+		}
 	}
-	return []string{"Закончились токены! Попробуйте завтра"}, nil
+	text = append(text, fmt.Sprintf(usageFormat, response.Usage.TotalTokens))
+	return text, nil
 }
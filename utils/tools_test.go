@@ -0,0 +1,78 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/mytelegrambot/models"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestParseChoices(t *testing.T) {
+	type args struct {
+		data string
+	}
+	// валидный ответ с choices
+	response, _ := json.Marshal(models.CompletionResponse{
+		ID:       "123",
+		Provider: "test-ai",
+		Model:    "test-model",
+		Choices: []models.Choice{
+			{
+				Message: models.R1Message{
+					Role:    "assistant",
+					Content: "Ответ",
+				},
+			},
+		},
+		Usage: models.Usage{
+			TotalTokens: 15,
+		},
+	})
+
+	// ответ без choices
+	empty, _ := json.Marshal(models.CompletionResponse{ID: "124", Model: "test-model"})
+
+	tests := []struct {
+		name    string
+		args    args
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "valid completion response",
+			args:    args{data: string(response)},
+			want:    []string{"Ответ", "потрачено 15 токенов"},
+			wantErr: false,
+		},
+		{
+			name:    "no choices",
+			args:    args{data: string(empty)},
+			want:    nil,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			args:    args{data: "{"},
+			want:    nil,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseChoices(tt.args.data)
+			if tt.wantErr {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIsUsageLine(t *testing.T) {
+	require.True(t, IsUsageLine("потрачено 15 токенов"))
+	require.False(t, IsUsageLine("потрачено 15 токенов и ещё немного"))
+	require.False(t, IsUsageLine("сколько токенов потрачено?"))
+}